@@ -3,9 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -86,6 +88,34 @@ func main() {
 				Aliases:     []string{"mmproj"},
 				Usage:       "Path where the GGUF file to load for the multimodal projector, optional.",
 			},
+			&cli.StringSliceFlag{
+				Destination: &loraPaths,
+				Category:    "Model/Local",
+				Name:        "lora-path",
+				Aliases:     []string{"lora"},
+				Usage: "Path where the GGUF file to load for the LoRA adapter, optional, " +
+					"can be specified multiple times, " +
+					"the adapter must be applicable to the main model.",
+			},
+			&cli.StringSliceFlag{
+				Destination: &controlVectorPaths,
+				Category:    "Model/Local",
+				Name:        "control-vector-path",
+				Aliases:     []string{"control-vector"},
+				Usage: "Path where the GGUF file to load for the control vector, optional, " +
+					"can be specified multiple times, " +
+					"the control vector must be applicable to the main model.",
+			},
+			&cli.StringFlag{
+				Destination: &imatrixPath,
+				Value:       imatrixPath,
+				Category:    "Model/Local",
+				Name:        "imatrix-path",
+				Aliases:     []string{"imatrix"},
+				Usage: "Path where the importance matrix file to check against the main model, optional, " +
+					"both the legacy binary(.dat) and the GGUF format are supported, " +
+					"display the tensors lacking importance matrix data.",
+			},
 			&cli.StringFlag{
 				Destination: &url,
 				Value:       url,
@@ -300,6 +330,35 @@ func main() {
 				Usage: "Limit the context size to the maximum context size of the model, " +
 					"if the context size is larger than the maximum context size.",
 			},
+			&cli.StringFlag{
+				Destination: &prompt,
+				Value:       prompt,
+				Category:    "Estimate",
+				Name:        "prompt",
+				Aliases:     []string{"p"},
+				Usage: "Specify a sample prompt, " +
+					"which is rendered with the model's chat template and tokenized with the model's vocabulary " +
+					"to suggest the context size, " +
+					"cannot be used with --ctx-size.",
+			},
+			&cli.StringFlag{
+				Destination: &promptFile,
+				Value:       promptFile,
+				Category:    "Estimate",
+				Name:        "prompt-file",
+				Usage: "Specify the path of a sample prompt file, " +
+					"which is either a plain text or a JSON array of messages with role and content, " +
+					"to suggest the context size as --prompt.",
+			},
+			&cli.IntFlag{
+				Destination: &nPredict,
+				Value:       nPredict,
+				Category:    "Estimate",
+				Name:        "n-predict",
+				Aliases:     []string{"n"},
+				Usage: "Specify the number of tokens expected to generate for each parallel sequence, " +
+					"works with --prompt/--prompt-file.",
+			},
 			&cli.IntFlag{
 				Destination: &logicalBatchSize,
 				Value:       logicalBatchSize,
@@ -327,6 +386,24 @@ func main() {
 				Usage: "Specify the number of parallel sequences to decode, " +
 					"which is used to estimate the usage.",
 			},
+			&cli.IntFlag{
+				Destination: &imageCount,
+				Value:       imageCount,
+				Category:    "Estimate",
+				Name:        "image-count",
+				Usage: "Specify the number of images in the context, " +
+					"which is used to estimate the usage of the multimodal projector with vision encoder, " +
+					"default is 1.",
+			},
+			&cli.IntFlag{
+				Destination: &audioDuration,
+				Value:       audioDuration,
+				Category:    "Estimate",
+				Name:        "audio-duration",
+				Usage: "Specify the duration of the audio in seconds, " +
+					"which is used to estimate the usage of the multimodal projector with audio encoder, " +
+					"default is 30 seconds.",
+			},
 			&cli.StringFlag{
 				Destination: &kvType,
 				Value:       kvType,
@@ -374,6 +451,66 @@ func main() {
 					"which is used to estimate the usage. " +
 					"Flash Attention can reduce the usage of RAM/VRAM.",
 			},
+			&cli.BoolFlag{
+				Destination: &swaFull,
+				Value:       swaFull,
+				Category:    "Estimate",
+				Name:        "swa-full",
+				Usage: "Specify using the full size KV cache for the sliding window attention layers, " +
+					"which is used to estimate the usage. " +
+					"By default, the sliding window attention layers only cache the window.",
+			},
+			&cli.UintFlag{
+				Destination: &imageWidth,
+				Value:       imageWidth,
+				Category:    "Estimate/StableDiffusionCpp",
+				Name:        "image-width",
+				Usage: "Specify the width of the generated images, " +
+					"which is used to estimate the usage of the diffusion model, " +
+					"default is the native resolution of the model.",
+			},
+			&cli.UintFlag{
+				Destination: &imageHeight,
+				Value:       imageHeight,
+				Category:    "Estimate/StableDiffusionCpp",
+				Name:        "image-height",
+				Usage: "Specify the height of the generated images, " +
+					"which is used to estimate the usage of the diffusion model, " +
+					"default is the native resolution of the model.",
+			},
+			&cli.IntFlag{
+				Destination: &imageBatchCount,
+				Value:       imageBatchCount,
+				Category:    "Estimate/StableDiffusionCpp",
+				Name:        "image-batch-count",
+				Usage: "Specify the number of the generated images, " +
+					"which is used to estimate the usage of the diffusion model.",
+			},
+			&cli.BoolFlag{
+				Destination: &imageVAETiling,
+				Value:       imageVAETiling,
+				Category:    "Estimate/StableDiffusionCpp",
+				Name:        "image-vae-tiling",
+				Usage: "Specify decoding the latents in tiles, " +
+					"which is used to estimate the usage of the diffusion model. " +
+					"VAE tiling can reduce the usage of RAM/VRAM.",
+			},
+			&cli.BoolFlag{
+				Destination: &clipOnCPU,
+				Value:       clipOnCPU,
+				Category:    "Estimate/StableDiffusionCpp",
+				Name:        "clip-on-cpu",
+				Usage: "Specify keeping the conditioner(text encoders) in RAM, " +
+					"which is used to estimate the usage of the diffusion model.",
+			},
+			&cli.BoolFlag{
+				Destination: &vaeOnCPU,
+				Value:       vaeOnCPU,
+				Category:    "Estimate/StableDiffusionCpp",
+				Name:        "vae-on-cpu",
+				Usage: "Specify keeping the autoencoder(VAE) in RAM, " +
+					"which is used to estimate the usage of the diffusion model.",
+			},
 			&cli.StringFlag{
 				Destination: &platformFootprint,
 				Value:       platformFootprint,
@@ -415,6 +552,36 @@ func main() {
 					"which is used to estimate the usage, " +
 					"default is full offloaded.",
 			},
+			&cli.StringFlag{
+				Destination: &tensorSplit,
+				Value:       tensorSplit,
+				Category:    "Estimate",
+				Name:        "tensor-split",
+				Aliases:     []string{"ts"},
+				Usage: "Specify the fraction of the model to offload to each device, " +
+					"which is used to estimate the usage of each device, " +
+					"a comma-separated list of proportions, e.g. 3,1.",
+			},
+			&cli.StringFlag{
+				Destination: &splitMode,
+				Value:       splitMode,
+				Category:    "Estimate",
+				Name:        "split-mode",
+				Aliases:     []string{"sm"},
+				Usage: "Specify how to split the model across multiple devices, " +
+					"which is used to estimate the usage of each device, select from [layer, row, none], " +
+					"works with --tensor-split.",
+			},
+			&cli.UintFlag{
+				Destination: &mainGPU,
+				Value:       mainGPU,
+				Category:    "Estimate",
+				Name:        "main-gpu",
+				Aliases:     []string{"mg"},
+				Usage: "Specify the device to hold the computation buffers, " +
+					"and the model in the row or none split mode, " +
+					"works with --tensor-split.",
+			},
 			&cli.Uint64Flag{
 				Destination: &offloadLayersStep,
 				Value:       offloadLayersStep,
@@ -458,6 +625,15 @@ func main() {
 				Name:        "skip-tokenizer",
 				Usage:       "Skip to display tokenizer metadata.",
 			},
+			&cli.BoolFlag{
+				Destination: &checkStopSequences,
+				Value:       checkStopSequences,
+				Category:    "Output",
+				Name:        "check-stop-sequences",
+				Usage: "Check the stop sequences of the chat template against the vocabulary, " +
+					"which loads the whole vocabulary of the model, " +
+					"works without --skip-tokenizer.",
+			},
 			&cli.BoolFlag{
 				Destination: &skipEstimate,
 				Value:       skipEstimate,
@@ -465,6 +641,15 @@ func main() {
 				Name:        "skip-estimate",
 				Usage:       "Skip to estimate.",
 			},
+			&cli.BoolFlag{
+				Destination: &showTensors,
+				Value:       showTensors,
+				Category:    "Output",
+				Name:        "tensors",
+				Usage: "Display the tensors of each layer, " +
+					"includes the type, dimensions, size, parameters and bits per weight, " +
+					"along with the layer subtotals, the quantization type histogram and the recognized quantization mix.",
+			},
 			&cli.BoolFlag{
 				Destination: &inMib,
 				Value:       inMib,
@@ -498,27 +683,30 @@ func main() {
 
 var (
 	// model options
-	path         string
-	mmprojPath   string // for estimate
-	draftPath    string // for estimate
-	url          string
-	mmprojUrl    string // for estimate
-	draftUrl     string // for estimate
-	token        string
-	hfRepo       string
-	hfFile       string
-	hfMMProjFile string // for estimate
-	hfDraftRepo  string // for estimate
-	hfDraftFile  string // for estimate
-	hfToken      string
-	msRepo       string
-	msFile       string
-	msMMProjFile string // for estimate
-	msDraftRepo  string // for estimate
-	msDraftFile  string // for estimate
-	msToken      string
-	olModel      string
-	olUsage      bool
+	path               string
+	mmprojPath         string          // for estimate
+	draftPath          string          // for estimate
+	imatrixPath        string          // for imatrix coverage
+	loraPaths          cli.StringSlice // for estimate
+	controlVectorPaths cli.StringSlice // for estimate
+	url                string
+	mmprojUrl          string // for estimate
+	draftUrl           string // for estimate
+	token              string
+	hfRepo             string
+	hfFile             string
+	hfMMProjFile       string // for estimate
+	hfDraftRepo        string // for estimate
+	hfDraftFile        string // for estimate
+	hfToken            string
+	msRepo             string
+	msFile             string
+	msMMProjFile       string // for estimate
+	msDraftRepo        string // for estimate
+	msDraftFile        string // for estimate
+	msToken            string
+	olModel            string
+	olUsage            bool
 	// load options
 	debug                  bool
 	skipProxy              bool
@@ -529,29 +717,46 @@ var (
 	// estimate options
 	ctxSize            = -1
 	inMaxCtxSize       bool
+	prompt             string
+	promptFile         string
+	nPredict           = 512
 	logicalBatchSize   = 2048
 	physicalBatchSize  = 512
 	parallelSize       = 1
+	imageCount         = 0
+	audioDuration      = 0
 	kvType             = "f16"
 	cacheKeyType       = "f16"
 	cacheValueType     = "f16"
 	noKVOffload        bool
 	flashAttention     bool
+	swaFull            bool
+	imageWidth         uint
+	imageHeight        uint
+	imageBatchCount    = 1
+	imageVAETiling     bool
+	clipOnCPU          bool
+	vaeOnCPU           bool
 	platformFootprint  = "150,250"
 	noMMap             bool
 	offloadLayers      = -1
 	offloadLayersDraft = -1
 	offloadLayersStep  uint64
+	tensorSplit        string
+	splitMode          = "layer"
+	mainGPU            uint
 	// output options
-	raw              bool
-	rawOutput        string
-	skipModel        bool
-	skipArchitecture bool
-	skipTokenizer    bool
-	skipEstimate     bool
-	inMib            bool
-	inJson           bool
-	inPrettyJson     = true
+	raw                bool
+	rawOutput          string
+	skipModel          bool
+	skipArchitecture   bool
+	skipTokenizer      bool
+	checkStopSequences bool
+	skipEstimate       bool
+	showTensors        bool
+	inMib              bool
+	inJson             bool
+	inPrettyJson       = true
 )
 
 func mainAction(c *cli.Context) error {
@@ -582,6 +787,33 @@ func mainAction(c *cli.Context) error {
 	if skipCache {
 		ropts = append(ropts, SkipCache())
 	}
+	if !raw && !skipTokenizer && checkStopSequences {
+		// Keep the tokens to check the stop sequences of the chat template.
+		ropts = append(ropts, KeepLargeMetadata("tokenizer.ggml.tokens"))
+	}
+
+	var msgs []GGUFChatMessage
+	if prompt != "" || promptFile != "" {
+		if ctxSize > 0 {
+			return errors.New("--ctx-size cannot be used with --prompt/--prompt-file")
+		}
+		if prompt != "" {
+			msgs = append(msgs, GGUFChatMessage{Role: "user", Content: prompt})
+		}
+		if promptFile != "" {
+			bs, err := os.ReadFile(promptFile)
+			if err != nil {
+				return fmt.Errorf("failed to read prompt file: %w", err)
+			}
+			var fmsgs []GGUFChatMessage
+			if err = json.Unmarshal(bs, &fmsgs); err != nil {
+				fmsgs = []GGUFChatMessage{{Role: "user", Content: string(bs)}}
+			}
+			msgs = append(msgs, fmsgs...)
+		}
+		// Keep the vocabulary to tokenize the prompt.
+		ropts = append(ropts, KeepLargeMetadata(GGUFTokenizerVocabularyKeys...))
+	}
 
 	eopts := []LLaMACppUsageEstimateOption{
 		WithCacheValueType(GGMLTypeF16),
@@ -605,6 +837,12 @@ func mainAction(c *cli.Context) error {
 	if parallelSize > 0 {
 		eopts = append(eopts, WithParallelSize(int32(parallelSize)))
 	}
+	if imageCount > 0 {
+		eopts = append(eopts, WithImageCount(int32(imageCount)))
+	}
+	if audioDuration > 0 {
+		eopts = append(eopts, WithAudioDuration(int32(audioDuration)))
+	}
 	if kvType != "" {
 		kv := toGGMLType(kvType)
 		eopts = append(eopts, WithCacheKeyType(kv), WithCacheValueType(kv))
@@ -621,10 +859,63 @@ func mainAction(c *cli.Context) error {
 	if flashAttention {
 		eopts = append(eopts, WithFlashAttention())
 	}
+	if swaFull {
+		eopts = append(eopts, WithFullSWACache())
+	}
+	if tensorSplit != "" {
+		parts := strings.Split(tensorSplit, ",")
+		ts := make([]float64, len(parts))
+		for i := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+			if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("--tensor-split must be a comma-separated list of non-negative proportions: %s", tensorSplit)
+			}
+			ts[i] = v
+		}
+		if mainGPU >= uint(len(ts)) {
+			return errors.New("--main-gpu must be less than the number of devices of --tensor-split")
+		}
+		sm := LLaMACppSplitModeLayer
+		switch splitMode {
+		case "layer":
+		case "row":
+			sm = LLaMACppSplitModeRow
+		case "none":
+			sm = LLaMACppSplitModeNone
+		default:
+			return fmt.Errorf("--split-mode must be one of [layer, row, none]: %s", splitMode)
+		}
+		eopts = append(eopts, WithTensorSplit(ts), WithSplitMode(sm), WithMainGPU(uint64(mainGPU)))
+	}
+
+	var sdeopts []StableDiffusionCppUsageEstimateOption
+	if imageWidth > 0 || imageHeight > 0 {
+		if imageWidth == 0 || imageHeight == 0 {
+			return errors.New("--image-width and --image-height must be specified together")
+		}
+		sdeopts = append(sdeopts, WithStableDiffusionCppResolution(uint32(imageWidth), uint32(imageHeight)))
+	}
+	if imageBatchCount > 0 {
+		sdeopts = append(sdeopts, WithStableDiffusionCppBatchCount(int32(imageBatchCount)))
+	}
+	if imageVAETiling {
+		sdeopts = append(sdeopts, WithStableDiffusionCppVAETiling())
+	}
+	if clipOnCPU {
+		sdeopts = append(sdeopts, WithoutStableDiffusionCppOffloadConditioner())
+	}
+	if vaeOnCPU {
+		sdeopts = append(sdeopts, WithoutStableDiffusionCppOffloadAutoencoder())
+	}
 
 	// Parse GGUF file.
 
-	var gf, mmpgf, dftgf *GGUFFile
+	var (
+		gf, mmpgf, dftgf *GGUFFile
+		lgfs             []*GGUFFile
+		cvs              []GGUFControlVectorMetadata
+		im               *GGUFImatrix
+	)
 	{
 		var err error
 
@@ -709,6 +1000,40 @@ func mainAction(c *cli.Context) error {
 		if err != nil {
 			return fmt.Errorf("failed to parse draft GGUF file: %w", err)
 		}
+
+		// LoRA adapters.
+		for _, lp := range loraPaths.Value() {
+			var lgf *GGUFFile
+			lgf, err = ParseGGUFFile(lp, ropts...)
+			if err != nil {
+				return fmt.Errorf("failed to parse LoRA adapter GGUF file: %w", err)
+			}
+			if err = lgf.ValidateLoRAAdapter(gf); err != nil {
+				return fmt.Errorf("failed to apply LoRA adapter %s: %w", lp, err)
+			}
+			lgfs = append(lgfs, lgf)
+		}
+
+		// Control vectors.
+		for _, cvp := range controlVectorPaths.Value() {
+			var cvgf *GGUFFile
+			cvgf, err = ParseGGUFFile(cvp, ropts...)
+			if err != nil {
+				return fmt.Errorf("failed to parse control vector GGUF file: %w", err)
+			}
+			if err = cvgf.ValidateControlVector(gf.Architecture()); err != nil {
+				return fmt.Errorf("failed to apply control vector %s: %w", cvp, err)
+			}
+			cvs = append(cvs, cvgf.ControlVector())
+		}
+
+		// Importance matrix.
+		if imatrixPath != "" {
+			im, err = ParseGGUFImatrixFile(imatrixPath, ropts...)
+			if err != nil {
+				return fmt.Errorf("failed to parse importance matrix file: %w", err)
+			}
+		}
 	}
 
 	// Output raw.
@@ -732,10 +1057,15 @@ func mainAction(c *cli.Context) error {
 	// Otherwise, display the metadata and estimate the usage.
 
 	var (
-		m GGUFModelMetadata
-		a GGUFArchitectureMetadata
-		t GGUFTokenizerMetadata
-		e LLaMACppUsageEstimate
+		m   GGUFModelMetadata
+		a   GGUFArchitectureMetadata
+		t   GGUFTokenizerMetadata
+		f   GGUFChatTemplateFamilyMetadata
+		p   LLaMACppContextSizeSuggestion
+		e   LLaMACppUsageEstimate
+		sde StableDiffusionCppUsageEstimate
+
+		diffusion = gf.Architecture().Architecture == "diffusion"
 	)
 	if !skipModel {
 		m = gf.Model()
@@ -745,8 +1075,25 @@ func mainAction(c *cli.Context) error {
 	}
 	if !skipTokenizer && !skipEstimate {
 		t = gf.Tokenizer()
+		f = gf.ChatTemplateFamily()
+	}
+	if len(msgs) != 0 {
+		var err error
+		p, err = gf.SuggestLLaMACppContextSize(msgs, int32(nPredict), int32(parallelSize))
+		if err != nil {
+			return fmt.Errorf("failed to suggest context size: %w", err)
+		}
+		if p.MaximumContextLength > 0 && p.ContextSize > p.MaximumContextLength {
+			_, _ = fmt.Fprintf(os.Stderr, "WARNING: suggested context size %d exceeds the maximum context length %d of the model\n",
+				p.ContextSize, p.MaximumContextLength)
+		}
+		// Clamp to the range of WithContextSize.
+		eopts = append(eopts, WithContextSize(int32(min(p.ContextSize, math.MaxInt32))))
 	}
-	if !skipEstimate {
+	if !skipEstimate && diffusion {
+		sde = gf.EstimateStableDiffusionCppUsage(sdeopts...)
+	}
+	if !skipEstimate && !diffusion {
 		if mmpgf != nil {
 			meopts := eopts[:len(eopts):len(eopts)]
 			me := mmpgf.EstimateLLaMACppUsage(meopts...)
@@ -762,6 +1109,13 @@ func mainAction(c *cli.Context) error {
 			eopts = append(eopts, WithDrafter(&de))
 		}
 
+		if len(lgfs) != 0 {
+			eopts = append(eopts, WithLoRAAdapters(lgfs...))
+		}
+		if len(cvs) != 0 {
+			eopts = append(eopts, WithControlVectors(cvs...))
+		}
+
 		deopts := eopts[:len(eopts):len(eopts)]
 		if offloadLayers >= 0 {
 			deopts = append(deopts, WithOffloadLayers(uint64(offloadLayers)))
@@ -799,8 +1153,17 @@ func mainAction(c *cli.Context) error {
 		}
 		if !skipTokenizer && t.Model != "" {
 			o["tokenizer"] = t
+			if f.Family != "" {
+				o["chatTemplate"] = f
+			}
+		}
+		if len(msgs) != 0 {
+			o["contextSizeSuggestion"] = p
 		}
-		if !skipEstimate {
+		if !skipEstimate && diffusion {
+			o["estimate"] = sde.Summarize(platformRAM, platformVRAM)
+		}
+		if !skipEstimate && !diffusion {
 			es := e.Summarize(mmap, platformRAM, platformVRAM)
 			if e.Architecture != "clip" {
 				switch {
@@ -832,6 +1195,15 @@ func mainAction(c *cli.Context) error {
 			}
 			o["estimate"] = es
 		}
+		if showTensors {
+			o["tensors"] = reportTensors(gf)
+		}
+		if len(cvs) != 0 {
+			o["controlVectors"] = cvs
+		}
+		if im != nil {
+			o["imatrix"] = reportImatrix(gf, im)
+		}
 
 		enc := json.NewEncoder(os.Stdout)
 		if inPrettyJson {
@@ -875,7 +1247,21 @@ func mainAction(c *cli.Context) error {
 			hd []string
 			bd []string
 		)
-		if a.Architecture != "clip" {
+		switch {
+		case a.Architecture == "diffusion":
+			hd = []string{
+				"Diffusion Arch",
+				"Transformer",
+				"Conditioners",
+				"Autoencoder",
+			}
+			bd = []string{
+				a.DiffusionArchitecture,
+				sprintf(a.DiffusionTransformer),
+				sprintf(tenary(len(a.DiffusionConditioners) == 0, "N/A", strings.Join(a.DiffusionConditioners, ", "))),
+				sprintf(tenary(a.DiffusionHasAutoencoder, "VAE", "N/A")),
+			}
+		case a.Architecture != "clip":
 			hd = []string{
 				"Max Context Len",
 				"Embedding Len",
@@ -898,7 +1284,65 @@ func mainAction(c *cli.Context) error {
 				sprintf(a.ExpertCount),
 				sprintf(a.VocabularyLength),
 			}
-		} else {
+			// Extended hyperparameters, only shown if the model has them.
+			if a.EncoderBlockCount > 0 {
+				hd = append(hd, "Layers (Enc / Dec)")
+				bd = append(bd, sprintf("%d / %d", a.EncoderBlockCount, a.DecoderBlockCount))
+			}
+			if a.ExpertSharedCount > 0 {
+				hd = append(hd, "Shared Expert Cnt")
+				bd = append(bd, sprintf(a.ExpertSharedCount))
+			}
+			if a.LeadingDenseBlockCount > 0 {
+				hd = append(hd, "Leading Dense Layers")
+				bd = append(bd, sprintf(a.LeadingDenseBlockCount))
+			}
+			if a.AttentionKeyValueLoRARank > 0 {
+				hd = append(hd, "MLA Rank (Q / KV)")
+				bd = append(bd, sprintf("%s / %d",
+					tenary(a.AttentionQueryLoRARank == 0, "N/A", sprintf(a.AttentionQueryLoRARank)), a.AttentionKeyValueLoRARank))
+			}
+			if a.AttentionSlidingWindow > 0 {
+				hd = append(hd, "Sliding Window")
+				bd = append(bd, sprintf(a.AttentionSlidingWindow))
+			}
+			if a.AttentionLogitSoftcapping > 0 || a.FinalLogitSoftcapping > 0 {
+				hd = append(hd, "Softcapping (Attn / Final)")
+				bd = append(bd, sprintf("%s / %s",
+					sprintf(tenary(a.AttentionLogitSoftcapping <= 0, "N/A", a.AttentionLogitSoftcapping)),
+					sprintf(tenary(a.FinalLogitSoftcapping <= 0, "N/A", a.FinalLogitSoftcapping))))
+			}
+			if a.RoPEScalingType != "" && a.RoPEScalingType != "none" {
+				hd = append(hd, "RoPE Scaling")
+				bd = append(bd, sprintf("%s x%v", a.RoPEScalingType, a.RoPEScalingFactor))
+			}
+			if a.SSMGroupCount > 0 {
+				hd = append(hd, "SSM Groups")
+				bd = append(bd, sprintf(a.SSMGroupCount))
+			}
+			if a.RWKVHeadSize > 0 {
+				hd = append(hd, "WKV Head Size")
+				bd = append(bd, sprintf(a.RWKVHeadSize))
+			}
+			if a.PoolingType != "" {
+				hd = append(hd, "Pooling")
+				bd = append(bd, a.PoolingType)
+			}
+			if a.ClassifierOutputCount > 0 {
+				hd = append(hd, "Classifier Outputs")
+				bd = append(bd, sprintf(tenary(a.Reranking, sprintf("%d (Reranking)", a.ClassifierOutputCount), a.ClassifierOutputCount)))
+			}
+		default:
+			var encs []string
+			if a.ClipHasTextEncoder {
+				encs = append(encs, "Text")
+			}
+			if a.ClipHasVisionEncoder {
+				encs = append(encs, "Vision")
+			}
+			if a.ClipHasAudioEncoder {
+				encs = append(encs, "Audio")
+			}
 			hd = []string{
 				"Embedding Len",
 				"Layers",
@@ -910,9 +1354,19 @@ func mainAction(c *cli.Context) error {
 				sprintf(a.EmbeddingLength),
 				sprintf(a.BlockCount),
 				sprintf(a.FeedForwardLength),
-				sprintf(tenary(a.ClipHasTextEncoder, tenary(a.ClipHasVisionEncoder, "Text & Vision", "Text"), tenary(a.ClipHasVisionEncoder, "Vision", "N/A"))),
+				sprintf(tenary(len(encs) == 0, "N/A", strings.Join(encs, " & "))),
 				sprintf(tenary(a.ClipHasLLaVaProjector, a.ClipProjectorType, "N/A")),
 			}
+			if a.ClipVisionImageSize > 0 && a.ClipVisionPatchSize > 0 {
+				hd = append(hd, "Image / Patch Size")
+				bd = append(bd, sprintf("%d / %d", a.ClipVisionImageSize, a.ClipVisionPatchSize))
+			}
+			if a.ClipHasAudioEncoder {
+				hd = append(hd, "Audio Projector", "Mel Bins")
+				bd = append(bd,
+					sprintf(tenary(a.ClipAudioProjectorType == "", "N/A", a.ClipAudioProjectorType)),
+					sprintf(a.ClipAudioMelBins))
+			}
 		}
 		tprint(
 			"ARCHITECTURE",
@@ -926,9 +1380,12 @@ func mainAction(c *cli.Context) error {
 			"TOKENIZER",
 			[]string{
 				"Model",
+				"Pre Tokenizer",
 				"Tokens Size",
 				"Tokens Len",
 				"Added Tokens Len",
+				"Control Tokens Len",
+				"User Defined Tokens Len",
 				"BOS Token",
 				"EOS Token",
 				"EOT Token",
@@ -936,13 +1393,17 @@ func mainAction(c *cli.Context) error {
 				"Unknown Token",
 				"Separator Token",
 				"Padding Token",
+				"Chat Template",
 			},
 			nil,
 			[]string{
 				t.Model,
+				sprintf(tenary(t.Pre == "", "N/A", t.Pre)),
 				sprintf(tenary(t.TokensSize <= 0, "N/A", GGUFBytesScalar(t.TokensSize))),
 				sprintf(tenary(t.TokensLength <= 0, "N/A", t.TokensLength)),
 				sprintf(tenary(t.AddedTokensLength <= 0, "N/A", t.AddedTokensLength)),
+				sprintf(tenary(t.ControlTokensLength <= 0, "N/A", t.ControlTokensLength)),
+				sprintf(tenary(t.UserDefinedTokensLength <= 0, "N/A", t.UserDefinedTokensLength)),
 				sprintf(tenary(t.BOSTokenID < 0, "N/A", t.BOSTokenID)),
 				sprintf(tenary(t.EOSTokenID < 0, "N/A", t.EOSTokenID)),
 				sprintf(tenary(t.EOTTokenID < 0, "N/A", t.EOTTokenID)),
@@ -950,10 +1411,55 @@ func mainAction(c *cli.Context) error {
 				sprintf(tenary(t.UnknownTokenID < 0, "N/A", t.UnknownTokenID)),
 				sprintf(tenary(t.SeparatorTokenID < 0, "N/A", t.SeparatorTokenID)),
 				sprintf(tenary(t.PaddingTokenID < 0, "N/A", t.PaddingTokenID)),
+				sprintf(tenary(f.Family == "", "N/A", f.Family)),
+			})
+		for _, s := range f.MissingStopSequences() {
+			_, _ = fmt.Fprintf(os.Stderr, "WARNING: stop sequence %q of the %s chat template is missing from the vocabulary\n", s, f.Family)
+		}
+	}
+
+	if len(msgs) != 0 {
+		tprint(
+			"PROMPT",
+			[]string{
+				"Prompt Tokens",
+				"Predict Tokens",
+				"Parallel Size",
+				"Suggested Context Size",
+			},
+			nil,
+			[]string{
+				sprintf(p.PromptTokens),
+				sprintf(p.PredictTokens),
+				sprintf(p.ParallelSize),
+				sprintf(p.ContextSize),
 			})
 	}
 
-	if !skipEstimate {
+	if !skipEstimate && diffusion {
+		es := sde.Summarize(platformRAM, platformVRAM)
+		tprint(
+			"ESTIMATE",
+			[]string{
+				"Arch",
+				"Resolution",
+				"Batch Count",
+				"VAE Tiling",
+				"RAM",
+				"VRAM",
+			},
+			nil,
+			[]string{
+				sprintf(es.Architecture),
+				sprintf("%d x %d", es.Width, es.Height),
+				sprintf(es.BatchCount),
+				sprintf(es.VAETiling),
+				sprintf(es.RAM),
+				sprintf(es.VRAM),
+			})
+	}
+
+	if !skipEstimate && !diffusion {
 		var (
 			hd  []string
 			mg  []int
@@ -975,6 +1481,9 @@ func mainAction(c *cli.Context) error {
 				"NonUMA VRAM",
 			}
 			mg = []int{0, 1, 2, 3, 4, 7}
+			for i := range e.Devices {
+				hd = append(hd, sprintf("NonUMA VRAM (GPU %d)", i))
+			}
 
 			switch {
 			case offloadLayersStep > e.OffloadLayers:
@@ -1011,7 +1520,7 @@ func mainAction(c *cli.Context) error {
 					sprintf("%d / %d", es.LogicalBatchSize, es.PhysicalBatchSize),
 					sprintf(es.FlashAttention),
 					sprintf(!es.NoMMap),
-					sprintf(es.EmbeddingOnly),
+					sprintf(tenary(es.EmbeddingOnly && es.PoolingType != "", sprintf("true (%s)", es.PoolingType), es.EmbeddingOnly)),
 					sprintf(tenary(es.Memory[i].FullOffloaded, sprintf("%d (%d + 1)",
 						es.Memory[i].OffloadLayers, es.Memory[i].OffloadLayers-1), es.Memory[i].OffloadLayers)),
 					sprintf(tenary(es.Memory[i].FullOffloaded, "Yes", "No")),
@@ -1019,6 +1528,9 @@ func mainAction(c *cli.Context) error {
 					sprintf(es.Memory[i].NonUMA.RAM),
 					sprintf(es.Memory[i].NonUMA.VRAM),
 				}
+				for j := range es.Memory[i].NonUMA.VRAMs {
+					bds[i] = append(bds[i], sprintf(es.Memory[i].NonUMA.VRAMs[j]))
+				}
 			}
 		} else {
 			hd = []string{
@@ -1035,6 +1547,14 @@ func mainAction(c *cli.Context) error {
 					sprintf(max(es.Memory[0].UMA.RAM, es.Memory[0].UMA.VRAM)),
 				},
 			}
+			if es.ImageTokens > 0 {
+				hd = append(hd, "Image Tokens")
+				bds[0] = append(bds[0], sprintf(es.ImageTokens))
+			}
+			if es.AudioTokens > 0 {
+				hd = append(hd, "Audio Tokens")
+				bds[0] = append(bds[0], sprintf(es.AudioTokens))
+			}
 		}
 		tprint(
 			"ESTIMATE",
@@ -1043,9 +1563,319 @@ func mainAction(c *cli.Context) error {
 			bds...)
 	}
 
+	if showTensors {
+		r := reportTensors(gf)
+
+		var bds [][]string
+		for _, l := range r.Layers {
+			for _, t := range l.Tensors {
+				bds = append(bds, []string{
+					l.Name,
+					t.Name,
+					t.Type,
+					sprintf(strings.Join(func() []string {
+						ds := make([]string, len(t.Dimensions))
+						for i := range t.Dimensions {
+							ds[i] = sprintf(t.Dimensions[i])
+						}
+						return ds
+					}(), " x ")),
+					sprintf(t.Size),
+					sprintf(t.Parameters),
+					sprintf(t.BitsPerWeight),
+				})
+			}
+			if len(l.Tensors) > 1 {
+				bds = append(bds, []string{
+					l.Name,
+					"Subtotal",
+					"",
+					"",
+					sprintf(l.Size),
+					sprintf(l.Parameters),
+					sprintf(l.BitsPerWeight),
+				})
+			}
+		}
+		tprint(
+			"TENSORS",
+			[]string{
+				"Layer",
+				"Tensor",
+				"Type",
+				"Dimensions",
+				"Size",
+				"Parameters",
+				"BPW",
+			},
+			[]int{0},
+			bds...)
+
+		bds = make([][]string, len(r.Types))
+		for i, t := range r.Types {
+			bds[i] = []string{
+				t.Type,
+				sprintf(t.Count),
+				sprintf(t.Size),
+				sprintf(t.Parameters),
+				sprintf(t.BitsPerWeight),
+			}
+		}
+		tprint(
+			"TENSOR TYPES",
+			[]string{
+				"Type",
+				"Tensors",
+				"Size",
+				"Parameters",
+				"BPW",
+			},
+			nil,
+			bds...)
+
+		qm := r.QuantizationMix
+		bd := []string{
+			sprintf(tenary(qm.Name != "", qm.Name, "Unknown")),
+			string(qm.Confidence),
+			sprintf(qm.Imatrix),
+			sprintf("%d / %d", qm.Matched, qm.Total),
+		}
+		bds = [][]string{
+			append(bd, "None", "", ""),
+		}
+		if len(qm.Deviations) != 0 {
+			bds = make([][]string, len(qm.Deviations))
+			for i, d := range qm.Deviations {
+				bds[i] = append(bd[:len(bd):len(bd)],
+					d.Name,
+					d.Expected.String(),
+					d.Actual.String())
+			}
+		}
+		tprint(
+			"QUANTIZATION MIX",
+			[]string{
+				"Recipe",
+				"Confidence",
+				"Imatrix",
+				"Matched",
+				"Deviated Tensor",
+				"Expected",
+				"Actual",
+			},
+			[]int{0, 1, 2, 3},
+			bds...)
+	}
+
+	if len(cvs) != 0 {
+		bds := make([][]string, len(cvs))
+		for i := range cvs {
+			lr := "N/A"
+			if ls := cvs[i].Layers; len(ls) != 0 {
+				lr = sprintf("%d ~ %d", ls[0], ls[len(ls)-1])
+			}
+			bds[i] = []string{
+				sprintf(tenary(cvs[i].ModelHint != "", cvs[i].ModelHint, "N/A")),
+				sprintf("%d / %d", len(cvs[i].Layers), cvs[i].LayerCount),
+				lr,
+				sprintf(cvs[i].EmbeddingLength),
+				cvs[i].Size.String(),
+			}
+		}
+		tprint(
+			"CONTROL VECTORS",
+			[]string{
+				"Model Hint",
+				"Steered Layers",
+				"Layer Range",
+				"Embedding Len",
+				"Size",
+			},
+			nil,
+			bds...)
+	}
+
+	if im != nil {
+		r := reportImatrix(gf, im)
+
+		tprint(
+			"IMATRIX",
+			[]string{
+				"Format",
+				"Datasets",
+				"Chunks",
+				"Entries",
+				"Coverage",
+			},
+			nil,
+			[]string{
+				r.Format,
+				sprintf(tenary(len(r.Datasets) != 0, strings.Join(r.Datasets, ", "), "N/A")),
+				sprintf(tenary(r.ChunkSize != 0, sprintf("%d x %d", r.ChunkCount, r.ChunkSize), r.ChunkCount)),
+				sprintf(r.Entries),
+				sprintf("%d / %d", r.Coverage.Covered, r.Coverage.Total),
+			})
+
+		var bds [][]string
+		for _, n := range r.Coverage.Missing {
+			bds = append(bds, []string{"Missing", n, "No importance matrix data"})
+		}
+		for _, mm := range r.Coverage.Mismatched {
+			bds = append(bds, []string{"Mismatched", mm.Name, sprintf("Expected %d values, but got %d", mm.Expected, mm.Actual)})
+		}
+		for _, n := range r.Coverage.Incomplete {
+			bds = append(bds, []string{"Incomplete", n, "Some matrices have no activations"})
+		}
+		for _, n := range r.Coverage.Unknown {
+			bds = append(bds, []string{"Unknown", n, "Not found in the model"})
+		}
+		if len(bds) != 0 {
+			tprint(
+				"IMATRIX ISSUES",
+				[]string{
+					"Issue",
+					"Tensor",
+					"Detail",
+				},
+				[]int{0},
+				bds...)
+		}
+	}
+
 	return nil
 }
 
+type (
+	tensorReport struct {
+		// Layers holds the tensors of each layer,
+		// in the order of GGUFFile.Layers.
+		Layers []tensorReportLayer `json:"layers"`
+		// Types holds the histogram of the tensor types,
+		// sorted by the size in descending order.
+		Types []tensorReportType `json:"types"`
+		// QuantizationMix holds the recognized quantization recipe.
+		QuantizationMix GGUFQuantizationMix `json:"quantizationMix"`
+	}
+
+	tensorReportLayer struct {
+		Name          string                  `json:"name"`
+		Tensors       []tensorReportTensor    `json:"tensors"`
+		Size          GGUFBytesScalar         `json:"size"`
+		Parameters    GGUFParametersScalar    `json:"parameters"`
+		BitsPerWeight GGUFBitsPerWeightScalar `json:"bitsPerWeight"`
+	}
+
+	tensorReportTensor struct {
+		Name          string                  `json:"name"`
+		Type          string                  `json:"type"`
+		Dimensions    []uint64                `json:"dimensions"`
+		Size          GGUFBytesScalar         `json:"size"`
+		Parameters    GGUFParametersScalar    `json:"parameters"`
+		BitsPerWeight GGUFBitsPerWeightScalar `json:"bitsPerWeight"`
+	}
+
+	tensorReportType struct {
+		Type          string                  `json:"type"`
+		Count         uint64                  `json:"count"`
+		Size          GGUFBytesScalar         `json:"size"`
+		Parameters    GGUFParametersScalar    `json:"parameters"`
+		BitsPerWeight GGUFBitsPerWeightScalar `json:"bitsPerWeight"`
+	}
+)
+
+type imatrixReport struct {
+	Format     string              `json:"format"`
+	Datasets   []string            `json:"datasets,omitempty"`
+	ChunkCount uint64              `json:"chunkCount"`
+	ChunkSize  uint64              `json:"chunkSize,omitempty"`
+	Entries    int                 `json:"entries"`
+	Coverage   GGUFImatrixCoverage `json:"coverage"`
+}
+
+func reportImatrix(gf *GGUFFile, im *GGUFImatrix) imatrixReport {
+	return imatrixReport{
+		Format:     im.Format,
+		Datasets:   im.Datasets,
+		ChunkCount: im.ChunkCount,
+		ChunkSize:  im.ChunkSize,
+		Entries:    len(im.Entries),
+		Coverage:   im.Coverage(gf),
+	}
+}
+
+func reportTensors(gf *GGUFFile) (r tensorReport) {
+	bpw := func(s GGUFBytesScalar, p GGUFParametersScalar) GGUFBitsPerWeightScalar {
+		if p == 0 {
+			return 0
+		}
+		return GGUFBitsPerWeightScalar(float64(s) * 8 / float64(p))
+	}
+
+	tm := make(map[GGMLType]*tensorReportType)
+
+	// Top level tensors are reported as a layer respectively,
+	// the tensors of a named layer are reported together,
+	// and the nested named layers are reported independently.
+	var walk func(name string, ls GGUFLayerTensorInfos)
+	walk = func(name string, ls GGUFLayerTensorInfos) {
+		idx := -1
+		for i := range ls {
+			switch v := ls[i].(type) {
+			case GGUFTensorInfo:
+				t := tensorReportTensor{
+					Name:       v.Name,
+					Type:       v.Type.String(),
+					Dimensions: v.Dimensions,
+					Size:       GGUFBytesScalar(v.Bytes()),
+					Parameters: GGUFParametersScalar(v.Elements()),
+				}
+				t.BitsPerWeight = bpw(t.Size, t.Parameters)
+
+				if _, ok := tm[v.Type]; !ok {
+					tm[v.Type] = &tensorReportType{Type: t.Type}
+				}
+				tm[v.Type].Count++
+				tm[v.Type].Size += t.Size
+				tm[v.Type].Parameters += t.Parameters
+
+				if name == "" {
+					r.Layers = append(r.Layers, tensorReportLayer{Name: v.Name})
+				} else if idx < 0 {
+					r.Layers = append(r.Layers, tensorReportLayer{Name: name})
+					idx = len(r.Layers) - 1
+				}
+				l := &r.Layers[len(r.Layers)-1]
+				if name != "" {
+					l = &r.Layers[idx]
+				}
+				l.Tensors = append(l.Tensors, t)
+				l.Size += t.Size
+				l.Parameters += t.Parameters
+				l.BitsPerWeight = bpw(l.Size, l.Parameters)
+			case *GGUFNamedTensorInfos:
+				walk(v.Name, v.GGUFLayerTensorInfos)
+			}
+		}
+	}
+	walk("", gf.Layers())
+
+	r.Types = make([]tensorReportType, 0, len(tm))
+	for _, t := range tm {
+		t.BitsPerWeight = bpw(t.Size, t.Parameters)
+		r.Types = append(r.Types, *t)
+	}
+	sort.Slice(r.Types, func(i, j int) bool {
+		if r.Types[i].Size == r.Types[j].Size {
+			return r.Types[i].Type < r.Types[j].Type
+		}
+		return r.Types[i].Size > r.Types[j].Size
+	})
+
+	r.QuantizationMix = gf.QuantizationMix()
+
+	return r
+}
+
 func sprintf(f any, a ...any) string {
 	if v, ok := f.(string); ok {
 		if len(a) != 0 {
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -158,6 +159,11 @@ type (
 
 		// Size is the size of the array in bytes.
 		Size int64 `json:"size"`
+
+		// Counts holds the number of each distinct item value of the integer array,
+		// which is only recorded when streaming the array under SkipLargeMetadata,
+		// e.g. `tokenizer.ggml.token_type`.
+		Counts map[int64]uint64 `json:"counts,omitempty"`
 	}
 
 	// GGUFMetadataKVs is a list of GGUFMetadataKV.
@@ -415,7 +421,8 @@ func (gf *GGUFFile) layers() GGUFLayerTensorInfos {
 			}
 			l := pm[p].(*GGUFNamedTensorInfos)
 			l.GGUFLayerTensorInfos = append(l.GGUFLayerTensorInfos, gf.TensorInfos[i])
-		case ps[0] == "v" || ps[0] == "t": // Clip.
+		case ps[0] == "v" || ps[0] == "t" || ps[0] == "a", // Clip.
+			ps[0] == "enc" || ps[0] == "dec": // T5.
 			p := ps[0]
 			if _, ok := pm[p]; !ok {
 				xl := &GGUFNamedTensorInfos{Name: p}
@@ -455,6 +462,19 @@ func (gf *GGUFFile) layers() GGUFLayerTensorInfos {
 			}
 			l := pm[p].(*GGUFNamedTensorInfos)
 			l.GGUFLayerTensorInfos = append(l.GGUFLayerTensorInfos, gf.TensorInfos[i])
+		case ps[0] == "model" && ps[1] == "diffusion_model", // Stable Diffusion.
+			ps[0] == "first_stage_model" || ps[0] == "cond_stage_model" || ps[0] == "conditioner" || ps[0] == "text_encoders":
+			p := ps[0]
+			if ps[0] == "model" {
+				p = strings.Join([]string{ps[0], ps[1]}, ".")
+			}
+			if _, ok := pm[p]; !ok {
+				l := &GGUFNamedTensorInfos{Name: p}
+				pm[p] = l
+				ret = append(ret, l)
+			}
+			l := pm[p].(*GGUFNamedTensorInfos)
+			l.GGUFLayerTensorInfos = append(l.GGUFLayerTensorInfos, gf.TensorInfos[i])
 		}
 	}
 	return ret
@@ -624,6 +644,21 @@ func (kv GGUFMetadataKV) ValueArray() GGUFMetadataKVArrayValue {
 			}(),
 			StartOffset: anyx.Number[int64](t["startOffset"]),
 			Size:        anyx.Number[int64](t["size"]),
+			Counts: func() map[int64]uint64 {
+				vv, ok := t["counts"].(map[string]any)
+				if !ok {
+					return nil
+				}
+				r := make(map[int64]uint64, len(vv))
+				for k := range vv {
+					ik, err := strconv.ParseInt(k, 10, 64)
+					if err != nil {
+						continue
+					}
+					r[ik] = anyx.Number[uint64](vv[k])
+				}
+				return r
+			}(),
 		}
 	default:
 		panic(fmt.Errorf("invalid type: %T", kv.Value))
@@ -1186,6 +1221,10 @@ type _GGUFReader struct {
 	o  _GGUFReadOptions
 	f  io.ReadSeeker
 	bo binary.ByteOrder
+
+	// counting streams the integer array to count its items,
+	// instead of seeking over it under SkipLargeMetadata.
+	counting bool
 }
 
 func (rd _GGUFReader) ReadUint8() (v uint8, err error) {
@@ -1336,24 +1375,32 @@ func (rd _GGUFReader) ReadArray() (v GGUFMetadataKVArrayValue, err error) {
 		return v, nil
 	}
 
-	switch v.Type {
-	case GGUFMetadataValueTypeUint8, GGUFMetadataValueTypeInt8, GGUFMetadataValueTypeBool:
-		_, err = rd.f.Seek(int64(v.Len), io.SeekCurrent)
-	case GGUFMetadataValueTypeUint16, GGUFMetadataValueTypeInt16:
-		_, err = rd.f.Seek(int64(v.Len)*2, io.SeekCurrent)
-	case GGUFMetadataValueTypeUint32, GGUFMetadataValueTypeInt32, GGUFMetadataValueTypeFloat32:
-		_, err = rd.f.Seek(int64(v.Len)*4, io.SeekCurrent)
-	case GGUFMetadataValueTypeUint64, GGUFMetadataValueTypeInt64, GGUFMetadataValueTypeFloat64:
-		_, err = rd.f.Seek(int64(v.Len)*8, io.SeekCurrent)
-	case GGUFMetadataValueTypeString:
-		for i := uint64(0); i < v.Len; i++ {
-			if err = rd.SkipReadingString(); err != nil {
-				return v, fmt.Errorf("seek array[string] %d: %w", i, err)
+	var counted bool
+	if rd.counting {
+		if counted, err = rd.countArray(&v); err != nil {
+			return v, fmt.Errorf("count array: %w", err)
+		}
+	}
+	if !counted {
+		switch v.Type {
+		case GGUFMetadataValueTypeUint8, GGUFMetadataValueTypeInt8, GGUFMetadataValueTypeBool:
+			_, err = rd.f.Seek(int64(v.Len), io.SeekCurrent)
+		case GGUFMetadataValueTypeUint16, GGUFMetadataValueTypeInt16:
+			_, err = rd.f.Seek(int64(v.Len)*2, io.SeekCurrent)
+		case GGUFMetadataValueTypeUint32, GGUFMetadataValueTypeInt32, GGUFMetadataValueTypeFloat32:
+			_, err = rd.f.Seek(int64(v.Len)*4, io.SeekCurrent)
+		case GGUFMetadataValueTypeUint64, GGUFMetadataValueTypeInt64, GGUFMetadataValueTypeFloat64:
+			_, err = rd.f.Seek(int64(v.Len)*8, io.SeekCurrent)
+		case GGUFMetadataValueTypeString:
+			for i := uint64(0); i < v.Len; i++ {
+				if err = rd.SkipReadingString(); err != nil {
+					return v, fmt.Errorf("seek array[string] %d: %w", i, err)
+				}
 			}
+		default:
+			// Should not happen.
+			panic(fmt.Errorf("invalid type: %v", v.Type))
 		}
-	default:
-		// Should not happen.
-		panic(fmt.Errorf("invalid type: %v", v.Type))
 	}
 	if err != nil {
 		return v, fmt.Errorf("seek array end: %w", err)
@@ -1368,6 +1415,56 @@ func (rd _GGUFReader) ReadArray() (v GGUFMetadataKVArrayValue, err error) {
 	return v, nil
 }
 
+// countArray streams the items of the integer array to count each distinct value,
+// returns false if the array is not an integer array.
+func (rd _GGUFReader) countArray(v *GGUFMetadataKVArrayValue) (bool, error) {
+	var is uint64
+	switch v.Type {
+	case GGUFMetadataValueTypeUint8, GGUFMetadataValueTypeInt8:
+		is = 1
+	case GGUFMetadataValueTypeUint16, GGUFMetadataValueTypeInt16:
+		is = 2
+	case GGUFMetadataValueTypeUint32, GGUFMetadataValueTypeInt32:
+		is = 4
+	case GGUFMetadataValueTypeUint64, GGUFMetadataValueTypeInt64:
+		is = 8
+	default:
+		return false, nil
+	}
+
+	v.Counts = map[int64]uint64{}
+	buf := make([]byte, min(v.Len, 8192)*is)
+	for l := v.Len; l > 0; {
+		n := min(l, 8192)
+		b := buf[:n*is]
+		if _, err := io.ReadFull(rd.f, b); err != nil {
+			return false, fmt.Errorf("read array items: %w", err)
+		}
+		for i := uint64(0); i < n; i++ {
+			var x int64
+			switch bi := b[i*is:]; v.Type {
+			case GGUFMetadataValueTypeUint8:
+				x = int64(bi[0])
+			case GGUFMetadataValueTypeInt8:
+				x = int64(int8(bi[0]))
+			case GGUFMetadataValueTypeUint16:
+				x = int64(rd.bo.Uint16(bi))
+			case GGUFMetadataValueTypeInt16:
+				x = int64(int16(rd.bo.Uint16(bi)))
+			case GGUFMetadataValueTypeUint32:
+				x = int64(rd.bo.Uint32(bi))
+			case GGUFMetadataValueTypeInt32:
+				x = int64(int32(rd.bo.Uint32(bi)))
+			default:
+				x = int64(rd.bo.Uint64(bi))
+			}
+			v.Counts[x]++
+		}
+		l -= n
+	}
+	return true, nil
+}
+
 func (rd _GGUFReader) ReadUint64() (v uint64, err error) {
 	err = binary.Read(rd.f, rd.bo, &v)
 	if err != nil {
@@ -1434,6 +1531,22 @@ func (rd _GGUFReader) ReadValue(vt GGUFMetadataValueType) (v any, err error) {
 	return v, nil
 }
 
+// _GGUFCountedMetadata holds the keys of the large integer arrays,
+// which are streamed to count their items under SkipLargeMetadata.
+var _GGUFCountedMetadata = []string{
+	"tokenizer.ggml.token_type",
+}
+
+// _GGUFPerLayerMetadataSuffixes holds the key suffixes of the hyperparameters,
+// which may be stored as per-layer arrays,
+// they are small enough to read even under SkipLargeMetadata.
+var _GGUFPerLayerMetadataSuffixes = []string{
+	".attention.head_count",
+	".attention.head_count_kv",
+	".feed_forward_length",
+	".attention.sliding_window_pattern",
+}
+
 type _GGUFMetadataReader struct {
 	_GGUFReader
 }
@@ -1455,7 +1568,17 @@ func (rd _GGUFMetadataReader) Read() (kv GGUFMetadataKV, err error) {
 		}
 	}
 
-	kv.Value, err = rd.ReadValue(kv.ValueType)
+	vrd := rd._GGUFReader
+	if vrd.o.SkipLargeMetadata {
+		switch {
+		case slices.Contains(vrd.o.KeepLargeMetadata, kv.Key),
+			slices.ContainsFunc(_GGUFPerLayerMetadataSuffixes, func(s string) bool { return strings.HasSuffix(kv.Key, s) }):
+			vrd.o.SkipLargeMetadata = false
+		case slices.Contains(_GGUFCountedMetadata, kv.Key):
+			vrd.counting = true
+		}
+	}
+	kv.Value, err = vrd.ReadValue(kv.ValueType)
 	if err != nil {
 		return kv, fmt.Errorf("read %s value: %w", kv.Key, err)
 	}
@@ -1501,8 +1624,8 @@ func (rd _GGUFTensorInfoReader) Read() (ti GGUFTensorInfo, err error) {
 			return ti, fmt.Errorf("read type: %w", err)
 		}
 		ti.Type = GGMLType(v)
-		if ti.Type >= _GGMLTypeCount {
-			return ti, fmt.Errorf("invalid type: %v", ti.Type)
+		if !ti.Type.Valid() {
+			return ti, fmt.Errorf("invalid type: %v, unknown types can be registered by RegisterGGMLType", ti.Type)
 		}
 	}
 
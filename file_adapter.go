@@ -0,0 +1,96 @@
+package gguf_parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Suffixes of the LoRA adapter tensor names,
+// e.g. "blk.0.attn_q.weight.lora_a" adapts "blk.0.attn_q.weight".
+const (
+	_GGUFLoRAAdapterTensorSuffixA = ".lora_a"
+	_GGUFLoRAAdapterTensorSuffixB = ".lora_b"
+)
+
+// ValidateLoRAAdapter validates the GGUF file as a LoRA adapter of the given base model,
+// which is inspired by
+// https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama.cpp#L18808-L18906.
+//
+// It returns an error joined all the problems found, or nil if the adapter can be applied to the base model.
+func (gf *GGUFFile) ValidateLoRAAdapter(base *GGUFFile) error {
+	a := gf.Architecture()
+	if a.Type != "adapter" || a.AdapterType != "lora" {
+		return errors.New("not a LoRA adapter")
+	}
+	if ba := base.Architecture(); ba.Architecture != a.Architecture {
+		return fmt.Errorf("architecture mismatch: adapter %s, base model %s", a.Architecture, ba.Architecture)
+	}
+
+	type pair struct {
+		a, b *GGUFTensorInfo
+	}
+	var (
+		ns []string
+		ps = map[string]*pair{}
+	)
+	for i := range gf.TensorInfos {
+		ti := &gf.TensorInfos[i]
+
+		var n string
+		switch {
+		case strings.HasSuffix(ti.Name, _GGUFLoRAAdapterTensorSuffixA):
+			n = strings.TrimSuffix(ti.Name, _GGUFLoRAAdapterTensorSuffixA)
+		case strings.HasSuffix(ti.Name, _GGUFLoRAAdapterTensorSuffixB):
+			n = strings.TrimSuffix(ti.Name, _GGUFLoRAAdapterTensorSuffixB)
+		default:
+			continue
+		}
+		p, ok := ps[n]
+		if !ok {
+			p = &pair{}
+			ps[n] = p
+			ns = append(ns, n)
+		}
+		if strings.HasSuffix(ti.Name, _GGUFLoRAAdapterTensorSuffixA) {
+			p.a = ti
+		} else {
+			p.b = ti
+		}
+	}
+	if len(ns) == 0 {
+		return errors.New("no LoRA tensors")
+	}
+
+	var errs []error
+	for _, n := range ns {
+		p := ps[n]
+		if p.a == nil || p.b == nil {
+			errs = append(errs, fmt.Errorf("tensor %s: lora_a or lora_b is missing", n))
+			continue
+		}
+		bti, ok := base.TensorInfos.Get(n)
+		if !ok {
+			errs = append(errs, fmt.Errorf("tensor %s: not found in base model", n))
+			continue
+		}
+		if p.a.NDimensions < 2 || p.b.NDimensions < 2 || bti.NDimensions < 2 {
+			errs = append(errs, fmt.Errorf("tensor %s: must be 2D", n))
+			continue
+		}
+		// lora_a is [n_in, rank], lora_b is [rank, n_out],
+		// and the base tensor is [n_in, n_out].
+		if bti.Dimensions[0] != p.a.Dimensions[0] || bti.Dimensions[1] != p.b.Dimensions[1] {
+			errs = append(errs, fmt.Errorf("tensor %s: incorrect shape, base [%d, %d], lora_a [%d, %d], lora_b [%d, %d]",
+				n, bti.Dimensions[0], bti.Dimensions[1],
+				p.a.Dimensions[0], p.a.Dimensions[1], p.b.Dimensions[0], p.b.Dimensions[1]))
+			continue
+		}
+		if p.a.Dimensions[1] != p.b.Dimensions[0] {
+			errs = append(errs, fmt.Errorf("tensor %s: lora_a is not transposed, rank %d of lora_a, rank %d of lora_b",
+				n, p.a.Dimensions[1], p.b.Dimensions[0]))
+		}
+	}
+
+	return errors.Join(errs...)
+}
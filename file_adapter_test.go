@@ -0,0 +1,73 @@
+package gguf_parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGGUFFile_ValidateLoRAAdapter(t *testing.T) {
+	const (
+		nEmbd = 64
+		nFF   = 128
+		rank  = 8
+	)
+
+	base := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				{Key: "general.architecture", ValueType: GGUFMetadataValueTypeString, Value: "llama"},
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			{Name: "blk.0.attn_q.weight", NDimensions: 2, Dimensions: []uint64{nEmbd, nEmbd}, Type: GGMLTypeF16},
+			{Name: "blk.0.ffn_up.weight", NDimensions: 2, Dimensions: []uint64{nEmbd, nFF}, Type: GGMLTypeF16},
+		},
+	}
+	adapter := func(tis ...GGUFTensorInfo) *GGUFFile {
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: GGUFMetadataKVs{
+					{Key: "general.architecture", ValueType: GGUFMetadataValueTypeString, Value: "llama"},
+					{Key: "general.type", ValueType: GGUFMetadataValueTypeString, Value: "adapter"},
+					{Key: "adapter.type", ValueType: GGUFMetadataValueTypeString, Value: "lora"},
+					{Key: "adapter.lora.alpha", ValueType: GGUFMetadataValueTypeFloat32, Value: float32(16)},
+				},
+			},
+			TensorInfos: tis,
+		}
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		gf := adapter(
+			GGUFTensorInfo{Name: "blk.0.attn_q.weight.lora_a", NDimensions: 2, Dimensions: []uint64{nEmbd, rank}, Type: GGMLTypeF16},
+			GGUFTensorInfo{Name: "blk.0.attn_q.weight.lora_b", NDimensions: 2, Dimensions: []uint64{rank, nEmbd}, Type: GGMLTypeF16},
+			GGUFTensorInfo{Name: "blk.0.ffn_up.weight.lora_a", NDimensions: 2, Dimensions: []uint64{nEmbd, rank}, Type: GGMLTypeF16},
+			GGUFTensorInfo{Name: "blk.0.ffn_up.weight.lora_b", NDimensions: 2, Dimensions: []uint64{rank, nFF}, Type: GGMLTypeF16},
+		)
+		a := gf.Architecture()
+		assert.Equal(t, "adapter", a.Type)
+		assert.Equal(t, "lora", a.AdapterType)
+		assert.Equal(t, float32(16), a.AdapterLoRAAlpha)
+		assert.Equal(t, "adapter", gf.Model().Type)
+		assert.NoError(t, gf.ValidateLoRAAdapter(base))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		gf := adapter(
+			GGUFTensorInfo{Name: "blk.0.attn_q.weight.lora_a", NDimensions: 2, Dimensions: []uint64{nEmbd, rank}, Type: GGMLTypeF16},
+			GGUFTensorInfo{Name: "blk.0.ffn_up.weight.lora_a", NDimensions: 2, Dimensions: []uint64{nEmbd, rank}, Type: GGMLTypeF16},
+			GGUFTensorInfo{Name: "blk.0.ffn_up.weight.lora_b", NDimensions: 2, Dimensions: []uint64{rank, nEmbd}, Type: GGMLTypeF16},
+			GGUFTensorInfo{Name: "blk.0.ffn_gate.weight.lora_a", NDimensions: 2, Dimensions: []uint64{nEmbd, rank}, Type: GGMLTypeF16},
+			GGUFTensorInfo{Name: "blk.0.ffn_gate.weight.lora_b", NDimensions: 2, Dimensions: []uint64{rank, nFF}, Type: GGMLTypeF16},
+		)
+		err := gf.ValidateLoRAAdapter(base)
+		if assert.Error(t, err) {
+			assert.ErrorContains(t, err, "tensor blk.0.attn_q.weight: lora_a or lora_b is missing")
+			assert.ErrorContains(t, err, "tensor blk.0.ffn_up.weight: incorrect shape")
+			assert.ErrorContains(t, err, "tensor blk.0.ffn_gate.weight: not found in base model")
+		}
+
+		assert.EqualError(t, base.ValidateLoRAAdapter(base), "not a LoRA adapter")
+	})
+}
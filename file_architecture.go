@@ -1,5 +1,13 @@
 package gguf_parser
 
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/constraints"
+)
+
 // GGUFArchitectureMetadata represents the architecture metadata of a GGUF file.
 type GGUFArchitectureMetadata struct {
 	/* Basic */
@@ -8,6 +16,9 @@ type GGUFArchitectureMetadata struct {
 	//
 	// All lowercase ASCII, with only [a-z0-9]+ characters allowed.
 	Architecture string `json:"architecture"`
+	// Type describes the type of the file,
+	// either "model" or "adapter".
+	Type string `json:"type"`
 	// MaximumContextLength(n_ctx_train) is the maximum context length of the model.
 	//
 	// For most architectures, this is the hard limit on the length of the input.
@@ -22,7 +33,12 @@ type GGUFArchitectureMetadata struct {
 	// This does not include the input or embedding layers.
 	BlockCount uint64 `json:"blockCount"`
 	// FeedForwardLength(n_ff) is the length of the feed-forward layer.
+	//
+	// If the model stores per-layer lengths, this is the maximum of them.
 	FeedForwardLength uint64 `json:"feedForwardLength,omitempty"`
+	// FeedForwardLengthPerLayer is the length of the feed-forward layer in each block,
+	// which is only set if the model stores per-layer lengths, e.g. OpenELM.
+	FeedForwardLengthPerLayer []uint64 `json:"feedForwardLengthPerLayer,omitempty"`
 	// ExpertFeedForwardLength(expert_feed_forward_length) is the length of the feed-forward layer in the expert model.
 	ExpertFeedForwardLength uint64 `json:"expertFeedForwardLength,omitempty"`
 	// ExpertSharedFeedForwardLength(expert_shared_feed_forward_length) is the length of the shared feed-forward layer in the expert model.
@@ -31,13 +47,33 @@ type GGUFArchitectureMetadata struct {
 	ExpertCount uint32 `json:"expertCount,omitempty"`
 	// ExpertUsedCount(n_expert_used) is the number of experts used during each token evaluation in MoE models.
 	ExpertUsedCount uint32 `json:"expertUsedCount,omitempty"`
+	// ExpertSharedCount(n_expert_shared) is the number of shared experts in MoE models,
+	// which are used for every token evaluation.
+	ExpertSharedCount uint32 `json:"expertSharedCount,omitempty"`
+	// ExpertWeightsScale(expert_weights_scale) is the scale of the routed expert weights in MoE models.
+	ExpertWeightsScale float32 `json:"expertWeightsScale,omitempty"`
+	// LeadingDenseBlockCount(n_layer_dense_lead) is the number of leading blocks,
+	// which use dense feed-forward layers instead of experts in MoE models.
+	LeadingDenseBlockCount uint64 `json:"leadingDenseBlockCount,omitempty"`
 	// AttentionHeadCount(n_head) is the number of attention heads.
+	//
+	// If the model stores per-layer counts, this is the maximum of them.
 	AttentionHeadCount uint64 `json:"attentionHeadCount,omitempty"`
+	// AttentionHeadCountPerLayer is the number of attention heads in each block,
+	// which is only set if the model stores per-layer counts, e.g. OpenELM, DeciLM.
+	//
+	// A zero count means the block has no attention.
+	AttentionHeadCountPerLayer []uint64 `json:"attentionHeadCountPerLayer,omitempty"`
 	// AttentionHeadCountKV(n_head_kv) is the number of attention heads per group used in Grouped-Query-Attention.
 	//
 	// If not provided or equal to AttentionHeadCount,
 	// the model does not use Grouped-Query-Attention.
+	//
+	// If the model stores per-layer counts, this is the maximum of them.
 	AttentionHeadCountKV uint64 `json:"attentionHeadCountKV,omitempty"`
+	// AttentionHeadCountKVPerLayer is the number of attention heads per group in each block,
+	// which is only set if the model stores per-layer counts.
+	AttentionHeadCountKVPerLayer []uint64 `json:"attentionHeadCountKVPerLayer,omitempty"`
 	// AttentionMaxALiBIBias is the maximum bias to use for ALiBI.
 	AttentionMaxALiBIBias float32 `json:"attentionMaxALiBIBias,omitempty"`
 	// AttentionClampKQV describes a value `C`,
@@ -58,6 +94,30 @@ type GGUFArchitectureMetadata struct {
 	AttentionValueLength uint32 `json:"attentionValueLength"`
 	// AttentionCausal is true if the attention is causal.
 	AttentionCausal bool `json:"attentionCausal,omitempty"`
+	// AttentionRelativeBucketsCount(n_rel_attn_bkts) is the number of buckets of the relative position bias,
+	// which is used by T5.
+	AttentionRelativeBucketsCount uint32 `json:"attentionRelativeBucketsCount,omitempty"`
+	// AttentionSlidingWindow(n_swa) is the size of the sliding window of the attention.
+	AttentionSlidingWindow uint64 `json:"attentionSlidingWindow,omitempty"`
+	// AttentionSlidingWindowPattern(n_swa_pattern) is the pattern of the sliding window layers,
+	// every N-th layer uses the global attention and the others use the sliding window,
+	// zero for all layers using the sliding window.
+	AttentionSlidingWindowPattern uint32 `json:"attentionSlidingWindowPattern,omitempty"`
+	// AttentionSlidingWindowPatternPerLayer is the flag of each layer to indicate whether using the sliding window,
+	// which overrides the AttentionSlidingWindowPattern if present.
+	AttentionSlidingWindowPatternPerLayer []bool `json:"attentionSlidingWindowPatternPerLayer,omitempty"`
+	// attentionSlidingWindowApplied indicates whether llama.cpp applies the sliding window,
+	// which is true if the pattern metadata is present or the architecture hard-codes the pattern,
+	// the other models record the sliding window but attend to the full context.
+	attentionSlidingWindowApplied bool
+	// AttentionLogitSoftcapping(f_attn_logit_softcapping) is the softcapping value of the attention logits.
+	AttentionLogitSoftcapping float32 `json:"attentionLogitSoftcapping,omitempty"`
+	// FinalLogitSoftcapping(f_final_logit_softcapping) is the softcapping value of the final logits.
+	FinalLogitSoftcapping float32 `json:"finalLogitSoftcapping,omitempty"`
+	// AttentionQueryLoRARank(n_lora_q) is the rank of the query compression in the Multi-head Latent Attention(MLA).
+	AttentionQueryLoRARank uint32 `json:"attentionQueryLoRARank,omitempty"`
+	// AttentionKeyValueLoRARank(n_lora_kv) is the rank of the key-value compression in the Multi-head Latent Attention(MLA).
+	AttentionKeyValueLoRARank uint32 `json:"attentionKeyValueLoRARank,omitempty"`
 	// RoPEDimensionCount is the number of dimensions in the RoPE(Rotary Positional Encoding).
 	RoPEDimensionCount uint64 `json:"ropeDimensionCount,omitempty"`
 	// RoPEFrequencyBase is the base frequency of the RoPE.
@@ -70,6 +130,18 @@ type GGUFArchitectureMetadata struct {
 	RoPEScalingOriginalContextLength uint64 `json:"ropeScalingOriginalContextLength,omitempty"`
 	// RoPEScalingFinetuned is true if the RoPE scaling is fine-tuned.
 	RoPEScalingFinetuned bool `json:"ropeScalingFinetuned,omitempty"`
+	// RoPEScalingAttentionFactor is the attention factor of the RoPE scaling.
+	RoPEScalingAttentionFactor float32 `json:"ropeScalingAttentionFactor,omitempty"`
+	// RoPEScalingYaRNLogMultiplier is the multiplier of the logarithmic attention factor of the YaRN RoPE scaling.
+	RoPEScalingYaRNLogMultiplier float32 `json:"ropeScalingYaRNLogMultiplier,omitempty"`
+	// RoPEScalingYaRNExtensionFactor is the extrapolation mix factor of the YaRN RoPE scaling.
+	RoPEScalingYaRNExtensionFactor float32 `json:"ropeScalingYaRNExtensionFactor,omitempty"`
+	// RoPEScalingYaRNAttentionFactor is the attention magnitude factor of the YaRN RoPE scaling.
+	RoPEScalingYaRNAttentionFactor float32 `json:"ropeScalingYaRNAttentionFactor,omitempty"`
+	// RoPEScalingYaRNBetaFast is the low correction dimension of the YaRN RoPE scaling.
+	RoPEScalingYaRNBetaFast float32 `json:"ropeScalingYaRNBetaFast,omitempty"`
+	// RoPEScalingYaRNBetaSlow is the high correction dimension of the YaRN RoPE scaling.
+	RoPEScalingYaRNBetaSlow float32 `json:"ropeScalingYaRNBetaSlow,omitempty"`
 	// SSMConvolutionKernel is the size of the convolution kernel used in the SSM(Selective State Space Model).
 	SSMConvolutionKernel uint32 `json:"ssmConvolutionKernel,omitempty"`
 	// SSMInnerSize is the embedding size of the state in SSM.
@@ -78,10 +150,41 @@ type GGUFArchitectureMetadata struct {
 	SSMStateSize uint32 `json:"ssmStateSize,omitempty"`
 	// SSMTimeStepRank is the rank of the time steps in SSM.
 	SSMTimeStepRank uint32 `json:"ssmTimeStepRank,omitempty"`
+	// SSMGroupCount is the number of groups in SSM,
+	// which is only set for Mamba-2.
+	SSMGroupCount uint32 `json:"ssmGroupCount,omitempty"`
+	// RWKVHeadSize(wkv_head_size) is the size of a head of the WKV(Weighted Key Value) in RWKV.
+	RWKVHeadSize uint32 `json:"rwkvHeadSize,omitempty"`
+	// RWKVTokenShiftCount(token_shift_count) is the number of token shifts kept in the state of RWKV.
+	RWKVTokenShiftCount uint32 `json:"rwkvTokenShiftCount,omitempty"`
+	// RWKVTimeMixExtraDimension(time_mix_extra_dim) is the extra dimension of the time mix in RWKV.
+	RWKVTimeMixExtraDimension uint32 `json:"rwkvTimeMixExtraDimension,omitempty"`
+	// RWKVTimeDecayExtraDimension(time_decay_extra_dim) is the extra dimension of the time decay in RWKV.
+	RWKVTimeDecayExtraDimension uint32 `json:"rwkvTimeDecayExtraDimension,omitempty"`
+	// RWKVRescaleEveryNLayers(rescale_every_n_layers) is the interval of layers to rescale the output in RWKV.
+	RWKVRescaleEveryNLayers uint32 `json:"rwkvRescaleEveryNLayers,omitempty"`
+	// PoolingType is the type of the pooling for the embeddings,
+	// e.g. "none", "mean", "cls", "last" or "rank".
+	//
+	// Empty if not specified.
+	PoolingType string `json:"poolingType,omitempty"`
+	// ClassifierOutputCount(n_cls_out) is the number of the outputs of the classifier head,
+	// which is the number of the scores of a reranker.
+	//
+	// Zero if the model has no classifier head.
+	ClassifierOutputCount uint64 `json:"classifierOutputCount,omitempty"`
+	// ClassifierOutputLabels is the labels of the outputs of the classifier head.
+	ClassifierOutputLabels []string `json:"classifierOutputLabels,omitempty"`
 	// VocabularyLength is the size of the vocabulary.
 	//
 	// VocabularyLength is the same as the tokenizer's token size.
 	VocabularyLength uint64 `json:"vocabularyLength"`
+	// DecoderStartTokenID is the ID of the token to start decoding in encoder-decoder models.
+	//
+	// Use -1 if the token is not found.
+	//
+	// Only used when EncoderBlockCount is not zero.
+	DecoderStartTokenID int64 `json:"decoderStartTokenID,omitempty"`
 
 	/* Appendix */
 
@@ -91,6 +194,23 @@ type GGUFArchitectureMetadata struct {
 	EmbeddingKeyGQA uint64 `json:"embeddingKeyGQA,omitempty"`
 	// EmbeddingValueGQA is the number of value GQA in the embedding layer.
 	EmbeddingValueGQA uint64 `json:"embeddingValueGQA,omitempty"`
+	// EmbeddingRollingState is the size of the rolling state of a recurrent layer per sequence,
+	// which is the convolution state in SSM or the token shift state in RWKV.
+	EmbeddingRollingState uint64 `json:"embeddingRollingState,omitempty"`
+	// EmbeddingRecurrentState is the size of the recurrent state of a recurrent layer per sequence,
+	// which is the SSM state in SSM or the WKV state in RWKV.
+	EmbeddingRecurrentState uint64 `json:"embeddingRecurrentState,omitempty"`
+	// Reranking is true if the model can rerank,
+	// which pools the sequences by rank with a classifier head.
+	Reranking bool `json:"reranking,omitempty"`
+
+	// EncoderBlockCount is the number of blocks in the encoder stack,
+	// which is only set for encoder-decoder models, like T5.
+	EncoderBlockCount uint64 `json:"encoderBlockCount,omitempty"`
+	// DecoderBlockCount is the number of blocks in the decoder stack,
+	// which is only set for encoder-decoder models,
+	// zero means an encoder-only model, like T5 encoder.
+	DecoderBlockCount uint64 `json:"decoderBlockCount,omitempty"`
 
 	// ClipHasTextEncoder indicates whether the clip model has text encoder or not.
 	//
@@ -108,6 +228,89 @@ type GGUFArchitectureMetadata struct {
 	//
 	// Only used when Architecture is "clip".
 	ClipProjectorType string `json:"clipProjectorType,omitempty"`
+	// ClipVisionImageSize is the size of the (square) image fed into the vision encoder.
+	//
+	// Only used when Architecture is "clip".
+	ClipVisionImageSize uint32 `json:"clipVisionImageSize,omitempty"`
+	// ClipVisionPatchSize is the size of the (square) patch split from the image.
+	//
+	// Only used when Architecture is "clip".
+	ClipVisionPatchSize uint32 `json:"clipVisionPatchSize,omitempty"`
+	// ClipVisionProjectionDimension is the dimension of the projected image tokens,
+	// which matches the embedding length of the model.
+	//
+	// Only used when Architecture is "clip".
+	ClipVisionProjectionDimension uint32 `json:"clipVisionProjectionDimension,omitempty"`
+	// ClipMiniCPMVVersion is the version of the MiniCPM-V resampler.
+	//
+	// Only used when ClipProjectorType is "resampler".
+	ClipMiniCPMVVersion int32 `json:"clipMiniCPMVVersion,omitempty"`
+	// ClipHasAudioEncoder indicates whether the clip model has audio encoder or not,
+	// which is a Whisper-like encoder, like Ultravox or Qwen2-Audio.
+	//
+	// Only used when Architecture is "clip".
+	ClipHasAudioEncoder bool `json:"clipHasAudioEncoder,omitempty"`
+	// ClipAudioProjectorType is the type of the projector used by the audio encoder.
+	//
+	// Only used when ClipHasAudioEncoder is true.
+	ClipAudioProjectorType string `json:"clipAudioProjectorType,omitempty"`
+	// ClipAudioEmbeddingLength is the length of the embedding layer of the audio encoder.
+	//
+	// Only used when ClipHasAudioEncoder is true.
+	ClipAudioEmbeddingLength uint64 `json:"clipAudioEmbeddingLength,omitempty"`
+	// ClipAudioBlockCount is the number of blocks of the audio encoder.
+	//
+	// Only used when ClipHasAudioEncoder is true.
+	ClipAudioBlockCount uint64 `json:"clipAudioBlockCount,omitempty"`
+	// ClipAudioFeedForwardLength is the length of the feed-forward layer of the audio encoder.
+	//
+	// Only used when ClipHasAudioEncoder is true.
+	ClipAudioFeedForwardLength uint64 `json:"clipAudioFeedForwardLength,omitempty"`
+	// ClipAudioAttentionHeadCount is the number of attention heads of the audio encoder.
+	//
+	// Only used when ClipHasAudioEncoder is true.
+	ClipAudioAttentionHeadCount uint64 `json:"clipAudioAttentionHeadCount,omitempty"`
+	// ClipAudioMelBins is the number of the mel bins of the spectrogram fed into the audio encoder.
+	//
+	// Only used when ClipHasAudioEncoder is true.
+	ClipAudioMelBins uint32 `json:"clipAudioMelBins,omitempty"`
+	// ClipAudioProjectionDimension is the dimension of the projected audio tokens,
+	// which matches the embedding length of the model.
+	//
+	// Only used when ClipHasAudioEncoder is true.
+	ClipAudioProjectionDimension uint32 `json:"clipAudioProjectionDimension,omitempty"`
+	// ClipAudioStackFactor is the number of the frames stacked into an audio token by the projector.
+	//
+	// Only used when ClipAudioProjectorType is "ultravox" or "voxtral".
+	ClipAudioStackFactor uint32 `json:"clipAudioStackFactor,omitempty"`
+
+	// DiffusionArchitecture describes what architecture the diffusion model implements,
+	// e.g. "Stable Diffusion XL".
+	//
+	// Only used when Architecture is "diffusion".
+	DiffusionArchitecture string `json:"diffusionArchitecture,omitempty"`
+	// DiffusionTransformer indicates whether the diffusion model is a transformer(DiT) or a UNet.
+	//
+	// Only used when Architecture is "diffusion".
+	DiffusionTransformer bool `json:"diffusionTransformer,omitempty"`
+	// DiffusionConditioners is the list of the conditioners(text encoders) bundled in the file.
+	//
+	// Only used when Architecture is "diffusion".
+	DiffusionConditioners []string `json:"diffusionConditioners,omitempty"`
+	// DiffusionHasAutoencoder indicates whether the autoencoder(VAE) is bundled in the file or not.
+	//
+	// Only used when Architecture is "diffusion".
+	DiffusionHasAutoencoder bool `json:"diffusionHasAutoencoder,omitempty"`
+
+	// AdapterType is the type of the adapter, e.g. "lora".
+	//
+	// Only used when Type is "adapter".
+	AdapterType string `json:"adapterType,omitempty"`
+	// AdapterLoRAAlpha is the alpha value of the LoRA adapter,
+	// which is used to scale the adapter weights with the rank.
+	//
+	// Only used when AdapterType is "lora".
+	AdapterLoRAAlpha float32 `json:"adapterLoRAAlpha,omitempty"`
 }
 
 // Architecture returns the architecture metadata of the GGUF file.
@@ -115,21 +318,151 @@ func (gf *GGUFFile) Architecture() (ga GGUFArchitectureMetadata) {
 	arch := "llama"
 	if v, ok := gf.Header.MetadataKV.Get("general.architecture"); ok {
 		arch = v.ValueString()
+	} else if gf.isDiffusion() {
+		arch = "diffusion"
 	}
 
+	if v, ok := gf.Header.MetadataKV.Get("general.type"); ok && v.ValueString() == "adapter" {
+		return gf.adapterArchitecture(arch)
+	}
 	if arch == "clip" {
 		return gf.clipArchitecture()
 	}
+	if arch == "diffusion" || slices.Contains(_GGUFDiffusionArchitectures, arch) {
+		return gf.diffusionArchitecture(arch)
+	}
 	return gf.transformArchitecture(arch)
 }
 
+// _GGUFDiffusionArchitectures holds the architectures of the diffusion models,
+// which are written by the ComfyUI-GGUF converter.
+var _GGUFDiffusionArchitectures = []string{
+	"sd1",
+	"sdxl",
+	"sd3",
+	"flux",
+	"aura",
+}
+
+// _GGUFDiffusionConditioners holds the tensor name prefixes and names of the conditioners,
+// which are bundled in the stable-diffusion.cpp checkpoints.
+var _GGUFDiffusionConditioners = [][2]string{
+	{"cond_stage_model.transformer.", "CLIP ViT-L/14"},
+	{"cond_stage_model.model.", "OpenCLIP ViT-H/14"},
+	{"conditioner.embedders.0.", "CLIP ViT-L/14"},
+	{"conditioner.embedders.1.", "OpenCLIP ViT-bigG/14"},
+	{"text_encoders.clip_l.", "CLIP ViT-L/14"},
+	{"text_encoders.clip_g.", "OpenCLIP ViT-bigG/14"},
+	{"text_encoders.t5xxl.", "T5-XXL"},
+}
+
+// isDiffusion returns true if the GGUF file is a stable-diffusion.cpp checkpoint,
+// which has no architecture metadata but the diffusion model tensors.
+func (gf *GGUFFile) isDiffusion() bool {
+	return slices.ContainsFunc(gf.TensorInfos, func(ti GGUFTensorInfo) bool {
+		return strings.HasPrefix(ti.Name, "model.diffusion_model.")
+	})
+}
+
+func (gf *GGUFFile) diffusionArchitecture(arch string) (ga GGUFArchitectureMetadata) {
+	ga.Architecture = "diffusion"
+	ga.Type = "model"
+
+	switch arch {
+	case "sd1":
+		ga.DiffusionArchitecture = "Stable Diffusion 1.x"
+	case "sdxl":
+		ga.DiffusionArchitecture = "Stable Diffusion XL"
+	case "sd3":
+		ga.DiffusionArchitecture = "Stable Diffusion 3.x"
+		ga.DiffusionTransformer = true
+	case "flux":
+		ga.DiffusionArchitecture = "FLUX.1"
+		ga.DiffusionTransformer = true
+	case "aura":
+		ga.DiffusionArchitecture = "AuraFlow"
+		ga.DiffusionTransformer = true
+	}
+
+	var hasFlux, hasSD3, hasSDXL, hasSD2 bool
+	for _, ti := range gf.TensorInfos {
+		switch {
+		case strings.HasPrefix(ti.Name, "first_stage_model."), strings.HasPrefix(ti.Name, "vae."):
+			ga.DiffusionHasAutoencoder = true
+		case strings.HasPrefix(ti.Name, "cond_stage_model."), strings.HasPrefix(ti.Name, "conditioner."),
+			strings.HasPrefix(ti.Name, "text_encoders."):
+			for _, c := range _GGUFDiffusionConditioners {
+				if strings.HasPrefix(ti.Name, c[0]) && !slices.Contains(ga.DiffusionConditioners, c[1]) {
+					ga.DiffusionConditioners = append(ga.DiffusionConditioners, c[1])
+				}
+			}
+		default:
+			n := strings.TrimPrefix(ti.Name, "model.diffusion_model.")
+			hasFlux = hasFlux || strings.HasPrefix(n, "double_blocks.")
+			hasSD3 = hasSD3 || strings.HasPrefix(n, "joint_blocks.")
+			hasSDXL = hasSDXL || strings.HasPrefix(n, "label_emb.")
+			// The cross attention of SD 2.x takes the 1024 dimensions of OpenCLIP.
+			hasSD2 = hasSD2 || n == "input_blocks.1.1.transformer_blocks.0.attn2.to_k.weight" && ti.Dimensions[0] == 1024
+		}
+	}
+
+	// Detect the version as stable-diffusion.cpp does,
+	// if the architecture metadata is missing.
+	if ga.DiffusionArchitecture == "" {
+		switch {
+		case hasFlux:
+			ga.DiffusionArchitecture = "FLUX.1"
+			ga.DiffusionTransformer = true
+		case hasSD3:
+			ga.DiffusionArchitecture = "Stable Diffusion 3.x"
+			ga.DiffusionTransformer = true
+		case hasSDXL:
+			ga.DiffusionArchitecture = "Stable Diffusion XL"
+		case hasSD2:
+			ga.DiffusionArchitecture = "Stable Diffusion 2.x"
+		default:
+			ga.DiffusionArchitecture = "Stable Diffusion 1.x"
+		}
+	}
+
+	return ga
+}
+
+func (gf *GGUFFile) adapterArchitecture(arch string) (ga GGUFArchitectureMetadata) {
+	var (
+		typeKey      = "adapter.type"
+		loraAlphaKey = "adapter.lora.alpha"
+	)
+
+	ga.Architecture = arch
+	ga.Type = "adapter"
+
+	m, _ := gf.Header.MetadataKV.Index([]string{
+		typeKey,
+		loraAlphaKey,
+	})
+
+	if v, ok := m[typeKey]; ok {
+		ga.AdapterType = v.ValueString()
+	}
+	if v, ok := m[loraAlphaKey]; ok {
+		ga.AdapterLoRAAlpha = ValueNumeric[float32](v)
+	}
+
+	return ga
+}
+
 func (gf *GGUFFile) clipArchitecture() (ga GGUFArchitectureMetadata) {
 	var (
 		hasTextEncoderKey    = "clip.has_text_encoder"
 		hasVisionEncoderKey  = "clip.has_vision_encoder"
 		hasLLaVaProjectorKey = "clip.has_llava_projector"
+		hasAudioEncoderKey   = "clip.has_audio_encoder"
 		projectorTypeKey     = "clip.projector_type"
 
+		visionProjectorTypeKey = "clip.vision.projector_type"
+		audioProjectorTypeKey  = "clip.audio.projector_type"
+
 		textEmbeddingLengthKey              = "clip.text.embedding_length"
 		textBlockCountKey                   = "clip.text.block_count"
 		textFeedForwardLengthKey            = "clip.text.feed_forward_length"
@@ -141,15 +474,31 @@ func (gf *GGUFFile) clipArchitecture() (ga GGUFArchitectureMetadata) {
 		visionFeedForwardLengthKey            = "clip.vision.feed_forward_length"
 		visionAttentionHeadCountKey           = "clip.vision.attention.head_count"
 		visionAttentionLayerNormRMSEpsilonKey = "clip.vision.attention.layer_norm_epsilon"
+		visionImageSizeKey                    = "clip.vision.image_size"
+		visionPatchSizeKey                    = "clip.vision.patch_size"
+		visionProjectionDimKey                = "clip.vision.projection_dim"
+		miniCPMVVersionKey                    = "clip.minicpmv_version"
+
+		audioEmbeddingLengthKey    = "clip.audio.embedding_length"
+		audioBlockCountKey         = "clip.audio.block_count"
+		audioFeedForwardLengthKey  = "clip.audio.feed_forward_length"
+		audioAttentionHeadCountKey = "clip.audio.attention.head_count"
+		audioMelBinsKey            = "clip.audio.num_mel_bins"
+		audioProjectionDimKey      = "clip.audio.projection_dim"
+		audioStackFactorKey        = "clip.audio.projector.stack_factor"
 	)
 
 	ga.Architecture = "clip"
+	ga.Type = "model"
 
 	m, _ := gf.Header.MetadataKV.Index([]string{
 		hasTextEncoderKey,
 		hasVisionEncoderKey,
 		hasLLaVaProjectorKey,
+		hasAudioEncoderKey,
 		projectorTypeKey,
+		visionProjectorTypeKey,
+		audioProjectorTypeKey,
 		textEmbeddingLengthKey,
 		textBlockCountKey,
 		textFeedForwardLengthKey,
@@ -160,6 +509,17 @@ func (gf *GGUFFile) clipArchitecture() (ga GGUFArchitectureMetadata) {
 		visionFeedForwardLengthKey,
 		visionAttentionHeadCountKey,
 		visionAttentionLayerNormRMSEpsilonKey,
+		visionImageSizeKey,
+		visionPatchSizeKey,
+		visionProjectionDimKey,
+		miniCPMVVersionKey,
+		audioEmbeddingLengthKey,
+		audioBlockCountKey,
+		audioFeedForwardLengthKey,
+		audioAttentionHeadCountKey,
+		audioMelBinsKey,
+		audioProjectionDimKey,
+		audioStackFactorKey,
 	})
 
 	if v, ok := m[hasTextEncoderKey]; ok {
@@ -171,8 +531,13 @@ func (gf *GGUFFile) clipArchitecture() (ga GGUFArchitectureMetadata) {
 	if v, ok := m[hasLLaVaProjectorKey]; ok {
 		ga.ClipHasLLaVaProjector = v.ValueBool()
 	}
+	if v, ok := m[hasAudioEncoderKey]; ok {
+		ga.ClipHasAudioEncoder = v.ValueBool()
+	}
 	if v, ok := m[projectorTypeKey]; ok {
 		ga.ClipProjectorType = v.ValueString()
+	} else if v, ok = m[visionProjectorTypeKey]; ok {
+		ga.ClipProjectorType = v.ValueString()
 	} else {
 		ga.ClipProjectorType = "mlp"
 	}
@@ -208,6 +573,56 @@ func (gf *GGUFFile) clipArchitecture() (ga GGUFArchitectureMetadata) {
 	if v, ok := m[visionAttentionLayerNormRMSEpsilonKey]; ok {
 		ga.AttentionLayerNormRMSEpsilon = ValueNumeric[float32](v)
 	}
+	if v, ok := m[visionImageSizeKey]; ok {
+		ga.ClipVisionImageSize = ValueNumeric[uint32](v)
+	}
+	if v, ok := m[visionPatchSizeKey]; ok {
+		ga.ClipVisionPatchSize = ValueNumeric[uint32](v)
+	}
+	if v, ok := m[visionProjectionDimKey]; ok {
+		ga.ClipVisionProjectionDimension = ValueNumeric[uint32](v)
+	}
+	if v, ok := m[miniCPMVVersionKey]; ok {
+		ga.ClipMiniCPMVVersion = ValueNumeric[int32](v)
+	}
+
+	if ga.ClipHasAudioEncoder {
+		// The audio only projectors are written as the projector type.
+		if v, ok := m[audioProjectorTypeKey]; ok {
+			ga.ClipAudioProjectorType = v.ValueString()
+		} else if !ga.ClipHasVisionEncoder {
+			ga.ClipAudioProjectorType = ga.ClipProjectorType
+		}
+		if v, ok := m[audioEmbeddingLengthKey]; ok {
+			ga.ClipAudioEmbeddingLength = ValueNumeric[uint64](v)
+		}
+		if v, ok := m[audioBlockCountKey]; ok {
+			ga.ClipAudioBlockCount = ValueNumeric[uint64](v)
+		}
+		if v, ok := m[audioFeedForwardLengthKey]; ok {
+			ga.ClipAudioFeedForwardLength = ValueNumeric[uint64](v)
+		}
+		if v, ok := m[audioAttentionHeadCountKey]; ok {
+			ga.ClipAudioAttentionHeadCount = ValueNumeric[uint64](v)
+		}
+		if v, ok := m[audioMelBinsKey]; ok {
+			ga.ClipAudioMelBins = ValueNumeric[uint32](v)
+		}
+		if v, ok := m[audioProjectionDimKey]; ok {
+			ga.ClipAudioProjectionDimension = ValueNumeric[uint32](v)
+		}
+		if v, ok := m[audioStackFactorKey]; ok {
+			ga.ClipAudioStackFactor = ValueNumeric[uint32](v)
+		}
+
+		// Audio only models take the hyperparameters of the audio encoder.
+		if !ga.ClipHasVisionEncoder && !ga.ClipHasTextEncoder {
+			ga.EmbeddingLength = ga.ClipAudioEmbeddingLength
+			ga.BlockCount = ga.ClipAudioBlockCount
+			ga.FeedForwardLength = ga.ClipAudioFeedForwardLength
+			ga.AttentionHeadCount = ga.ClipAudioAttentionHeadCount
+		}
+	}
 
 	ga.AttentionHeadCountKV = ga.AttentionHeadCount
 
@@ -239,18 +654,28 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 		expertSharedFeedForwardLengthKey = arch + ".expert_shared_feed_forward_length"
 		expertCountKey                   = arch + ".expert_count"
 		expertUsedCountKey               = arch + ".expert_used_count"
+		expertSharedCountKey             = arch + ".expert_shared_count"
+		expertWeightsScaleKey            = arch + ".expert_weights_scale"
+		leadingDenseBlockCountKey        = arch + ".leading_dense_block_count"
 
-		attentionHeadCountKey           = arch + ".attention.head_count"
-		attentionHeadCountKVKey         = arch + ".attention.head_count_kv"
-		attentionMaxALiBIBiasKey        = arch + ".attention.max_alibi_bias"
-		attentionMaxALiBIBiasKey2       = arch + ".attention.alibi_bias_max"
-		attentionClampKQVKey            = arch + ".attention.clamp_kqv"
-		attentionClampKQVKey2           = arch + ".attention.clip_kqv"
-		attentionLayerNormEpsilonKey    = arch + ".attention.layer_norm_epsilon"
-		attentionLayerNormRMSEpsilonKey = arch + ".attention.layer_norm_rms_epsilon"
-		attentionKeyLengthKey           = arch + ".attention.key_length"
-		attentionValueLengthKey         = arch + ".attention.value_length"
-		attentionCausalKey              = arch + ".attention.causal"
+		attentionHeadCountKey            = arch + ".attention.head_count"
+		attentionHeadCountKVKey          = arch + ".attention.head_count_kv"
+		attentionMaxALiBIBiasKey         = arch + ".attention.max_alibi_bias"
+		attentionMaxALiBIBiasKey2        = arch + ".attention.alibi_bias_max"
+		attentionClampKQVKey             = arch + ".attention.clamp_kqv"
+		attentionClampKQVKey2            = arch + ".attention.clip_kqv"
+		attentionLayerNormEpsilonKey     = arch + ".attention.layer_norm_epsilon"
+		attentionLayerNormRMSEpsilonKey  = arch + ".attention.layer_norm_rms_epsilon"
+		attentionKeyLengthKey            = arch + ".attention.key_length"
+		attentionValueLengthKey          = arch + ".attention.value_length"
+		attentionCausalKey               = arch + ".attention.causal"
+		attentionRelativeBucketsKey      = arch + ".attention.relative_buckets_count"
+		attentionSlidingWindowKey        = arch + ".attention.sliding_window"
+		attentionSlidingWindowPatternKey = arch + ".attention.sliding_window_pattern"
+		attentionLogitSoftcappingKey     = arch + ".attn_logit_softcapping"
+		finalLogitSoftcappingKey         = arch + ".final_logit_softcapping"
+		attentionQueryLoRARankKey        = arch + ".attention.q_lora_rank"
+		attentionKeyValueLoRARankKey     = arch + ".attention.kv_lora_rank"
 
 		ropeDimensionCountKey         = arch + ".rope.dimension_count"
 		ropeFrequencyBaseKey          = arch + ".rope.freq_base"
@@ -259,25 +684,48 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 		ropeScalingFactorKey          = arch + ".rope.scaling.factor"
 		ropeScalingOriginalContextKey = arch + ".rope.scaling.original_context_length" // uint32 maybe
 		ropeScalingFinetunedKey       = arch + ".rope.scaling.finetuned"
+		ropeScalingAttnFactorKey      = arch + ".rope.scaling.attn_factor"
+		ropeScalingYaRNLogMulKey      = arch + ".rope.scaling.yarn_log_multiplier"
+		ropeScalingYaRNExtFactorKey   = arch + ".rope.scaling.yarn_ext_factor"
+		ropeScalingYaRNAttnFactorKey  = arch + ".rope.scaling.yarn_attn_factor"
+		ropeScalingYaRNBetaFastKey    = arch + ".rope.scaling.yarn_beta_fast"
+		ropeScalingYaRNBetaSlowKey    = arch + ".rope.scaling.yarn_beta_slow"
 
 		ssmConvolutionKernelKey = arch + ".ssm.conv_kernel"
 		ssmInnerSizeKey         = arch + ".ssm.inner_size"
 		ssmStateSizeKey         = arch + ".ssm.state_size"
 		ssmTimeStepRankKey      = arch + ".ssm.time_step_rank"
+		ssmGroupCountKey        = arch + ".ssm.group_count"
+
+		rwkvHeadSizeKey            = arch + ".wkv.head_size"
+		rwkvTokenShiftCountKey     = arch + ".token_shift_count"
+		rwkvTimeMixExtraDimKey     = arch + ".time_mix_extra_dim"
+		rwkvTimeDecayExtraDimKey   = arch + ".time_decay_extra_dim"
+		rwkvRescaleEveryNLayersKey = arch + ".rescale_every_n_layers"
 
-		vocabularyLengthKey    = arch + ".vocab_size"
-		tokenizerGGMLTokensKey = "tokenizer.ggml.tokens"
+		poolingTypeKey            = arch + ".pooling_type"
+		classifierOutputLabelsKey = arch + ".classifier.output_labels"
+		decoderStartTokenIDKey    = arch + ".decoder_start_token_id"
+		decoderBlockCountKey      = arch + ".decoder_block_count"
+		vocabularyLengthKey       = arch + ".vocab_size"
+		tokenizerGGMLTokensKey    = "tokenizer.ggml.tokens"
 	)
 
 	ga.Architecture = arch
+	ga.Type = "model"
 
 	m, _ := gf.Header.MetadataKV.Index([]string{
 		contextLengthKey,
 		embeddingLengthKey,
 		blockCountKey,
 		feedForwardLengthKey,
+		expertFeedForwardLengthKey,
+		expertSharedFeedForwardLengthKey,
 		expertCountKey,
 		expertUsedCountKey,
+		expertSharedCountKey,
+		expertWeightsScaleKey,
+		leadingDenseBlockCountKey,
 		attentionHeadCountKey,
 		attentionHeadCountKVKey,
 		attentionMaxALiBIBiasKey,
@@ -289,6 +737,13 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 		attentionKeyLengthKey,
 		attentionValueLengthKey,
 		attentionCausalKey,
+		attentionRelativeBucketsKey,
+		attentionSlidingWindowKey,
+		attentionSlidingWindowPatternKey,
+		attentionLogitSoftcappingKey,
+		finalLogitSoftcappingKey,
+		attentionQueryLoRARankKey,
+		attentionKeyValueLoRARankKey,
 		ropeDimensionCountKey,
 		ropeFrequencyBaseKey,
 		ropeScaleLinearKey,
@@ -296,10 +751,26 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 		ropeScalingFactorKey,
 		ropeScalingOriginalContextKey,
 		ropeScalingFinetunedKey,
+		ropeScalingAttnFactorKey,
+		ropeScalingYaRNLogMulKey,
+		ropeScalingYaRNExtFactorKey,
+		ropeScalingYaRNAttnFactorKey,
+		ropeScalingYaRNBetaFastKey,
+		ropeScalingYaRNBetaSlowKey,
 		ssmConvolutionKernelKey,
 		ssmInnerSizeKey,
 		ssmStateSizeKey,
 		ssmTimeStepRankKey,
+		ssmGroupCountKey,
+		rwkvHeadSizeKey,
+		rwkvTokenShiftCountKey,
+		rwkvTimeMixExtraDimKey,
+		rwkvTimeDecayExtraDimKey,
+		rwkvRescaleEveryNLayersKey,
+		poolingTypeKey,
+		classifierOutputLabelsKey,
+		decoderStartTokenIDKey,
+		decoderBlockCountKey,
 		vocabularyLengthKey,
 		tokenizerGGMLTokensKey,
 	})
@@ -314,7 +785,7 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 		ga.BlockCount = ValueNumeric[uint64](v)
 	}
 	if v, ok := m[feedForwardLengthKey]; ok {
-		ga.FeedForwardLength = ValueNumeric[uint64](v)
+		ga.FeedForwardLength, ga.FeedForwardLengthPerLayer = valueNumericPerLayer[uint64](v)
 	}
 
 	if v, ok := m[expertCountKey]; ok {
@@ -329,14 +800,28 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 	if v, ok := m[expertSharedFeedForwardLengthKey]; ok {
 		ga.ExpertSharedFeedForwardLength = ValueNumeric[uint64](v)
 	}
+	if v, ok := m[expertSharedCountKey]; ok {
+		ga.ExpertSharedCount = ValueNumeric[uint32](v)
+	}
+	if v, ok := m[expertWeightsScaleKey]; ok {
+		ga.ExpertWeightsScale = ValueNumeric[float32](v)
+	}
+	if v, ok := m[leadingDenseBlockCountKey]; ok {
+		ga.LeadingDenseBlockCount = ValueNumeric[uint64](v)
+	}
 
 	if v, ok := m[attentionHeadCountKey]; ok {
-		ga.AttentionHeadCount = ValueNumeric[uint64](v)
+		ga.AttentionHeadCount, ga.AttentionHeadCountPerLayer = valueNumericPerLayer[uint64](v)
 	}
 	if v, ok := m[attentionHeadCountKVKey]; ok {
-		ga.AttentionHeadCountKV = ValueNumeric[uint64](v)
+		ga.AttentionHeadCountKV, ga.AttentionHeadCountKVPerLayer = valueNumericPerLayer[uint64](v)
 	} else {
-		ga.AttentionHeadCountKV = ga.AttentionHeadCount
+		ga.AttentionHeadCountKV, ga.AttentionHeadCountKVPerLayer = ga.AttentionHeadCount, ga.AttentionHeadCountPerLayer
+	}
+	// Like llama.cpp, the head size defaults to the first layer's.
+	nHead := ga.AttentionHeadCount
+	if len(ga.AttentionHeadCountPerLayer) != 0 {
+		nHead = ga.AttentionHeadCountPerLayer[0]
 	}
 	if v, ok := m[attentionMaxALiBIBiasKey]; ok {
 		ga.AttentionMaxALiBIBias = ValueNumeric[float32](v)
@@ -356,18 +841,58 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 	}
 	if v, ok := m[attentionKeyLengthKey]; ok {
 		ga.AttentionKeyLength = ValueNumeric[uint32](v)
-	} else if ga.AttentionHeadCount != 0 {
-		ga.AttentionKeyLength = uint32(ga.EmbeddingLength / ga.AttentionHeadCount)
+	} else if nHead != 0 {
+		ga.AttentionKeyLength = uint32(ga.EmbeddingLength / nHead)
 	}
 	if v, ok := m[attentionValueLengthKey]; ok {
 		ga.AttentionValueLength = ValueNumeric[uint32](v)
-	} else if ga.AttentionHeadCount != 0 {
-		ga.AttentionValueLength = uint32(ga.EmbeddingLength / ga.AttentionHeadCount)
+	} else if nHead != 0 {
+		ga.AttentionValueLength = uint32(ga.EmbeddingLength / nHead)
 	}
 	if v, ok := m[attentionCausalKey]; ok {
 		ga.AttentionCausal = v.ValueBool()
 	} else {
-		ga.AttentionCausal = true
+		ga.AttentionCausal = arch != "t5encoder"
+	}
+	if v, ok := m[attentionRelativeBucketsKey]; ok {
+		ga.AttentionRelativeBucketsCount = ValueNumeric[uint32](v)
+	}
+	if v, ok := m[attentionSlidingWindowKey]; ok {
+		ga.AttentionSlidingWindow = ValueNumeric[uint64](v)
+		// The models without the pattern metadata hard-code it in llama.cpp.
+		switch arch {
+		case "gemma2":
+			ga.AttentionSlidingWindowPattern = 2
+			ga.attentionSlidingWindowApplied = true
+		case "gemma3":
+			ga.AttentionSlidingWindowPattern = 6
+			ga.attentionSlidingWindowApplied = true
+		case "cohere2":
+			ga.AttentionSlidingWindowPattern = 4
+			ga.attentionSlidingWindowApplied = true
+		}
+	}
+	if v, ok := m[attentionSlidingWindowPatternKey]; ok {
+		ga.attentionSlidingWindowApplied = true
+		if v.ValueType == GGUFMetadataValueTypeArray {
+			if av := v.ValueArray(); av.Type == GGUFMetadataValueTypeBool && uint64(len(av.Array)) == av.Len {
+				ga.AttentionSlidingWindowPatternPerLayer = av.ValuesBool()
+			}
+		} else {
+			ga.AttentionSlidingWindowPattern = ValueNumeric[uint32](v)
+		}
+	}
+	if v, ok := m[attentionLogitSoftcappingKey]; ok {
+		ga.AttentionLogitSoftcapping = ValueNumeric[float32](v)
+	}
+	if v, ok := m[finalLogitSoftcappingKey]; ok {
+		ga.FinalLogitSoftcapping = ValueNumeric[float32](v)
+	}
+	if v, ok := m[attentionQueryLoRARankKey]; ok {
+		ga.AttentionQueryLoRARank = ValueNumeric[uint32](v)
+	}
+	if v, ok := m[attentionKeyValueLoRARankKey]; ok {
+		ga.AttentionKeyValueLoRARank = ValueNumeric[uint32](v)
 	}
 
 	if v, ok := m[ropeDimensionCountKey]; ok {
@@ -392,6 +917,24 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 	if v, ok := m[ropeScalingFinetunedKey]; ok {
 		ga.RoPEScalingFinetuned = v.ValueBool()
 	}
+	if v, ok := m[ropeScalingAttnFactorKey]; ok {
+		ga.RoPEScalingAttentionFactor = ValueNumeric[float32](v)
+	}
+	if v, ok := m[ropeScalingYaRNLogMulKey]; ok {
+		ga.RoPEScalingYaRNLogMultiplier = ValueNumeric[float32](v)
+	}
+	if v, ok := m[ropeScalingYaRNExtFactorKey]; ok {
+		ga.RoPEScalingYaRNExtensionFactor = ValueNumeric[float32](v)
+	}
+	if v, ok := m[ropeScalingYaRNAttnFactorKey]; ok {
+		ga.RoPEScalingYaRNAttentionFactor = ValueNumeric[float32](v)
+	}
+	if v, ok := m[ropeScalingYaRNBetaFastKey]; ok {
+		ga.RoPEScalingYaRNBetaFast = ValueNumeric[float32](v)
+	}
+	if v, ok := m[ropeScalingYaRNBetaSlowKey]; ok {
+		ga.RoPEScalingYaRNBetaSlow = ValueNumeric[float32](v)
+	}
 
 	if v, ok := m[ssmConvolutionKernelKey]; ok {
 		ga.SSMConvolutionKernel = ValueNumeric[uint32](v)
@@ -405,7 +948,58 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 	if v, ok := m[ssmTimeStepRankKey]; ok {
 		ga.SSMTimeStepRank = ValueNumeric[uint32](v)
 	}
+	if v, ok := m[ssmGroupCountKey]; ok {
+		ga.SSMGroupCount = ValueNumeric[uint32](v)
+	}
+
+	if v, ok := m[rwkvHeadSizeKey]; ok {
+		ga.RWKVHeadSize = ValueNumeric[uint32](v)
+		ga.RWKVTokenShiftCount = 2
+	}
+	if v, ok := m[rwkvTokenShiftCountKey]; ok {
+		ga.RWKVTokenShiftCount = ValueNumeric[uint32](v)
+	}
+	if v, ok := m[rwkvTimeMixExtraDimKey]; ok {
+		ga.RWKVTimeMixExtraDimension = ValueNumeric[uint32](v)
+	}
+	if v, ok := m[rwkvTimeDecayExtraDimKey]; ok {
+		ga.RWKVTimeDecayExtraDimension = ValueNumeric[uint32](v)
+	}
+	if v, ok := m[rwkvRescaleEveryNLayersKey]; ok {
+		ga.RWKVRescaleEveryNLayers = ValueNumeric[uint32](v)
+	}
 
+	if v, ok := m[poolingTypeKey]; ok {
+		ga.PoolingType = toPoolingType(ValueNumeric[int32](v))
+	}
+	// The classifier head outputs a score per label,
+	// or a single score if the labels are not specified, like the `n_cls_out` of llama.cpp.
+	if v, ok := m[classifierOutputLabelsKey]; ok {
+		av := v.ValueArray()
+		ga.ClassifierOutputCount = av.Len
+		if av.Type == GGUFMetadataValueTypeString && uint64(len(av.Array)) == av.Len {
+			ga.ClassifierOutputLabels = av.ValuesString()
+		}
+	}
+	if ga.ClassifierOutputCount == 0 && slices.ContainsFunc(gf.TensorInfos, func(ti GGUFTensorInfo) bool {
+		return ti.Name == "cls.weight" || ti.Name == "cls.output.weight"
+	}) {
+		ga.ClassifierOutputCount = 1
+	}
+	switch arch {
+	case "t5", "t5encoder":
+		ga.EncoderBlockCount = ga.BlockCount
+		if arch == "t5" {
+			ga.DecoderBlockCount = ga.BlockCount
+			if v, ok := m[decoderBlockCountKey]; ok {
+				ga.DecoderBlockCount = ValueNumeric[uint64](v)
+			}
+		}
+		ga.DecoderStartTokenID = -1
+		if v, ok := m[decoderStartTokenIDKey]; ok {
+			ga.DecoderStartTokenID = ValueNumeric[int64](v)
+		}
+	}
 	if v, ok := m[vocabularyLengthKey]; ok {
 		ga.VocabularyLength = ValueNumeric[uint64](v)
 	} else if v, ok := m[tokenizerGGMLTokensKey]; ok {
@@ -420,11 +1014,123 @@ func (gf *GGUFFile) transformArchitecture(arch string) (ga GGUFArchitectureMetad
 			ga.EmbeddingKeyGQA = uint64(ga.AttentionKeyLength) * ga.AttentionHeadCountKV
 			ga.EmbeddingValueGQA = uint64(ga.AttentionValueLength) * ga.AttentionHeadCountKV
 		}
-		if ga.Architecture == "mamba" {
-			ga.EmbeddingKeyGQA = uint64((ga.SSMConvolutionKernel - 1) * ga.SSMInnerSize)
-			ga.EmbeddingValueGQA = uint64(ga.SSMStateSize * ga.SSMInnerSize)
+		// MLA caches the compressed key-value and the RoPE key shared by all heads.
+		if ga.AttentionKeyValueLoRARank > 0 {
+			ga.EmbeddingKeyGQA = uint64(ga.AttentionKeyValueLoRARank) + ga.RoPEDimensionCount
+			ga.EmbeddingValueGQA = uint64(ga.AttentionKeyValueLoRARank)
 		}
+		switch {
+		case ga.SSMInnerSize > 0:
+			// The convolution covers the inner states and the B/C of each group.
+			ga.EmbeddingRollingState = uint64(max(ga.SSMConvolutionKernel, 1)-1) *
+				(uint64(ga.SSMInnerSize) + 2*uint64(ga.SSMGroupCount)*uint64(ga.SSMStateSize))
+			ga.EmbeddingRecurrentState = uint64(ga.SSMStateSize) * uint64(ga.SSMInnerSize)
+		case ga.RWKVHeadSize > 0:
+			// The token shift states and the WKV state.
+			ga.EmbeddingRollingState = uint64(ga.RWKVTokenShiftCount) * ga.EmbeddingLength
+			ga.EmbeddingRecurrentState = uint64(ga.RWKVHeadSize) * ga.EmbeddingLength
+		}
+		// Pure recurrent models have no attention,
+		// so the states take the place of the key and value.
+		if ga.AttentionHeadCount == 0 && ga.EmbeddingRecurrentState > 0 {
+			ga.EmbeddingKeyGQA = ga.EmbeddingRollingState
+			ga.EmbeddingValueGQA = ga.EmbeddingRecurrentState
+		}
+		ga.Reranking = ga.PoolingType == "rank" && ga.ClassifierOutputCount > 0
 	}
 
 	return ga
 }
+
+// toPoolingType converts the llama.cpp `enum llama_pooling_type` to its name.
+func toPoolingType(v int32) string {
+	switch v {
+	case -1:
+		return "unspecified"
+	case 0:
+		return "none"
+	case 1:
+		return "mean"
+	case 2:
+		return "cls"
+	case 3:
+		return "last"
+	case 4:
+		return "rank"
+	}
+	return strconv.FormatInt(int64(v), 10)
+}
+
+// valueNumericPerLayer returns the numeric value of the GGUFMetadataKV,
+// if the GGUFMetadataKV is a per-layer array,
+// returns the maximum of the array and the array itself.
+func valueNumericPerLayer[T constraints.Integer | constraints.Float](kv GGUFMetadataKV) (T, []T) {
+	if kv.ValueType != GGUFMetadataValueTypeArray {
+		return ValueNumeric[T](kv), nil
+	}
+	av := kv.ValueArray()
+	if uint64(len(av.Array)) != av.Len || av.Len == 0 {
+		return 0, nil
+	}
+	vs := ValuesNumeric[T](av)
+	return slices.Max(vs), vs
+}
+
+// AttentionSlidingWindowOf returns the size of the sliding window of the given layer,
+// or zero if the layer uses the global attention,
+// or the model does not apply the sliding window, see attentionSlidingWindowApplied.
+func (ga GGUFArchitectureMetadata) AttentionSlidingWindowOf(il uint64) uint64 {
+	if ga.AttentionSlidingWindow == 0 || !ga.attentionSlidingWindowApplied {
+		return 0
+	}
+	if len(ga.AttentionSlidingWindowPatternPerLayer) != 0 {
+		if il < uint64(len(ga.AttentionSlidingWindowPatternPerLayer)) && ga.AttentionSlidingWindowPatternPerLayer[il] {
+			return ga.AttentionSlidingWindow
+		}
+		return 0
+	}
+	if n := uint64(ga.AttentionSlidingWindowPattern); n == 0 || il%n < n-1 {
+		return ga.AttentionSlidingWindow
+	}
+	return 0
+}
+
+// AttentionHeadCountOf returns the number of attention heads of the given layer.
+func (ga GGUFArchitectureMetadata) AttentionHeadCountOf(il uint64) uint64 {
+	if il < uint64(len(ga.AttentionHeadCountPerLayer)) {
+		return ga.AttentionHeadCountPerLayer[il]
+	}
+	return ga.AttentionHeadCount
+}
+
+// AttentionHeadCountKVOf returns the number of attention heads per group of the given layer.
+func (ga GGUFArchitectureMetadata) AttentionHeadCountKVOf(il uint64) uint64 {
+	if il < uint64(len(ga.AttentionHeadCountKVPerLayer)) {
+		return ga.AttentionHeadCountKVPerLayer[il]
+	}
+	return ga.AttentionHeadCountKV
+}
+
+// FeedForwardLengthOf returns the length of the feed-forward layer of the given layer.
+func (ga GGUFArchitectureMetadata) FeedForwardLengthOf(il uint64) uint64 {
+	if il < uint64(len(ga.FeedForwardLengthPerLayer)) {
+		return ga.FeedForwardLengthPerLayer[il]
+	}
+	return ga.FeedForwardLength
+}
+
+// EmbeddingKeyGQAOf returns the number of key GQA of the given layer.
+func (ga GGUFArchitectureMetadata) EmbeddingKeyGQAOf(il uint64) uint64 {
+	if len(ga.AttentionHeadCountKVPerLayer) == 0 {
+		return ga.EmbeddingKeyGQA
+	}
+	return uint64(ga.AttentionKeyLength) * ga.AttentionHeadCountKVOf(il)
+}
+
+// EmbeddingValueGQAOf returns the number of value GQA of the given layer.
+func (ga GGUFArchitectureMetadata) EmbeddingValueGQAOf(il uint64) uint64 {
+	if len(ga.AttentionHeadCountKVPerLayer) == 0 {
+		return ga.EmbeddingValueGQA
+	}
+	return uint64(ga.AttentionValueLength) * ga.AttentionHeadCountKVOf(il)
+}
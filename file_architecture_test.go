@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGGUFFile_Architecture(t *testing.T) {
@@ -44,3 +45,41 @@ func BenchmarkGGUFFile_Architecture(b *testing.B) {
 		_ = f.Architecture()
 	}
 }
+
+func TestGGUFFile_Architecture_Hyperparameters(t *testing.T) {
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "deepseek2"),
+				testKV("deepseek2.expert_feed_forward_length", GGUFMetadataValueTypeUint32, uint32(1536)),
+				testKV("deepseek2.expert_shared_count", GGUFMetadataValueTypeUint32, uint32(2)),
+				testKV("deepseek2.expert_weights_scale", GGUFMetadataValueTypeFloat32, float32(16)),
+				testKV("deepseek2.leading_dense_block_count", GGUFMetadataValueTypeUint32, uint32(1)),
+				testKV("deepseek2.attention.q_lora_rank", GGUFMetadataValueTypeUint32, uint32(1536)),
+				testKV("deepseek2.attention.kv_lora_rank", GGUFMetadataValueTypeUint32, uint32(512)),
+				testKV("deepseek2.attention.sliding_window", GGUFMetadataValueTypeUint32, uint32(4096)),
+				testKV("deepseek2.attn_logit_softcapping", GGUFMetadataValueTypeFloat32, float32(50)),
+				testKV("deepseek2.final_logit_softcapping", GGUFMetadataValueTypeFloat32, float32(30)),
+				testKV("deepseek2.rope.scaling.type", GGUFMetadataValueTypeString, "yarn"),
+				testKV("deepseek2.rope.scaling.factor", GGUFMetadataValueTypeFloat32, float32(40)),
+				testKV("deepseek2.rope.scaling.yarn_log_multiplier", GGUFMetadataValueTypeFloat32, float32(0.1)),
+				testKV("deepseek2.pooling_type", GGUFMetadataValueTypeUint32, uint32(2)),
+			},
+		},
+	}
+
+	a := f.Architecture()
+	assert.Equal(t, uint64(1536), a.ExpertFeedForwardLength)
+	assert.Equal(t, uint32(2), a.ExpertSharedCount)
+	assert.Equal(t, float32(16), a.ExpertWeightsScale)
+	assert.Equal(t, uint64(1), a.LeadingDenseBlockCount)
+	assert.Equal(t, uint32(1536), a.AttentionQueryLoRARank)
+	assert.Equal(t, uint32(512), a.AttentionKeyValueLoRARank)
+	assert.Equal(t, uint64(4096), a.AttentionSlidingWindow)
+	assert.Equal(t, float32(50), a.AttentionLogitSoftcapping)
+	assert.Equal(t, float32(30), a.FinalLogitSoftcapping)
+	assert.Equal(t, "yarn", a.RoPEScalingType)
+	assert.Equal(t, float32(40), a.RoPEScalingFactor)
+	assert.Equal(t, float32(0.1), a.RoPEScalingYaRNLogMultiplier)
+	assert.Equal(t, "cls", a.PoolingType)
+}
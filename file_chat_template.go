@@ -0,0 +1,386 @@
+package gguf_parser
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/gpustack/gguf-parser-go/util/jinja"
+)
+
+// GGUFChatTemplate represents a chat template of a GGUF file.
+type GGUFChatTemplate struct {
+	// Name is the name of the chat template,
+	// which is "default" for `tokenizer.chat_template`,
+	// or the suffix of `tokenizer.chat_template.<name>`.
+	Name string `json:"name"`
+	// Template is the Jinja2 source of the chat template.
+	Template string `json:"template"`
+	// BOSToken is the text of the beginning of sentence token,
+	// which is empty if the tokens are not loaded.
+	BOSToken string `json:"bosToken"`
+	// EOSToken is the text of the end of sentence token,
+	// which is empty if the tokens are not loaded.
+	EOSToken string `json:"eosToken"`
+}
+
+// GGUFChatMessage is a message to render with a GGUFChatTemplate.
+type GGUFChatMessage struct {
+	// Role is the role of the message author,
+	// e.g. "system", "user" or "assistant".
+	Role string `json:"role"`
+	// Content is the content of the message.
+	Content string `json:"content"`
+}
+
+// ChatTemplates returns the chat templates of the GGUF file,
+// the default one goes first if present.
+func (gf *GGUFFile) ChatTemplates() []GGUFChatTemplate {
+	const (
+		chatTemplateKey = "tokenizer.chat_template"
+		tokensKey       = "tokenizer.ggml.tokens"
+	)
+
+	var bos, eos string
+	{
+		gt := gf.Tokenizer()
+		if v, ok := gf.Header.MetadataKV.Get(tokensKey); ok && v.ValueType == GGUFMetadataValueTypeArray {
+			if av := v.ValueArray(); av.Type == GGUFMetadataValueTypeString && uint64(len(av.Array)) == av.Len {
+				if gt.BOSTokenID >= 0 && uint64(gt.BOSTokenID) < av.Len {
+					bos = av.Array[gt.BOSTokenID].(string)
+				}
+				if gt.EOSTokenID >= 0 && uint64(gt.EOSTokenID) < av.Len {
+					eos = av.Array[gt.EOSTokenID].(string)
+				}
+			}
+		}
+	}
+
+	var cts []GGUFChatTemplate
+	for _, kv := range gf.Header.MetadataKV {
+		if kv.ValueType != GGUFMetadataValueTypeString || !strings.HasPrefix(kv.Key, chatTemplateKey) {
+			continue
+		}
+		ct := GGUFChatTemplate{
+			Template: kv.ValueString(),
+			BOSToken: bos,
+			EOSToken: eos,
+		}
+		switch {
+		case kv.Key == chatTemplateKey:
+			ct.Name = "default"
+			cts = append([]GGUFChatTemplate{ct}, cts...)
+		case kv.Key[len(chatTemplateKey)] == '.':
+			ct.Name = kv.Key[len(chatTemplateKey)+1:]
+			cts = append(cts, ct)
+		}
+	}
+	return cts
+}
+
+// ChatTemplate returns the chat template with the given name,
+// use "default" to get the one of `tokenizer.chat_template`.
+func (gf *GGUFFile) ChatTemplate(name string) (GGUFChatTemplate, bool) {
+	for _, ct := range gf.ChatTemplates() {
+		if ct.Name == name {
+			return ct, true
+		}
+	}
+	return GGUFChatTemplate{}, false
+}
+
+// RenderChat renders the messages with the default chat template of the GGUF file,
+// see GGUFChatTemplate.RenderChat.
+func (gf *GGUFFile) RenderChat(messages []GGUFChatMessage, addGenerationPrompt bool) (string, error) {
+	ct, ok := gf.ChatTemplate("default")
+	if !ok {
+		return "", errors.New("no chat template")
+	}
+	return ct.RenderChat(messages, addGenerationPrompt)
+}
+
+// RenderChat renders the messages with the chat template,
+// which is similar to HuggingFace transformers `apply_chat_template`.
+//
+// If addGenerationPrompt is true,
+// the template appends the tokens that indicate the start of an assistant message.
+func (ct GGUFChatTemplate) RenderChat(messages []GGUFChatMessage, addGenerationPrompt bool) (string, error) {
+	tmpl, err := jinja.Parse(ct.Template)
+	if err != nil {
+		return "", fmt.Errorf("parse chat template %q: %w", ct.Name, err)
+	}
+
+	msgs := make([]any, len(messages))
+	for i := range messages {
+		m := jinja.NewDict()
+		m.Set("role", messages[i].Role)
+		m.Set("content", messages[i].Content)
+		msgs[i] = m
+	}
+	r, err := tmpl.Render(map[string]any{
+		"messages":              msgs,
+		"add_generation_prompt": addGenerationPrompt,
+		"bos_token":             ct.BOSToken,
+		"eos_token":             ct.EOSToken,
+	})
+	if err != nil {
+		return "", fmt.Errorf("render chat template %q: %w", ct.Name, err)
+	}
+	return r, nil
+}
+
+// GGUFChatTemplateFamilyMetadata represents the family of the default chat template,
+// which is detected by the markers of the template.
+type GGUFChatTemplateFamilyMetadata struct {
+	// Family is the name of the detected family,
+	// e.g. "chatml", "llama2", "llama3", "mistral", "gemma", "phi3", "zephyr", "deepseek" or "command-r",
+	// or "unknown" if not detected,
+	// or empty if there is no chat template.
+	Family string `json:"family"`
+	// RoleMarkers maps the roles to the markers that start their messages.
+	RoleMarkers map[string]string `json:"roleMarkers,omitempty"`
+	// StopSequences holds the sequences that end the assistant message.
+	StopSequences []GGUFChatStopSequence `json:"stopSequences,omitempty"`
+	// VocabularyChecked indicates the stop sequences are cross-referenced with the vocabulary,
+	// which is false if the tokens are not loaded.
+	VocabularyChecked bool `json:"vocabularyChecked"`
+}
+
+// GGUFChatStopSequence is a stop sequence of a chat template family.
+type GGUFChatStopSequence struct {
+	// Text is the text of the stop sequence.
+	Text string `json:"text"`
+	// TokenID is the ID of the token whose text is the stop sequence.
+	//
+	// Use -1 if the token is not found or the vocabulary is not checked.
+	TokenID int64 `json:"tokenID"`
+	// SpecialTokens holds the special tokens of the vocabulary that the token is,
+	// i.e. "eos", "eot" or "eom".
+	SpecialTokens []string `json:"specialTokens,omitempty"`
+}
+
+// MissingStopSequences returns the texts of the stop sequences which are not tokens of the vocabulary,
+// it is always empty if the vocabulary is not checked.
+func (m GGUFChatTemplateFamilyMetadata) MissingStopSequences() (r []string) {
+	if !m.VocabularyChecked {
+		return nil
+	}
+	for _, s := range m.StopSequences {
+		if s.TokenID < 0 {
+			r = append(r, s.Text)
+		}
+	}
+	return r
+}
+
+// _GGUFChatTemplateFamily describes a known chat template family.
+type _GGUFChatTemplateFamily struct {
+	name   string
+	detect func(tmpl string) bool
+	roles  map[string]string
+	// stops are the stop sequences.
+	stops []string
+	// eos indicates the first stop sequence is the EOS token,
+	// which is replaced with the text of the EOS token if known.
+	eos bool
+	// optionalStops are the stop sequences only if present in the template.
+	optionalStops []string
+}
+
+// _GGUFChatTemplateFamilies are the known chat template families in detection order,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama-chat.cpp#L80-L190.
+var _GGUFChatTemplateFamilies = []_GGUFChatTemplateFamily{
+	{
+		name:   "chatml",
+		detect: containsAll("<|im_start|>"),
+		roles: map[string]string{
+			"system":    "<|im_start|>system\n",
+			"user":      "<|im_start|>user\n",
+			"assistant": "<|im_start|>assistant\n",
+		},
+		stops:         []string{"<|im_end|>"},
+		optionalStops: []string{"<|endoftext|>"},
+	},
+	{
+		name:   "command-r",
+		detect: containsAll("<|START_OF_TURN_TOKEN|>", "<|USER_TOKEN|>"),
+		roles: map[string]string{
+			"system":    "<|START_OF_TURN_TOKEN|><|SYSTEM_TOKEN|>",
+			"user":      "<|START_OF_TURN_TOKEN|><|USER_TOKEN|>",
+			"assistant": "<|START_OF_TURN_TOKEN|><|CHATBOT_TOKEN|>",
+		},
+		stops: []string{"<|END_OF_TURN_TOKEN|>"},
+	},
+	{
+		name:   "llama3",
+		detect: containsAll("<|start_header_id|>", "<|end_header_id|>"),
+		roles: map[string]string{
+			"system":    "<|start_header_id|>system<|end_header_id|>\n\n",
+			"user":      "<|start_header_id|>user<|end_header_id|>\n\n",
+			"assistant": "<|start_header_id|>assistant<|end_header_id|>\n\n",
+		},
+		stops:         []string{"<|eot_id|>"},
+		optionalStops: []string{"<|eom_id|>"},
+	},
+	{
+		name:   "deepseek",
+		detect: containsAll("<｜User｜>", "<｜Assistant｜>"),
+		roles: map[string]string{
+			"user":      "<｜User｜>",
+			"assistant": "<｜Assistant｜>",
+		},
+		stops: []string{"<｜end▁of▁sentence｜>"},
+		eos:   true,
+	},
+	{
+		name:   "gemma",
+		detect: containsAll("<start_of_turn>"),
+		roles: map[string]string{
+			"user":      "<start_of_turn>user\n",
+			"assistant": "<start_of_turn>model\n",
+		},
+		stops: []string{"<end_of_turn>"},
+	},
+	{
+		name:   "phi3",
+		detect: containsAll("<|assistant|>", "<|end|>"),
+		roles: map[string]string{
+			"system":    "<|system|>\n",
+			"user":      "<|user|>\n",
+			"assistant": "<|assistant|>\n",
+		},
+		stops:         []string{"<|end|>"},
+		optionalStops: []string{"<|endoftext|>"},
+	},
+	{
+		name:   "zephyr",
+		detect: containsAll("<|user|>", "<|assistant|>"),
+		roles: map[string]string{
+			"system":    "<|system|>\n",
+			"user":      "<|user|>\n",
+			"assistant": "<|assistant|>\n",
+		},
+		stops: []string{"</s>"},
+		eos:   true,
+	},
+	{
+		name:   "llama2",
+		detect: containsAll("[INST]", "<<SYS>>"),
+		roles: map[string]string{
+			"system":    "<<SYS>>\n",
+			"user":      "[INST] ",
+			"assistant": "[/INST] ",
+		},
+		stops: []string{"</s>"},
+		eos:   true,
+	},
+	{
+		name:   "mistral",
+		detect: containsAll("[INST]"),
+		roles: map[string]string{
+			"user":      "[INST]",
+			"assistant": "[/INST]",
+		},
+		stops: []string{"</s>"},
+		eos:   true,
+	},
+	{
+		name:   "deepseek",
+		detect: containsAll("User: ", "Assistant:"),
+		roles: map[string]string{
+			"user":      "User: ",
+			"assistant": "Assistant: ",
+		},
+		stops: []string{"<｜end▁of▁sentence｜>"},
+		eos:   true,
+	},
+}
+
+func containsAll(subs ...string) func(tmpl string) bool {
+	return func(tmpl string) bool {
+		for _, s := range subs {
+			if !strings.Contains(tmpl, s) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// ChatTemplateFamily detects the family of the default chat template,
+// and cross-references its stop sequences with the EOS/EOT/EOM tokens of the vocabulary.
+//
+// The stop sequences are checked only if the tokens are loaded,
+// parse with KeepLargeMetadata("tokenizer.ggml.tokens") to check them under SkipLargeMetadata.
+func (gf *GGUFFile) ChatTemplateFamily() (cf GGUFChatTemplateFamilyMetadata) {
+	const tokensKey = "tokenizer.ggml.tokens"
+
+	ct, ok := gf.ChatTemplate("default")
+	if !ok {
+		return cf
+	}
+
+	cf.Family = "unknown"
+	var f *_GGUFChatTemplateFamily
+	for i := range _GGUFChatTemplateFamilies {
+		if _GGUFChatTemplateFamilies[i].detect(ct.Template) {
+			f = &_GGUFChatTemplateFamilies[i]
+			break
+		}
+	}
+	if f == nil {
+		return cf
+	}
+	cf.Family = f.name
+	cf.RoleMarkers = maps.Clone(f.roles)
+
+	// Collect the stop sequences.
+	stops := slices.Clone(f.stops)
+	if f.eos && ct.EOSToken != "" {
+		stops[0] = ct.EOSToken
+	}
+	for _, s := range f.optionalStops {
+		if strings.Contains(ct.Template, s) {
+			stops = append(stops, s)
+		}
+	}
+
+	// Cross-reference with the vocabulary.
+	var ids map[string]int64
+	if v, ok := gf.Header.MetadataKV.Get(tokensKey); ok && v.ValueType == GGUFMetadataValueTypeArray {
+		if av := v.ValueArray(); av.Type == GGUFMetadataValueTypeString && av.Len != 0 && uint64(len(av.Array)) == av.Len {
+			ids = make(map[string]int64, len(stops))
+			for i := range av.Array {
+				if s := av.Array[i].(string); slices.Contains(stops, s) {
+					if _, ok := ids[s]; !ok {
+						ids[s] = int64(i)
+					}
+				}
+			}
+		}
+	}
+	cf.VocabularyChecked = ids != nil
+	gt := gf.Tokenizer()
+	for _, s := range stops {
+		ss := GGUFChatStopSequence{Text: s, TokenID: -1}
+		if id, ok := ids[s]; ok {
+			ss.TokenID = id
+			for _, st := range []struct {
+				name string
+				id   int64
+			}{
+				{"eos", gt.EOSTokenID},
+				{"eot", gt.EOTTokenID},
+				{"eom", gt.EOMTokenID},
+			} {
+				if st.id == id {
+					ss.SpecialTokens = append(ss.SpecialTokens, st.name)
+				}
+			}
+		}
+		cf.StopSequences = append(cf.StopSequences, ss)
+	}
+	return cf
+}
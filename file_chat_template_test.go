@@ -0,0 +1,259 @@
+package gguf_parser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gpustack/gguf-parser-go/util/jinja"
+)
+
+func TestGGUFFile_RenderChat(t *testing.T) {
+	chat := func(kvs ...GGUFMetadataKV) *GGUFFile {
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: append(GGUFMetadataKVs{
+					{Key: "tokenizer.ggml.tokens", ValueType: GGUFMetadataValueTypeArray, Value: GGUFMetadataKVArrayValue{
+						Type:  GGUFMetadataValueTypeString,
+						Len:   3,
+						Array: []any{"<unk>", "<s>", "</s>"},
+					}},
+					{Key: "tokenizer.ggml.bos_token_id", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(1)},
+					{Key: "tokenizer.ggml.eos_token_id", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(2)},
+				}, kvs...),
+			},
+		}
+	}
+	template := func(key, tmpl string) GGUFMetadataKV {
+		return GGUFMetadataKV{Key: key, ValueType: GGUFMetadataValueTypeString, Value: tmpl}
+	}
+	msgs := []GGUFChatMessage{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Content: " Hi! "},
+		{Role: "assistant", Content: "Hello."},
+		{Role: "user", Content: "How are you?"},
+	}
+
+	testCases := []struct {
+		name     string
+		template string
+		messages []GGUFChatMessage
+		expected string
+	}{
+		{
+			name: "chatml",
+			template: "{% for message in messages %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}" +
+				"{% if add_generation_prompt %}{{ '<|im_start|>assistant\n' }}{% endif %}",
+			messages: msgs[:2],
+			expected: "<|im_start|>system\nYou are helpful.<|im_end|>\n<|im_start|>user\n Hi! <|im_end|>\n<|im_start|>assistant\n",
+		},
+		{
+			name: "llama3",
+			template: "{% set loop_messages = messages %}{% for message in loop_messages %}" +
+				"{% set content = '<|start_header_id|>' + message['role'] + '<|end_header_id|>\n\n'+ message['content'] | trim + '<|eot_id|>' %}" +
+				"{% if loop.index0 == 0 %}{% set content = bos_token + content %}{% endif %}{{ content }}{% endfor %}" +
+				"{% if add_generation_prompt %}{{ '<|start_header_id|>assistant<|end_header_id|>\n\n' }}{% endif %}",
+			messages: msgs[:2],
+			expected: "<s><|start_header_id|>system<|end_header_id|>\n\nYou are helpful.<|eot_id|>" +
+				"<|start_header_id|>user<|end_header_id|>\n\nHi!<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n",
+		},
+		{
+			name: "llama2",
+			template: "{% if messages[0]['role'] == 'system' %}{% set loop_messages = messages[1:] %}{% set system_message = messages[0]['content'] %}" +
+				"{% else %}{% set loop_messages = messages %}{% set system_message = false %}{% endif %}" +
+				"{% for message in loop_messages %}" +
+				"{% if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}{{ raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}{% endif %}" +
+				"{% if loop.index0 == 0 and system_message != false %}{% set content = '<<SYS>>\\n' + system_message + '\\n<</SYS>>\\n\\n' + message['content'] %}" +
+				"{% else %}{% set content = message['content'] %}{% endif %}" +
+				"{% if message['role'] == 'user' %}{{ bos_token + '[INST] ' + content.strip() + ' [/INST]' }}" +
+				"{% elif message['role'] == 'assistant' %}{{ ' '  + content.strip() + ' ' + eos_token }}{% endif %}{% endfor %}",
+			messages: msgs,
+			expected: "<s>[INST] <<SYS>>\nYou are helpful.\n<</SYS>>\n\n Hi! [/INST] Hello. </s><s>[INST] How are you? [/INST]",
+		},
+		{
+			name: "whitespace control",
+			template: `{%- set ns = namespace(system='') %}
+{%- for message in messages if message.role == 'system' %}
+    {%- set ns.system = ns.system ~ message.content %}
+{%- endfor %}
+{%- if ns.system %}
+<system>{{ ns.system }}</system>
+{% endif %}
+{% for message in messages[ns.system | length > 0:] %}
+  {% if message.role is not defined or message.content is none %}
+    {{- raise_exception('invalid message') }}
+  {% endif %}
+<{{ message.role | upper }} #{{ loop.index }}/{{ loop.length }}>{{ message.content.split('!')[0] | trim }}
+{% else %}
+no messages
+{% endfor %}
+{{- [1, 2.5, 'x', none, true] | tojson }}|{{ {'a': [1], 'b': {}} | tojson(indent=2) }}
+{{ messages | selectattr('role', 'equalto', 'user') | map(attribute='content') | join(',') }}|{{ messages | length * 2 // 3 }}|{{ 'abc'[::-1] }}
+{%- if add_generation_prompt %}
+<ASSISTANT>
+{%- endif %}`,
+			messages: msgs[:3],
+			expected: "<system>You are helpful.</system>\n" +
+				"<USER #1/2>Hi\n" +
+				"<ASSISTANT #2/2>Hello.\n" +
+				"[1, 2.5, \"x\", null, true]|{\n  \"a\": [\n    1\n  ],\n  \"b\": {}\n}\n" +
+				" Hi! |2|cba<ASSISTANT>",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gf := chat(template("tokenizer.chat_template", tc.template))
+			actual, err := gf.RenderChat(tc.messages, true)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+
+	t.Run("named", func(t *testing.T) {
+		gf := chat(
+			template("tokenizer.chat_template.tool_use", "{{ bos_token }}tool"),
+			template("tokenizer.chat_template", "{{ eos_token }}default"),
+			template("tokenizer.chat_template.rag", "rag"))
+		cts := gf.ChatTemplates()
+		require.Len(t, cts, 3)
+		assert.Equal(t, []string{"default", "tool_use", "rag"}, []string{cts[0].Name, cts[1].Name, cts[2].Name})
+		assert.Equal(t, "<s>", cts[0].BOSToken)
+		assert.Equal(t, "</s>", cts[0].EOSToken)
+
+		ct, ok := gf.ChatTemplate("tool_use")
+		require.True(t, ok)
+		actual, err := ct.RenderChat(nil, false)
+		require.NoError(t, err)
+		assert.Equal(t, "<s>tool", actual)
+	})
+
+	t.Run("raise exception", func(t *testing.T) {
+		gf := chat(template("tokenizer.chat_template",
+			"{% if messages[0]['role'] == 'system' %}{{ raise_exception('System role not supported') }}{% endif %}"))
+		_, err := gf.RenderChat(msgs, true)
+		var re *jinja.RaiseError
+		require.True(t, errors.As(err, &re))
+		assert.Equal(t, "System role not supported", re.Message)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := chat().RenderChat(msgs, true)
+		assert.Error(t, err)
+
+		_, err = chat(template("tokenizer.chat_template", "{% for message in messages %}")).RenderChat(msgs, true)
+		assert.ErrorContains(t, err, "endfor")
+	})
+}
+
+func TestGGUFFile_ChatTemplateFamily(t *testing.T) {
+	vocab := func(tmpl string, tokens ...any) *GGUFFile {
+		gf := &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: GGUFMetadataKVs{
+					{Key: "tokenizer.ggml.eos_token_id", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(1)},
+					{Key: "tokenizer.ggml.eot_token_id", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(2)},
+				},
+			},
+		}
+		if tmpl != "" {
+			gf.Header.MetadataKV = append(gf.Header.MetadataKV,
+				GGUFMetadataKV{Key: "tokenizer.chat_template", ValueType: GGUFMetadataValueTypeString, Value: tmpl})
+		}
+		if tokens != nil {
+			gf.Header.MetadataKV = append(gf.Header.MetadataKV,
+				GGUFMetadataKV{Key: "tokenizer.ggml.tokens", ValueType: GGUFMetadataValueTypeArray, Value: GGUFMetadataKVArrayValue{
+					Type:  GGUFMetadataValueTypeString,
+					Len:   uint64(len(tokens)),
+					Array: tokens,
+				}})
+		}
+		return gf
+	}
+
+	testCases := []struct {
+		name     string
+		given    *GGUFFile
+		expected GGUFChatTemplateFamilyMetadata
+		missing  []string
+	}{
+		{
+			name:     "none",
+			given:    vocab(""),
+			expected: GGUFChatTemplateFamilyMetadata{},
+		},
+		{
+			name:     "unknown",
+			given:    vocab("{{ messages[0]['content'] }}"),
+			expected: GGUFChatTemplateFamilyMetadata{Family: "unknown"},
+		},
+		{
+			name:  "llama3",
+			given: vocab("<|start_header_id|>{{ role }}<|end_header_id|>{{ content }}<|eot_id|><|eom_id|>", "<unk>", "<|end_of_text|>", "<|eot_id|>"),
+			expected: GGUFChatTemplateFamilyMetadata{
+				Family: "llama3",
+				RoleMarkers: map[string]string{
+					"system":    "<|start_header_id|>system<|end_header_id|>\n\n",
+					"user":      "<|start_header_id|>user<|end_header_id|>\n\n",
+					"assistant": "<|start_header_id|>assistant<|end_header_id|>\n\n",
+				},
+				StopSequences: []GGUFChatStopSequence{
+					{Text: "<|eot_id|>", TokenID: 2, SpecialTokens: []string{"eot"}},
+					{Text: "<|eom_id|>", TokenID: -1},
+				},
+				VocabularyChecked: true,
+			},
+			missing: []string{"<|eom_id|>"},
+		},
+		{
+			name:  "llama2",
+			given: vocab("[INST] <<SYS>>{{ eos_token }}", "<unk>", "</s>", "<pad>"),
+			expected: GGUFChatTemplateFamilyMetadata{
+				Family: "llama2",
+				RoleMarkers: map[string]string{
+					"system":    "<<SYS>>\n",
+					"user":      "[INST] ",
+					"assistant": "[/INST] ",
+				},
+				StopSequences: []GGUFChatStopSequence{
+					{Text: "</s>", TokenID: 1, SpecialTokens: []string{"eos"}},
+				},
+				VocabularyChecked: true,
+			},
+		},
+		{
+			name:  "gemma without vocabulary",
+			given: vocab("<start_of_turn>user\n{{ content }}<end_of_turn>"),
+			expected: GGUFChatTemplateFamilyMetadata{
+				Family: "gemma",
+				RoleMarkers: map[string]string{
+					"user":      "<start_of_turn>user\n",
+					"assistant": "<start_of_turn>model\n",
+				},
+				StopSequences: []GGUFChatStopSequence{
+					{Text: "<end_of_turn>", TokenID: -1},
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.given.ChatTemplateFamily()
+			assert.Equal(t, tc.expected, actual)
+			assert.Equal(t, tc.missing, actual.MissingStopSequences())
+		})
+	}
+
+	for tmpl, family := range map[string]string{
+		"<|im_start|>{{ role }}":                                 "chatml",
+		"<|START_OF_TURN_TOKEN|><|USER_TOKEN|>":                  "command-r",
+		"<｜User｜>{{ content }}<｜Assistant｜>":                     "deepseek",
+		"<|user|>{{ content }}<|end|><|assistant|>":              "phi3",
+		"<|user|>{{ content }}{{ eos_token }}<|assistant|>":      "zephyr",
+		"[INST] {{ content }} [/INST]":                           "mistral",
+		"{{ 'User: ' + content }}{{ 'Assistant:' + eos_token }}": "deepseek",
+	} {
+		assert.Equal(t, family, vocab(tmpl).ChatTemplateFamily().Family, tmpl)
+	}
+}
@@ -0,0 +1,135 @@
+package gguf_parser
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// _GGUFControlVectorTensorPrefix is the prefix of the control vector tensor names,
+// e.g. "direction.1" is the direction of the 1st layer.
+const _GGUFControlVectorTensorPrefix = "direction."
+
+// GGUFControlVectorMetadata represents the control vector metadata of a GGUF file.
+type GGUFControlVectorMetadata struct {
+	/* Basic */
+
+	// ModelHint is the architecture of the model which the control vector is trained for,
+	// e.g. "llama".
+	ModelHint string `json:"modelHint"`
+	// LayerCount is the count of layers declared by the control vector.
+	LayerCount uint64 `json:"layerCount"`
+
+	/* Appendix */
+
+	// EmbeddingLength is the length of the direction tensors,
+	// which must be equal to the embedding length of the model.
+	EmbeddingLength uint64 `json:"embeddingLength"`
+	// Layers is the indexes of the layers steered by the control vector, in ascending order,
+	// layer 0 is never steered.
+	Layers []uint64 `json:"layers"`
+	// Size is the size of the direction tensors in bytes.
+	Size GGUFBytesScalar `json:"size"`
+}
+
+// ControlVector returns the control vector metadata of the GGUF file.
+func (gf *GGUFFile) ControlVector() (gcv GGUFControlVectorMetadata) {
+	var (
+		modelHintKey  = "controlvector.model_hint"
+		layerCountKey = "controlvector.layer_count"
+	)
+
+	m, _ := gf.Header.MetadataKV.Index([]string{
+		modelHintKey,
+		layerCountKey,
+	})
+
+	if v, ok := m[modelHintKey]; ok {
+		gcv.ModelHint = v.ValueString()
+	}
+	if v, ok := m[layerCountKey]; ok {
+		gcv.LayerCount = ValueNumeric[uint64](v)
+	}
+
+	for _, ti := range gf.TensorInfos {
+		il, ok := ti.controlVectorLayerIndex()
+		if !ok || il == 0 {
+			continue
+		}
+		if gcv.EmbeddingLength == 0 && ti.NDimensions > 0 {
+			gcv.EmbeddingLength = ti.Dimensions[0]
+		}
+		gcv.Layers = append(gcv.Layers, il)
+		gcv.Size += GGUFBytesScalar(ti.Bytes())
+	}
+	slices.Sort(gcv.Layers)
+	gcv.Layers = slices.Compact(gcv.Layers)
+
+	return gcv
+}
+
+// ValidateControlVector validates the GGUF file as a control vector of the given architecture,
+// which is inspired by
+// https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/common/common.cpp#L2040-L2110.
+//
+// It returns an error joined all the problems found, or nil if the control vector can be applied to the model.
+func (gf *GGUFFile) ValidateControlVector(arch GGUFArchitectureMetadata) error {
+	var (
+		errs []error
+		seen = map[uint64]bool{}
+		cnt  int
+	)
+	for _, ti := range gf.TensorInfos {
+		if !strings.HasPrefix(ti.Name, _GGUFControlVectorTensorPrefix) {
+			continue
+		}
+		cnt++
+
+		il, ok := ti.controlVectorLayerIndex()
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("tensor %s: invalid layer index", ti.Name))
+			continue
+		case il == 0:
+			errs = append(errs, fmt.Errorf("tensor %s: layer 0 cannot be steered", ti.Name))
+			continue
+		case il >= arch.BlockCount:
+			errs = append(errs, fmt.Errorf("tensor %s: layer %d is out of the model, which has %d layers",
+				ti.Name, il, arch.BlockCount))
+		}
+		if seen[il] {
+			errs = append(errs, fmt.Errorf("tensor %s: duplicated layer %d", ti.Name, il))
+		}
+		seen[il] = true
+
+		if ti.Type != GGMLTypeF32 || ti.NDimensions != 1 {
+			errs = append(errs, fmt.Errorf("tensor %s: must be 1D F32, got %dD %s", ti.Name, ti.NDimensions, ti.Type))
+			continue
+		}
+		if ti.Dimensions[0] != arch.EmbeddingLength {
+			errs = append(errs, fmt.Errorf("tensor %s: embedding length mismatch: control vector %d, model %d",
+				ti.Name, ti.Dimensions[0], arch.EmbeddingLength))
+		}
+	}
+	if cnt == 0 {
+		return errors.New("no direction tensors")
+	}
+
+	return errors.Join(errs...)
+}
+
+// controlVectorLayerIndex returns the layer index of the control vector tensor,
+// e.g. 1 for "direction.1".
+func (ti GGUFTensorInfo) controlVectorLayerIndex() (uint64, bool) {
+	s, ok := strings.CutPrefix(ti.Name, _GGUFControlVectorTensorPrefix)
+	if !ok {
+		return 0, false
+	}
+	il, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return il, true
+}
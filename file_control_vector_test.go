@@ -0,0 +1,66 @@
+package gguf_parser
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGGUFFile_ControlVector(t *testing.T) {
+	const (
+		nEmbd  = 64
+		nLayer = 4
+	)
+
+	cv := func(tis ...GGUFTensorInfo) *GGUFFile {
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: GGUFMetadataKVs{
+					{Key: "general.architecture", ValueType: GGUFMetadataValueTypeString, Value: "controlvector"},
+					{Key: "controlvector.model_hint", ValueType: GGUFMetadataValueTypeString, Value: "llama"},
+					{Key: "controlvector.layer_count", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(nLayer - 1)},
+				},
+			},
+			TensorInfos: tis,
+		}
+	}
+	dir := func(il int, dims ...uint64) GGUFTensorInfo {
+		return GGUFTensorInfo{
+			Name:        fmt.Sprintf("direction.%d", il),
+			NDimensions: uint32(len(dims)),
+			Dimensions:  dims,
+			Type:        GGMLTypeF32,
+		}
+	}
+	arch := GGUFArchitectureMetadata{
+		Architecture:    "llama",
+		EmbeddingLength: nEmbd,
+		BlockCount:      nLayer,
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		gf := cv(dir(3, nEmbd), dir(1, nEmbd), dir(2, nEmbd))
+		assert.Equal(t, GGUFControlVectorMetadata{
+			ModelHint:       "llama",
+			LayerCount:      nLayer - 1,
+			EmbeddingLength: nEmbd,
+			Layers:          []uint64{1, 2, 3},
+			Size:            3 * nEmbd * 4,
+		}, gf.ControlVector())
+		assert.NoError(t, gf.ValidateControlVector(arch))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		gf := cv(dir(0, nEmbd), dir(1, nEmbd*2), dir(2, nEmbd, 2), dir(4, nEmbd))
+		err := gf.ValidateControlVector(arch)
+		if assert.Error(t, err) {
+			assert.ErrorContains(t, err, "tensor direction.0: layer 0 cannot be steered")
+			assert.ErrorContains(t, err, "tensor direction.1: embedding length mismatch")
+			assert.ErrorContains(t, err, "tensor direction.2: must be 1D F32")
+			assert.ErrorContains(t, err, "tensor direction.4: layer 4 is out of the model")
+		}
+
+		assert.EqualError(t, cv().ValidateControlVector(arch), "no direction tensors")
+	})
+}
@@ -2,7 +2,6 @@ package gguf_parser
 
 import (
 	"regexp"
-	"strings"
 
 	"github.com/gpustack/gguf-parser-go/util/ptr"
 )
@@ -18,6 +17,9 @@ type (
 		FlashAttention bool `json:"flashAttention"`
 		// ContextSize is the size of the context.
 		ContextSize uint64 `json:"contextSize"`
+		// SlidingWindowCacheSize is the size of the KV cache of the sliding window attention layers,
+		// which is only set for models with sliding window attention.
+		SlidingWindowCacheSize uint64 `json:"slidingWindowCacheSize,omitempty"`
 		// OffloadLayers is the number of offloaded layers.
 		OffloadLayers uint64 `json:"offloadLayers"`
 		// FullOffloaded is the flag to indicate whether the layers are fully offloaded,
@@ -29,6 +31,21 @@ type (
 		// EmbeddingOnly is the flag to indicate whether the model is used for embedding only,
 		// true for embedding only.
 		EmbeddingOnly bool `json:"embeddingOnly"`
+		// PoolingType is the type of the pooling for the embeddings,
+		// which is only set for embedding only models.
+		PoolingType string `json:"poolingType,omitempty"`
+		// Reranking is the flag to indicate whether the model can rerank,
+		// true for reranking.
+		Reranking bool `json:"reranking,omitempty"`
+		// ImageTokens is the number of tokens the images of the estimated count inject into the context of the model,
+		// which is only set for clip models with vision encoder.
+		//
+		// The images are encoded one by one,
+		// so the computation of the clip models does not scale with the count.
+		ImageTokens uint64 `json:"imageTokens,omitempty"`
+		// AudioTokens is the number of tokens the audio of the estimated duration injects into the context of the model,
+		// which is only set for clip models with audio encoder.
+		AudioTokens uint64 `json:"audioTokens,omitempty"`
 		// LogicalBatchSize is the logical batch size.
 		LogicalBatchSize int32 `json:"logicalBatchSize"`
 		// PhysicalBatchSize is the physical batch size.
@@ -37,6 +54,12 @@ type (
 		Load LLaMACppMemoryUsage `json:"load"`
 		// Offload is the memory usage for loading the GGUF file in VRAM.
 		Offload LLaMACppMemoryUsage `json:"offload"`
+		// Devices is the memory usage of each device that the offloaded layers are split across,
+		// which sums up to Offload, and is only set when WithTensorSplit is given.
+		Devices []LLaMACppMemoryUsage `json:"devices,omitempty"`
+		// MainGPU is the index of the main device in Devices,
+		// which holds the computation buffers.
+		MainGPU uint64 `json:"mainGPU,omitempty"`
 		// MultimodalProjector is the memory usage of multimodal projector.
 		MultimodalProjector *LLaMACppUsageEstimate `json:"multimodalProjector,omitempty"`
 		// Drafter is the memory usage of drafter.
@@ -63,6 +86,12 @@ type (
 		Compute GGUFBytesScalar `json:"compute"`
 		// Output is the memory usage for loading output tensors.
 		Output GGUFBytesScalar `json:"output"`
+		// LoRA is the memory usage for loading LoRA adapter tensors,
+		// which are always read into the buffers, even if mmap is enabled.
+		LoRA GGUFBytesScalar `json:"lora"`
+		// ControlVector is the memory usage for loading control vector tensors,
+		// which are always read into the buffers, even if mmap is enabled.
+		ControlVector GGUFBytesScalar `json:"controlVector"`
 	}
 
 	// LLaMACppKVCacheUsage represents the memory usage of caching previous KV in llama.cpp.
@@ -71,6 +100,10 @@ type (
 		Key GGUFBytesScalar `json:"key"`
 		// Value is the memory usage for caching previous values.
 		Value GGUFBytesScalar `json:"value"`
+		// SlidingWindowKey is the part of Key for the sliding window attention layers.
+		SlidingWindowKey GGUFBytesScalar `json:"slidingWindowKey,omitempty"`
+		// SlidingWindowValue is the part of Value for the sliding window attention layers.
+		SlidingWindowValue GGUFBytesScalar `json:"slidingWindowValue,omitempty"`
 	}
 
 	// LLaMACppComputationUsage represents the memory usage of computation in llama.cpp.
@@ -131,6 +164,10 @@ func (gf *GGUFFile) EstimateLLaMACppUsage(opts ...LLaMACppUsageEstimateOption) (
 	}
 	e.Architecture = a.Architecture
 
+	// Architecture estimator,
+	// which estimates the architecture specific parts with the shared parameters.
+	ae := getLLaMACppArchitectureEstimator(a.Architecture)
+
 	// Flash attention.
 	{
 		// Quantization requires flash attention,
@@ -138,18 +175,19 @@ func (gf *GGUFFile) EstimateLLaMACppUsage(opts ...LLaMACppUsageEstimateOption) (
 		if *o.CacheValueType > GGMLTypeF16 && !o.FlashAttention {
 			o.FlashAttention = true
 		}
-		// Grok is not compatible with flash attention,
-		// see https://github.com/ggerganov/llama.cpp/blob/172c8256840ffd882ab9992ecedbb587d9b21f15/llama.cpp#L16050-L16053.
-		if a.Architecture == "grok" {
+		if !ae.SupportFlashAttention() {
 			o.FlashAttention = false
 		}
 
 		e.FlashAttention = o.FlashAttention
 	}
 
-	// Embedding.
+	// Embedding,
+	// the non-causal attention needs all tokens of a sequence in the same physical batch.
 	if !a.AttentionCausal {
 		e.EmbeddingOnly = true
+		e.PoolingType = a.PoolingType
+		e.Reranking = a.Reranking
 		o.PhysicalBatchSize = o.LogicalBatchSize
 	}
 
@@ -166,6 +204,10 @@ func (gf *GGUFFile) EstimateLLaMACppUsage(opts ...LLaMACppUsageEstimateOption) (
 		nOutputs  uint64
 		nParallel uint64
 		nKV       uint64
+		nSWA      uint64
+
+		rcLayers         []bool
+		nRecurrentLayers uint64
 	)
 	{
 		nContext = a.MaximumContextLength
@@ -190,14 +232,48 @@ func (gf *GGUFFile) EstimateLLaMACppUsage(opts ...LLaMACppUsageEstimateOption) (
 		nParallel = uint64(ptr.Deref(o.ParallelSize, 1))
 		nKV = nContext
 
+		// Recurrent layers keep a fixed-size state per sequence instead of KV cache,
+		// hybrid models interleave them with attention layers,
+		// so tell them apart by the tensors of each block.
+		if a.EmbeddingRecurrentState > 0 {
+			rcLayers = make([]bool, a.BlockCount)
+			if a.AttentionHeadCount == 0 {
+				for il := range rcLayers {
+					rcLayers[il] = true
+				}
+			} else {
+				for _, ti := range gf.TensorInfos.Search(regexp.MustCompile(`^blk\.\d+\.(ssm_in|time_mix_\w+)\.weight$`)) {
+					if il := ti.layerIndex(); il < a.BlockCount {
+						rcLayers[il] = true
+					}
+				}
+			}
+			for il := range rcLayers {
+				if rcLayers[il] {
+					nRecurrentLayers++
+				}
+			}
+		}
+
 		// For mamba,
 		// see https://github.com/ggerganov/llama.cpp/blob/7672adeec7a79ea271058c63106c142ba84f951a/llama.cpp#L16122-L16129.
-		if a.Architecture == "mamba" {
+		// The same applies to other pure recurrent models, like RWKV.
+		if nRecurrentLayers > 0 && nRecurrentLayers == a.BlockCount {
 			nKV = nParallel
 			o.CacheKeyType = ptr.To(GGMLTypeF32)
 			o.CacheValueType = ptr.To(GGMLTypeF32)
 		}
 
+		// Sliding window attention layers only keep the window of each sequence and the batch,
+		// unless the full cache is required.
+		nSWA = nKV
+		if a.AttentionSlidingWindow > 0 && a.attentionSlidingWindowApplied {
+			if !o.FullSWACache {
+				nSWA = min(nKV, GGMLPadding(a.AttentionSlidingWindow*nParallel+nTokens, 256))
+			}
+			e.SlidingWindowCacheSize = nSWA
+		}
+
 		e.ContextSize = nContext
 	}
 
@@ -210,10 +286,8 @@ func (gf *GGUFFile) EstimateLLaMACppUsage(opts ...LLaMACppUsageEstimateOption) (
 		isOffloadOutputLayer bool
 	)
 	{
-		// For clip,
-		// see https://github.com/ggerganov/llama.cpp/blob/148ec970b62c3c5ae0a8bfdaad2fc237aaae350d/examples/llava/clip.cpp#L994-L1008.
-		if a.Architecture == "clip" {
-			o.OffloadLayers = ptr.To(a.BlockCount + 1) // Clip means full offload.
+		if ae.FullOffload() {
+			o.OffloadLayers = ptr.To(a.BlockCount + 1) // Including the output layer.
 		}
 		if v := o.OffloadLayers; v == nil {
 			o.OffloadLayers = ptr.To(a.BlockCount)
@@ -244,71 +318,109 @@ func (gf *GGUFFile) EstimateLLaMACppUsage(opts ...LLaMACppUsageEstimateOption) (
 			fp += t.MergesLength * (48 /* key type */ + 56 /* value type */)
 		}
 		fp += t.TokensLength * (32 /* id to token vector */ + (24 + 32) /* token to id map*/)
+		// Special tokens cache and precompiled charsmap copy.
+		fp += (t.ControlTokensLength+t.UserDefinedTokensLength)*4 + uint64(t.PrecompiledCharsmapSize)
 		e.Load.Footprint += GGUFBytesScalar(fp)
 
 		// Output buffer,
 		// see https://github.com/ggerganov/llama.cpp/blob/7672adeec7a79ea271058c63106c142ba84f951a/llama.cpp#L11940-L12003.
 		ob := 4 /* float32 size */ * (a.VocabularyLength + a.EmbeddingLength) * nParallel
+		if e.EmbeddingOnly {
+			// Embedding only models output the embeddings of all tokens in the batch without logits,
+			// then keep the pooled embeddings or the rank scores of each sequence.
+			ob = 4 /* float32 size */ * a.EmbeddingLength * nBatch
+			switch e.PoolingType {
+			case "", "unspecified", "none":
+			case "rank":
+				ob += 4 /* float32 size */ * a.ClassifierOutputCount * nParallel
+			default:
+				ob += 4 /* float32 size */ * a.EmbeddingLength * nParallel
+			}
+		}
 		e.Load.Footprint += GGUFBytesScalar(ob)
+
+		// Encoder output,
+		// which is kept in host memory for the cross attention of the decoder.
+		if a.DecoderBlockCount > 0 {
+			e.Load.Footprint += GGUFBytesScalar(4 /* float32 size */ * a.EmbeddingLength * nTokens)
+		}
 	}
 
 	ls := gf.Layers()
 	ioLs, tfLs, _ := ls.Cut([]string{
 		"token_embd.weight",
+		"token_embd_norm.weight",
+		"token_embd_norm.bias",
+		"token_types.weight",
+		"position_embd.weight",
 		"output.weight",
 		"output_norm.weight",
 		"output_norm.bias",
+		"cls.weight",
+		"cls.bias",
+		"cls.output.weight",
+		"cls.output.bias",
 	})
 	ipLs, opLs, _ := ioLs.Cut([]string{
 		"token_embd.weight",
+		"token_embd_norm.weight",
+		"token_embd_norm.bias",
+		"token_types.weight",
+		"position_embd.weight",
 	})
 
+	// Shared parameters of the architecture estimator.
+	p := &LLaMACppUsageEstimateParameters{
+		File:                gf,
+		Architecture:        a,
+		FlashAttention:      o.FlashAttention,
+		CacheKeyType:        *o.CacheKeyType,
+		CacheValueType:      *o.CacheValueType,
+		OffloadKVCache:      *o.OffloadKVCache,
+		LoRAAdapters:        o.LoRAAdapters,
+		ControlVectors:      o.ControlVectors,
+		ImageCount:          uint64(ptr.Deref(o.ImageCount, 1)),
+		AudioDuration:       uint64(ptr.Deref(o.AudioDuration, 30)),
+		ContextSize:         nContext,
+		Tokens:              nTokens,
+		Batch:               nBatch,
+		Outputs:             nOutputs,
+		Parallel:            nParallel,
+		KV:                  nKV,
+		SlidingWindowKV:     nSWA,
+		RecurrentLayers:     rcLayers,
+		RecurrentLayerCount: nRecurrentLayers,
+		LoadLayers:          nLoadLayers,
+		OffloadLayers:       nOffloadLayers,
+		OffloadOutputLayer:  isOffloadOutputLayer,
+		InputLayers:         ipLs,
+		TransformerLayers:   tfLs,
+		OutputLayers:        opLs,
+	}
+
 	// Weight.
 	{
 		// Compute.
-		switch a.Architecture {
-		case "clip":
-			e.Offload.Weight.Compute = GGUFBytesScalar(ls.Bytes())
-		default:
-			for i, offloadStart := uint64(0), uint64(len(tfLs))-nOffloadLayers; i < uint64(len(tfLs)); i++ {
-				switch {
-				case i < nLoadLayers:
-					e.Load.Weight.Compute += GGUFBytesScalar(tfLs[i].Bytes())
-				case i >= offloadStart:
-					e.Offload.Weight.Compute += GGUFBytesScalar(tfLs[i].Bytes())
-				}
-			}
-		}
+		ae.EstimateWeight(p, &e)
 
 		// IO,
 		// see https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L4930-L5002.
 		e.Load.Weight.Input = GGUFBytesScalar(ipLs.Bytes())
-		if _, ok := opLs.Get("output.weight"); ok {
+		if _, ok := opLs.Get("output.weight"); ok || !a.AttentionCausal {
+			// Embedding only models have no logits,
+			// but the output norm and the classifier head.
 			e.Load.Weight.Output = GGUFBytesScalar(opLs.Bytes())
-		} else if a.AttentionCausal {
+		} else {
 			e.Load.Weight.Output = GGUFBytesScalar(opLs.Bytes()) + e.Load.Weight.Input /* duplicate the input layer */
 		}
-	}
 
-	// KV cache,
-	// see https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L2479-L2501.
-	{
-		krs := o.CacheKeyType.RowSizeOf([]uint64{a.EmbeddingKeyGQA * nKV})
-		vrs := o.CacheValueType.RowSizeOf([]uint64{a.EmbeddingValueGQA * nKV})
-
-		e.Load.KVCache.Key = GGUFBytesScalar(krs * nLoadLayers)
-		e.Load.KVCache.Value = GGUFBytesScalar(vrs * nLoadLayers)
-		e.Offload.KVCache.Key = GGUFBytesScalar(krs * nOffloadLayers)
-		e.Offload.KVCache.Value = GGUFBytesScalar(vrs * nOffloadLayers)
-
-		if !*o.OffloadKVCache {
-			e.Load.KVCache.Key += e.Offload.KVCache.Key
-			e.Load.KVCache.Value += e.Offload.KVCache.Value
-			e.Offload.KVCache.Key = GGUFBytesScalar(0)
-			e.Offload.KVCache.Value = GGUFBytesScalar(0)
-		}
+		// LoRA adapters and control vectors.
+		p.estimateAdapters(&e)
 	}
 
+	// KV cache.
+	ae.EstimateKVCache(p, &e)
+
 	// Computation.
 	{
 		// Bootstrap, compute metadata,
@@ -328,141 +440,18 @@ func (gf *GGUFFile) EstimateLLaMACppUsage(opts ...LLaMACppUsageEstimateOption) (
 
 		// Tensor usage,
 		// see https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L16149.
-		//
-		// First, get the usage of input layer,
-		// see https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L2279-L2290.
-		var (
-			inpTokens = GGMLTypeI32.RowSizeOf([]uint64{nBatch})                    // I32 [n_batch]
-			inpEmbd   = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, nBatch}) // F32 [n_embd, n_batch]
-			inpPos    = GGMLTypeI32.RowSizeOf([]uint64{nBatch})                    // I32 [n_batch]
-			inpOutIds = GGMLTypeI32.RowSizeOf([]uint64{nOutputs})                  // I32 [n_outputs],
-			inpKQMask = GGMLTypeF32.RowSizeOf([]uint64{nKV, nBatch})               // F32 [n_kv, n_batch]
-			inpSMask  = GGMLTypeF32.RowSizeOf([]uint64{1, nKV})                    // F32 [1, n_kv]
-			inpSSeq   = GGMLTypeI32.RowSizeOf([]uint64{nKV, nBatch})               // I32 [n_kv, n_batch]
-		)
-		switch a.Architecture {
-		case "clip":
-			// NOP.
-		case "mamba":
-			e.Load.Computation.Input = GGUFBytesScalar(inpTokens + inpEmbd + inpSMask + inpSSeq + inpOutIds)
-			e.Offload.Computation.Input = GGUFBytesScalar(inpEmbd + inpSMask + inpSSeq + inpOutIds)
-		default:
-			e.Load.Computation.Input = GGUFBytesScalar(inpTokens + inpEmbd + inpPos + inpKQMask + inpOutIds)
-			e.Offload.Computation.Input = GGUFBytesScalar(inpEmbd + inpPos + inpKQMask + inpOutIds)
-		}
-		// Since the steps between transformer layers are serial,
-		// the allocated memory can be reused for the next layer.
-		// So, we only consider the usage of the largest layer,
-		// which is the last layer by default.
-		switch a.Architecture {
-		case "clip":
-			// NOP.
-		case "mamba":
-			convInc := GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingKeyGQA, nKV}) // F32 [n_embd_key_gqa, n_kv] reshape
-			for _, l := range tfLs[len(tfLs)-1].Search(regexp.MustCompile(`.*\.\d+\.(attn_norm|ssm_in|ssm_conv1d)\.weight`)) {
-				if !strings.HasSuffix(l.Name, ".ssm_conv1d.weight") {
-					rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
-					convInc += rs
-					continue
-				}
-				// https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L10379.
-				rs := GGMLTypeF32.RowSizeOf([]uint64{uint64(a.SSMInnerSize)*nTokens + uint64(a.SSMConvolutionKernel)*uint64(a.SSMInnerSize)*nKV})
-				convInc += rs
-			}
-			ssmInc := uint64(0)
-			for _, l := range tfLs[len(tfLs)-1].Search(regexp.MustCompile(`.*\.\d+\.ssm_(dt\.weight|a)`)) {
-				if !strings.HasSuffix(l.Name, ".ssm_a") {
-					rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
-					ssmInc += rs
-					continue
-				}
-				// https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L10413.
-				rs := GGMLTypeF32.RowSizeOf([]uint64{uint64(a.SSMInnerSize)*nTokens + uint64(a.SSMStateSize)*uint64(a.SSMInnerSize)*nKV})
-				ssmInc += rs
-			}
-			e.Offload.Computation.Compute = GGUFBytesScalar(convInc + ssmInc)
-		default:
-			loadAttnInc, offloadAttnInc := uint64(0), uint64(0)
-			if o.FlashAttention {
-				// https://github.com/ggerganov/llama.cpp/blob/172c8256840ffd882ab9992ecedbb587d9b21f15/llama.cpp#L7387.
-				offloadAttnInc = GGMLTypeF16.RowSizeOf([]uint64{nKV, nTokens})
-				for _, l := range tfLs[len(tfLs)-1].Search(regexp.MustCompile(`.*\.\d+\.attn_(norm|q|qkv)\.weight`)) {
-					if strings.HasSuffix(l.Name, ".attn_norm.weight") {
-						rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
-						offloadAttnInc += rs
-						continue
-					}
-					rs := l.Bytes()
-					offloadAttnInc += rs
-				}
-				// https://github.com/ggerganov/llama.cpp/blob/172c8256840ffd882ab9992ecedbb587d9b21f15/llama.cpp#L6986-L6992.
-				rs := o.CacheKeyType.RowSizeOf([]uint64{uint64(a.AttentionKeyLength), nKV, a.AttentionHeadCountKV})
-				offloadAttnInc += rs
-				// https://github.com/ggerganov/llama.cpp/blob/172c8256840ffd882ab9992ecedbb587d9b21f15/llama.cpp#L7000-L7007.
-				rs = o.CacheValueType.RowSizeOf([]uint64{uint64(a.AttentionValueLength), nKV, a.AttentionHeadCountKV})
-				offloadAttnInc += rs
-			} else {
-				offloadAttnInc = uint64(0)
-				for _, l := range tfLs[len(tfLs)-1].Search(regexp.MustCompile(`.*\.\d+\.attn_(norm|q|qkv)\.weight`)) {
-					var rs uint64
-					switch {
-					default: // norm.
-						rs = GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
-						offloadAttnInc += rs
-					case strings.HasSuffix(l.Name, ".attn_q.weight"):
-						rs = GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[0], nTokens})
-						offloadAttnInc += rs * 2 // Qcur, Qcur + RoPE.
-						if !isOffloadOutputLayer {
-							loadAttnInc = rs // Vcur.
-						}
-						rs = GGMLTypeF32.RowSizeOf([]uint64{nKV, nTokens, a.AttentionHeadCount})
-						offloadAttnInc += rs // kq.
-						rs = o.CacheKeyType.RowSizeOf([]uint64{uint64(a.AttentionKeyLength), nKV, a.AttentionHeadCountKV})
-						offloadAttnInc += rs * 2 // k-?, v-?.
-					case strings.HasSuffix(l.Name, ".attn_qkv.weight"):
-						rs = GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[0], nTokens})
-						offloadAttnInc += rs * 2 // Qcur, Qcur + RoPE.
-						if !isOffloadOutputLayer {
-							loadAttnInc = rs // Vcur.
-						}
-						rs = GGMLTypeF32.RowSizeOf([]uint64{nKV, nTokens, a.AttentionHeadCount})
-						offloadAttnInc += rs // kq.
-						rs = o.CacheKeyType.RowSizeOf([]uint64{uint64(a.AttentionKeyLength), nKV, a.AttentionHeadCountKV})
-						offloadAttnInc += rs * 2 // k-?, v-?.
-					}
-				}
-			}
-			ffnInc := uint64(0)
-			for _, l := range tfLs[len(tfLs)-1].Search(regexp.MustCompile(`.*\.\d+\.(attn_norm|ffn_norm|ffn_gate|ffn_up)\.weight`)) {
-				rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
-				ffnInc += rs
-			}
-			e.Load.Computation.Compute = GGUFBytesScalar(loadAttnInc)
-			e.Offload.Computation.Compute = GGUFBytesScalar(max(offloadAttnInc, ffnInc))
-			// Special case: we cannot use mmap for splitting expert weights in MoE.
-			if a.ExpertCount > 0 {
-				e.NoMMap = len(tfLs[0].Search(regexp.MustCompile(`.*\.\d+\.ffn_gate_exps\.weight`))) == 0
-			}
-		}
-		// Finally, get the usage of output layer.
-		switch a.Architecture {
-		case "clip":
-			// NOP.
-		default:
-			outInc := inpEmbd
-			if a.Architecture == "mamba" {
-				outInc += inpSMask + inpSSeq
-			}
-			if l, ok := opLs.Get("output.weight"); ok {
-				rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
-				outInc += rs
-			} else if l, ok := ipLs.Get("token_embd.weight"); ok {
-				rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
-				outInc += rs
-			}
-			outInc += uint64(e.Load.Weight.Output)
-			e.Offload.Computation.Output = GGUFBytesScalar(outInc)
+		ae.EstimateComputation(p, &e)
+	}
+
+	// Devices,
+	// which splits the offloaded usage across multiple devices.
+	if len(o.TensorSplit) != 0 {
+		// Fall back to the first device if the main device is out of range.
+		if o.MainGPU >= uint64(len(o.TensorSplit)) {
+			o.MainGPU = 0
 		}
+		e.Devices = estimateLLaMACppDevices(ae, p, &e, o.TensorSplit, o.SplitMode, o.MainGPU)
+		e.MainGPU = o.MainGPU
 	}
 
 	// Multimodal projector.
@@ -497,6 +486,21 @@ type (
 		// EmbeddingOnly is the flag to indicate whether the model is used for embedding only,
 		// true for embedding only.
 		EmbeddingOnly bool `json:"embeddingOnly"`
+		// PoolingType is the type of the pooling for the embeddings,
+		// which is only set for embedding only models.
+		PoolingType string `json:"poolingType,omitempty"`
+		// Reranking is the flag to indicate whether the model can rerank,
+		// true for reranking.
+		Reranking bool `json:"reranking,omitempty"`
+		// ImageTokens is the number of tokens the images of the estimated count inject into the context of the model,
+		// which is only set for clip models with vision encoder.
+		//
+		// The images are encoded one by one,
+		// so the computation of the clip models does not scale with the count.
+		ImageTokens uint64 `json:"imageTokens,omitempty"`
+		// AudioTokens is the number of tokens the audio of the estimated duration injects into the context of the model,
+		// which is only set for clip models with audio encoder.
+		AudioTokens uint64 `json:"audioTokens,omitempty"`
 		// LogicalBatchSize is the logical batch size.
 		LogicalBatchSize int32 `json:"logicalBatchSize"`
 		// PhysicalBatchSize is the physical batch size.
@@ -523,6 +527,9 @@ type (
 			RAM GGUFBytesScalar `json:"ram"`
 			// VRAM is the memory usage for loading the GGUF file in VRAM.
 			VRAM GGUFBytesScalar `json:"vram"`
+			// VRAMs is the memory usage for loading the GGUF file in the VRAM of each device,
+			// which sums up to VRAM, and is only set when the estimate is split across devices.
+			VRAMs []GGUFBytesScalar `json:"vrams,omitempty"`
 		} `json:"nonUMA"`
 	}
 )
@@ -544,16 +551,19 @@ func (e LLaMACppUsageEstimate) SummarizeMemory(mmap bool, nonUMARamFootprint, no
 		cp := e.Load.Computation.Sum()
 		ems.UMA.RAM = fp + wg + kv + cp
 		if !e.NoMMap && mmap && e.Architecture != "clip" {
-			ems.UMA.RAM -= wg
+			ems.UMA.RAM -= e.Load.Weight.mappable()
 		}
 		// VRAM.
 		fp = e.Offload.Footprint
 		wg = e.Offload.Weight.Sum()
 		kv = e.Offload.KVCache.Sum()
 		cp = 0
+		if e.Architecture == "clip" {
+			cp = e.Offload.Computation.Sum() // Clip encodes the images on the device.
+		}
 		ems.UMA.VRAM = fp + wg + kv + cp
 		if !e.NoMMap && mmap && e.Architecture != "clip" {
-			ems.UMA.VRAM -= wg
+			ems.UMA.VRAM -= e.Offload.Weight.mappable()
 		}
 	}
 
@@ -566,7 +576,7 @@ func (e LLaMACppUsageEstimate) SummarizeMemory(mmap bool, nonUMARamFootprint, no
 		cp := e.Load.Computation.Sum()
 		ems.NonUMA.RAM = fp + wg + kv + cp
 		if !e.NoMMap && (mmap || e.FullOffloaded) {
-			ems.NonUMA.RAM -= wg
+			ems.NonUMA.RAM -= e.Load.Weight.mappable()
 			if !mmap {
 				ems.NonUMA.RAM += e.Load.Weight.Output
 			}
@@ -577,6 +587,15 @@ func (e LLaMACppUsageEstimate) SummarizeMemory(mmap bool, nonUMARamFootprint, no
 		kv = e.Offload.KVCache.Sum()
 		cp = e.Offload.Computation.Sum()
 		ems.NonUMA.VRAM = fp + wg + kv + cp
+		// Each device takes the platform footprint.
+		if len(e.Devices) != 0 {
+			ems.NonUMA.VRAM = 0
+			ems.NonUMA.VRAMs = make([]GGUFBytesScalar, len(e.Devices))
+			for i, d := range e.Devices {
+				ems.NonUMA.VRAMs[i] = GGUFBytesScalar(nonUMAVramFootprint) + d.Footprint + d.Weight.Sum() + d.KVCache.Sum() + d.Computation.Sum()
+				ems.NonUMA.VRAM += ems.NonUMA.VRAMs[i]
+			}
+		}
 	}
 
 	// MultimodalProjector.
@@ -584,6 +603,7 @@ func (e LLaMACppUsageEstimate) SummarizeMemory(mmap bool, nonUMARamFootprint, no
 		cems := e.MultimodalProjector.SummarizeMemory(mmap, 0, 0)
 		ems.NonUMA.RAM += cems.NonUMA.RAM
 		ems.NonUMA.VRAM += cems.NonUMA.VRAM
+		addLLaMACppDeviceVRAMs(ems.NonUMA.VRAMs, cems.NonUMA.VRAMs, cems.NonUMA.VRAM, e.MainGPU)
 		ems.UMA.RAM += cems.UMA.RAM
 		ems.UMA.VRAM += cems.UMA.VRAM
 	}
@@ -593,6 +613,7 @@ func (e LLaMACppUsageEstimate) SummarizeMemory(mmap bool, nonUMARamFootprint, no
 		dmes := e.Drafter.SummarizeMemory(mmap, 0, 0)
 		ems.NonUMA.RAM += dmes.NonUMA.RAM
 		ems.NonUMA.VRAM += dmes.NonUMA.VRAM
+		addLLaMACppDeviceVRAMs(ems.NonUMA.VRAMs, dmes.NonUMA.VRAMs, dmes.NonUMA.VRAM, e.MainGPU)
 		ems.UMA.RAM += dmes.UMA.RAM
 		ems.UMA.VRAM += dmes.UMA.VRAM
 	}
@@ -600,6 +621,21 @@ func (e LLaMACppUsageEstimate) SummarizeMemory(mmap bool, nonUMARamFootprint, no
 	return ems
 }
 
+// addLLaMACppDeviceVRAMs adds the device VRAMs of the other estimate into the given device VRAMs,
+// or adds the total VRAM into the main device if the other estimate is not split across the same devices.
+func addLLaMACppDeviceVRAMs(vrams, others []GGUFBytesScalar, other GGUFBytesScalar, main uint64) {
+	if len(vrams) == 0 {
+		return
+	}
+	if len(others) != len(vrams) {
+		vrams[main] += other
+		return
+	}
+	for i := range vrams {
+		vrams[i] += others[i]
+	}
+}
+
 // Summarize returns the summary of the estimated result of loading the GGUF file in llama.cpp,
 // the input options are used to adjust the summary.
 func (e LLaMACppUsageEstimate) Summarize(mmap bool, nonUMARamFootprint, nonUMAVramFootprint uint64) (es LLaMACppUsageEstimateSummary) {
@@ -614,6 +650,10 @@ func (e LLaMACppUsageEstimate) Summarize(mmap bool, nonUMARamFootprint, nonUMAVr
 	es.FlashAttention = e.FlashAttention
 	es.NoMMap = e.NoMMap
 	es.EmbeddingOnly = e.EmbeddingOnly
+	es.PoolingType = e.PoolingType
+	es.Reranking = e.Reranking
+	es.ImageTokens = e.ImageTokens
+	es.AudioTokens = e.AudioTokens
 	es.LogicalBatchSize = e.LogicalBatchSize
 	es.PhysicalBatchSize = e.PhysicalBatchSize
 
@@ -621,6 +661,12 @@ func (e LLaMACppUsageEstimate) Summarize(mmap bool, nonUMARamFootprint, nonUMAVr
 }
 
 func (u LLaMACppWeightUsage) Sum() GGUFBytesScalar {
+	return u.Input + u.Compute + u.Output + u.LoRA + u.ControlVector
+}
+
+// mappable returns the memory usage of the weights which can be mapped from the file,
+// that is, excluding the LoRA adapters and control vectors.
+func (u LLaMACppWeightUsage) mappable() GGUFBytesScalar {
 	return u.Input + u.Compute + u.Output
 }
 
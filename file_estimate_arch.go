@@ -0,0 +1,535 @@
+package gguf_parser
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// LLaMACppArchitectureEstimator is the interface to estimate the architecture specific usage in llama.cpp.
+//
+// The architecture independent parts,
+// like the footprint, the input/output weights, the LoRA adapters and the control vectors,
+// are estimated before calling the estimator.
+//
+// Implementations can embed LLaMACppDefaultArchitectureEstimator,
+// and only override the methods that differ from the default.
+type LLaMACppArchitectureEstimator interface {
+	// EstimateWeight estimates the usage of the compute weights,
+	// i.e. Load.Weight.Compute and Offload.Weight.Compute.
+	EstimateWeight(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate)
+	// EstimateKVCache estimates the usage of the KV cache,
+	// i.e. Load.KVCache and Offload.KVCache.
+	EstimateKVCache(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate)
+	// EstimateComputation estimates the usage of the computation,
+	// i.e. the Input, Compute and Output of Load.Computation and Offload.Computation.
+	EstimateComputation(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate)
+	// SupportFlashAttention returns true if the architecture supports the flash attention,
+	// otherwise, the flash attention is disabled regardless of the options.
+	SupportFlashAttention() bool
+	// FullOffload returns true if the architecture always offloads all layers,
+	// including the output layer, regardless of the options.
+	FullOffload() bool
+}
+
+var (
+	// _LLaMACppArchitectureEstimatorsMu guards _LLaMACppArchitectureEstimators,
+	// which can be extended by RegisterLLaMACppArchitectureEstimator.
+	_LLaMACppArchitectureEstimatorsMu sync.RWMutex
+
+	// _LLaMACppArchitectureEstimators is a table of LLaMACppArchitectureEstimator for architecture,
+	// the architectures not in the table use LLaMACppDefaultArchitectureEstimator.
+	_LLaMACppArchitectureEstimators = map[string]LLaMACppArchitectureEstimator{
+		"clip":       _LLaMACppClipEstimator{},
+		"grok":       _LLaMACppGrokEstimator{},
+		"mamba":      _LLaMACppSSMEstimator{},
+		"mamba2":     _LLaMACppSSMEstimator{},
+		"rwkv6":      _LLaMACppRWKVEstimator{},
+		"rwkv6qwen2": _LLaMACppRWKVEstimator{},
+		"rwkv7":      _LLaMACppRWKVEstimator{},
+		"t5":         _LLaMACppT5Estimator{},
+		"t5encoder":  _LLaMACppT5Estimator{},
+	}
+)
+
+// RegisterLLaMACppArchitectureEstimator registers a LLaMACppArchitectureEstimator for the given architecture,
+// which is used to support the architectures introduced by the llama.cpp forks,
+// or the upstream architectures that are not supported yet.
+//
+// The architecture that has been registered, including the built-in ones, cannot be registered again.
+func RegisterLLaMACppArchitectureEstimator(arch string, impl LLaMACppArchitectureEstimator) error {
+	if arch == "" {
+		return fmt.Errorf("blank architecture")
+	}
+	if impl == nil {
+		return fmt.Errorf("nil estimator of architecture %s", arch)
+	}
+
+	_LLaMACppArchitectureEstimatorsMu.Lock()
+	defer _LLaMACppArchitectureEstimatorsMu.Unlock()
+
+	if _, ok := _LLaMACppArchitectureEstimators[arch]; ok {
+		return fmt.Errorf("architecture %s has been registered", arch)
+	}
+
+	_LLaMACppArchitectureEstimators[arch] = impl
+	return nil
+}
+
+// unregisterLLaMACppArchitectureEstimator removes the LLaMACppArchitectureEstimator registered by RegisterLLaMACppArchitectureEstimator.
+func unregisterLLaMACppArchitectureEstimator(arch string) {
+	_LLaMACppArchitectureEstimatorsMu.Lock()
+	defer _LLaMACppArchitectureEstimatorsMu.Unlock()
+
+	delete(_LLaMACppArchitectureEstimators, arch)
+}
+
+// getLLaMACppArchitectureEstimator returns the LLaMACppArchitectureEstimator of the given architecture.
+func getLLaMACppArchitectureEstimator(arch string) LLaMACppArchitectureEstimator {
+	_LLaMACppArchitectureEstimatorsMu.RLock()
+	impl, ok := _LLaMACppArchitectureEstimators[arch]
+	_LLaMACppArchitectureEstimatorsMu.RUnlock()
+	if ok {
+		return impl
+	}
+	return LLaMACppDefaultArchitectureEstimator{}
+}
+
+// LLaMACppUsageEstimateParameters holds the parameters shared by the LLaMACppArchitectureEstimator,
+// which are resolved from the GGUF file and the estimate options.
+type LLaMACppUsageEstimateParameters struct {
+	// File is the GGUF file to estimate.
+	File *GGUFFile
+	// Architecture is the architecture metadata of the GGUF file.
+	Architecture GGUFArchitectureMetadata
+
+	// FlashAttention is the flag to indicate whether enable the flash attention,
+	// true for enable.
+	FlashAttention bool
+	// CacheKeyType is the type of the key cache.
+	CacheKeyType GGMLType
+	// CacheValueType is the type of the value cache.
+	CacheValueType GGMLType
+	// OffloadKVCache is the flag to indicate whether offload the KV cache,
+	// true for offload.
+	OffloadKVCache bool
+	// LoRAAdapters is the LoRA adapters applied to the model.
+	LoRAAdapters []*GGUFFile
+	// ControlVectors is the control vectors applied to the model.
+	ControlVectors []GGUFControlVectorMetadata
+	// ImageCount is the number of images in the context,
+	// which is only used by the clip models with vision encoder.
+	ImageCount uint64
+	// AudioDuration is the duration of the audio in seconds,
+	// which is only used by the clip models with audio encoder.
+	AudioDuration uint64
+
+	// ContextSize(n_ctx) is the size of the context.
+	ContextSize uint64
+	// Tokens(n_tokens) is the number of the tokens in a physical batch.
+	Tokens uint64
+	// Batch(n_batch) is the size of the input batch.
+	Batch uint64
+	// Outputs(n_outputs) is the number of the outputs in a physical batch.
+	Outputs uint64
+	// Parallel(n_seq_max) is the number of the parallel sequences.
+	Parallel uint64
+	// KV(n_kv) is the number of the cells of the KV cache.
+	KV uint64
+	// SlidingWindowKV is the number of the cells of the KV cache of the sliding window attention layers.
+	SlidingWindowKV uint64
+	// RecurrentLayers indicates whether each layer is a recurrent layer,
+	// which is nil if the model has no recurrent layer.
+	RecurrentLayers []bool
+	// RecurrentLayerCount is the number of the recurrent layers.
+	RecurrentLayerCount uint64
+
+	// LoadLayers is the number of the layers kept in RAM.
+	LoadLayers uint64
+	// OffloadLayers is the number of the layers offloaded to VRAM.
+	OffloadLayers uint64
+	// OffloadOutputLayer is the flag to indicate whether the output layer is offloaded,
+	// true for offload.
+	OffloadOutputLayer bool
+
+	// InputLayers is the tensors of the input layer.
+	InputLayers GGUFLayerTensorInfos
+	// TransformerLayers is the tensors of the transformer layers.
+	TransformerLayers GGUFLayerTensorInfos
+	// OutputLayers is the tensors of the output layer.
+	OutputLayers GGUFLayerTensorInfos
+}
+
+// UsageOf returns the memory usage of the estimate where the given layer is placed,
+// which is Load if the layer is kept in RAM, otherwise Offload.
+func (p *LLaMACppUsageEstimateParameters) UsageOf(e *LLaMACppUsageEstimate, il uint64) *LLaMACppMemoryUsage {
+	if il < p.LoadLayers {
+		return &e.Load
+	}
+	return &e.Offload
+}
+
+// estimateAdapters estimates the usage of the LoRA adapters and the control vectors,
+// i.e. Load.Weight.LoRA/ControlVector and Offload.Weight.LoRA/ControlVector.
+func (p *LLaMACppUsageEstimateParameters) estimateAdapters(e *LLaMACppUsageEstimate) {
+	// LoRA adapters,
+	// the adapter tensors are placed in the same buffer type of the adapted tensors,
+	// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama.cpp#L18842-L18855.
+	for _, ad := range p.LoRAAdapters {
+		for _, ti := range ad.TensorInfos {
+			switch {
+			case strings.HasPrefix(ti.Name, "blk."), strings.HasPrefix(ti.Name, "enc.blk."), strings.HasPrefix(ti.Name, "dec.blk."):
+				p.UsageOf(e, ti.stackLayerIndex()).Weight.LoRA += GGUFBytesScalar(ti.Bytes())
+			case strings.HasPrefix(ti.Name, "output.") && p.OffloadOutputLayer:
+				e.Offload.Weight.LoRA += GGUFBytesScalar(ti.Bytes())
+			default:
+				e.Load.Weight.LoRA += GGUFBytesScalar(ti.Bytes())
+			}
+		}
+	}
+
+	// Control vectors,
+	// all control vectors are merged into one F32 direction per layer except the first layer,
+	// which is placed in the same buffer type of the layer,
+	// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama.cpp#L19023-L19059.
+	if len(p.ControlVectors) != 0 {
+		rs := GGUFBytesScalar(GGMLTypeF32.RowSizeOf([]uint64{p.Architecture.EmbeddingLength}))
+		for il := uint64(1); il < p.Architecture.BlockCount; il++ {
+			p.UsageOf(e, il).Weight.ControlVector += rs
+		}
+	}
+}
+
+// ssmComputation returns the computation usage of a SSM layer.
+//
+// The SSM of a layer convolves the inner states with the B/C of each group,
+// then scans them with the recurrent state of each sequence.
+func (p *LLaMACppUsageEstimateParameters) ssmComputation(l IGGUFTensorInfos) uint64 {
+	var (
+		a         = p.Architecture
+		nTokens   = p.Tokens
+		nParallel = p.Parallel
+	)
+	nConv := uint64(a.SSMInnerSize) + 2*uint64(a.SSMGroupCount)*uint64(a.SSMStateSize)
+	convInc := GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingRollingState, nParallel}) // F32 [n_embd_r, n_rs] reshape
+	for _, l := range l.Search(regexp.MustCompile(`.*\.\d+\.(attn_norm|ssm_in|ssm_conv1d)\.weight`)) {
+		if !strings.HasSuffix(l.Name, ".ssm_conv1d.weight") {
+			rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
+			convInc += rs
+			continue
+		}
+		// https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L10379.
+		rs := GGMLTypeF32.RowSizeOf([]uint64{nConv*nTokens + uint64(a.SSMConvolutionKernel)*nConv*nParallel})
+		convInc += rs
+	}
+	scanInc := uint64(0)
+	for _, l := range l.Search(regexp.MustCompile(`.*\.\d+\.ssm_(dt\.weight|a)`)) {
+		if !strings.HasSuffix(l.Name, ".ssm_a") {
+			rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
+			scanInc += rs
+			continue
+		}
+		// https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L10413.
+		rs := GGMLTypeF32.RowSizeOf([]uint64{uint64(a.SSMInnerSize)*nTokens + a.EmbeddingRecurrentState*nParallel})
+		scanInc += rs
+	}
+	return convInc + scanInc
+}
+
+// LLaMACppDefaultArchitectureEstimator is the LLaMACppArchitectureEstimator of the decoder-only transformer models,
+// which also handles the per-layer hyperparameters, the MLA, the sliding window attention and the hybrid recurrent layers.
+type LLaMACppDefaultArchitectureEstimator struct{}
+
+// SupportFlashAttention implements LLaMACppArchitectureEstimator,
+// most architectures support the flash attention.
+func (LLaMACppDefaultArchitectureEstimator) SupportFlashAttention() bool {
+	return true
+}
+
+// FullOffload implements LLaMACppArchitectureEstimator,
+// the layers are offloaded as the options.
+func (LLaMACppDefaultArchitectureEstimator) FullOffload() bool {
+	return false
+}
+
+// EstimateWeight implements LLaMACppArchitectureEstimator,
+// the layers are kept in RAM from the first one, and offloaded to VRAM from the last one.
+func (LLaMACppDefaultArchitectureEstimator) EstimateWeight(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	tfLs := p.TransformerLayers
+	for i, offloadStart := uint64(0), uint64(len(tfLs))-p.OffloadLayers; i < uint64(len(tfLs)); i++ {
+		switch {
+		case i < p.LoadLayers:
+			e.Load.Weight.Compute += GGUFBytesScalar(tfLs[i].Bytes())
+		case i >= offloadStart:
+			e.Offload.Weight.Compute += GGUFBytesScalar(tfLs[i].Bytes())
+		}
+	}
+}
+
+// EstimateKVCache implements LLaMACppArchitectureEstimator,
+// see https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L2479-L2501.
+func (LLaMACppDefaultArchitectureEstimator) EstimateKVCache(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	a := p.Architecture
+
+	// Sum per layer, as the number of KV heads may differ between layers,
+	// only the decoder stack of encoder-decoder models needs KV cache,
+	// the recurrent layers keep F32 states for each sequence instead,
+	// and the sliding window attention layers only cache the window.
+	nKVLayers := a.BlockCount
+	if a.DecoderBlockCount > 0 {
+		nKVLayers = a.DecoderBlockCount
+	}
+	for il := uint64(0); il < nKVLayers; il++ {
+		var (
+			krs, vrs uint64
+			swa      bool
+		)
+		switch {
+		case il < uint64(len(p.RecurrentLayers)) && p.RecurrentLayers[il]:
+			krs = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingRollingState * p.Parallel})
+			vrs = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingRecurrentState * p.Parallel})
+		case a.AttentionSlidingWindowOf(il) > 0:
+			krs = p.CacheKeyType.RowSizeOf([]uint64{a.EmbeddingKeyGQAOf(il) * p.SlidingWindowKV})
+			vrs = p.CacheValueType.RowSizeOf([]uint64{a.EmbeddingValueGQAOf(il) * p.SlidingWindowKV})
+			swa = true
+		default:
+			krs = p.CacheKeyType.RowSizeOf([]uint64{a.EmbeddingKeyGQAOf(il) * p.KV})
+			vrs = p.CacheValueType.RowSizeOf([]uint64{a.EmbeddingValueGQAOf(il) * p.KV})
+		}
+		kv := &p.UsageOf(e, il).KVCache
+		kv.Key += GGUFBytesScalar(krs)
+		kv.Value += GGUFBytesScalar(vrs)
+		if swa {
+			kv.SlidingWindowKey += GGUFBytesScalar(krs)
+			kv.SlidingWindowValue += GGUFBytesScalar(vrs)
+		}
+	}
+
+	if !p.OffloadKVCache {
+		e.Load.KVCache.Key += e.Offload.KVCache.Key
+		e.Load.KVCache.Value += e.Offload.KVCache.Value
+		e.Load.KVCache.SlidingWindowKey += e.Offload.KVCache.SlidingWindowKey
+		e.Load.KVCache.SlidingWindowValue += e.Offload.KVCache.SlidingWindowValue
+		e.Offload.KVCache = LLaMACppKVCacheUsage{}
+	}
+}
+
+// EstimateComputation implements LLaMACppArchitectureEstimator,
+// which is the sum of EstimateComputationInput, EstimateComputationCompute and EstimateComputationOutput.
+func (d LLaMACppDefaultArchitectureEstimator) EstimateComputation(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	d.EstimateComputationInput(p, e)
+	d.EstimateComputationCompute(p, e)
+	d.EstimateComputationOutput(p, e)
+}
+
+// EstimateComputationInput estimates the usage of the input tensors,
+// see https://github.com/ggerganov/llama.cpp/blob/d6ef0e77dd25f54fb5856af47e3926cf6f36c281/llama.cpp#L2279-L2290.
+func (LLaMACppDefaultArchitectureEstimator) EstimateComputationInput(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	var (
+		inpTokens = GGMLTypeI32.RowSizeOf([]uint64{p.Batch})                                 // I32 [n_batch]
+		inpEmbd   = GGMLTypeF32.RowSizeOf([]uint64{p.Architecture.EmbeddingLength, p.Batch}) // F32 [n_embd, n_batch]
+		inpPos    = GGMLTypeI32.RowSizeOf([]uint64{p.Batch})                                 // I32 [n_batch]
+		inpOutIds = GGMLTypeI32.RowSizeOf([]uint64{p.Outputs})                               // I32 [n_outputs],
+		inpKQMask = GGMLTypeF32.RowSizeOf([]uint64{p.KV, p.Batch})                           // F32 [n_kv, n_batch]
+		inpSMask  = GGMLTypeF32.RowSizeOf([]uint64{1, p.Parallel})                           // F32 [1, n_rs]
+		inpSSeq   = GGMLTypeI32.RowSizeOf([]uint64{p.Parallel, p.Batch})                     // I32 [n_rs, n_batch]
+	)
+	inp := inpEmbd + inpPos + inpKQMask + inpOutIds
+	if p.RecurrentLayerCount > 0 {
+		inp += inpSMask + inpSSeq
+	}
+	e.Load.Computation.Input = GGUFBytesScalar(inpTokens + inp)
+	e.Offload.Computation.Input = GGUFBytesScalar(inp)
+}
+
+// EstimateComputationCompute estimates the usage of the transformer layers.
+//
+// Since the steps between transformer layers are serial,
+// the allocated memory can be reused for the next layer.
+// So, we only consider the usage of the largest layer,
+// which is the last layer by default.
+func (LLaMACppDefaultArchitectureEstimator) EstimateComputationCompute(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	var (
+		a       = p.Architecture
+		tfLs    = p.TransformerLayers
+		nTokens = p.Tokens
+		nKV     = p.KV
+
+		attnRegex = regexp.MustCompile(`.*\.\d+\.attn_(norm|q|qkv)\.weight`)
+		ffnRegex  = regexp.MustCompile(`.*\.\d+\.(attn_norm|ffn_norm|ffn_gate|ffn_up|ffn_gate_shexp|ffn_up_shexp)\.weight`)
+
+		loadAttnInc, offloadAttnInc, ffnInc uint64
+	)
+	if len(tfLs) == 0 {
+		return
+	}
+	// Take the largest layer,
+	// which is the last layer unless the model has per-layer hyperparameters,
+	// leading dense blocks or recurrent layers.
+	ils := []int{len(tfLs) - 1}
+	if len(a.AttentionHeadCountPerLayer) != 0 || len(a.AttentionHeadCountKVPerLayer) != 0 || len(a.FeedForwardLengthPerLayer) != 0 ||
+		a.LeadingDenseBlockCount > 0 || p.RecurrentLayerCount > 0 {
+		ils = make([]int, len(tfLs))
+		for i := range ils {
+			ils[i] = i
+		}
+	}
+	for _, il := range ils {
+		var (
+			bi      = layerIndexOf(tfLs[il]) // Block index, which may differ from the position in tfLs.
+			nHead   = a.AttentionHeadCountOf(bi)
+			nHeadKV = a.AttentionHeadCountKVOf(bi)
+
+			lai, oai, fi uint64
+		)
+		switch {
+		case bi < uint64(len(p.RecurrentLayers)) && p.RecurrentLayers[bi]:
+			oai = p.ssmComputation(tfLs[il])
+		case a.AttentionKeyValueLoRARank > 0:
+			// MLA projects the query of each head into the compressed key-value,
+			// then attends to the cached latent directly.
+			for _, l := range tfLs[il].Search(regexp.MustCompile(`.*\.\d+\.attn_(norm|kv_a_norm|q_a_norm)\.weight`)) {
+				rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
+				oai += rs
+			}
+			rs := GGMLTypeF32.RowSizeOf([]uint64{uint64(a.AttentionKeyLength) * nHead, nTokens})
+			oai += rs * 2 // Qcur, Qcur + RoPE.
+			if !p.OffloadOutputLayer {
+				lai = rs // Qcur.
+			}
+			rs = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingKeyGQA, nHead, nTokens})
+			oai += rs // q_absorbed.
+			if p.FlashAttention {
+				rs = GGMLTypeF16.RowSizeOf([]uint64{nKV, nTokens})
+			} else {
+				rs = GGMLTypeF32.RowSizeOf([]uint64{nKV, nTokens, nHead})
+			}
+			oai += rs // kq.
+			rs = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingValueGQA, nHead, nTokens})
+			oai += rs // kqv.
+			rs = p.CacheKeyType.RowSizeOf([]uint64{a.EmbeddingKeyGQA, nKV}) + p.CacheValueType.RowSizeOf([]uint64{a.EmbeddingValueGQA, nKV})
+			oai += rs // k-?, v-?.
+		case p.FlashAttention:
+			// https://github.com/ggerganov/llama.cpp/blob/172c8256840ffd882ab9992ecedbb587d9b21f15/llama.cpp#L7387.
+			oai = GGMLTypeF16.RowSizeOf([]uint64{nKV, nTokens})
+			for _, l := range tfLs[il].Search(attnRegex) {
+				if strings.HasSuffix(l.Name, ".attn_norm.weight") {
+					rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
+					oai += rs
+					continue
+				}
+				rs := l.Bytes()
+				oai += rs
+			}
+			// https://github.com/ggerganov/llama.cpp/blob/172c8256840ffd882ab9992ecedbb587d9b21f15/llama.cpp#L6986-L6992.
+			rs := p.CacheKeyType.RowSizeOf([]uint64{uint64(a.AttentionKeyLength), nKV, nHeadKV})
+			oai += rs
+			// https://github.com/ggerganov/llama.cpp/blob/172c8256840ffd882ab9992ecedbb587d9b21f15/llama.cpp#L7000-L7007.
+			rs = p.CacheValueType.RowSizeOf([]uint64{uint64(a.AttentionValueLength), nKV, nHeadKV})
+			oai += rs
+		default:
+			for _, l := range tfLs[il].Search(attnRegex) {
+				var rs uint64
+				switch {
+				default: // norm.
+					rs = GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
+					oai += rs
+				case strings.HasSuffix(l.Name, ".attn_q.weight"):
+					rs = GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[0], nTokens})
+					oai += rs * 2 // Qcur, Qcur + RoPE.
+					if !p.OffloadOutputLayer {
+						lai = rs // Vcur.
+					}
+					rs = GGMLTypeF32.RowSizeOf([]uint64{nKV, nTokens, nHead})
+					oai += rs // kq.
+					rs = p.CacheKeyType.RowSizeOf([]uint64{uint64(a.AttentionKeyLength), nKV, nHeadKV})
+					oai += rs * 2 // k-?, v-?.
+				case strings.HasSuffix(l.Name, ".attn_qkv.weight"):
+					rs = GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[0], nTokens})
+					oai += rs * 2 // Qcur, Qcur + RoPE.
+					if !p.OffloadOutputLayer {
+						lai = rs // Vcur.
+					}
+					rs = GGMLTypeF32.RowSizeOf([]uint64{nKV, nTokens, nHead})
+					oai += rs // kq.
+					rs = p.CacheKeyType.RowSizeOf([]uint64{uint64(a.AttentionKeyLength), nKV, nHeadKV})
+					oai += rs * 2 // k-?, v-?.
+				}
+			}
+		}
+		for _, l := range tfLs[il].Search(ffnRegex) {
+			rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
+			fi += rs
+		}
+		loadAttnInc = max(loadAttnInc, lai)
+		offloadAttnInc = max(offloadAttnInc, oai)
+		ffnInc = max(ffnInc, fi)
+	}
+	// LoRA adapters multiply the input with lora_a and lora_b in turn,
+	// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama.cpp#L7373-L7391.
+	loraInc := uint64(0)
+	if len(p.LoRAAdapters) != 0 {
+		lp := fmt.Sprintf("blk.%d.", a.BlockCount-1)
+		for _, ad := range p.LoRAAdapters {
+			for _, ti := range ad.TensorInfos {
+				if !strings.HasPrefix(ti.Name, lp) || ti.NDimensions < 2 {
+					continue
+				}
+				loraInc += GGMLTypeF32.RowSizeOf([]uint64{ti.Dimensions[1], nTokens})
+			}
+		}
+	}
+	e.Load.Computation.Compute = GGUFBytesScalar(loadAttnInc)
+	e.Offload.Computation.Compute = GGUFBytesScalar(max(offloadAttnInc, ffnInc) + loraInc)
+	// Special case: we cannot use mmap for splitting expert weights in MoE.
+	// Leading dense blocks have no experts, so search all blocks.
+	if a.ExpertCount > 0 {
+		e.NoMMap = len(tfLs.Search(regexp.MustCompile(`.*\.\d+\.ffn_gate_exps\.weight`))) == 0
+	}
+}
+
+// EstimateComputationOutput estimates the usage of the output layer.
+func (LLaMACppDefaultArchitectureEstimator) EstimateComputationOutput(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	var (
+		a       = p.Architecture
+		nTokens = p.Tokens
+
+		inpEmbd  = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, p.Batch}) // F32 [n_embd, n_batch]
+		inpSMask = GGMLTypeF32.RowSizeOf([]uint64{1, p.Parallel})              // F32 [1, n_rs]
+		inpSSeq  = GGMLTypeI32.RowSizeOf([]uint64{p.Parallel, p.Batch})        // I32 [n_rs, n_batch]
+	)
+	outInc := inpEmbd
+	if p.RecurrentLayerCount > 0 {
+		outInc += inpSMask + inpSSeq
+	}
+	if e.EmbeddingOnly {
+		// Pool the embeddings of each sequence in the batch,
+		// then score the pooled embeddings with the classifier head for reranking.
+		nSeqs := min(p.Parallel, nTokens)
+		switch e.PoolingType {
+		case "", "unspecified", "none":
+		case "mean":
+			outInc += GGMLTypeF32.RowSizeOf([]uint64{nTokens, nSeqs})           // F32 [n_tokens, n_seqs] inp_mean.
+			outInc += GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, nSeqs}) // F32 [n_embd, n_seqs] pooled.
+		default:
+			outInc += GGMLTypeI32.RowSizeOf([]uint64{nSeqs})                    // I32 [n_seqs] inp_cls.
+			outInc += GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, nSeqs}) // F32 [n_embd, n_seqs] pooled.
+			if e.PoolingType != "rank" {
+				break
+			}
+			for _, l := range p.OutputLayers.Search(regexp.MustCompile(`^cls\.(output\.)?weight$`)) {
+				rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nSeqs})
+				outInc += rs
+			}
+		}
+	} else if l, ok := p.OutputLayers.Get("output.weight"); ok {
+		rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
+		outInc += rs
+	} else if l, ok := p.InputLayers.Get("token_embd.weight"); ok {
+		rs := GGMLTypeF32.RowSizeOf([]uint64{l.Dimensions[l.NDimensions-1], nTokens})
+		outInc += rs
+	}
+	outInc += uint64(e.Load.Weight.Output)
+	e.Offload.Computation.Output = GGUFBytesScalar(outInc)
+}
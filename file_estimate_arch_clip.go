@@ -0,0 +1,159 @@
+package gguf_parser
+
+// _LLaMACppClipEstimator is the LLaMACppArchitectureEstimator of the clip models,
+// i.e. the multimodal projectors with vision encoder or audio encoder,
+// see https://github.com/ggerganov/llama.cpp/blob/148ec970b62c3c5ae0a8bfdaad2fc237aaae350d/examples/llava/clip.cpp#L994-L1008.
+type _LLaMACppClipEstimator struct {
+	LLaMACppDefaultArchitectureEstimator
+}
+
+// FullOffload implements LLaMACppArchitectureEstimator,
+// the encoders always run on the device.
+func (_LLaMACppClipEstimator) FullOffload() bool {
+	return true
+}
+
+// EstimateWeight implements LLaMACppArchitectureEstimator,
+// all weights are offloaded.
+func (_LLaMACppClipEstimator) EstimateWeight(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	e.Offload.Weight.Compute = GGUFBytesScalar(p.InputLayers.Bytes() + p.TransformerLayers.Bytes() + p.OutputLayers.Bytes())
+}
+
+// EstimateKVCache implements LLaMACppArchitectureEstimator,
+// the encoders do not cache.
+func (_LLaMACppClipEstimator) EstimateKVCache(_ *LLaMACppUsageEstimateParameters, _ *LLaMACppUsageEstimate) {
+}
+
+// EstimateComputation implements LLaMACppArchitectureEstimator.
+//
+// The images and the audios are encoded in different graphs,
+// so take the larger one of each part.
+func (_LLaMACppClipEstimator) EstimateComputation(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	a := p.Architecture
+
+	var inp, compInc, outInc uint64
+
+	// Vision,
+	// the vision encoder of clip splits an image into patches and attends over them,
+	// then the projector maps the patches into the image tokens of the model.
+	if a.ClipHasVisionEncoder && a.ClipVisionPatchSize > 0 {
+		var (
+			nPatchesPerSide = uint64(a.ClipVisionImageSize / a.ClipVisionPatchSize)
+			nPatches        = nPatchesPerSide * nPatchesPerSide
+			nPositions      = nPatches
+		)
+		if _, ok := p.File.TensorInfos.Get("v.class_embd"); ok {
+			nPositions++
+		}
+		switch a.ClipProjectorType {
+		case "ldp", "ldpv2":
+			// Downsample the patches by 2x2.
+			e.ImageTokens = nPatches / 4
+		case "resampler":
+			// Query the patches with the learnable queries of MiniCPM-V.
+			e.ImageTokens = 64
+			if a.ClipMiniCPMVVersion == 2 {
+				e.ImageTokens = 96
+			}
+		case "qwen2vl_merger", "qwen2.5vl_merger":
+			// Merge the 2x2 neighbouring patches.
+			e.ImageTokens = nPatches / 4
+		case "gemma3":
+			// Average the 4x4 neighbouring patches.
+			e.ImageTokens = nPatches / 16
+		default:
+			e.ImageTokens = nPatches
+		}
+
+		// The raw image, the positions and the patch embeddings of the vision encoder.
+		var (
+			inpRaw        = GGMLTypeF32.RowSizeOf([]uint64{uint64(a.ClipVisionImageSize), uint64(a.ClipVisionImageSize), 3}) // F32 [image_size, image_size, 3]
+			inpPosVision  = GGMLTypeI32.RowSizeOf([]uint64{nPositions})                                                      // I32 [n_positions]
+			inpEmbdVision = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, nPositions})                                   // F32 [n_embd, n_positions]
+		)
+		inp = inpRaw + inpPosVision + inpEmbdVision
+
+		// The images are encoded one by one,
+		// each layer attends over all patches without KV cache.
+		attnInc := GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, nPositions}) * 4            // Q, K, V, KQV.
+		attnInc += GGMLTypeF32.RowSizeOf([]uint64{nPositions, nPositions, a.AttentionHeadCount}) // KQ.
+		ffnInc := GGMLTypeF32.RowSizeOf([]uint64{a.FeedForwardLength, nPositions})
+		compInc = max(attnInc, ffnInc)
+
+		// The projector maps the patches into the image tokens.
+		nProj := uint64(a.ClipVisionProjectionDimension)
+		if nProj == 0 {
+			nProj = a.EmbeddingLength
+		}
+		switch a.ClipProjectorType {
+		case "resampler":
+			outInc = GGMLTypeF32.RowSizeOf([]uint64{nProj, nPatches}) * 2                         // K, V.
+			outInc += GGMLTypeF32.RowSizeOf([]uint64{nPatches, e.ImageTokens, max(nProj/128, 1)}) // KQ.
+		case "qwen2vl_merger", "qwen2.5vl_merger":
+			outInc = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength * 4, e.ImageTokens}) // merged.
+			outInc += GGMLTypeF32.RowSizeOf([]uint64{nProj, e.ImageTokens})                // projected.
+		default:
+			outInc = GGMLTypeF32.RowSizeOf([]uint64{nProj, nPatches}) * 2   // up, down.
+			outInc += GGMLTypeF32.RowSizeOf([]uint64{nProj, e.ImageTokens}) // projected.
+		}
+
+		// The tokens of each image are injected into the context in turn.
+		e.ImageTokens *= p.ImageCount
+	}
+
+	// Audio,
+	// the audio encoder of clip encodes the mel spectrogram of each 30 seconds chunk in turn,
+	// which has 100 frames per second and halves the frames by the convolution,
+	// then the projector stacks or pools the frames into the audio tokens of the model.
+	if a.ClipHasAudioEncoder && a.ClipAudioMelBins > 0 {
+		audioTokensOf := func(seconds uint64) uint64 {
+			nPos := seconds * 100 / 2
+			switch a.ClipAudioProjectorType {
+			case "ultravox", "voxtral":
+				nStack := uint64(a.ClipAudioStackFactor)
+				if nStack == 0 {
+					nStack = 8
+				}
+				return (nPos + nStack - 1) / nStack
+			case "qwen2a", "qwen2.5o":
+				return nPos / 2
+			}
+			return nPos
+		}
+		var (
+			nDuration       = p.AudioDuration
+			nMelFrames      = min(nDuration, 30) * 100
+			nAudioPositions = nMelFrames / 2
+			nAudioTokens    = audioTokensOf(min(nDuration, 30))
+		)
+		e.AudioTokens = nDuration/30*audioTokensOf(30) + audioTokensOf(nDuration%30)
+
+		// The mel spectrogram and the frame embeddings of the audio encoder.
+		var (
+			inpMel       = GGMLTypeF32.RowSizeOf([]uint64{nMelFrames, uint64(a.ClipAudioMelBins)})      // F32 [n_frames, n_mel]
+			inpEmbdAudio = GGMLTypeF32.RowSizeOf([]uint64{a.ClipAudioEmbeddingLength, nAudioPositions}) // F32 [n_embd, n_positions]
+		)
+		inp = max(inp, inpMel+inpEmbdAudio)
+
+		// The audio chunks are encoded one by one,
+		// the convolutions run over all frames before the attention layers.
+		convInc := GGMLTypeF32.RowSizeOf([]uint64{a.ClipAudioEmbeddingLength, nMelFrames}) * 2                      // conv1, gelu.
+		attnInc := GGMLTypeF32.RowSizeOf([]uint64{a.ClipAudioEmbeddingLength, nAudioPositions}) * 4                 // Q, K, V, KQV.
+		attnInc += GGMLTypeF32.RowSizeOf([]uint64{nAudioPositions, nAudioPositions, a.ClipAudioAttentionHeadCount}) // KQ.
+		ffnInc := GGMLTypeF32.RowSizeOf([]uint64{a.ClipAudioFeedForwardLength, nAudioPositions})
+		compInc = max(compInc, convInc, attnInc, ffnInc)
+
+		// The projector stacks or pools the frames into the audio tokens.
+		nProj := uint64(a.ClipAudioProjectionDimension)
+		if nProj == 0 {
+			nProj = a.ClipAudioEmbeddingLength
+		}
+		aoi := GGMLTypeF32.RowSizeOf([]uint64{a.ClipAudioEmbeddingLength, nAudioPositions}) // stacked.
+		aoi += GGMLTypeF32.RowSizeOf([]uint64{nProj, nAudioTokens}) * 2                     // up, down.
+		outInc = max(outInc, aoi)
+	}
+
+	e.Offload.Computation.Input = GGUFBytesScalar(inp)
+	e.Offload.Computation.Compute = GGUFBytesScalar(compInc)
+	e.Offload.Computation.Output = GGUFBytesScalar(outInc)
+}
@@ -0,0 +1,13 @@
+package gguf_parser
+
+// _LLaMACppGrokEstimator is the LLaMACppArchitectureEstimator of the Grok models.
+type _LLaMACppGrokEstimator struct {
+	LLaMACppDefaultArchitectureEstimator
+}
+
+// SupportFlashAttention implements LLaMACppArchitectureEstimator,
+// Grok is not compatible with flash attention,
+// see https://github.com/ggerganov/llama.cpp/blob/172c8256840ffd882ab9992ecedbb587d9b21f15/llama.cpp#L16050-L16053.
+func (_LLaMACppGrokEstimator) SupportFlashAttention() bool {
+	return false
+}
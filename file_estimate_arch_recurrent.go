@@ -0,0 +1,61 @@
+package gguf_parser
+
+// estimateRecurrentComputationInput estimates the usage of the input tensors of the pure recurrent models,
+// which take the states of each sequence instead of the positions and the KQ mask.
+func estimateRecurrentComputationInput(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	var (
+		inpTokens = GGMLTypeI32.RowSizeOf([]uint64{p.Batch})                                 // I32 [n_batch]
+		inpEmbd   = GGMLTypeF32.RowSizeOf([]uint64{p.Architecture.EmbeddingLength, p.Batch}) // F32 [n_embd, n_batch]
+		inpOutIds = GGMLTypeI32.RowSizeOf([]uint64{p.Outputs})                               // I32 [n_outputs],
+		inpSMask  = GGMLTypeF32.RowSizeOf([]uint64{1, p.Parallel})                           // F32 [1, n_rs]
+		inpSSeq   = GGMLTypeI32.RowSizeOf([]uint64{p.Parallel, p.Batch})                     // I32 [n_rs, n_batch]
+	)
+	e.Load.Computation.Input = GGUFBytesScalar(inpTokens + inpEmbd + inpSMask + inpSSeq + inpOutIds)
+	e.Offload.Computation.Input = GGUFBytesScalar(inpEmbd + inpSMask + inpSSeq + inpOutIds)
+}
+
+// _LLaMACppSSMEstimator is the LLaMACppArchitectureEstimator of the Mamba models.
+type _LLaMACppSSMEstimator struct {
+	LLaMACppDefaultArchitectureEstimator
+}
+
+// EstimateComputation implements LLaMACppArchitectureEstimator.
+func (s _LLaMACppSSMEstimator) EstimateComputation(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	estimateRecurrentComputationInput(p, e)
+	if tfLs := p.TransformerLayers; len(tfLs) != 0 {
+		e.Offload.Computation.Compute = GGUFBytesScalar(p.ssmComputation(tfLs[len(tfLs)-1]))
+	}
+	s.EstimateComputationOutput(p, e)
+}
+
+// _LLaMACppRWKVEstimator is the LLaMACppArchitectureEstimator of the RWKV models.
+type _LLaMACppRWKVEstimator struct {
+	LLaMACppDefaultArchitectureEstimator
+}
+
+// EstimateComputation implements LLaMACppArchitectureEstimator.
+func (r _LLaMACppRWKVEstimator) EstimateComputation(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	estimateRecurrentComputationInput(p, e)
+
+	// The time mix interpolates the shifted tokens for r/k/v/g/w,
+	// then runs the WKV over the tokens of each sequence with its state.
+	var (
+		a         = p.Architecture
+		nTokens   = p.Tokens
+		nKV       = p.KV
+		nHeadSize = uint64(a.RWKVHeadSize)
+		nStateInc = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingKeyGQA, nKV}) // F32 [n_embd_k_s, n_seqs] reshape
+	)
+	tmInc := nStateInc
+	tmInc += GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, nTokens}) * 10                             // xxx, xw, xk, xv, xr, xg, r, k, v, g.
+	tmInc += GGMLTypeF32.RowSizeOf([]uint64{uint64(a.RWKVTimeMixExtraDimension) * 5, nTokens})            // time_mix_w1.
+	tmInc += GGMLTypeF32.RowSizeOf([]uint64{uint64(a.RWKVTimeDecayExtraDimension), nTokens})              // time_decay_w1.
+	tmInc += GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength*nTokens + a.EmbeddingLength*nHeadSize*nKV}) // wkv output and state.
+	// The channel mix is a squared ReLU feed forward over the shifted tokens.
+	cmInc := nStateInc
+	cmInc += GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, nTokens}) * 2 // xk, xr.
+	cmInc += GGMLTypeF32.RowSizeOf([]uint64{a.FeedForwardLength, nTokens})   // k.
+	e.Offload.Computation.Compute = GGUFBytesScalar(max(tmInc, cmInc))
+
+	r.EstimateComputationOutput(p, e)
+}
@@ -0,0 +1,92 @@
+package gguf_parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// _LLaMACppT5Estimator is the LLaMACppArchitectureEstimator of the T5 models,
+// including the encoder-decoder models and the encoder-only models.
+type _LLaMACppT5Estimator struct {
+	LLaMACppDefaultArchitectureEstimator
+}
+
+// EstimateWeight implements LLaMACppArchitectureEstimator.
+//
+// The encoder block and the decoder block of the same index are placed together,
+// and the final norms of both stacks go with the output layer.
+func (_LLaMACppT5Estimator) EstimateWeight(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	for _, ti := range p.TransformerLayers.Search(regexp.MustCompile(`.*`)) {
+		switch {
+		case strings.HasPrefix(ti.Name, "enc.blk."), strings.HasPrefix(ti.Name, "dec.blk."):
+			p.UsageOf(e, ti.stackLayerIndex()).Weight.Compute += GGUFBytesScalar(ti.Bytes())
+		case p.OffloadOutputLayer:
+			e.Offload.Weight.Compute += GGUFBytesScalar(ti.Bytes())
+		default:
+			e.Load.Weight.Compute += GGUFBytesScalar(ti.Bytes())
+		}
+	}
+}
+
+// EstimateKVCache implements LLaMACppArchitectureEstimator,
+// only the decoder stack caches, so the encoder-only models have no KV cache.
+func (t _LLaMACppT5Estimator) EstimateKVCache(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	if p.Architecture.DecoderBlockCount == 0 {
+		return
+	}
+	t.LLaMACppDefaultArchitectureEstimator.EstimateKVCache(p, e)
+}
+
+// EstimateComputation implements LLaMACppArchitectureEstimator.
+func (t _LLaMACppT5Estimator) EstimateComputation(p *LLaMACppUsageEstimateParameters, e *LLaMACppUsageEstimate) {
+	var (
+		a       = p.Architecture
+		nTokens = p.Tokens
+		nBatch  = p.Batch
+		nKV     = p.KV
+	)
+
+	// The relative position buckets and the encoder output with its mask for cross attention.
+	{
+		var (
+			inpTokens      = GGMLTypeI32.RowSizeOf([]uint64{nBatch})                     // I32 [n_batch]
+			inpEmbd        = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, nBatch})  // F32 [n_embd, n_batch]
+			inpOutIds      = GGMLTypeI32.RowSizeOf([]uint64{p.Outputs})                  // I32 [n_outputs],
+			inpKQMask      = GGMLTypeF32.RowSizeOf([]uint64{nKV, nBatch})                // F32 [n_kv, n_batch]
+			inpPosBucket   = GGMLTypeI32.RowSizeOf([]uint64{nKV, nBatch})                // I32 [n_kv, n_batch]
+			inpEmbdEnc     = GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingLength, nTokens}) // F32 [n_embd, n_outputs_enc]
+			inpKQMaskCross = GGMLTypeF32.RowSizeOf([]uint64{nTokens, nBatch})            // F32 [n_outputs_enc, n_batch]
+		)
+		inp := inpEmbd + inpKQMask + inpPosBucket + inpOutIds
+		if a.DecoderBlockCount > 0 {
+			inp += inpEmbdEnc + inpKQMaskCross
+		}
+		e.Load.Computation.Input = GGUFBytesScalar(inpTokens + inp)
+		e.Offload.Computation.Input = GGUFBytesScalar(inp)
+	}
+
+	// The encoder runs once over the prompt without KV cache,
+	// then each decoder layer attends to its KV cache and to the encoder output.
+	{
+		var (
+			nEmbdQ = uint64(a.AttentionKeyLength) * a.AttentionHeadCount
+			nEnc   = nTokens
+			ffnInc = GGMLTypeF32.RowSizeOf([]uint64{a.FeedForwardLength, nTokens}) * 2 // up, gate.
+		)
+		encInc := GGMLTypeF32.RowSizeOf([]uint64{nEmbdQ, nTokens}) * 3                        // Qcur, Kcur, Vcur.
+		encInc += GGMLTypeF32.RowSizeOf([]uint64{nTokens, nTokens, a.AttentionHeadCount}) * 2 // kq, kq_pos_bias.
+		compInc := max(encInc, ffnInc)
+		if a.DecoderBlockCount > 0 {
+			selfInc := GGMLTypeF32.RowSizeOf([]uint64{nEmbdQ, nTokens})                                                                       // Qcur.
+			selfInc += GGMLTypeF32.RowSizeOf([]uint64{nKV, nTokens, a.AttentionHeadCount}) * 2                                                // kq, kq_pos_bias.
+			selfInc += p.CacheKeyType.RowSizeOf([]uint64{uint64(a.AttentionKeyLength), nKV, a.AttentionHeadCountKV}) * 2                      // k-?, v-?.
+			crossInc := GGMLTypeF32.RowSizeOf([]uint64{nEmbdQ, nTokens})                                                                      // Qcur.
+			crossInc += GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingKeyGQA, nEnc}) + GGMLTypeF32.RowSizeOf([]uint64{a.EmbeddingValueGQA, nEnc}) // Kcur, Vcur.
+			crossInc += GGMLTypeF32.RowSizeOf([]uint64{nEnc, nTokens, a.AttentionHeadCount})                                                  // kq.
+			compInc = max(compInc, selfInc, crossInc)
+		}
+		e.Offload.Computation.Compute = GGUFBytesScalar(compInc)
+	}
+
+	t.EstimateComputationOutput(p, e)
+}
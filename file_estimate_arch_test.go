@@ -0,0 +1,92 @@
+package gguf_parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type _testLLaMACppNoKVCacheEstimator struct {
+	LLaMACppDefaultArchitectureEstimator
+}
+
+func (_testLLaMACppNoKVCacheEstimator) EstimateKVCache(_ *LLaMACppUsageEstimateParameters, _ *LLaMACppUsageEstimate) {
+}
+
+func TestRegisterLLaMACppArchitectureEstimator(t *testing.T) {
+	assert.Error(t, RegisterLLaMACppArchitectureEstimator("", _testLLaMACppNoKVCacheEstimator{}),
+		"blank architecture should be rejected")
+	assert.Error(t, RegisterLLaMACppArchitectureEstimator("llama-fork", nil),
+		"nil estimator should be rejected")
+	assert.Error(t, RegisterLLaMACppArchitectureEstimator("clip", _testLLaMACppNoKVCacheEstimator{}),
+		"built-in architecture should not be overridden")
+
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "llama-fork"),
+				testKV("llama-fork.context_length", GGUFMetadataValueTypeUint32, uint32(4096)),
+				testKV("llama-fork.embedding_length", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("llama-fork.feed_forward_length", GGUFMetadataValueTypeUint32, uint32(512)),
+				testKV("llama-fork.block_count", GGUFMetadataValueTypeUint32, uint32(2)),
+				testKV("llama-fork.attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			testTensor("token_embd.weight", 128, 32),
+			testTensor("blk.0.attn_q.weight", 128, 128),
+			testTensor("blk.1.attn_q.weight", 128, 128),
+			testTensor("output.weight", 128, 32),
+		},
+	}
+
+	// The unregistered architecture falls back to the default estimator.
+	de := f.EstimateLLaMACppUsage()
+	assert.NotZero(t, de.Offload.KVCache.Sum())
+
+	assert.NoError(t, RegisterLLaMACppArchitectureEstimator("llama-fork", _testLLaMACppNoKVCacheEstimator{}))
+	t.Cleanup(func() { unregisterLLaMACppArchitectureEstimator("llama-fork") })
+	assert.Error(t, RegisterLLaMACppArchitectureEstimator("llama-fork", _testLLaMACppNoKVCacheEstimator{}),
+		"registered architecture should not be registered again")
+
+	// The registered estimator only overrides the KV cache.
+	e := f.EstimateLLaMACppUsage()
+	assert.Zero(t, e.Load.KVCache.Sum())
+	assert.Zero(t, e.Offload.KVCache.Sum())
+	assert.Equal(t, de.Load.Weight, e.Load.Weight)
+	assert.Equal(t, de.Offload.Weight, e.Offload.Weight)
+	assert.Equal(t, de.Offload.Computation, e.Offload.Computation)
+}
+
+func TestLLaMACppArchitectureEstimator_Overrides(t *testing.T) {
+	f := func(arch string) *GGUFFile {
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: GGUFMetadataKVs{
+					testKV("general.architecture", GGUFMetadataValueTypeString, arch),
+					testKV(arch+".context_length", GGUFMetadataValueTypeUint32, uint32(4096)),
+					testKV(arch+".embedding_length", GGUFMetadataValueTypeUint32, uint32(128)),
+					testKV(arch+".feed_forward_length", GGUFMetadataValueTypeUint32, uint32(512)),
+					testKV(arch+".block_count", GGUFMetadataValueTypeUint32, uint32(2)),
+					testKV(arch+".attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+				},
+			},
+			TensorInfos: GGUFTensorInfos{
+				testTensor("token_embd.weight", 128, 32),
+				testTensor("blk.0.attn_q.weight", 128, 128),
+				testTensor("blk.1.attn_q.weight", 128, 128),
+				testTensor("output.weight", 128, 32),
+			},
+		}
+	}
+
+	// Grok does not support the flash attention.
+	assert.True(t, f("llama").EstimateLLaMACppUsage(WithFlashAttention()).FlashAttention)
+	assert.False(t, f("grok").EstimateLLaMACppUsage(WithFlashAttention()).FlashAttention)
+
+	// Clip is always fully offloaded.
+	e := f("llama").EstimateLLaMACppUsage(WithOffloadLayers(0))
+	assert.Equal(t, uint64(0), e.OffloadLayers)
+	e = f("clip").EstimateLLaMACppUsage(WithOffloadLayers(0))
+	assert.True(t, e.FullOffloaded)
+}
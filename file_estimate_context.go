@@ -0,0 +1,69 @@
+package gguf_parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LLaMACppContextSizeSuggestion represents the context size suggested for the given prompt.
+type LLaMACppContextSizeSuggestion struct {
+	// PromptTokens is the number of tokens of the rendered prompt.
+	PromptTokens uint64 `json:"promptTokens"`
+	// PredictTokens is the number of tokens expected to generate.
+	PredictTokens uint64 `json:"predictTokens"`
+	// ParallelSize is the number of parallel sequences to decode,
+	// each of which holds a prompt and its generation.
+	ParallelSize uint64 `json:"parallelSize"`
+	// ContextSize is the suggested size of the context,
+	// which is padded to a multiple of 256.
+	ContextSize uint64 `json:"contextSize"`
+	// MaximumContextLength is the maximum context length of the model,
+	// the suggested context size may exceed it.
+	MaximumContextLength uint64 `json:"maximumContextLength"`
+}
+
+// SuggestLLaMACppContextSize suggests the context size for the given messages,
+// which are rendered with the default chat template and tokenized with the vocabulary of the GGUF file,
+// then plus the number of tokens to predict for each of the parallel sequences.
+//
+// The messages are joined by line breaks if the GGUF file has no chat template.
+//
+// The GGUF file must be parsed without SkipLargeMetadata,
+// or keeps GGUFTokenizerVocabularyKeys by KeepLargeMetadata.
+//
+// The result can feed to EstimateLLaMACppUsage with WithContextSize.
+func (gf *GGUFFile) SuggestLLaMACppContextSize(messages []GGUFChatMessage, nPredict, nParallel int32) (s LLaMACppContextSizeSuggestion, err error) {
+	if len(messages) == 0 {
+		return s, errors.New("no messages")
+	}
+
+	t, err := gf.NewTokenizer()
+	if err != nil {
+		return s, fmt.Errorf("new tokenizer: %w", err)
+	}
+
+	var prompt string
+	if _, ok := gf.ChatTemplate("default"); ok {
+		prompt, err = gf.RenderChat(messages, true)
+		if err != nil {
+			return s, err
+		}
+	} else {
+		cs := make([]string, len(messages))
+		for i := range messages {
+			cs[i] = messages[i].Content
+		}
+		prompt = strings.Join(cs, "\n")
+	}
+
+	// Tokenize with the special tokens as llama.cpp server does.
+	s.PromptTokens = uint64(len(t.Tokenize(prompt, true, true)))
+	s.PredictTokens = uint64(max(nPredict, 0))
+	s.ParallelSize = uint64(max(nParallel, 1))
+	// Each parallel sequence takes an equal slot of the context in llama.cpp server.
+	s.ContextSize = GGMLPadding((s.PromptTokens+s.PredictTokens)*s.ParallelSize, 256)
+	s.MaximumContextLength = gf.Architecture().MaximumContextLength
+
+	return s, nil
+}
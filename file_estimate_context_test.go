@@ -0,0 +1,78 @@
+package gguf_parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGGUFFile_SuggestLLaMACppContextSize(t *testing.T) {
+	vocab := func(kvs ...GGUFMetadataKV) *GGUFFile {
+		array := func(key string, typ GGUFMetadataValueType, vs ...any) GGUFMetadataKV {
+			return GGUFMetadataKV{
+				Key:       key,
+				ValueType: GGUFMetadataValueTypeArray,
+				Value:     GGUFMetadataKVArrayValue{Type: typ, Len: uint64(len(vs)), Array: vs},
+			}
+		}
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: append(GGUFMetadataKVs{
+					{Key: "general.architecture", ValueType: GGUFMetadataValueTypeString, Value: "llama"},
+					{Key: "llama.context_length", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(512)},
+					{Key: "tokenizer.ggml.model", ValueType: GGUFMetadataValueTypeString, Value: "llama"},
+					{Key: "tokenizer.ggml.bos_token_id", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(1)},
+					{Key: "tokenizer.ggml.eos_token_id", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(2)},
+					array("tokenizer.ggml.tokens", GGUFMetadataValueTypeString,
+						"<unk>", "<s>", "</s>", "▁", "a", "b", "▁a", "ab", "▁ab"),
+					array("tokenizer.ggml.scores", GGUFMetadataValueTypeFloat32,
+						float32(0), float32(0), float32(0), float32(-1), float32(-2), float32(-3), float32(-4), float32(-5), float32(-6)),
+					array("tokenizer.ggml.token_type", GGUFMetadataValueTypeInt32,
+						int32(2), int32(3), int32(3), int32(1), int32(1), int32(1), int32(1), int32(1), int32(1)),
+				}, kvs...),
+			},
+		}
+	}
+	msgs := []GGUFChatMessage{
+		{Role: "user", Content: "ab"},
+		{Role: "assistant", Content: "ab"},
+	}
+
+	t.Run("without chat template", func(t *testing.T) {
+		// <s> ▁ab <unk> ab, the line break is unknown.
+		actual, err := vocab().SuggestLLaMACppContextSize(msgs, 100, 2)
+		require.NoError(t, err)
+		assert.Equal(t, LLaMACppContextSizeSuggestion{
+			PromptTokens:         4,
+			PredictTokens:        100,
+			ParallelSize:         2,
+			ContextSize:          256,
+			MaximumContextLength: 512,
+		}, actual)
+	})
+
+	t.Run("with chat template", func(t *testing.T) {
+		gf := vocab(GGUFMetadataKV{
+			Key:       "tokenizer.chat_template",
+			ValueType: GGUFMetadataValueTypeString,
+			Value:     "{{ bos_token }}{% for m in messages %}{{ m.content }}</s>{% endfor %}",
+		})
+		// <s> <s> ▁ab </s> ▁ab </s>.
+		actual, err := gf.SuggestLLaMACppContextSize(msgs, 251, 0)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(6), actual.PromptTokens)
+		assert.Equal(t, uint64(1), actual.ParallelSize)
+		assert.Equal(t, uint64(512), actual.ContextSize)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := vocab().SuggestLLaMACppContextSize(nil, 0, 1)
+		assert.Error(t, err)
+
+		gf := vocab()
+		gf.Header.MetadataKV = gf.Header.MetadataKV[:5]
+		_, err = gf.SuggestLLaMACppContextSize(msgs, 0, 1)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,170 @@
+package gguf_parser
+
+import (
+	"regexp"
+)
+
+// estimateLLaMACppDevices splits the offloaded usage of the estimate across the devices by the given proportions,
+// like the `--tensor-split`, `--split-mode` and `--main-gpu` of llama.cpp.
+//
+// The computation buffers are always placed on the main device.
+func estimateLLaMACppDevices(
+	ae LLaMACppArchitectureEstimator,
+	p *LLaMACppUsageEstimateParameters,
+	e *LLaMACppUsageEstimate,
+	split []float64,
+	mode LLaMACppSplitMode,
+	main uint64,
+) []LLaMACppMemoryUsage {
+	ds := make([]LLaMACppMemoryUsage, len(split))
+
+	// Normalize the proportions into the cumulative splits,
+	// split evenly if all proportions are zero.
+	splits := make([]float64, len(split))
+	{
+		var sum float64
+		for i := range split {
+			sum += split[i]
+			splits[i] = sum
+		}
+		for i := range splits {
+			if sum == 0 {
+				splits[i] = float64(i+1) / float64(len(splits))
+			} else {
+				splits[i] /= sum
+			}
+		}
+	}
+
+	// Clip encodes on a single device,
+	// and the layers stay on the main device without splitting.
+	if p.Architecture.Architecture == "clip" || mode == LLaMACppSplitModeNone || len(ds) == 1 {
+		ds[main] = e.Offload
+		return ds
+	}
+
+	// usageFrom returns the offloaded usage of the layers from the given one to the last one,
+	// excluding the output layer.
+	usageFrom := func(il uint64) LLaMACppMemoryUsage {
+		q := *p
+		q.LoadLayers, q.OffloadLayers, q.OffloadOutputLayer = il, p.Architecture.BlockCount-il, false
+		var qe LLaMACppUsageEstimate
+		ae.EstimateWeight(&q, &qe)
+		ae.EstimateKVCache(&q, &qe)
+		q.estimateAdapters(&qe)
+		return qe.Offload
+	}
+
+	// The output layer and the rest go to the main device unless assigned.
+	outDev := main
+
+	switch mode {
+	default: // LLaMACppSplitModeLayer.
+		// Assign each layer to the device whose split covers the layer,
+		// then the output layer follows as the last one.
+		nAct := p.OffloadLayers
+		if p.OffloadOutputLayer {
+			nAct++
+		}
+		deviceOf := func(il uint64) uint64 {
+			x := float64(il-p.LoadLayers) / float64(nAct)
+			for i := range splits {
+				if splits[i] > x {
+					return uint64(i)
+				}
+			}
+			return uint64(len(splits) - 1)
+		}
+		if p.OffloadOutputLayer {
+			outDev = deviceOf(p.Architecture.BlockCount)
+		}
+
+		// The usage of the layers in [il, to) is the difference of the usage from il and from to.
+		prev := usageFrom(p.LoadLayers)
+		for il := p.LoadLayers; il < p.Architecture.BlockCount; {
+			d, to := deviceOf(il), il+1
+			for to < p.Architecture.BlockCount && deviceOf(to) == d {
+				to++
+			}
+			next := usageFrom(to)
+			ds[d] = ds[d].add(prev.sub(next))
+			prev, il = next, to
+		}
+	case LLaMACppSplitModeRow:
+		// Split the rows of the matrices of the offloaded layers by the proportions,
+		// the rest stays on the main device.
+		var mb, ab uint64
+		for _, ti := range p.TransformerLayers.Search(regexp.MustCompile(`.*`)) {
+			if ti.NDimensions >= 2 {
+				mb += ti.Bytes()
+			}
+			ab += ti.Bytes()
+		}
+		if ab == 0 {
+			break
+		}
+		rb := float64(usageFrom(p.LoadLayers).Weight.Compute) * float64(mb) / float64(ab)
+		for i := range ds {
+			if uint64(i) == main {
+				continue
+			}
+			prop := splits[i]
+			if i > 0 {
+				prop -= splits[i-1]
+			}
+			ds[i].Weight.Compute = GGUFBytesScalar(rb * prop)
+		}
+	}
+
+	// The rest of the weights and the KV cache.
+	rest := e.Offload
+	rest.Computation = LLaMACppComputationUsage{}
+	for i := range ds {
+		rest = rest.sub(ds[i])
+	}
+	ds[outDev] = ds[outDev].add(rest)
+
+	// The computation.
+	ds[main].Computation = e.Offload.Computation
+
+	return ds
+}
+
+// add returns the sum of the memory usages.
+func (u LLaMACppMemoryUsage) add(v LLaMACppMemoryUsage) LLaMACppMemoryUsage {
+	u.Footprint += v.Footprint
+	u.Weight.Input += v.Weight.Input
+	u.Weight.Compute += v.Weight.Compute
+	u.Weight.Output += v.Weight.Output
+	u.Weight.LoRA += v.Weight.LoRA
+	u.Weight.ControlVector += v.Weight.ControlVector
+	u.KVCache.Key += v.KVCache.Key
+	u.KVCache.Value += v.KVCache.Value
+	u.KVCache.SlidingWindowKey += v.KVCache.SlidingWindowKey
+	u.KVCache.SlidingWindowValue += v.KVCache.SlidingWindowValue
+	u.Computation.Footprint += v.Computation.Footprint
+	u.Computation.Input += v.Computation.Input
+	u.Computation.Compute += v.Computation.Compute
+	u.Computation.Output += v.Computation.Output
+	return u
+}
+
+// sub returns the difference of the memory usages,
+// the given usage must be a part of the receiver.
+func (u LLaMACppMemoryUsage) sub(v LLaMACppMemoryUsage) LLaMACppMemoryUsage {
+	u.Footprint -= v.Footprint
+	u.Weight.Input -= v.Weight.Input
+	u.Weight.Compute -= v.Weight.Compute
+	u.Weight.Output -= v.Weight.Output
+	u.Weight.LoRA -= v.Weight.LoRA
+	u.Weight.ControlVector -= v.Weight.ControlVector
+	u.KVCache.Key -= v.KVCache.Key
+	u.KVCache.Value -= v.KVCache.Value
+	u.KVCache.SlidingWindowKey -= v.KVCache.SlidingWindowKey
+	u.KVCache.SlidingWindowValue -= v.KVCache.SlidingWindowValue
+	u.Computation.Footprint -= v.Computation.Footprint
+	u.Computation.Input -= v.Computation.Input
+	u.Computation.Compute -= v.Computation.Compute
+	u.Computation.Output -= v.Computation.Output
+	return u
+}
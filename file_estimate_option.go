@@ -1,6 +1,7 @@
 package gguf_parser
 
 import (
+	"math"
 	"slices"
 
 	"github.com/gpustack/gguf-parser-go/util/ptr"
@@ -19,9 +20,17 @@ type (
 		CacheValueType      *GGMLType
 		OffloadKVCache      *bool
 		OffloadLayers       *uint64
+		TensorSplit         []float64
+		SplitMode           LLaMACppSplitMode
+		MainGPU             uint64
 		FlashAttention      bool
+		FullSWACache        bool
+		ImageCount          *int32
+		AudioDuration       *int32
 		MultimodalProjector *LLaMACppUsageEstimate
 		Drafter             *LLaMACppUsageEstimate
+		LoRAAdapters        []*GGUFFile
+		ControlVectors      []GGUFControlVectorMetadata
 	}
 	LLaMACppUsageEstimateOption func(*_LLaMACppUsageEstimateOptions)
 )
@@ -134,6 +143,59 @@ func WithOffloadLayers(layers uint64) LLaMACppUsageEstimateOption {
 	}
 }
 
+// LLaMACppSplitMode is the mode to split the offloaded layers across multiple devices,
+// like `--split-mode` of llama.cpp.
+type LLaMACppSplitMode uint32
+
+// LLaMACppSplitMode constants.
+const (
+	// LLaMACppSplitModeLayer assigns each layer and its KV cache to a device.
+	LLaMACppSplitModeLayer LLaMACppSplitMode = iota
+	// LLaMACppSplitModeRow splits the rows of each matrix across the devices,
+	// and keeps the rest of each layer and its KV cache on the main device.
+	LLaMACppSplitModeRow
+	// LLaMACppSplitModeNone keeps all offloaded layers on the main device.
+	LLaMACppSplitModeNone
+)
+
+// WithTensorSplit sets the proportions to split the offloaded layers across multiple devices,
+// like `--tensor-split` of llama.cpp,
+// the proportions are split evenly if all of them are zero,
+// and the split is ignored if any proportion is negative or not finite.
+func WithTensorSplit(split []float64) LLaMACppUsageEstimateOption {
+	return func(o *_LLaMACppUsageEstimateOptions) {
+		if len(split) == 0 {
+			return
+		}
+		for _, v := range split {
+			if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+				return
+			}
+		}
+		o.TensorSplit = slices.Clone(split)
+	}
+}
+
+// WithSplitMode sets the mode to split the offloaded layers across multiple devices,
+// which is only used with WithTensorSplit.
+func WithSplitMode(mode LLaMACppSplitMode) LLaMACppUsageEstimateOption {
+	return func(o *_LLaMACppUsageEstimateOptions) {
+		if mode > LLaMACppSplitModeNone {
+			return
+		}
+		o.SplitMode = mode
+	}
+}
+
+// WithMainGPU sets the index of the main device,
+// which holds the computation buffers, and the whole layers in LLaMACppSplitModeRow or LLaMACppSplitModeNone,
+// the first device is used if the index is not less than the length of WithTensorSplit.
+func WithMainGPU(index uint64) LLaMACppUsageEstimateOption {
+	return func(o *_LLaMACppUsageEstimateOptions) {
+		o.MainGPU = index
+	}
+}
+
 // WithFlashAttention sets the flash attention flag.
 func WithFlashAttention() LLaMACppUsageEstimateOption {
 	return func(o *_LLaMACppUsageEstimateOptions) {
@@ -141,6 +203,36 @@ func WithFlashAttention() LLaMACppUsageEstimateOption {
 	}
 }
 
+// WithFullSWACache keeps the full size KV cache for the sliding window attention layers,
+// which allows reusing the cached prompt beyond the window, like `--swa-full` of llama.cpp.
+func WithFullSWACache() LLaMACppUsageEstimateOption {
+	return func(o *_LLaMACppUsageEstimateOptions) {
+		o.FullSWACache = true
+	}
+}
+
+// WithImageCount sets the number of images in the context for the estimate,
+// which is only used for the clip models with vision encoder.
+func WithImageCount(count int32) LLaMACppUsageEstimateOption {
+	return func(o *_LLaMACppUsageEstimateOptions) {
+		if count <= 0 {
+			return
+		}
+		o.ImageCount = &count
+	}
+}
+
+// WithAudioDuration sets the duration of the audio in seconds for the estimate,
+// which is only used for the clip models with audio encoder.
+func WithAudioDuration(seconds int32) LLaMACppUsageEstimateOption {
+	return func(o *_LLaMACppUsageEstimateOptions) {
+		if seconds <= 0 {
+			return
+		}
+		o.AudioDuration = &seconds
+	}
+}
+
 // WithMultimodalProjector sets the multimodal projector estimate usage.
 func WithMultimodalProjector(mmp *LLaMACppUsageEstimate) LLaMACppUsageEstimateOption {
 	return func(o *_LLaMACppUsageEstimateOptions) {
@@ -154,3 +246,19 @@ func WithDrafter(dft *LLaMACppUsageEstimate) LLaMACppUsageEstimateOption {
 		o.Drafter = dft
 	}
 }
+
+// WithLoRAAdapters sets the LoRA adapters for the estimate,
+// the adapters should be validated by GGUFFile.ValidateLoRAAdapter in advance.
+func WithLoRAAdapters(adapters ...*GGUFFile) LLaMACppUsageEstimateOption {
+	return func(o *_LLaMACppUsageEstimateOptions) {
+		o.LoRAAdapters = append(o.LoRAAdapters, adapters...)
+	}
+}
+
+// WithControlVectors sets the control vectors for the estimate,
+// the control vectors should be validated by GGUFFile.ValidateControlVector in advance.
+func WithControlVectors(cvs ...GGUFControlVectorMetadata) LLaMACppUsageEstimateOption {
+	return func(o *_LLaMACppUsageEstimateOptions) {
+		o.ControlVectors = append(o.ControlVectors, cvs...)
+	}
+}
@@ -0,0 +1,329 @@
+package gguf_parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gpustack/gguf-parser-go/util/ptr"
+)
+
+// Types for StableDiffusionCpp estimation.
+type (
+	// StableDiffusionCppUsageEstimate represents the estimated result of loading the GGUF file in stable-diffusion.cpp.
+	StableDiffusionCppUsageEstimate struct {
+		// Architecture describes what architecture the diffusion model implements.
+		Architecture string `json:"architecture"`
+		// Width is the width of the generated images.
+		Width uint32 `json:"width"`
+		// Height is the height of the generated images.
+		Height uint32 `json:"height"`
+		// BatchCount is the number of the generated images.
+		BatchCount int32 `json:"batchCount"`
+		// VAETiling is the flag to indicate whether decoding the latents in tiles,
+		// true for enable.
+		VAETiling bool `json:"vaeTiling"`
+		// Footprint is the memory footprint for bootstrapping,
+		// which includes the latents and the generated images.
+		Footprint GGUFBytesScalar `json:"footprint"`
+		// Conditioner is the memory usage of the conditioner(text encoders),
+		// which is nil if the conditioner is not bundled in the file.
+		Conditioner *StableDiffusionCppComponentUsage `json:"conditioner,omitempty"`
+		// Diffusion is the memory usage of the diffusion model.
+		Diffusion StableDiffusionCppComponentUsage `json:"diffusion"`
+		// Autoencoder is the memory usage of the autoencoder(VAE),
+		// which is nil if the autoencoder is not bundled in the file.
+		Autoencoder *StableDiffusionCppComponentUsage `json:"autoencoder,omitempty"`
+	}
+
+	// StableDiffusionCppComponentUsage represents the memory usage of a component in stable-diffusion.cpp.
+	StableDiffusionCppComponentUsage struct {
+		// Offloaded is the flag to indicate whether the component is placed in VRAM,
+		// false for RAM.
+		Offloaded bool `json:"offloaded"`
+		// Weight is the memory usage of loading weights.
+		Weight GGUFBytesScalar `json:"weight"`
+		// Computation is the memory usage of computation.
+		Computation GGUFBytesScalar `json:"computation"`
+	}
+)
+
+// Hyperparameters hard-coded by stable-diffusion.cpp rather than stored in the model files,
+// which are used when they cannot be read from the tensor shapes.
+const (
+	// _SDCppCLIPTokens is the number of the padded CLIP prompt tokens,
+	// see the CLIP embedders in stable-diffusion.cpp conditioner.hpp.
+	_SDCppCLIPTokens = 77
+	// _SDCppT5Tokens is the number of the padded T5 prompt tokens,
+	// see the T5 embedders in stable-diffusion.cpp conditioner.hpp.
+	_SDCppT5Tokens = 512
+	// _SDCppTextHeadDim is the head dimension of the text encoders,
+	// see stable-diffusion.cpp clip.hpp and t5.hpp.
+	_SDCppTextHeadDim = 64
+	// _SDCppFluxHiddenSize is the hidden size of Flux,
+	// see FluxParams in stable-diffusion.cpp flux.hpp.
+	_SDCppFluxHiddenSize = 3072
+	// _SDCppFluxHeadDim is the head dimension of Flux, i.e. hidden_size / num_heads,
+	// see FluxParams in stable-diffusion.cpp flux.hpp.
+	_SDCppFluxHeadDim = 128
+	// _SDCppMMDiTHeadDim is the head dimension of SD3,
+	// see MMDiT in stable-diffusion.cpp mmdit.hpp.
+	_SDCppMMDiTHeadDim = 64
+	// _SDCppUNetChannels is the number of the channels of the first level of the UNet, i.e. model_channels,
+	// see UnetModelBlock in stable-diffusion.cpp unet.hpp.
+	_SDCppUNetChannels = 320
+	// _SDCppUNetHeadCount is the number of the attention heads of SD 1.x, i.e. num_heads,
+	// see UnetModelBlock in stable-diffusion.cpp unet.hpp.
+	_SDCppUNetHeadCount = 8
+	// _SDCppUNetHeadDim is the head dimension of SD 2.x and SDXL, i.e. num_head_channels,
+	// see UnetModelBlock in stable-diffusion.cpp unet.hpp.
+	_SDCppUNetHeadDim = 64
+)
+
+// EstimateStableDiffusionCppUsage returns the inference memory usage estimated result of the GGUF file in stable-diffusion.cpp.
+//
+// The computation is approximated by the largest activations of each component,
+// i.e. the attention scores of the highest resolution in the diffusion model,
+// and the full resolution feature maps in the autoencoder.
+func (gf *GGUFFile) EstimateStableDiffusionCppUsage(opts ...StableDiffusionCppUsageEstimateOption) (e StableDiffusionCppUsageEstimate) {
+	var o _StableDiffusionCppUsageEstimateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// Architecture.
+	var a GGUFArchitectureMetadata
+	if o.Architecture != nil {
+		a = *o.Architecture
+	} else {
+		a = gf.Architecture()
+	}
+	e.Architecture = a.DiffusionArchitecture
+
+	if o.Width == nil || o.Height == nil {
+		// The native resolution of the model.
+		switch a.DiffusionArchitecture {
+		case "Stable Diffusion 1.x":
+			o.Width, o.Height = ptr.To(uint32(512)), ptr.To(uint32(512))
+		case "Stable Diffusion 2.x":
+			o.Width, o.Height = ptr.To(uint32(768)), ptr.To(uint32(768))
+		default:
+			o.Width, o.Height = ptr.To(uint32(1024)), ptr.To(uint32(1024))
+		}
+	}
+	if o.BatchCount == nil {
+		o.BatchCount = ptr.To(int32(1))
+	}
+	if o.OffloadConditioner == nil {
+		o.OffloadConditioner = ptr.To(true)
+	}
+	if o.OffloadAutoencoder == nil {
+		o.OffloadAutoencoder = ptr.To(true)
+	}
+	e.Width, e.Height, e.BatchCount, e.VAETiling = *o.Width, *o.Height, *o.BatchCount, o.VAETiling
+
+	var (
+		nWidth  = uint64(*o.Width)
+		nHeight = uint64(*o.Height)
+		nBatch  = uint64(*o.BatchCount)
+
+		// The autoencoder compresses 8x8 pixels into a latent,
+		// which has 16 channels for the transformer models and 4 channels for the UNet models.
+		nLatentWidth    = nWidth / 8
+		nLatentHeight   = nHeight / 8
+		nLatentChannels = uint64(4)
+	)
+	if a.DiffusionTransformer {
+		nLatentChannels = 16
+	}
+
+	// Footprint.
+	{
+		// Bootstrap.
+		e.Footprint = GGUFBytesScalar(5*1024*1024) /* model load */ + (gf.Size - gf.ModelSize) /* metadata */
+
+		// The latents and the generated images of the batch.
+		e.Footprint += GGUFBytesScalar(GGMLTypeF32.RowSizeOf([]uint64{nLatentWidth * nLatentHeight * nLatentChannels}) * nBatch)
+		e.Footprint += GGUFBytesScalar(nWidth * nHeight * 3 /* RGB */ * nBatch)
+	}
+
+	// Weight.
+	var cw, dw, aw uint64
+	for _, ti := range gf.TensorInfos {
+		switch {
+		case strings.HasPrefix(ti.Name, "first_stage_model."), strings.HasPrefix(ti.Name, "vae."):
+			aw += ti.Bytes()
+		case strings.HasPrefix(ti.Name, "cond_stage_model."), strings.HasPrefix(ti.Name, "conditioner."),
+			strings.HasPrefix(ti.Name, "text_encoders."):
+			cw += ti.Bytes()
+		default:
+			dw += ti.Bytes()
+		}
+	}
+
+	// Conditioner,
+	// each text encoder runs over the padded prompt tokens in turn.
+	if len(a.DiffusionConditioners) != 0 {
+		var ci uint64
+		for _, ti := range gf.TensorInfos.Search(regexp.MustCompile(`\.(token_embedding|shared)\.weight$`)) {
+			var (
+				nTokens = uint64(_SDCppCLIPTokens)
+				nEmbd   = ti.Dimensions[0]
+				nHead   = max(nEmbd/_SDCppTextHeadDim, 1)
+			)
+			if strings.HasSuffix(ti.Name, ".shared.weight") {
+				nTokens = _SDCppT5Tokens
+			}
+			rs := GGMLTypeF32.RowSizeOf([]uint64{nTokens, nTokens, nHead}) // kq.
+			rs += GGMLTypeF32.RowSizeOf([]uint64{nEmbd * 4, nTokens}) * 2  // qkv, ffn.
+			ci = max(ci, rs)
+		}
+		e.Conditioner = &StableDiffusionCppComponentUsage{
+			Offloaded:   *o.OffloadConditioner,
+			Weight:      GGUFBytesScalar(cw),
+			Computation: GGUFBytesScalar(ci),
+		}
+	}
+
+	// Diffusion,
+	// the latents are denoised one by one,
+	// so the computation is reused across the batch and the sampling steps.
+	{
+		var di uint64
+		if a.DiffusionTransformer {
+			// The transformer patchifies 2x2 latents into a token,
+			// and attends to the image tokens and the text tokens jointly.
+			var (
+				nTokens  = (nLatentWidth/2)*(nLatentHeight/2) + _SDCppT5Tokens
+				nEmbd    = uint64(_SDCppFluxHiddenSize)
+				nHeadDim = uint64(_SDCppFluxHeadDim)
+			)
+			for _, ti := range gf.TensorInfos.Search(regexp.MustCompile(`(double_blocks|joint_blocks)\.0\.(img_attn|x_block\.attn)\.qkv\.weight$`)) {
+				nEmbd = ti.Dimensions[0]
+				if strings.Contains(ti.Name, "joint_blocks.") {
+					nHeadDim = _SDCppMMDiTHeadDim
+				}
+			}
+			nHead := max(nEmbd/nHeadDim, 1)
+			di = GGMLTypeF32.RowSizeOf([]uint64{nTokens, nTokens, nHead})       // kq.
+			di += GGMLTypeF32.RowSizeOf([]uint64{nEmbd * (3 + 4 + 1), nTokens}) // qkv, mlp, residual.
+		} else {
+			// The UNet attends over the pixels of the first level with spatial transformers,
+			// which is the latent resolution for SD 1.x/2.x and the half for SDXL.
+			var (
+				nLevel    = uint64(0)
+				nChannels = uint64(_SDCppUNetChannels)
+				nHead     = uint64(_SDCppUNetHeadCount)
+			)
+			if a.DiffusionArchitecture == "Stable Diffusion XL" {
+				nLevel, nChannels = 1, _SDCppUNetChannels*2
+			}
+			// Read from the first spatial transformer if present,
+			// the input blocks are 1 + 3 per level, and the downsample block ends each level.
+			ib := uint64(0)
+			for _, ti := range gf.TensorInfos.Search(regexp.MustCompile(`\.input_blocks\.\d+\.1\.proj_in\.weight$`)) {
+				i, err := strconv.ParseUint(strings.Split(strings.TrimPrefix(ti.Name, "model.diffusion_model.input_blocks."), ".")[0], 10, 64)
+				if err != nil || i == 0 || (ib != 0 && i >= ib) {
+					continue
+				}
+				ib = i
+				nLevel, nChannels = (i-1)/3, ti.Dimensions[ti.NDimensions-1]
+			}
+			if a.DiffusionArchitecture != "Stable Diffusion 1.x" {
+				nHead = max(nChannels/_SDCppUNetHeadDim, 1)
+			}
+			nTokens := (nLatentWidth >> nLevel) * (nLatentHeight >> nLevel)
+			di = GGMLTypeF32.RowSizeOf([]uint64{nTokens, nTokens, nHead})       // kq.
+			di += GGMLTypeF32.RowSizeOf([]uint64{nChannels * (3 + 8), nTokens}) // qkv, ff, resnet.
+		}
+		e.Diffusion = StableDiffusionCppComponentUsage{
+			Offloaded:   true,
+			Weight:      GGUFBytesScalar(dw),
+			Computation: GGUFBytesScalar(di),
+		}
+	}
+
+	// Autoencoder,
+	// the decoder upsamples the latents back to the pixels,
+	// the last block runs 128 channels at the full resolution,
+	// and the middle block attends over the latents.
+	if a.DiffusionHasAutoencoder {
+		nTileWidth, nTileHeight := nWidth, nHeight
+		if o.VAETiling {
+			// Decode 32x32 latents at a time.
+			nTileWidth, nTileHeight = min(nWidth, 256), min(nHeight, 256)
+		}
+		ai := GGMLTypeF32.RowSizeOf([]uint64{nTileWidth, nTileHeight, 128}) * 3                                           // conv, norm, upsample.
+		ai += GGMLTypeF32.RowSizeOf([]uint64{(nTileWidth / 8) * (nTileHeight / 8), (nTileWidth / 8) * (nTileHeight / 8)}) // kq.
+		e.Autoencoder = &StableDiffusionCppComponentUsage{
+			Offloaded:   *o.OffloadAutoencoder,
+			Weight:      GGUFBytesScalar(aw),
+			Computation: GGUFBytesScalar(ai),
+		}
+	}
+
+	return e
+}
+
+// Types for StableDiffusionCpp estimated summary.
+type (
+	// StableDiffusionCppUsageEstimateSummary represents the summary of the usage for loading the GGUF file in stable-diffusion.cpp.
+	StableDiffusionCppUsageEstimateSummary struct {
+		/* Basic */
+
+		// RAM is the memory usage for loading the GGUF file in RAM.
+		RAM GGUFBytesScalar `json:"ram"`
+		// VRAM is the memory usage for loading the GGUF file in VRAM.
+		VRAM GGUFBytesScalar `json:"vram"`
+
+		/* Appendix */
+
+		// Architecture describes what architecture the diffusion model implements.
+		Architecture string `json:"architecture"`
+		// Width is the width of the generated images.
+		Width uint32 `json:"width"`
+		// Height is the height of the generated images.
+		Height uint32 `json:"height"`
+		// BatchCount is the number of the generated images.
+		BatchCount int32 `json:"batchCount"`
+		// VAETiling is the flag to indicate whether decoding the latents in tiles,
+		// true for enable.
+		VAETiling bool `json:"vaeTiling"`
+	}
+)
+
+// Summarize returns the summary of the estimated result of loading the GGUF file in stable-diffusion.cpp,
+// the input options are used to adjust the summary.
+//
+// All weights stay in memory during the generation,
+// while the components run in turn,
+// so only the largest computation of each device is counted.
+func (e StableDiffusionCppUsageEstimate) Summarize(nonUMARamFootprint, nonUMAVramFootprint uint64) (es StableDiffusionCppUsageEstimateSummary) {
+	es.RAM = GGUFBytesScalar(nonUMARamFootprint) + e.Footprint
+	es.VRAM = GGUFBytesScalar(nonUMAVramFootprint)
+
+	var rcp, vcp GGUFBytesScalar
+	for _, c := range []*StableDiffusionCppComponentUsage{e.Conditioner, &e.Diffusion, e.Autoencoder} {
+		if c == nil {
+			continue
+		}
+		if c.Offloaded {
+			es.VRAM += c.Weight
+			vcp = max(vcp, c.Computation)
+		} else {
+			es.RAM += c.Weight
+			rcp = max(rcp, c.Computation)
+		}
+	}
+	es.RAM += rcp
+	es.VRAM += vcp
+
+	// Just copy from the original estimate.
+	es.Architecture = e.Architecture
+	es.Width = e.Width
+	es.Height = e.Height
+	es.BatchCount = e.BatchCount
+	es.VAETiling = e.VAETiling
+
+	return es
+}
@@ -0,0 +1,72 @@
+package gguf_parser
+
+import (
+	"github.com/gpustack/gguf-parser-go/util/ptr"
+)
+
+type (
+	_StableDiffusionCppUsageEstimateOptions struct {
+		Architecture       *GGUFArchitectureMetadata
+		Width              *uint32
+		Height             *uint32
+		BatchCount         *int32
+		VAETiling          bool
+		OffloadConditioner *bool
+		OffloadAutoencoder *bool
+	}
+	StableDiffusionCppUsageEstimateOption func(*_StableDiffusionCppUsageEstimateOptions)
+)
+
+// WithStableDiffusionCppArchitecture sets the architecture for the estimate.
+//
+// Allows reusing the same GGUFArchitectureMetadata for multiple estimates.
+func WithStableDiffusionCppArchitecture(arch GGUFArchitectureMetadata) StableDiffusionCppUsageEstimateOption {
+	return func(o *_StableDiffusionCppUsageEstimateOptions) {
+		o.Architecture = &arch
+	}
+}
+
+// WithStableDiffusionCppResolution sets the width and height of the generated images for the estimate.
+func WithStableDiffusionCppResolution(width, height uint32) StableDiffusionCppUsageEstimateOption {
+	return func(o *_StableDiffusionCppUsageEstimateOptions) {
+		if width == 0 || height == 0 {
+			return
+		}
+		o.Width = &width
+		o.Height = &height
+	}
+}
+
+// WithStableDiffusionCppBatchCount sets the number of the generated images for the estimate.
+func WithStableDiffusionCppBatchCount(count int32) StableDiffusionCppUsageEstimateOption {
+	return func(o *_StableDiffusionCppUsageEstimateOptions) {
+		if count <= 0 {
+			return
+		}
+		o.BatchCount = &count
+	}
+}
+
+// WithStableDiffusionCppVAETiling enables decoding the latents in tiles for the estimate,
+// which reduces the usage of the autoencoder.
+func WithStableDiffusionCppVAETiling() StableDiffusionCppUsageEstimateOption {
+	return func(o *_StableDiffusionCppUsageEstimateOptions) {
+		o.VAETiling = true
+	}
+}
+
+// WithoutStableDiffusionCppOffloadConditioner disables offloading the conditioner(text encoders),
+// like `--clip-on-cpu` of stable-diffusion.cpp.
+func WithoutStableDiffusionCppOffloadConditioner() StableDiffusionCppUsageEstimateOption {
+	return func(o *_StableDiffusionCppUsageEstimateOptions) {
+		o.OffloadConditioner = ptr.To(false)
+	}
+}
+
+// WithoutStableDiffusionCppOffloadAutoencoder disables offloading the autoencoder(VAE),
+// like `--vae-on-cpu` of stable-diffusion.cpp.
+func WithoutStableDiffusionCppOffloadAutoencoder() StableDiffusionCppUsageEstimateOption {
+	return func(o *_StableDiffusionCppUsageEstimateOptions) {
+		o.OffloadAutoencoder = ptr.To(false)
+	}
+}
@@ -0,0 +1,61 @@
+package gguf_parser
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGGUFFile_EstimateStableDiffusionCppUsage(t *testing.T) {
+	f := &GGUFFile{
+		TensorInfos: GGUFTensorInfos{
+			testTensor("cond_stage_model.transformer.text_model.embeddings.token_embedding.weight", 768, 49408),
+			testTensor("conditioner.embedders.1.model.token_embedding.weight", 1280, 49408),
+			testTensor("first_stage_model.decoder.conv_in.weight", 3, 3, 4, 512),
+			testTensor("model.diffusion_model.input_blocks.0.0.weight", 3, 3, 4, 320),
+			testTensor("model.diffusion_model.label_emb.0.0.weight", 2816, 1280),
+		},
+	}
+
+	a := f.Architecture()
+	assert.Equal(t, "diffusion", a.Architecture)
+	assert.Equal(t, "Stable Diffusion XL", a.DiffusionArchitecture)
+	assert.False(t, a.DiffusionTransformer)
+	assert.Equal(t, []string{"CLIP ViT-L/14", "OpenCLIP ViT-bigG/14"}, a.DiffusionConditioners)
+	assert.True(t, a.DiffusionHasAutoencoder)
+	assert.Equal(t, "diffusion", f.Model().Architecture)
+
+	e := f.EstimateStableDiffusionCppUsage()
+	assert.Equal(t, uint32(1024), e.Width)
+	if assert.NotNil(t, e.Conditioner) {
+		assert.Equal(t, GGUFBytesScalar((768+1280)*49408*4), e.Conditioner.Weight)
+	}
+	assert.Equal(t, GGUFBytesScalar((3*3*4*320+2816*1280)*4), e.Diffusion.Weight)
+	if assert.NotNil(t, e.Autoencoder) {
+		assert.Equal(t, GGUFBytesScalar(3*3*4*512*4), e.Autoencoder.Weight)
+	}
+
+	// Decoding in tiles reduces the autoencoder computation.
+	te := f.EstimateStableDiffusionCppUsage(WithStableDiffusionCppVAETiling())
+	assert.Less(t, te.Autoencoder.Computation, e.Autoencoder.Computation)
+	assert.Equal(t, e.Diffusion, te.Diffusion)
+
+	// The larger resolution and batch need more memory.
+	be := f.EstimateStableDiffusionCppUsage(WithStableDiffusionCppResolution(2048, 2048), WithStableDiffusionCppBatchCount(4))
+	assert.Greater(t, be.Footprint, e.Footprint)
+	assert.Greater(t, be.Diffusion.Computation, e.Diffusion.Computation)
+
+	// The hyperparameters read from the first spatial transformer agree with the defaults.
+	tf := &GGUFFile{TensorInfos: append(slices.Clone(f.TensorInfos),
+		testTensor("model.diffusion_model.input_blocks.4.1.proj_in.weight", 640, 640),
+		testTensor("model.diffusion_model.input_blocks.7.1.proj_in.weight", 1280, 1280))}
+	assert.Equal(t, e.Diffusion.Computation, tf.EstimateStableDiffusionCppUsage().Diffusion.Computation)
+
+	// The components kept in RAM move out of VRAM.
+	es := e.Summarize(0, 0)
+	oe := f.EstimateStableDiffusionCppUsage(WithoutStableDiffusionCppOffloadConditioner(), WithoutStableDiffusionCppOffloadAutoencoder())
+	oes := oe.Summarize(0, 0)
+	assert.Equal(t, es.VRAM-e.Conditioner.Weight-e.Autoencoder.Weight-e.Autoencoder.Computation+e.Diffusion.Computation, oes.VRAM)
+	assert.Equal(t, es.RAM+e.Conditioner.Weight+e.Autoencoder.Weight+e.Autoencoder.Computation, oes.RAM)
+}
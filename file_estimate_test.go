@@ -2,11 +2,25 @@ package gguf_parser
 
 import (
 	"context"
+	"math"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/stretchr/testify/assert"
 )
 
+// testKV returns a GGUFMetadataKV for the synthetic GGUF files of the tests.
+func testKV(key string, vt GGUFMetadataValueType, v any) GGUFMetadataKV {
+	return GGUFMetadataKV{Key: key, ValueType: vt, Value: v}
+}
+
+// testTensor returns a F32 GGUFTensorInfo for the synthetic GGUF files of the tests.
+func testTensor(name string, dims ...uint64) GGUFTensorInfo {
+	return GGUFTensorInfo{Name: name, NDimensions: uint32(len(dims)), Dimensions: dims, Type: GGMLTypeF32}
+}
+
 func TestGGUFFile_EstimateLLaMACppUsage(t *testing.T) {
 	ctx := context.Background()
 
@@ -123,3 +137,691 @@ func TestGGUFFile_EstimateLLaMACppUsage_OffloadLayers(t *testing.T) {
 		})
 	}
 }
+
+func TestGGUFFile_EstimateLLaMACppUsage_PerLayer(t *testing.T) {
+	array := func(key string, vs ...any) GGUFMetadataKV {
+		return testKV(key, GGUFMetadataValueTypeArray, GGUFMetadataKVArrayValue{
+			Type:  GGUFMetadataValueTypeUint32,
+			Len:   uint64(len(vs)),
+			Array: vs,
+		})
+	}
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "openelm"),
+				testKV("openelm.context_length", GGUFMetadataValueTypeUint32, uint32(2048)),
+				testKV("openelm.embedding_length", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("openelm.block_count", GGUFMetadataValueTypeUint32, uint32(3)),
+				testKV("openelm.attention.key_length", GGUFMetadataValueTypeUint32, uint32(16)),
+				testKV("openelm.attention.value_length", GGUFMetadataValueTypeUint32, uint32(16)),
+				array("openelm.attention.head_count", uint32(4), uint32(0), uint32(8)),
+				array("openelm.attention.head_count_kv", uint32(2), uint32(0), uint32(4)),
+				array("openelm.feed_forward_length", uint32(256), uint32(512), uint32(384)),
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			testTensor("token_embd.weight", 128, 32),
+			testTensor("blk.0.attn_q.weight", 128, 64),
+			testTensor("blk.0.ffn_up.weight", 128, 256),
+			testTensor("blk.1.ffn_up.weight", 128, 512),
+			testTensor("blk.2.attn_q.weight", 128, 128),
+			testTensor("blk.2.ffn_up.weight", 128, 384),
+		},
+	}
+
+	a := f.Architecture()
+	assert.Equal(t, uint64(8), a.AttentionHeadCount)
+	assert.Equal(t, []uint64{4, 0, 8}, a.AttentionHeadCountPerLayer)
+	assert.Equal(t, uint64(4), a.AttentionHeadCountKV)
+	assert.Equal(t, uint64(512), a.FeedForwardLength)
+	assert.Equal(t, uint64(256), a.FeedForwardLengthOf(0))
+	assert.Equal(t, uint64(32), a.EmbeddingKeyGQAOf(0))
+	assert.Equal(t, uint64(0), a.EmbeddingKeyGQAOf(1))
+
+	// Key cache: 16 * (2 + 0 + 4) heads * 32 cells * 2 bytes(f16).
+	e := f.EstimateLLaMACppUsage(WithContextSize(32))
+	assert.Equal(t, GGUFBytesScalar(6144), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(6144), e.Offload.KVCache.Value)
+	assert.Equal(t, GGUFBytesScalar(0), e.Load.KVCache.Key)
+
+	// The first layer stays in RAM.
+	e = f.EstimateLLaMACppUsage(WithContextSize(32), WithOffloadLayers(2))
+	assert.Equal(t, GGUFBytesScalar(2048), e.Load.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(4096), e.Offload.KVCache.Key)
+
+	// The hyperparameters are taken by the block index rather than the position of the layer.
+	_, tfLs, _ := f.Layers().Cut([]string{"token_embd.weight"})
+	assert.Equal(t, []uint64{0, 1, 2}, []uint64{layerIndexOf(tfLs[0]), layerIndexOf(tfLs[1]), layerIndexOf(tfLs[2])})
+	f.TensorInfos = slices.Delete(f.TensorInfos, 3, 4) // Drop blk.1.
+	_, tfLs, _ = f.Layers().Cut([]string{"token_embd.weight"})
+	assert.Equal(t, uint64(2), layerIndexOf(tfLs[1]))
+
+	// No transformer layers.
+	f.Header.MetadataKV = f.Header.MetadataKV[:6] // Drop the per-layer hyperparameters.
+	f.TensorInfos = GGUFTensorInfos{testTensor("token_embd.weight", 128, 32)}
+	assert.NotPanics(t, func() { f.EstimateLLaMACppUsage(WithContextSize(32)) })
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_RWKV(t *testing.T) {
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "rwkv6"),
+				testKV("rwkv6.context_length", GGUFMetadataValueTypeUint32, uint32(1048576)),
+				testKV("rwkv6.embedding_length", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("rwkv6.feed_forward_length", GGUFMetadataValueTypeUint32, uint32(448)),
+				testKV("rwkv6.block_count", GGUFMetadataValueTypeUint32, uint32(2)),
+				testKV("rwkv6.wkv.head_size", GGUFMetadataValueTypeUint32, uint32(64)),
+				testKV("rwkv6.time_mix_extra_dim", GGUFMetadataValueTypeUint32, uint32(32)),
+				testKV("rwkv6.time_decay_extra_dim", GGUFMetadataValueTypeUint32, uint32(64)),
+				testKV("rwkv6.rescale_every_n_layers", GGUFMetadataValueTypeUint32, uint32(6)),
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			testTensor("token_embd.weight", 128, 32),
+			testTensor("blk.0.time_mix_key.weight", 128, 128),
+			testTensor("blk.1.time_mix_key.weight", 128, 128),
+			testTensor("output.weight", 128, 32),
+		},
+	}
+
+	a := f.Architecture()
+	assert.Equal(t, uint32(2), a.RWKVTokenShiftCount)
+	assert.Equal(t, uint64(2*128), a.EmbeddingKeyGQA)
+	assert.Equal(t, uint64(128*64), a.EmbeddingValueGQA)
+
+	// Token shift: 2 layers * 2 * 128 * 4 bytes(f32) per sequence,
+	// WKV: 2 layers * 128 * 64 * 4 bytes(f32) per sequence.
+	e := f.EstimateLLaMACppUsage(WithContextSize(4096))
+	assert.Equal(t, GGUFBytesScalar(2*1024), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(2*32768), e.Offload.KVCache.Value)
+
+	// The state does not grow with the context size, but with the sequences.
+	e2 := f.EstimateLLaMACppUsage(WithContextSize(32768))
+	assert.Equal(t, e.Offload.KVCache, e2.Offload.KVCache)
+	e2 = f.EstimateLLaMACppUsage(WithContextSize(4096), WithParallelSize(4))
+	assert.Equal(t, 4*e.Offload.KVCache.Key, e2.Offload.KVCache.Key)
+	assert.Equal(t, 4*e.Offload.KVCache.Value, e2.Offload.KVCache.Value)
+
+	// The variants share the estimator.
+	for _, arch := range []string{"rwkv6qwen2", "rwkv7"} {
+		vf := *f
+		vf.Header.MetadataKV = slices.Clone(f.Header.MetadataKV)
+		for i := range vf.Header.MetadataKV {
+			kv := &vf.Header.MetadataKV[i]
+			if kv.Key == "general.architecture" {
+				kv.Value = arch
+				continue
+			}
+			kv.Key = arch + strings.TrimPrefix(kv.Key, "rwkv6")
+		}
+		e2 = vf.EstimateLLaMACppUsage(WithContextSize(4096))
+		assert.Equal(t, e.Offload.KVCache, e2.Offload.KVCache, arch)
+		assert.Equal(t, e.Offload.Computation, e2.Offload.Computation, arch)
+	}
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_Hybrid(t *testing.T) {
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "jamba"),
+				testKV("jamba.context_length", GGUFMetadataValueTypeUint32, uint32(262144)),
+				testKV("jamba.embedding_length", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("jamba.feed_forward_length", GGUFMetadataValueTypeUint32, uint32(256)),
+				testKV("jamba.block_count", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("jamba.attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("jamba.attention.head_count_kv", GGUFMetadataValueTypeArray, GGUFMetadataKVArrayValue{
+					Type:  GGUFMetadataValueTypeUint32,
+					Len:   4,
+					Array: []any{uint32(0), uint32(2), uint32(0), uint32(2)},
+				}),
+				testKV("jamba.ssm.conv_kernel", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("jamba.ssm.inner_size", GGUFMetadataValueTypeUint32, uint32(256)),
+				testKV("jamba.ssm.state_size", GGUFMetadataValueTypeUint32, uint32(16)),
+				testKV("jamba.ssm.time_step_rank", GGUFMetadataValueTypeUint32, uint32(8)),
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			testTensor("token_embd.weight", 128, 32),
+			testTensor("blk.0.ssm_in.weight", 128, 512),
+			testTensor("blk.0.ssm_conv1d.weight", 4, 256),
+			testTensor("blk.0.ssm_a", 16, 256),
+			testTensor("blk.1.attn_q.weight", 128, 128),
+			testTensor("blk.2.ssm_in.weight", 128, 512),
+			testTensor("blk.2.ssm_conv1d.weight", 4, 256),
+			testTensor("blk.2.ssm_a", 16, 256),
+			testTensor("blk.3.attn_q.weight", 128, 128),
+			testTensor("output.weight", 128, 32),
+		},
+	}
+
+	a := f.Architecture()
+	assert.Equal(t, uint64(3*256), a.EmbeddingRollingState)
+	assert.Equal(t, uint64(16*256), a.EmbeddingRecurrentState)
+	assert.Equal(t, uint64(32*2), a.EmbeddingKeyGQA)
+
+	// Attention: 2 layers * 32 * 2 heads * 64 cells * 2 bytes(f16),
+	// SSM: 2 layers * (3 * 256 or 16 * 256) * 4 bytes(f32).
+	e := f.EstimateLLaMACppUsage(WithContextSize(64))
+	assert.Equal(t, GGUFBytesScalar(16384+6144), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(16384+32768), e.Offload.KVCache.Value)
+
+	// Only the attention layers grow with the context size.
+	e = f.EstimateLLaMACppUsage(WithContextSize(128))
+	assert.Equal(t, GGUFBytesScalar(32768+6144), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(32768+32768), e.Offload.KVCache.Value)
+
+	// Only the SSM layers grow with the sequences.
+	e = f.EstimateLLaMACppUsage(WithContextSize(64), WithParallelSize(2))
+	assert.Equal(t, GGUFBytesScalar(16384+6144*2), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(16384+32768*2), e.Offload.KVCache.Value)
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_Mamba2(t *testing.T) {
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "mamba2"),
+				testKV("mamba2.embedding_length", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("mamba2.block_count", GGUFMetadataValueTypeUint32, uint32(2)),
+				testKV("mamba2.attention.head_count", GGUFMetadataValueTypeUint32, uint32(0)),
+				testKV("mamba2.ssm.conv_kernel", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("mamba2.ssm.inner_size", GGUFMetadataValueTypeUint32, uint32(256)),
+				testKV("mamba2.ssm.state_size", GGUFMetadataValueTypeUint32, uint32(64)),
+				testKV("mamba2.ssm.group_count", GGUFMetadataValueTypeUint32, uint32(2)),
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			testTensor("token_embd.weight", 128, 32),
+			testTensor("blk.0.ssm_in.weight", 128, 776),
+			testTensor("blk.0.ssm_conv1d.weight", 4, 512),
+			testTensor("blk.0.ssm_a", 1, 8),
+			testTensor("blk.1.ssm_in.weight", 128, 776),
+			testTensor("blk.1.ssm_conv1d.weight", 4, 512),
+			testTensor("blk.1.ssm_a", 1, 8),
+		},
+	}
+
+	// The convolution covers the inner states and B/C of 2 groups.
+	a := f.Architecture()
+	assert.Equal(t, uint32(2), a.SSMGroupCount)
+	assert.Equal(t, uint64(3*(256+2*2*64)), a.EmbeddingKeyGQA)
+	assert.Equal(t, uint64(64*256), a.EmbeddingValueGQA)
+
+	e := f.EstimateLLaMACppUsage(WithContextSize(4096))
+	assert.Equal(t, GGUFBytesScalar(2*3*(256+2*2*64)*4), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(2*64*256*4), e.Offload.KVCache.Value)
+
+	// No transformer layers.
+	f.TensorInfos = GGUFTensorInfos{testTensor("token_embd.weight", 128, 32)}
+	assert.NotPanics(t, func() { f.EstimateLLaMACppUsage(WithContextSize(4096)) })
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_MLA(t *testing.T) {
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "deepseek2"),
+				testKV("deepseek2.context_length", GGUFMetadataValueTypeUint32, uint32(163840)),
+				testKV("deepseek2.embedding_length", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("deepseek2.feed_forward_length", GGUFMetadataValueTypeUint32, uint32(512)),
+				testKV("deepseek2.expert_feed_forward_length", GGUFMetadataValueTypeUint32, uint32(64)),
+				testKV("deepseek2.block_count", GGUFMetadataValueTypeUint32, uint32(3)),
+				testKV("deepseek2.leading_dense_block_count", GGUFMetadataValueTypeUint32, uint32(1)),
+				testKV("deepseek2.expert_count", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("deepseek2.expert_used_count", GGUFMetadataValueTypeUint32, uint32(2)),
+				testKV("deepseek2.expert_shared_count", GGUFMetadataValueTypeUint32, uint32(1)),
+				testKV("deepseek2.attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("deepseek2.attention.head_count_kv", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("deepseek2.attention.key_length", GGUFMetadataValueTypeUint32, uint32(48)),
+				testKV("deepseek2.attention.value_length", GGUFMetadataValueTypeUint32, uint32(32)),
+				testKV("deepseek2.attention.kv_lora_rank", GGUFMetadataValueTypeUint32, uint32(64)),
+				testKV("deepseek2.rope.dimension_count", GGUFMetadataValueTypeUint32, uint32(16)),
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			testTensor("token_embd.weight", 128, 32),
+			testTensor("blk.0.attn_kv_a_mqa.weight", 128, 80),
+			testTensor("blk.0.ffn_up.weight", 128, 512),
+			testTensor("blk.1.attn_kv_a_mqa.weight", 128, 80),
+			testTensor("blk.1.ffn_gate_exps.weight", 128, 64, 4),
+			testTensor("blk.1.ffn_up_shexp.weight", 128, 64),
+			testTensor("blk.2.attn_kv_a_mqa.weight", 128, 80),
+			testTensor("blk.2.ffn_gate_exps.weight", 128, 64, 4),
+			testTensor("blk.2.ffn_up_shexp.weight", 128, 64),
+			testTensor("output.weight", 128, 32),
+		},
+	}
+
+	a := f.Architecture()
+	assert.Equal(t, uint64(64+16), a.EmbeddingKeyGQA)
+	assert.Equal(t, uint64(64), a.EmbeddingValueGQA)
+
+	// Key cache: 3 layers * (64 + 16) * 32 cells * 2 bytes(f16),
+	// rather than 3 layers * 48 * 4 heads * 32 cells * 2 bytes(f16).
+	e := f.EstimateLLaMACppUsage(WithContextSize(32))
+	assert.Equal(t, GGUFBytesScalar(3*80*32*2), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(3*64*32*2), e.Offload.KVCache.Value)
+	assert.False(t, e.NoMMap)
+
+	// The dense block stays in RAM.
+	e = f.EstimateLLaMACppUsage(WithContextSize(32), WithOffloadLayers(2))
+	assert.Equal(t, GGUFBytesScalar((128*80+128*512)*4), e.Load.Weight.Compute)
+	assert.Equal(t, GGUFBytesScalar(2*(128*80+128*64*4+128*64)*4), e.Offload.Weight.Compute)
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_SlidingWindow(t *testing.T) {
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "gemma2"),
+				testKV("gemma2.context_length", GGUFMetadataValueTypeUint32, uint32(8192)),
+				testKV("gemma2.embedding_length", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("gemma2.block_count", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("gemma2.attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("gemma2.attention.head_count_kv", GGUFMetadataValueTypeUint32, uint32(2)),
+				testKV("gemma2.attention.sliding_window", GGUFMetadataValueTypeUint32, uint32(128)),
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			testTensor("token_embd.weight", 128, 32),
+			testTensor("blk.0.attn_q.weight", 128, 128),
+			testTensor("blk.1.attn_q.weight", 128, 128),
+			testTensor("blk.2.attn_q.weight", 128, 128),
+			testTensor("blk.3.attn_q.weight", 128, 128),
+		},
+	}
+
+	// Every second layer uses the global attention.
+	a := f.Architecture()
+	assert.Equal(t, uint32(2), a.AttentionSlidingWindowPattern)
+	assert.Equal(t, uint64(128), a.AttentionSlidingWindowOf(0))
+	assert.Equal(t, uint64(0), a.AttentionSlidingWindowOf(1))
+
+	// Global: 2 layers * 32 * 2 heads * 4096 cells * 2 bytes(f16),
+	// sliding window: 2 layers * 32 * 2 heads * (128 + 512 -> 768) cells * 2 bytes(f16).
+	e := f.EstimateLLaMACppUsage(WithContextSize(4096))
+	assert.Equal(t, uint64(768), e.SlidingWindowCacheSize)
+	assert.Equal(t, GGUFBytesScalar(2*524288+2*98304), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(2*98304), e.Offload.KVCache.SlidingWindowKey)
+	assert.Equal(t, GGUFBytesScalar(2*98304), e.Offload.KVCache.SlidingWindowValue)
+
+	// The full cache keeps all cells.
+	e = f.EstimateLLaMACppUsage(WithContextSize(4096), WithFullSWACache())
+	assert.Equal(t, uint64(4096), e.SlidingWindowCacheSize)
+	assert.Equal(t, GGUFBytesScalar(4*524288), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(2*524288), e.Offload.KVCache.SlidingWindowKey)
+
+	// The per-layer pattern overrides.
+	f.Header.MetadataKV = append(f.Header.MetadataKV,
+		testKV("gemma2.attention.sliding_window_pattern", GGUFMetadataValueTypeArray, GGUFMetadataKVArrayValue{
+			Type:  GGUFMetadataValueTypeBool,
+			Len:   4,
+			Array: []any{true, true, true, false},
+		}))
+	e = f.EstimateLLaMACppUsage(WithContextSize(4096))
+	assert.Equal(t, GGUFBytesScalar(524288+3*98304), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(3*98304), e.Offload.KVCache.SlidingWindowKey)
+
+	// The other models record the sliding window without the pattern, but attend to the full context.
+	f.Header.MetadataKV = slices.Clone(f.Header.MetadataKV[:len(f.Header.MetadataKV)-1])
+	for i := range f.Header.MetadataKV {
+		kv := &f.Header.MetadataKV[i]
+		if kv.Key == "general.architecture" {
+			kv.Value = "mistral"
+			continue
+		}
+		kv.Key = "mistral" + strings.TrimPrefix(kv.Key, "gemma2")
+	}
+	a = f.Architecture()
+	assert.Equal(t, uint64(128), a.AttentionSlidingWindow)
+	assert.Equal(t, uint64(0), a.AttentionSlidingWindowOf(0))
+	e = f.EstimateLLaMACppUsage(WithContextSize(4096))
+	assert.Equal(t, uint64(0), e.SlidingWindowCacheSize)
+	assert.Equal(t, GGUFBytesScalar(4*524288), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(0), e.Offload.KVCache.SlidingWindowKey)
+
+	// The pattern metadata applies the sliding window, zero for all layers.
+	f.Header.MetadataKV = append(f.Header.MetadataKV,
+		testKV("mistral.attention.sliding_window_pattern", GGUFMetadataValueTypeUint32, uint32(0)))
+	e = f.EstimateLLaMACppUsage(WithContextSize(4096))
+	assert.Equal(t, GGUFBytesScalar(4*98304), e.Offload.KVCache.Key)
+	assert.Equal(t, GGUFBytesScalar(4*98304), e.Offload.KVCache.SlidingWindowKey)
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_Embedding(t *testing.T) {
+	bert := func(pooling uint32) *GGUFFile {
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: GGUFMetadataKVs{
+					testKV("general.architecture", GGUFMetadataValueTypeString, "bert"),
+					testKV("bert.context_length", GGUFMetadataValueTypeUint32, uint32(512)),
+					testKV("bert.embedding_length", GGUFMetadataValueTypeUint32, uint32(64)),
+					testKV("bert.block_count", GGUFMetadataValueTypeUint32, uint32(2)),
+					testKV("bert.attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+					testKV("bert.attention.causal", GGUFMetadataValueTypeBool, false),
+					testKV("bert.pooling_type", GGUFMetadataValueTypeUint32, pooling),
+				},
+			},
+			TensorInfos: GGUFTensorInfos{
+				testTensor("token_embd.weight", 64, 100),
+				testTensor("token_types.weight", 64, 2),
+				testTensor("token_embd_norm.weight", 64),
+				testTensor("blk.0.attn_q.weight", 64, 64),
+				testTensor("blk.1.attn_q.weight", 64, 64),
+				testTensor("cls.weight", 64, 64),
+				testTensor("cls.output.weight", 64, 1),
+			},
+		}
+	}
+
+	t.Run("rank", func(t *testing.T) {
+		f := bert(4)
+		a := f.Architecture()
+		assert.Equal(t, "rank", a.PoolingType)
+		assert.Equal(t, uint64(1), a.ClassifierOutputCount)
+		assert.True(t, a.Reranking)
+
+		e := f.EstimateLLaMACppUsage(WithParallelSize(4))
+		assert.True(t, e.EmbeddingOnly)
+		assert.True(t, e.Reranking)
+		assert.Equal(t, "rank", e.PoolingType)
+		assert.Equal(t, e.LogicalBatchSize, e.PhysicalBatchSize)
+		// The classifier head is the output layer,
+		// and the token types and the embedding norm are the input layer.
+		assert.Equal(t, GGUFBytesScalar((64*100+64*2+64)*4), e.Load.Weight.Input)
+		assert.Equal(t, GGUFBytesScalar((64*64+64*1)*4), e.Load.Weight.Output)
+		assert.Equal(t, GGUFBytesScalar(2*64*64*4), e.Offload.Weight.Compute)
+		assert.NotZero(t, e.Offload.Computation.Compute)
+		// Embeddings of 512 tokens, 4 cls ids, 4 pooled embeddings, 4 classified embeddings and 4 scores.
+		assert.Equal(t, GGUFBytesScalar(64*512*4+4*4+64*4*4+64*4*4+4*4)+e.Load.Weight.Output, e.Offload.Computation.Output)
+	})
+
+	t.Run("mean", func(t *testing.T) {
+		f := bert(1)
+		assert.False(t, f.Architecture().Reranking)
+
+		e := f.EstimateLLaMACppUsage(WithParallelSize(4))
+		assert.False(t, e.Reranking)
+		assert.Equal(t, "mean", e.PoolingType)
+		// Embeddings of 512 tokens, the mean matrix of 512 tokens for 4 sequences and 4 pooled embeddings.
+		assert.Equal(t, GGUFBytesScalar(64*512*4+512*4*4+64*4*4)+e.Load.Weight.Output, e.Offload.Computation.Output)
+
+		// The output buffer keeps the embeddings of the batch and the pooled embeddings without logits.
+		re := bert(4).EstimateLLaMACppUsage(WithParallelSize(4))
+		assert.Equal(t, GGUFBytesScalar(64*4*4-1*4*4), e.Load.Footprint-re.Load.Footprint)
+	})
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_EncoderDecoder(t *testing.T) {
+	t5 := func(arch string) *GGUFFile {
+		f := &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: GGUFMetadataKVs{
+					testKV("general.architecture", GGUFMetadataValueTypeString, arch),
+					testKV(arch+".context_length", GGUFMetadataValueTypeUint32, uint32(512)),
+					testKV(arch+".embedding_length", GGUFMetadataValueTypeUint32, uint32(64)),
+					testKV(arch+".feed_forward_length", GGUFMetadataValueTypeUint32, uint32(128)),
+					testKV(arch+".block_count", GGUFMetadataValueTypeUint32, uint32(2)),
+					testKV(arch+".attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+					testKV(arch+".attention.relative_buckets_count", GGUFMetadataValueTypeUint32, uint32(32)),
+					testKV(arch+".decoder_start_token_id", GGUFMetadataValueTypeUint32, uint32(0)),
+				},
+			},
+			TensorInfos: GGUFTensorInfos{
+				testTensor("token_embd.weight", 64, 100),
+				testTensor("enc.blk.0.attn_q.weight", 64, 64),
+				testTensor("enc.blk.1.attn_q.weight", 64, 64),
+				testTensor("enc.output_norm.weight", 64),
+			},
+		}
+		if arch == "t5" {
+			f.TensorInfos = append(f.TensorInfos,
+				testTensor("dec.blk.0.attn_q.weight", 64, 64),
+				testTensor("dec.blk.0.cross_attn_q.weight", 64, 64),
+				testTensor("dec.blk.1.attn_q.weight", 64, 64),
+				testTensor("dec.blk.1.cross_attn_q.weight", 64, 64),
+				testTensor("dec.output_norm.weight", 64),
+				testTensor("output.weight", 64, 100))
+		}
+		return f
+	}
+
+	t.Run("t5", func(t *testing.T) {
+		f := t5("t5")
+		a := f.Architecture()
+		assert.Equal(t, uint64(2), a.EncoderBlockCount)
+		assert.Equal(t, uint64(2), a.DecoderBlockCount)
+		assert.Equal(t, uint32(32), a.AttentionRelativeBucketsCount)
+		assert.Equal(t, int64(0), a.DecoderStartTokenID)
+		assert.True(t, a.AttentionCausal)
+
+		ls := f.Layers()
+		_, found := ls.Index([]string{"enc.blk.1.attn_q.weight", "dec.blk.1.cross_attn_q.weight"})
+		assert.Equal(t, 2, found)
+
+		// Offload the second layer of both stacks.
+		e := f.EstimateLLaMACppUsage(WithContextSize(32), WithOffloadLayers(1))
+		assert.Equal(t, GGUFBytesScalar(64*64*4*3+64*4*2), e.Load.Weight.Compute)
+		assert.Equal(t, GGUFBytesScalar(64*64*4*3), e.Offload.Weight.Compute)
+		// Key cache of the decoder: 64 * 32 cells * 2 bytes(f16) per layer.
+		assert.Equal(t, GGUFBytesScalar(4096), e.Load.KVCache.Key)
+		assert.Equal(t, GGUFBytesScalar(4096), e.Offload.KVCache.Key)
+		assert.NotZero(t, e.Offload.Computation.Compute)
+	})
+
+	t.Run("t5encoder", func(t *testing.T) {
+		f := t5("t5encoder")
+		a := f.Architecture()
+		assert.Equal(t, uint64(2), a.EncoderBlockCount)
+		assert.Equal(t, uint64(0), a.DecoderBlockCount)
+		assert.False(t, a.AttentionCausal)
+
+		e := f.EstimateLLaMACppUsage(WithContextSize(32))
+		assert.Equal(t, GGUFBytesScalar(64*64*4*2+64*4), e.Offload.Weight.Compute)
+		assert.Zero(t, e.Load.Weight.Output)
+
+		// The encoder runs without KV cache.
+		e = f.EstimateLLaMACppUsage(WithContextSize(512))
+		assert.Zero(t, e.Load.KVCache.Sum())
+		assert.Zero(t, e.Offload.KVCache.Sum())
+	})
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_Clip(t *testing.T) {
+	clip := func(projector string, imageSize uint32) *GGUFFile {
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: GGUFMetadataKVs{
+					testKV("general.architecture", GGUFMetadataValueTypeString, "clip"),
+					testKV("clip.has_vision_encoder", GGUFMetadataValueTypeBool, true),
+					testKV("clip.has_llava_projector", GGUFMetadataValueTypeBool, true),
+					testKV("clip.projector_type", GGUFMetadataValueTypeString, projector),
+					testKV("clip.vision.embedding_length", GGUFMetadataValueTypeUint32, uint32(64)),
+					testKV("clip.vision.feed_forward_length", GGUFMetadataValueTypeUint32, uint32(128)),
+					testKV("clip.vision.block_count", GGUFMetadataValueTypeUint32, uint32(2)),
+					testKV("clip.vision.attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+					testKV("clip.vision.image_size", GGUFMetadataValueTypeUint32, imageSize),
+					testKV("clip.vision.patch_size", GGUFMetadataValueTypeUint32, uint32(14)),
+					testKV("clip.vision.projection_dim", GGUFMetadataValueTypeUint32, uint32(256)),
+				},
+			},
+			TensorInfos: GGUFTensorInfos{
+				testTensor("v.class_embd", 64),
+				testTensor("v.blk.0.attn_q.weight", 64, 64),
+				testTensor("v.blk.1.attn_q.weight", 64, 64),
+				testTensor("mm.0.weight", 64, 256),
+			},
+		}
+	}
+
+	f := clip("mlp", 336)
+	a := f.Architecture()
+	assert.Equal(t, uint32(336), a.ClipVisionImageSize)
+	assert.Equal(t, uint32(14), a.ClipVisionPatchSize)
+	assert.Equal(t, uint32(256), a.ClipVisionProjectionDimension)
+
+	// 24x24 patches and the class embedding.
+	e := f.EstimateLLaMACppUsage()
+	assert.Equal(t, uint64(576), e.ImageTokens)
+	assert.True(t, e.FullOffloaded)
+	assert.Equal(t, GGUFBytesScalar(336*336*3*4+577*4+64*577*4), e.Offload.Computation.Input)
+	assert.Equal(t, GGUFBytesScalar(64*577*4*4+577*577*4*4), e.Offload.Computation.Compute)
+	assert.Equal(t, GGUFBytesScalar(256*576*4*3), e.Offload.Computation.Output)
+
+	// The computation is counted in the device.
+	es := e.Summarize(true, 0, 0)
+	assert.Equal(t, e.Offload.Weight.Sum()+e.Offload.Computation.Sum(), es.Memory[0].UMA.VRAM)
+
+	// The projectors reduce the patches into fewer tokens.
+	assert.Equal(t, uint64(144), clip("ldp", 336).EstimateLLaMACppUsage().ImageTokens)
+	assert.Equal(t, uint64(64), clip("resampler", 448).EstimateLLaMACppUsage().ImageTokens)
+	assert.Equal(t, uint64(400), clip("qwen2vl_merger", 560).EstimateLLaMACppUsage().ImageTokens)
+	assert.Equal(t, uint64(256), clip("gemma3", 896).EstimateLLaMACppUsage().ImageTokens)
+
+	// The images inject their tokens in turn without more computation.
+	me := f.EstimateLLaMACppUsage(WithImageCount(3))
+	assert.Equal(t, uint64(576*3), me.ImageTokens)
+	assert.Equal(t, e.Offload.Computation, me.Offload.Computation)
+	assert.Equal(t, uint64(576*3), me.Summarize(true, 0, 0).ImageTokens)
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_ClipAudio(t *testing.T) {
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "clip"),
+				testKV("clip.has_audio_encoder", GGUFMetadataValueTypeBool, true),
+				testKV("clip.projector_type", GGUFMetadataValueTypeString, "ultravox"),
+				testKV("clip.audio.embedding_length", GGUFMetadataValueTypeUint32, uint32(64)),
+				testKV("clip.audio.feed_forward_length", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("clip.audio.block_count", GGUFMetadataValueTypeUint32, uint32(2)),
+				testKV("clip.audio.attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("clip.audio.num_mel_bins", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("clip.audio.projection_dim", GGUFMetadataValueTypeUint32, uint32(256)),
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			testTensor("a.conv1d.1.weight", 3, 128, 64),
+			testTensor("a.blk.0.attn_q.weight", 64, 64),
+			testTensor("a.blk.1.attn_q.weight", 64, 64),
+			testTensor("mm.a.mlp.1.weight", 512, 256),
+		},
+	}
+
+	a := f.Architecture()
+	assert.True(t, a.ClipHasAudioEncoder)
+	assert.Equal(t, "ultravox", a.ClipAudioProjectorType)
+	assert.Equal(t, uint32(128), a.ClipAudioMelBins)
+	assert.Equal(t, uint64(2), a.BlockCount)
+
+	ls := f.Layers()
+	_, found := ls.Index([]string{"a.blk.0.attn_q.weight", "a.conv1d.1.weight"})
+	assert.Equal(t, 2, found)
+
+	// 3000 frames are halved into 1500 positions, then stacked by 8.
+	e := f.EstimateLLaMACppUsage()
+	assert.Equal(t, uint64(188), e.AudioTokens)
+	assert.Equal(t, GGUFBytesScalar(3000*128*4+64*1500*4), e.Offload.Computation.Input)
+	assert.Equal(t, GGUFBytesScalar(64*1500*4*4+1500*1500*4*4), e.Offload.Computation.Compute)
+	assert.Equal(t, GGUFBytesScalar(64*1500*4+256*188*4*2), e.Offload.Computation.Output)
+
+	// The longer audio is encoded in 30 seconds chunks.
+	le := f.EstimateLLaMACppUsage(WithAudioDuration(75))
+	assert.Equal(t, uint64(188*2+94), le.AudioTokens)
+	assert.Equal(t, e.Offload.Computation, le.Offload.Computation)
+}
+
+func TestGGUFFile_EstimateLLaMACppUsage_TensorSplit(t *testing.T) {
+	f := &GGUFFile{
+		Header: GGUFHeader{
+			MetadataKV: GGUFMetadataKVs{
+				testKV("general.architecture", GGUFMetadataValueTypeString, "llama"),
+				testKV("llama.context_length", GGUFMetadataValueTypeUint32, uint32(2048)),
+				testKV("llama.embedding_length", GGUFMetadataValueTypeUint32, uint32(128)),
+				testKV("llama.feed_forward_length", GGUFMetadataValueTypeUint32, uint32(512)),
+				testKV("llama.block_count", GGUFMetadataValueTypeUint32, uint32(4)),
+				testKV("llama.attention.head_count", GGUFMetadataValueTypeUint32, uint32(4)),
+			},
+		},
+		TensorInfos: GGUFTensorInfos{
+			testTensor("token_embd.weight", 128, 32),
+			testTensor("blk.0.attn_norm.weight", 128),
+			testTensor("blk.0.attn_q.weight", 128, 128),
+			testTensor("blk.1.attn_norm.weight", 128),
+			testTensor("blk.1.attn_q.weight", 128, 128),
+			testTensor("blk.2.attn_norm.weight", 128),
+			testTensor("blk.2.attn_q.weight", 128, 128),
+			testTensor("blk.3.attn_norm.weight", 128),
+			testTensor("blk.3.attn_q.weight", 128, 128),
+			testTensor("output.weight", 128, 32),
+		},
+	}
+	const (
+		layerWeight = GGUFBytesScalar((128*128 + 128) * 4)
+		layerKey    = GGUFBytesScalar(128 * 32 * 2)
+	)
+
+	// Without tensor split.
+	e := f.EstimateLLaMACppUsage(WithContextSize(32))
+	assert.Nil(t, e.Devices)
+	assert.Nil(t, e.Summarize(false, 0, 0).Memory[0].NonUMA.VRAMs)
+
+	// Split the layers evenly,
+	// the output layer counts as the last one.
+	le := f.EstimateLLaMACppUsage(WithContextSize(32), WithTensorSplit([]float64{1, 1}))
+	if assert.Len(t, le.Devices, 2) {
+		assert.Equal(t, 3*layerWeight, le.Devices[0].Weight.Compute)
+		assert.Equal(t, layerWeight, le.Devices[1].Weight.Compute)
+		assert.Equal(t, 3*layerKey, le.Devices[0].KVCache.Key)
+		assert.Equal(t, layerKey, le.Devices[1].KVCache.Key)
+		assert.Equal(t, e.Offload.Computation, le.Devices[0].Computation)
+		assert.Zero(t, le.Devices[1].Computation.Sum())
+		assert.Equal(t, e.Offload, le.Devices[0].add(le.Devices[1]))
+	}
+	ms := le.Summarize(false, 0, 0).Memory[0]
+	if assert.Len(t, ms.NonUMA.VRAMs, 2) {
+		assert.Equal(t, ms.NonUMA.VRAM, ms.NonUMA.VRAMs[0]+ms.NonUMA.VRAMs[1])
+	}
+	assert.Equal(t, e.Summarize(false, 0, 0).Memory[0].NonUMA.VRAM, ms.NonUMA.VRAM)
+
+	// The computation follows the main device.
+	me := f.EstimateLLaMACppUsage(WithContextSize(32), WithTensorSplit([]float64{1, 1}), WithMainGPU(1))
+	if assert.Len(t, me.Devices, 2) {
+		assert.Zero(t, me.Devices[0].Computation.Sum())
+		assert.Equal(t, e.Offload.Computation, me.Devices[1].Computation)
+	}
+
+	// Split the rows of the matrices,
+	// the rest of the layers stays on the main device.
+	re := f.EstimateLLaMACppUsage(WithContextSize(32), WithTensorSplit([]float64{1, 3}), WithSplitMode(LLaMACppSplitModeRow))
+	if assert.Len(t, re.Devices, 2) {
+		assert.Equal(t, 4*layerWeight-GGUFBytesScalar(4*128*128*4*3/4), re.Devices[0].Weight.Compute)
+		assert.Equal(t, GGUFBytesScalar(4*128*128*4*3/4), re.Devices[1].Weight.Compute)
+		assert.Equal(t, e.Offload.KVCache, re.Devices[0].KVCache)
+		assert.Zero(t, re.Devices[1].KVCache.Sum())
+	}
+
+	// Keep all on the main device.
+	ne := f.EstimateLLaMACppUsage(WithContextSize(32), WithTensorSplit([]float64{1, 1}), WithSplitMode(LLaMACppSplitModeNone), WithMainGPU(1))
+	if assert.Len(t, ne.Devices, 2) {
+		assert.Zero(t, ne.Devices[0].Weight.Sum())
+		assert.Equal(t, e.Offload, ne.Devices[1])
+	}
+
+	// The out of range main device falls back to the first one.
+	{
+		ce := f.EstimateLLaMACppUsage(WithTensorSplit([]float64{1, 1}), WithMainGPU(2))
+		fe := f.EstimateLLaMACppUsage(WithTensorSplit([]float64{1, 1}), WithMainGPU(0))
+		assert.Equal(t, uint64(0), ce.MainGPU)
+		assert.Equal(t, fe.Devices, ce.Devices)
+	}
+
+	// The invalid proportions are ignored.
+	for _, v := range []float64{-1, math.NaN(), math.Inf(1)} {
+		ie := f.EstimateLLaMACppUsage(WithContextSize(32), WithTensorSplit([]float64{1, v}))
+		assert.Nil(t, ie.Devices, v)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/gpustack/gguf-parser-go/util/httpx"
 	"github.com/gpustack/gguf-parser-go/util/osx"
+	"github.com/gpustack/gguf-parser-go/util/stringx"
 )
 
 // ParseGGUFFileFromHuggingFace parses a GGUF file from Hugging Face(https://huggingface.co/),
@@ -39,6 +40,9 @@ func ParseGGUFFileRemote(ctx context.Context, url string, opts ...GGUFReadOption
 			o.CachePath = filepath.Join(o.CachePath, "remote")
 			if o.SkipLargeMetadata {
 				o.CachePath = filepath.Join(o.CachePath, "brief")
+				if len(o.KeepLargeMetadata) != 0 {
+					o.CachePath += "-" + stringx.SumByFNV64a(o.KeepLargeMetadata[0], o.KeepLargeMetadata[1:]...)
+				}
 			}
 		}
 		c := GGUFFileCache(o.CachePath)
@@ -56,7 +60,24 @@ func ParseGGUFFileRemote(ctx context.Context, url string, opts ...GGUFReadOption
 		}()
 	}
 
-	cli := httpx.Client(
+	cli := newRemoteClient(o)
+
+	return parseGGUFFileFromRemote(ctx, cli, url, o)
+}
+
+func parseGGUFFileFromRemote(ctx context.Context, cli *http.Client, url string, o _GGUFReadOptions) (*GGUFFile, error) {
+	sf, err := openRemoteFile(ctx, cli, url, o)
+	if err != nil {
+		return nil, err
+	}
+	defer osx.Close(sf)
+
+	return parseGGUFFile(sf.Len(), io.NewSectionReader(sf, 0, sf.Len()), o)
+}
+
+// newRemoteClient returns a http client with the given options to read remote files.
+func newRemoteClient(o _GGUFReadOptions) *http.Client {
+	return httpx.Client(
 		httpx.ClientOptions().
 			WithUserAgent("gguf-parser-go").
 			If(o.Debug, func(x *httpx.ClientOption) *httpx.ClientOption {
@@ -84,35 +105,24 @@ func ParseGGUFFileRemote(ctx context.Context, url string, opts ...GGUFReadOption
 					If(o.SkipDNSCache, func(x *httpx.TransportOption) *httpx.TransportOption {
 						return x.WithoutDNSCache()
 					})))
-
-	return parseGGUFFileFromRemote(ctx, cli, url, o)
 }
 
-func parseGGUFFileFromRemote(ctx context.Context, cli *http.Client, url string, o _GGUFReadOptions) (*GGUFFile, error) {
-	var (
-		f io.ReadSeeker
-		s int64
-	)
-	{
-		req, err := httpx.NewGetRequestWithContext(ctx, url)
-		if err != nil {
-			return nil, fmt.Errorf("new request: %w", err)
-		}
-
-		sf, err := httpx.OpenSeekerFile(cli, req,
-			httpx.SeekerFileOptions().
-				WithBufferSize(o.BufferSize).
-				If(o.SkipRangeDownloadDetection, func(x *httpx.SeekerFileOption) *httpx.SeekerFileOption {
-					return x.WithoutRangeDownloadDetect()
-				}))
-		if err != nil {
-			return nil, fmt.Errorf("open http file: %w", err)
-		}
-		defer osx.Close(sf)
-
-		f = io.NewSectionReader(sf, 0, sf.Len())
-		s = sf.Len()
+// openRemoteFile opens a remote file from the given url as a seekable file,
+// the caller is responsible for closing the file.
+func openRemoteFile(ctx context.Context, cli *http.Client, url string, o _GGUFReadOptions) (*httpx.SeekerFile, error) {
+	req, err := httpx.NewGetRequestWithContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
 	}
 
-	return parseGGUFFile(s, f, o)
+	sf, err := httpx.OpenSeekerFile(cli, req,
+		httpx.SeekerFileOptions().
+			WithBufferSize(o.BufferSize).
+			If(o.SkipRangeDownloadDetection, func(x *httpx.SeekerFileOption) *httpx.SeekerFileOption {
+				return x.WithoutRangeDownloadDetect()
+			}))
+	if err != nil {
+		return nil, fmt.Errorf("open http file: %w", err)
+	}
+	return sf, nil
 }
@@ -0,0 +1,415 @@
+package gguf_parser
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gpustack/gguf-parser-go/util/funcx"
+	"github.com/gpustack/gguf-parser-go/util/osx"
+)
+
+// GGUFImatrix represents an importance matrix generated by llama.cpp `llama-imatrix`,
+// which is used to guide the quantization.
+type GGUFImatrix struct {
+	/* Basic */
+
+	// Format describes the format of the importance matrix file,
+	// either "dat" for the legacy binary format or "gguf" for the GGUF format.
+	Format string `json:"format"`
+	// Datasets holds the names of the datasets used to generate the importance matrix.
+	Datasets []string `json:"datasets,omitempty"`
+	// ChunkCount is the number of the chunks processed.
+	ChunkCount uint64 `json:"chunkCount"`
+	// ChunkSize is the number of the tokens per chunk,
+	// the legacy binary format does not record it.
+	ChunkSize uint64 `json:"chunkSize,omitempty"`
+	// Entries holds the importance matrix entries,
+	// sorted by the tensor name.
+	Entries []GGUFImatrixEntry `json:"entries"`
+
+	/* Appendix */
+
+	// Size is the size of the importance matrix file.
+	Size GGUFBytesScalar `json:"size"`
+}
+
+// GGUFImatrixEntry represents an entry of the importance matrix,
+// which describes the activations of a tensor.
+type GGUFImatrixEntry struct {
+	// Name is the name of the tensor,
+	// e.g. "blk.0.attn_q.weight".
+	Name string `json:"name"`
+	// Counts holds the number of the activations accumulated,
+	// the legacy binary format records one count for the whole tensor,
+	// and the GGUF format records one count per matrix,
+	// i.e. the length is the number of experts for MoE tensors.
+	Counts []uint64 `json:"counts"`
+	// Len is the number of the values,
+	// which is the number of the tensor's columns multiplies the number of the matrices.
+	Len uint64 `json:"len"`
+	// Values holds the sum of the squared activations of each column,
+	// it is empty if the importance matrix file is parsed with SkipLargeMetadata.
+	Values []float32 `json:"values,omitempty"`
+}
+
+// GGUFImatrixCoverage represents the coverage of an importance matrix against a model.
+type GGUFImatrixCoverage struct {
+	// Covered is the number of the model tensors that have importance matrix entries.
+	Covered uint64 `json:"covered"`
+	// Total is the number of the model tensors that consult the importance matrix during the quantization.
+	Total uint64 `json:"total"`
+	// Missing holds the names of the model tensors that lack importance matrix entries.
+	Missing []string `json:"missing,omitempty"`
+	// Unknown holds the names of the importance matrix entries that do not exist in the model.
+	Unknown []string `json:"unknown,omitempty"`
+	// Incomplete holds the names of the importance matrix entries that have no activations for some matrices,
+	// i.e. the experts that are never routed in MoE models.
+	Incomplete []string `json:"incomplete,omitempty"`
+	// Mismatched holds the importance matrix entries whose length disagree with the model tensors.
+	Mismatched []GGUFImatrixMismatch `json:"mismatched,omitempty"`
+}
+
+// GGUFImatrixMismatch represents an importance matrix entry whose length disagree with the model tensor.
+type GGUFImatrixMismatch struct {
+	// Name is the name of the tensor.
+	Name string `json:"name"`
+	// Expected is the length expected by the model tensor.
+	Expected uint64 `json:"expected"`
+	// Actual is the length of the importance matrix entry.
+	Actual uint64 `json:"actual"`
+}
+
+// ParseGGUFImatrixFile parses an importance matrix file from the local given path,
+// both the legacy binary format and the GGUF format are supported,
+// and returns the GGUFImatrix, or an error if any.
+//
+// With SkipLargeMetadata, the values of the entries are not read.
+func ParseGGUFImatrixFile(path string, opts ...GGUFReadOption) (*GGUFImatrix, error) {
+	var o _GGUFReadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var (
+		f io.ReadSeeker
+		s int64
+	)
+	if o.MMap {
+		mf, err := osx.OpenMmapFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("open mmap file: %w", err)
+		}
+		defer osx.Close(mf)
+		f = io.NewSectionReader(mf, 0, mf.Len())
+		s = mf.Len()
+	} else {
+		ff, err := osx.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open file: %w", err)
+		}
+		defer osx.Close(ff)
+		f = ff
+		s = funcx.MustNoError(ff.Stat()).Size()
+	}
+
+	return parseGGUFImatrix(s, f, o)
+}
+
+func parseGGUFImatrix(s int64, f io.ReadSeeker, o _GGUFReadOptions) (im *GGUFImatrix, err error) {
+	var magic GGUFMagic
+	if err = binary.Read(f, binary.LittleEndian, &magic); err != nil {
+		return nil, fmt.Errorf("read magic: %w", err)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seek start: %w", err)
+	}
+
+	switch magic {
+	case GGUFMagicGGUFLe, GGUFMagicGGUFBe:
+		im, err = parseGGUFImatrixFromGGUF(s, f, o)
+	default:
+		im, err = parseGGUFImatrixFromDat(s, f, o)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(im.Entries, func(i, j int) bool {
+		return im.Entries[i].Name < im.Entries[j].Name
+	})
+	im.Size = GGUFBytesScalar(s)
+
+	return im, nil
+}
+
+// parseGGUFImatrixFromDat parses the legacy binary format,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/examples/imatrix/imatrix.cpp#L216-L280.
+//
+// The counts read from the file are bounded by the remaining bytes before allocating,
+// so that a corrupted file cannot cause a huge allocation.
+func parseGGUFImatrixFromDat(s int64, f io.ReadSeeker, o _GGUFReadOptions) (*GGUFImatrix, error) {
+	im := GGUFImatrix{Format: "dat"}
+
+	const (
+		maxNameLen  = 1 << 16
+		minEntryLen = 4 /* name length */ + 4 /* calls */ + 4 /* values count */
+	)
+
+	var (
+		rd = bufio.NewReader(f)
+		bo = binary.LittleEndian
+		rm = s // Remaining bytes.
+	)
+	readInt32 := func() (v int32, err error) {
+		err = binary.Read(rd, bo, &v)
+		rm -= 4
+		return v, err
+	}
+	readString := func() (string, error) {
+		l, err := readInt32()
+		if err != nil {
+			return "", err
+		}
+		if l < 0 || l > maxNameLen || int64(l) > rm {
+			return "", fmt.Errorf("invalid string length: %d", l)
+		}
+		b := make([]byte, l)
+		if _, err = io.ReadFull(rd, b); err != nil {
+			return "", err
+		}
+		rm -= int64(l)
+		return string(b), nil
+	}
+
+	n, err := readInt32()
+	if err != nil {
+		return nil, fmt.Errorf("read entries count: %w", err)
+	}
+	if n < 0 || int64(n) > rm/minEntryLen {
+		return nil, fmt.Errorf("invalid entries count: %d", n)
+	}
+
+	im.Entries = make([]GGUFImatrixEntry, n)
+	for i := int32(0); i < n; i++ {
+		e := &im.Entries[i]
+
+		if e.Name, err = readString(); err != nil {
+			return nil, fmt.Errorf("read entry %d name: %w", i, err)
+		}
+		nc, err := readInt32()
+		if err != nil {
+			return nil, fmt.Errorf("read entry %q calls: %w", e.Name, err)
+		}
+		nv, err := readInt32()
+		if err != nil {
+			return nil, fmt.Errorf("read entry %q values count: %w", e.Name, err)
+		}
+		if nc < 0 || nv < 0 || int64(nv) > rm/4 {
+			return nil, fmt.Errorf("invalid entry %q: calls %d, values count %d", e.Name, nc, nv)
+		}
+		e.Counts = []uint64{uint64(nc)}
+		e.Len = uint64(nv)
+		rm -= int64(nv) * 4
+
+		if o.SkipLargeMetadata {
+			if _, err = rd.Discard(int(nv) * 4); err != nil {
+				return nil, fmt.Errorf("skip entry %q values: %w", e.Name, err)
+			}
+			continue
+		}
+		e.Values = make([]float32, nv)
+		if err = binary.Read(rd, bo, e.Values); err != nil {
+			return nil, fmt.Errorf("read entry %q values: %w", e.Name, err)
+		}
+	}
+
+	// The chunk count and the dataset are appended by the newer versions,
+	// the older files end here.
+	nc, err := readInt32()
+	switch {
+	case errors.Is(err, io.EOF):
+		return &im, nil
+	case err != nil:
+		return nil, fmt.Errorf("read chunk count: %w", err)
+	}
+	im.ChunkCount = uint64(nc)
+	ds, err := readString()
+	if err != nil {
+		return nil, fmt.Errorf("read dataset: %w", err)
+	}
+	if ds != "" {
+		im.Datasets = []string{ds}
+	}
+
+	return &im, nil
+}
+
+// parseGGUFImatrixFromGGUF parses the GGUF format,
+// which stores the sum of the squared activations in "<name>.in_sum2" tensors,
+// and the number of the activations in "<name>.counts" tensors.
+func parseGGUFImatrixFromGGUF(s int64, f io.ReadSeeker, o _GGUFReadOptions) (*GGUFImatrix, error) {
+	const (
+		typeKey       = "general.type"
+		datasetsKey   = "imatrix.datasets"
+		chunkCountKey = "imatrix.chunk_count"
+		chunkSizeKey  = "imatrix.chunk_size"
+
+		sumSuffix   = ".in_sum2"
+		countSuffix = ".counts"
+	)
+
+	ho := o
+	ho.SkipLargeMetadata = false
+	gf, err := parseGGUFFile(s, f, ho)
+	if err != nil {
+		return nil, err
+	}
+
+	m, _ := gf.Header.MetadataKV.Index([]string{
+		typeKey,
+		datasetsKey,
+		chunkCountKey,
+		chunkSizeKey,
+	})
+	if v, ok := m[typeKey]; !ok || v.ValueString() != "imatrix" {
+		return nil, errors.New("not an imatrix GGUF file")
+	}
+
+	im := GGUFImatrix{Format: "gguf"}
+	if v, ok := m[datasetsKey]; ok {
+		im.Datasets = v.ValueArray().ValuesString()
+	}
+	if v, ok := m[chunkCountKey]; ok {
+		im.ChunkCount = ValueNumeric[uint64](v)
+	}
+	if v, ok := m[chunkSizeKey]; ok {
+		im.ChunkSize = ValueNumeric[uint64](v)
+	}
+
+	var bo binary.ByteOrder = binary.LittleEndian
+	if gf.Header.Magic == GGUFMagicGGUFBe {
+		bo = binary.BigEndian
+	}
+	readF32s := func(ti GGUFTensorInfo) ([]float32, error) {
+		if ti.Type != GGMLTypeF32 {
+			return nil, fmt.Errorf("invalid type of tensor %q: %v", ti.Name, ti.Type)
+		}
+		off := gf.TensorDataStartOffset + int64(ti.Offset)
+		if ti.Elements() > uint64(max(s-off, 0))/4 {
+			return nil, fmt.Errorf("tensor %q exceeds the file size", ti.Name)
+		}
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seek tensor %q: %w", ti.Name, err)
+		}
+		v := make([]float32, ti.Elements())
+		if err := binary.Read(f, bo, v); err != nil {
+			return nil, fmt.Errorf("read tensor %q: %w", ti.Name, err)
+		}
+		return v, nil
+	}
+
+	tis := make(map[string]GGUFTensorInfo, len(gf.TensorInfos))
+	for i := range gf.TensorInfos {
+		tis[gf.TensorInfos[i].Name] = gf.TensorInfos[i]
+	}
+	for i := range gf.TensorInfos {
+		ti := gf.TensorInfos[i]
+		if !strings.HasSuffix(ti.Name, sumSuffix) {
+			continue
+		}
+
+		e := GGUFImatrixEntry{
+			Name: strings.TrimSuffix(ti.Name, sumSuffix),
+			Len:  ti.Elements(),
+		}
+
+		cti, ok := tis[e.Name+countSuffix]
+		if !ok {
+			return nil, fmt.Errorf("missing tensor %q", e.Name+countSuffix)
+		}
+		cs, err := readF32s(cti)
+		if err != nil {
+			return nil, err
+		}
+		e.Counts = make([]uint64, len(cs))
+		for j := range cs {
+			e.Counts[j] = uint64(cs[j])
+		}
+
+		if !o.SkipLargeMetadata {
+			if e.Values, err = readF32s(ti); err != nil {
+				return nil, err
+			}
+		}
+
+		im.Entries = append(im.Entries, e)
+	}
+
+	return &im, nil
+}
+
+// Coverage cross-checks the entries of the importance matrix against the given model,
+// returns the model tensors lacking entries, the entries unknown to the model,
+// and the entries whose length disagree with the model tensors.
+//
+// Only the quantizable tensors of the blocks consult the importance matrix,
+// the token embedding and output tensors are not collected by default.
+func (im *GGUFImatrix) Coverage(gf *GGUFFile) (ic GGUFImatrixCoverage) {
+	es := make(map[string]GGUFImatrixEntry, len(im.Entries))
+	for i := range im.Entries {
+		es[im.Entries[i].Name] = im.Entries[i]
+	}
+
+	tis := make(map[string]GGUFTensorInfo, len(gf.TensorInfos))
+	for i := range gf.TensorInfos {
+		ti := gf.TensorInfos[i]
+		tis[ti.Name] = ti
+
+		if !strings.HasPrefix(ti.Name, "blk.") || !ti.isQuantizable() {
+			continue
+		}
+		ic.Total++
+
+		e, ok := es[ti.Name]
+		if !ok {
+			ic.Missing = append(ic.Missing, ti.Name)
+			continue
+		}
+		ic.Covered++
+
+		// Dense tensors are [n_per_row, n_rows],
+		// MoE tensors are [n_per_row, n_rows, n_expert].
+		el := ti.Dimensions[0]
+		if ti.NDimensions > 2 {
+			el *= ti.Dimensions[2]
+		}
+		if e.Len != el {
+			ic.Mismatched = append(ic.Mismatched, GGUFImatrixMismatch{
+				Name:     ti.Name,
+				Expected: el,
+				Actual:   e.Len,
+			})
+		}
+	}
+
+	for i := range im.Entries {
+		e := im.Entries[i]
+		if _, ok := tis[e.Name]; !ok {
+			ic.Unknown = append(ic.Unknown, e.Name)
+			continue
+		}
+		for _, c := range e.Counts {
+			if c == 0 {
+				ic.Incomplete = append(ic.Incomplete, e.Name)
+				break
+			}
+		}
+	}
+
+	return ic
+}
@@ -0,0 +1,205 @@
+package gguf_parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGGUFImatrixFile(t *testing.T) {
+	p, ok := os.LookupEnv("TEST_IMATRIX_PATH")
+	if !ok {
+		t.Skip("TEST_IMATRIX_PATH is not set")
+		return
+	}
+
+	im, err := ParseGGUFImatrixFile(p, SkipLargeMetadata())
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	t.Log("\n", spew.Sdump(im), "\n")
+}
+
+func TestGGUFImatrix_Coverage(t *testing.T) {
+	type entry struct {
+		name  string
+		calls int32
+		len   int32
+	}
+	write := func(es []entry, chunks int32, dataset string) string {
+		var buf bytes.Buffer
+		w := func(v any) {
+			_ = binary.Write(&buf, binary.LittleEndian, v)
+		}
+		w(int32(len(es)))
+		for _, e := range es {
+			w(int32(len(e.name)))
+			buf.WriteString(e.name)
+			w(e.calls)
+			w(e.len)
+			w(make([]float32, e.len))
+		}
+		w(chunks)
+		w(int32(len(dataset)))
+		buf.WriteString(dataset)
+
+		p := filepath.Join(t.TempDir(), "imatrix.dat")
+		if err := os.WriteFile(p, buf.Bytes(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	gf := &GGUFFile{
+		TensorInfos: GGUFTensorInfos{
+			{Name: "token_embd.weight", NDimensions: 2, Dimensions: []uint64{64, 100}},
+			{Name: "blk.0.attn_norm.weight", NDimensions: 1, Dimensions: []uint64{64}},
+			{Name: "blk.0.attn_q.weight", NDimensions: 2, Dimensions: []uint64{64, 64}},
+			{Name: "blk.0.ffn_down_exps.weight", NDimensions: 3, Dimensions: []uint64{128, 64, 4}},
+			{Name: "blk.0.ffn_up_exps.weight", NDimensions: 3, Dimensions: []uint64{64, 128, 4}},
+			{Name: "output.weight", NDimensions: 2, Dimensions: []uint64{64, 100}},
+		},
+	}
+	p := write([]entry{
+		{name: "blk.0.attn_q.weight", calls: 10, len: 64},
+		{name: "blk.0.ffn_down_exps.weight", calls: 10, len: 128},
+		{name: "blk.1.attn_q.weight", calls: 10, len: 64},
+	}, 10, "wiki.train.raw")
+
+	for _, skip := range []bool{false, true} {
+		var opts []GGUFReadOption
+		if skip {
+			opts = append(opts, SkipLargeMetadata())
+		}
+		im, err := ParseGGUFImatrixFile(p, opts...)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		assert.Equal(t, "dat", im.Format)
+		assert.Equal(t, uint64(10), im.ChunkCount)
+		assert.Equal(t, []string{"wiki.train.raw"}, im.Datasets)
+		assert.Len(t, im.Entries, 3)
+		assert.Equal(t, skip, im.Entries[0].Values == nil)
+
+		ic := im.Coverage(gf)
+		assert.Equal(t, uint64(3), ic.Total)
+		assert.Equal(t, uint64(2), ic.Covered)
+		assert.Equal(t, []string{"blk.0.ffn_up_exps.weight"}, ic.Missing)
+		assert.Equal(t, []string{"blk.1.attn_q.weight"}, ic.Unknown)
+		assert.Equal(t, []GGUFImatrixMismatch{
+			{Name: "blk.0.ffn_down_exps.weight", Expected: 512, Actual: 128},
+		}, ic.Mismatched)
+	}
+}
+
+func TestParseGGUFImatrixFile_GGUF(t *testing.T) {
+	var buf bytes.Buffer
+	w := func(v any) {
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+	ws := func(s string) {
+		w(uint64(len(s)))
+		buf.WriteString(s)
+	}
+	w(GGUFMagicGGUFLe)
+	w(GGUFVersionV3)
+	w(uint64(2)) // tensor count
+	w(uint64(4)) // metadata count
+	ws("general.type")
+	w(GGUFMetadataValueTypeString)
+	ws("imatrix")
+	ws("imatrix.datasets")
+	w(GGUFMetadataValueTypeArray)
+	w(GGUFMetadataValueTypeString)
+	w(uint64(1))
+	ws("wiki.train.raw")
+	ws("imatrix.chunk_count")
+	w(GGUFMetadataValueTypeUint32)
+	w(uint32(10))
+	ws("imatrix.chunk_size")
+	w(GGUFMetadataValueTypeUint32)
+	w(uint32(512))
+	ws("blk.0.attn_q.weight.in_sum2")
+	w(uint32(2))
+	w([]uint64{64, 1})
+	w(GGMLTypeF32)
+	w(uint64(0))
+	ws("blk.0.attn_q.weight.counts")
+	w(uint32(2))
+	w([]uint64{1, 1})
+	w(GGMLTypeF32)
+	w(uint64(64 * 4))
+	buf.Write(make([]byte, GGMLPadding(uint64(buf.Len()), 32)-uint64(buf.Len())))
+	sums := make([]float32, 64)
+	for i := range sums {
+		sums[i] = float32(i)
+	}
+	w(sums)
+	w([]float32{10})
+
+	p := filepath.Join(t.TempDir(), "imatrix.gguf")
+	if err := os.WriteFile(p, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, skip := range []bool{false, true} {
+		var opts []GGUFReadOption
+		if skip {
+			opts = append(opts, SkipLargeMetadata())
+		}
+		im, err := ParseGGUFImatrixFile(p, opts...)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		assert.Equal(t, "gguf", im.Format)
+		assert.Equal(t, []string{"wiki.train.raw"}, im.Datasets)
+		assert.Equal(t, uint64(10), im.ChunkCount)
+		assert.Equal(t, uint64(512), im.ChunkSize)
+		if assert.Len(t, im.Entries, 1) {
+			e := im.Entries[0]
+			assert.Equal(t, "blk.0.attn_q.weight", e.Name)
+			assert.Equal(t, uint64(64), e.Len)
+			assert.Equal(t, []uint64{10}, e.Counts)
+			if skip {
+				assert.Nil(t, e.Values)
+			} else {
+				assert.Equal(t, sums, e.Values)
+			}
+		}
+	}
+}
+
+func TestParseGGUFImatrixFile_Corrupted(t *testing.T) {
+	cases := []struct {
+		name     string
+		given    []int32
+		expected string
+	}{
+		{"entries count", []int32{1 << 30}, "invalid entries count"},
+		{"name length", []int32{1, 1 << 15, 0, 0}, "invalid string length"},
+		{"values count", []int32{1, 0, 1, 1 << 30}, "invalid entry"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			_ = binary.Write(&buf, binary.LittleEndian, tc.given)
+
+			p := filepath.Join(t.TempDir(), "imatrix.dat")
+			if err := os.WriteFile(p, buf.Bytes(), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := ParseGGUFImatrixFile(p)
+			assert.ErrorContains(t, err, tc.expected)
+		})
+	}
+}
@@ -1,10 +1,5 @@
 package gguf_parser
 
-import (
-	"sort"
-	"strings"
-)
-
 // GGUFModelMetadata represents the model metadata of a GGUF file.
 type GGUFModelMetadata struct {
 	/* Basic */
@@ -13,6 +8,11 @@ type GGUFModelMetadata struct {
 	//
 	// All lowercase ASCII, with only [a-z0-9]+ characters allowed.
 	Architecture string `json:"architecture"`
+	// Type describes the type of the GGUF file,
+	// e.g. "model" or "adapter".
+	//
+	// Default is "model".
+	Type string `json:"type"`
 	// QuantizationVersion describes the version of the quantization format.
 	//
 	// Not required if the model is not quantized (i.e. no tensors are quantized).
@@ -105,10 +105,26 @@ const (
 	_GGUFFileTypeCount                             // Unknown
 )
 
+// GGUFFileType constants of the K-quants mixes.
+//
+// llama.cpp stores the K-quants mixes with the legacy values above,
+// e.g. GGUFFileTypeMostlyIQ2_XXS for Q4_K_M,
+// so these constants are placed far beyond the legacy values,
+// and only returned by recognizing the tensor types, see GGUFFile.QuantizationMix.
+const (
+	GGUFFileTypeMostlyQ3_K_M GGUFFileType = iota + 1024 // Q3_K_M
+	GGUFFileTypeMostlyQ3_K_L                            // Q3_K_L
+	GGUFFileTypeMostlyQ4_K_S                            // Q4_K_S
+	GGUFFileTypeMostlyQ4_K_M                            // Q4_K_M
+	GGUFFileTypeMostlyQ5_K_S                            // Q5_K_S
+	GGUFFileTypeMostlyQ5_K_M                            // Q5_K_M
+)
+
 // Model returns the model metadata of the GGUF file.
 func (gf *GGUFFile) Model() (gm GGUFModelMetadata) {
 	const (
 		architectureKey = "general.architecture"
+		typeKey         = "general.type"
 		quantizationKey = "general.quantization_version"
 		alignmentKey    = "general.alignment"
 		nameKey         = "general.name"
@@ -123,6 +139,7 @@ func (gf *GGUFFile) Model() (gm GGUFModelMetadata) {
 
 	m, _ := gf.Header.MetadataKV.Index([]string{
 		architectureKey,
+		typeKey,
 		quantizationKey,
 		alignmentKey,
 		nameKey,
@@ -135,9 +152,16 @@ func (gf *GGUFFile) Model() (gm GGUFModelMetadata) {
 
 	if v, ok := m[architectureKey]; ok {
 		gm.Architecture = v.ValueString()
+	} else if gf.isDiffusion() {
+		gm.Architecture = "diffusion"
 	} else {
 		gm.Architecture = "llama"
 	}
+	if v, ok := m[typeKey]; ok {
+		gm.Type = v.ValueString()
+	} else {
+		gm.Type = "model"
+	}
 	if v, ok := m[quantizationKey]; ok {
 		gm.QuantizationVersion = ValueNumeric[uint32](v)
 	}
@@ -203,11 +227,11 @@ func (t GGUFFileType) GGMLType() GGMLType {
 		return GGMLTypeQ5_1
 	case GGUFFileTypeMostlyQ2_K:
 		return GGMLTypeQ2_K
-	case GGUFFileTypeMostlyQ3_K:
+	case GGUFFileTypeMostlyQ3_K, GGUFFileTypeMostlyQ3_K_M, GGUFFileTypeMostlyQ3_K_L:
 		return GGMLTypeQ3_K
-	case GGUFFileTypeMostlyQ4_K:
+	case GGUFFileTypeMostlyQ4_K, GGUFFileTypeMostlyQ4_K_S, GGUFFileTypeMostlyQ4_K_M:
 		return GGMLTypeQ4_K
-	case GGUFFileTypeMostlyQ5_K:
+	case GGUFFileTypeMostlyQ5_K, GGUFFileTypeMostlyQ5_K_S, GGUFFileTypeMostlyQ5_K_M:
 		return GGMLTypeQ5_K
 	case GGUFFileTypeMostlyQ6_K:
 		return GGMLTypeQ6_K
@@ -243,108 +267,8 @@ func (t GGUFFileType) GGMLType() GGMLType {
 }
 
 // guessFileType guesses the GGUF file type by
-// statistically analyzing the tensor types,
-// which is inspired by
-// https://huggingface.co/TheBloke/Llama-2-13B-chat-GGML#provided-files.
+// recognizing the quantization recipe of the tensor types,
+// see GGUFFile.QuantizationMix.
 func (gf *GGUFFile) guessFileType() GGUFFileType {
-	if len(gf.TensorInfos) == 0 {
-		return _GGUFFileTypeCount
-	}
-
-	var ts []GGMLType
-	{
-		// Count.
-		cm := map[GGMLType]int{}
-		for i := range gf.TensorInfos {
-			if !strings.HasPrefix(gf.TensorInfos[i].Name, "blk") {
-				continue
-			}
-			cm[gf.TensorInfos[i].Type]++
-		}
-
-		// Calculate.
-		ts = make([]GGMLType, 0, len(cm))
-		for t := range cm {
-			ts = append(ts, t)
-		}
-		sort.Slice(ts, func(i, j int) bool {
-			return cm[ts[i]] > cm[ts[j]]
-		})
-	}
-
-	if len(ts) == 0 {
-		return _GGUFFileTypeCount
-	}
-
-	switch ts[0] {
-	case GGMLTypeF32:
-		return GGUFFileTypeAllF32
-	case GGMLTypeF16:
-		return GGUFFileTypeMostlyF16
-	case GGMLTypeQ4_0:
-		return GGUFFileTypeMostlyQ4_0
-	case GGMLTypeQ4_1:
-		return GGUFFileTypeMostlyQ4_1
-	case GGMLTypeQ4_2:
-		return GGUFFileTypeMostlyQ4_2
-	case GGMLTypeQ4_3:
-		return GGUFFileTypeMostlyQ4_3
-	case GGMLTypeQ5_0:
-		return GGUFFileTypeMostlyQ5_0
-	case GGMLTypeQ5_1:
-		return GGUFFileTypeMostlyQ5_1
-	case GGMLTypeQ8_0:
-		return GGUFFileTypeMostlyQ8_0
-	case GGMLTypeQ2_K:
-		return GGUFFileTypeMostlyQ2_K
-	case GGMLTypeQ3_K:
-		switch ts[1] {
-		case GGMLTypeQ4_K: // Legacy, Q3_K_M.
-			return GGUFFileTypeMostlyQ4_K
-		case GGMLTypeQ5_K: // Legacy, Q3_K_L.
-			return GGUFFileTypeMostlyQ5_K
-		default: // Legacy. Q3_K_S
-			return GGUFFileTypeMostlyQ3_K
-		}
-	case GGMLTypeQ4_K:
-		if len(ts) > 2 && ts[2] == GGMLTypeQ6_K { // Legacy, Q4_K_M.
-			return GGUFFileTypeMostlyIQ2_XXS
-		}
-		return GGUFFileTypeMostlyQ6_K // Legacy. Q4_K_S
-	case GGMLTypeQ5_K:
-		if len(ts) > 2 && ts[2] == GGMLTypeQ6_K { // Legacy, Q5_K_M.
-			return GGUFFileTypeMostlyIQ3_XXS
-		}
-		return GGUFFileTypeMostlyIQ2_XS // Legacy. Q5_K_S
-	case GGMLTypeQ6_K:
-		return GGUFFileTypeMostlyIQ1_S // Legacy. Q6_K
-	case GGMLTypeIQ2_XXS:
-		return GGUFFileTypeMostlyIQ2_XXS
-	case GGMLTypeIQ2_XS:
-		return GGUFFileTypeMostlyIQ2_XS
-	case GGMLTypeIQ3_XXS:
-		return GGUFFileTypeMostlyIQ3_XXS
-	case GGMLTypeIQ1_S:
-		return GGUFFileTypeMostlyIQ1_S
-	case GGMLTypeIQ4_NL:
-		return GGUFFileTypeMostlyIQ4_NL
-	case GGMLTypeIQ3_S:
-		return GGUFFileTypeMostlyIQ3_S
-	case GGMLTypeIQ2_S:
-		return GGUFFileTypeMostlyIQ2_S
-	case GGMLTypeIQ4_XS:
-		return GGUFFileTypeMostlyIQ4_XS
-	case GGMLTypeIQ1_M:
-		return GGUFFileTypeMostlyIQ1_M
-	case GGMLTypeBF16:
-		return GGUFFileTypeMostlyBF16
-	case GGMLTypeQ4_0_4_4:
-		return GGUFFileTypeMostlyQ4_0_4_4
-	case GGMLTypeQ4_0_4_8:
-		return GGUFFileTypeMostlyQ4_0_4_8
-	case GGMLTypeQ4_0_8_8:
-		return GGUFFileTypeMostlyQ4_0_8_8
-	default:
-	}
-	return _GGUFFileTypeCount
+	return gf.QuantizationMix().FileType
 }
@@ -50,31 +50,111 @@ func BenchmarkGGUFFile_Model(b *testing.B) {
 func TestGGUFFile_guessFileType(t *testing.T) {
 	ctx := context.Background()
 
-	cases := []string{
-		"Q2_K",
-		"Q3_K_L",
-		"Q3_K_M",
-		"Q3_K_S",
-		"Q4_0",
-		"Q4_K_M",
-		"Q4_K_S",
-		"Q5_0",
-		"Q5_K_M",
-		"Q5_K_S",
-		"Q6_K",
-		"Q8_0",
+	cases := []struct {
+		given    string
+		expected GGUFFileType
+	}{
+		{"Q2_K", GGUFFileTypeMostlyQ2_K},
+		{"Q3_K_L", GGUFFileTypeMostlyQ3_K_L},
+		{"Q3_K_M", GGUFFileTypeMostlyQ3_K_M},
+		{"Q3_K_S", GGUFFileTypeMostlyQ3_K},
+		{"Q4_0", GGUFFileTypeMostlyQ4_0},
+		{"Q4_K_M", GGUFFileTypeMostlyQ4_K_M},
+		{"Q4_K_S", GGUFFileTypeMostlyQ4_K_S},
+		{"Q5_0", GGUFFileTypeMostlyQ5_0},
+		{"Q5_K_M", GGUFFileTypeMostlyQ5_K_M},
+		{"Q5_K_S", GGUFFileTypeMostlyQ5_K_S},
+		{"Q6_K", GGUFFileTypeMostlyQ6_K},
+		{"Q8_0", GGUFFileTypeMostlyQ8_0},
 	}
 	for _, tc := range cases {
-		t.Run(tc, func(t *testing.T) {
+		t.Run(tc.given, func(t *testing.T) {
 			gf, err := ParseGGUFFileFromHuggingFace(
 				ctx,
 				"NousResearch/Hermes-2-Pro-Mistral-7B-GGUF",
-				fmt.Sprintf("Hermes-2-Pro-Mistral-7B.%s.gguf", tc))
+				fmt.Sprintf("Hermes-2-Pro-Mistral-7B.%s.gguf", tc.given))
 			if err != nil {
 				t.Fatal(err)
 				return
 			}
-			assert.Equal(t, gf.Model().FileType.String(), gf.guessFileType().String(), tc+" file type should be equal")
+			assert.Equal(t, tc.expected.String(), gf.guessFileType().String(), tc.given+" file type should be equal")
+		})
+	}
+}
+
+func TestGGUFFile_Model_FileTypeWithoutMetadata(t *testing.T) {
+	const (
+		nLayer = 8
+		nEmbd  = 256
+		nFF    = 512
+		nVocab = 1024
+	)
+	// build replays the recipe to type the tensors of a LLaMA alike model without `general.file_type`.
+	build := func(name string) *GGUFFile {
+		var r _GGUFQuantizationRecipe
+		for _, v := range _GGUFQuantizationRecipes {
+			if v.Name == name {
+				r = v
+			}
+		}
+		s := _GGUFQuantizationState{
+			Architecture:   "llama",
+			BlockCount:     nLayer,
+			EmbeddingGQA:   nEmbd,
+			HasOutput:      true,
+			AttentionWVCnt: nLayer,
+		}
+		var tis GGUFTensorInfos
+		add := func(name string, dims ...uint64) {
+			ti := GGUFTensorInfo{Name: name, NDimensions: uint32(len(dims)), Dimensions: dims, Type: GGMLTypeF32}
+			if len(dims) > 1 {
+				ti.Type = r.expect(ti, s)
+			}
+			tis = append(tis, ti)
+		}
+		add("token_embd.weight", nEmbd, nVocab)
+		for i := 0; i < nLayer; i++ {
+			add(fmt.Sprintf("blk.%d.attn_norm.weight", i), nEmbd)
+			add(fmt.Sprintf("blk.%d.attn_q.weight", i), nEmbd, nEmbd)
+			add(fmt.Sprintf("blk.%d.attn_k.weight", i), nEmbd, nEmbd)
+			add(fmt.Sprintf("blk.%d.attn_v.weight", i), nEmbd, nEmbd)
+			add(fmt.Sprintf("blk.%d.attn_output.weight", i), nEmbd, nEmbd)
+			add(fmt.Sprintf("blk.%d.ffn_gate.weight", i), nEmbd, nFF)
+			add(fmt.Sprintf("blk.%d.ffn_up.weight", i), nEmbd, nFF)
+			add(fmt.Sprintf("blk.%d.ffn_down.weight", i), nFF, nEmbd)
+		}
+		add("output_norm.weight", nEmbd)
+		add("output.weight", nEmbd, nVocab)
+
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: GGUFMetadataKVs{
+					{Key: "general.architecture", ValueType: GGUFMetadataValueTypeString, Value: "llama"},
+					{Key: "llama.block_count", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(nLayer)},
+					{Key: "llama.embedding_length", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(nEmbd)},
+					{Key: "llama.attention.head_count", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(8)},
+				},
+			},
+			TensorInfos: tis,
+		}
+	}
+
+	cases := []struct {
+		given    string
+		expected GGUFFileType
+		ggmlType GGMLType
+	}{
+		{"BF16", GGUFFileTypeMostlyBF16, GGMLTypeBF16},
+		{"IQ4_XS", GGUFFileTypeMostlyIQ4_XS, GGMLTypeIQ4_XS},
+		{"IQ3_M", GGUFFileTypeMostlyIQ3_S, GGMLTypeIQ3_S},
+		{"Q4_K_M", GGUFFileTypeMostlyQ4_K_M, GGMLTypeQ4_K},
+		{"Q5_K_S", GGUFFileTypeMostlyQ5_K_S, GGMLTypeQ5_K},
+	}
+	for _, tc := range cases {
+		t.Run(tc.given, func(t *testing.T) {
+			m := build(tc.given).Model()
+			assert.Equal(t, tc.expected, m.FileType)
+			assert.Equal(t, tc.ggmlType, m.FileType.GGMLType())
 		})
 	}
 }
@@ -14,6 +14,7 @@ type (
 	_GGUFReadOptions struct {
 		Debug             bool
 		SkipLargeMetadata bool
+		KeepLargeMetadata []string
 
 		// Local.
 		MMap bool
@@ -47,6 +48,14 @@ func SkipLargeMetadata() GGUFReadOption {
 	}
 }
 
+// KeepLargeMetadata keeps reading the large GGUFMetadataKV items of the given keys,
+// even if SkipLargeMetadata is used.
+func KeepLargeMetadata(keys ...string) GGUFReadOption {
+	return func(o *_GGUFReadOptions) {
+		o.KeepLargeMetadata = append(o.KeepLargeMetadata, keys...)
+	}
+}
+
 // UseMMap uses mmap to read the local file.
 func UseMMap() GGUFReadOption {
 	return func(o *_GGUFReadOptions) {
@@ -0,0 +1,659 @@
+package gguf_parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// GGUFQuantizationMix represents the quantization recipe recognized from the tensor types of a GGUF file.
+type GGUFQuantizationMix struct {
+	// FileType is the recognized file type,
+	// which is mapped from the llama.cpp file type of the recognized recipe,
+	// or _GGUFFileTypeCount if the recipe has no corresponding GGUFFileType.
+	FileType GGUFFileType `json:"fileType"`
+	// Name is the name of the recognized recipe,
+	// e.g. Q4_K_M.
+	Name string `json:"name"`
+	// Confidence describes how much the tensor types agree with the recognized recipe.
+	Confidence GGUFQuantizationConfidence `json:"confidence"`
+	// Imatrix is true if the model was quantized with an importance matrix.
+	Imatrix bool `json:"imatrix"`
+	// Matched is the number of the quantizable tensors that follow the recognized recipe.
+	Matched uint64 `json:"matched"`
+	// Total is the number of the quantizable tensors.
+	Total uint64 `json:"total"`
+	// Deviations holds the quantizable tensors that do not follow the recognized recipe,
+	// which are usually overridden by the quantizer, e.g. `--output-tensor-type`.
+	Deviations []GGUFQuantizationDeviation `json:"deviations,omitempty"`
+}
+
+// GGUFQuantizationDeviation represents a tensor that deviates from the recognized recipe.
+type GGUFQuantizationDeviation struct {
+	// Name is the name of the tensor.
+	Name string `json:"name"`
+	// Expected is the type that the recognized recipe expects.
+	Expected GGMLType `json:"expected"`
+	// Actual is the type that the tensor has.
+	Actual GGMLType `json:"actual"`
+}
+
+// GGUFQuantizationConfidence is the confidence level of a GGUFQuantizationMix.
+type GGUFQuantizationConfidence string
+
+// GGUFQuantizationConfidence constants.
+const (
+	// GGUFQuantizationConfidenceHigh means all quantizable tensors follow the recognized recipe.
+	GGUFQuantizationConfidenceHigh GGUFQuantizationConfidence = "high"
+	// GGUFQuantizationConfidenceMedium means most quantizable tensors follow the recognized recipe,
+	// or the recognized recipe disagrees with the `general.file_type` metadata.
+	GGUFQuantizationConfidenceMedium GGUFQuantizationConfidence = "medium"
+	// GGUFQuantizationConfidenceLow means the tensor types are mixed in a custom way.
+	GGUFQuantizationConfidenceLow GGUFQuantizationConfidence = "low"
+)
+
+// _LLaMACppFileType is the llama.cpp file type,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/include/llama.h#L139-L180.
+type _LLaMACppFileType uint32
+
+// _LLaMACppFileType constants.
+const (
+	_LLaMACppFileTypeAllF32         _LLaMACppFileType = 0
+	_LLaMACppFileTypeMostlyF16      _LLaMACppFileType = 1
+	_LLaMACppFileTypeMostlyQ4_0     _LLaMACppFileType = 2
+	_LLaMACppFileTypeMostlyQ4_1     _LLaMACppFileType = 3
+	_LLaMACppFileTypeMostlyQ8_0     _LLaMACppFileType = 7
+	_LLaMACppFileTypeMostlyQ5_0     _LLaMACppFileType = 8
+	_LLaMACppFileTypeMostlyQ5_1     _LLaMACppFileType = 9
+	_LLaMACppFileTypeMostlyQ2_K     _LLaMACppFileType = 10
+	_LLaMACppFileTypeMostlyQ3_K_S   _LLaMACppFileType = 11
+	_LLaMACppFileTypeMostlyQ3_K_M   _LLaMACppFileType = 12
+	_LLaMACppFileTypeMostlyQ3_K_L   _LLaMACppFileType = 13
+	_LLaMACppFileTypeMostlyQ4_K_S   _LLaMACppFileType = 14
+	_LLaMACppFileTypeMostlyQ4_K_M   _LLaMACppFileType = 15
+	_LLaMACppFileTypeMostlyQ5_K_S   _LLaMACppFileType = 16
+	_LLaMACppFileTypeMostlyQ5_K_M   _LLaMACppFileType = 17
+	_LLaMACppFileTypeMostlyQ6_K     _LLaMACppFileType = 18
+	_LLaMACppFileTypeMostlyIQ2_XXS  _LLaMACppFileType = 19
+	_LLaMACppFileTypeMostlyIQ2_XS   _LLaMACppFileType = 20
+	_LLaMACppFileTypeMostlyQ2_K_S   _LLaMACppFileType = 21
+	_LLaMACppFileTypeMostlyIQ3_XS   _LLaMACppFileType = 22
+	_LLaMACppFileTypeMostlyIQ3_XXS  _LLaMACppFileType = 23
+	_LLaMACppFileTypeMostlyIQ1_S    _LLaMACppFileType = 24
+	_LLaMACppFileTypeMostlyIQ4_NL   _LLaMACppFileType = 25
+	_LLaMACppFileTypeMostlyIQ3_S    _LLaMACppFileType = 26
+	_LLaMACppFileTypeMostlyIQ3_M    _LLaMACppFileType = 27
+	_LLaMACppFileTypeMostlyIQ2_S    _LLaMACppFileType = 28
+	_LLaMACppFileTypeMostlyIQ2_M    _LLaMACppFileType = 29
+	_LLaMACppFileTypeMostlyIQ4_XS   _LLaMACppFileType = 30
+	_LLaMACppFileTypeMostlyIQ1_M    _LLaMACppFileType = 31
+	_LLaMACppFileTypeMostlyBF16     _LLaMACppFileType = 32
+	_LLaMACppFileTypeMostlyQ4_0_4_4 _LLaMACppFileType = 33
+	_LLaMACppFileTypeMostlyQ4_0_4_8 _LLaMACppFileType = 34
+	_LLaMACppFileTypeMostlyQ4_0_8_8 _LLaMACppFileType = 35
+	_LLaMACppFileTypeMostlyTQ1_0    _LLaMACppFileType = 36
+	_LLaMACppFileTypeMostlyTQ2_0    _LLaMACppFileType = 37
+	_LLaMACppFileTypeGuessed        _LLaMACppFileType = 1024
+)
+
+// GGUFFileType returns the GGUFFileType of the llama.cpp file type,
+// each recipe is mapped to the GGUFFileType constant of the same name or the same main type,
+// and the recipes without corresponding GGUFFileType return _GGUFFileTypeCount.
+func (t _LLaMACppFileType) GGUFFileType() GGUFFileType {
+	switch t {
+	case _LLaMACppFileTypeAllF32:
+		return GGUFFileTypeAllF32
+	case _LLaMACppFileTypeMostlyF16:
+		return GGUFFileTypeMostlyF16
+	case _LLaMACppFileTypeMostlyQ4_0:
+		return GGUFFileTypeMostlyQ4_0
+	case _LLaMACppFileTypeMostlyQ4_1:
+		return GGUFFileTypeMostlyQ4_1
+	case _LLaMACppFileTypeMostlyQ8_0:
+		return GGUFFileTypeMostlyQ8_0
+	case _LLaMACppFileTypeMostlyQ5_0:
+		return GGUFFileTypeMostlyQ5_0
+	case _LLaMACppFileTypeMostlyQ5_1:
+		return GGUFFileTypeMostlyQ5_1
+	case _LLaMACppFileTypeMostlyQ2_K, _LLaMACppFileTypeMostlyQ2_K_S:
+		return GGUFFileTypeMostlyQ2_K
+	case _LLaMACppFileTypeMostlyQ3_K_S:
+		return GGUFFileTypeMostlyQ3_K
+	case _LLaMACppFileTypeMostlyQ3_K_M:
+		return GGUFFileTypeMostlyQ3_K_M
+	case _LLaMACppFileTypeMostlyQ3_K_L:
+		return GGUFFileTypeMostlyQ3_K_L
+	case _LLaMACppFileTypeMostlyQ4_K_S:
+		return GGUFFileTypeMostlyQ4_K_S
+	case _LLaMACppFileTypeMostlyQ4_K_M:
+		return GGUFFileTypeMostlyQ4_K_M
+	case _LLaMACppFileTypeMostlyQ5_K_S:
+		return GGUFFileTypeMostlyQ5_K_S
+	case _LLaMACppFileTypeMostlyQ5_K_M:
+		return GGUFFileTypeMostlyQ5_K_M
+	case _LLaMACppFileTypeMostlyQ6_K:
+		return GGUFFileTypeMostlyQ6_K
+	case _LLaMACppFileTypeMostlyIQ2_XXS:
+		return GGUFFileTypeMostlyIQ2_XXS
+	case _LLaMACppFileTypeMostlyIQ2_XS:
+		return GGUFFileTypeMostlyIQ2_XS
+	case _LLaMACppFileTypeMostlyIQ2_S, _LLaMACppFileTypeMostlyIQ2_M:
+		return GGUFFileTypeMostlyIQ2_S
+	case _LLaMACppFileTypeMostlyIQ3_XXS:
+		return GGUFFileTypeMostlyIQ3_XXS
+	case _LLaMACppFileTypeMostlyIQ3_XS, _LLaMACppFileTypeMostlyIQ3_S, _LLaMACppFileTypeMostlyIQ3_M:
+		return GGUFFileTypeMostlyIQ3_S
+	case _LLaMACppFileTypeMostlyIQ1_S:
+		return GGUFFileTypeMostlyIQ1_S
+	case _LLaMACppFileTypeMostlyIQ1_M:
+		return GGUFFileTypeMostlyIQ1_M
+	case _LLaMACppFileTypeMostlyIQ4_NL:
+		return GGUFFileTypeMostlyIQ4_NL
+	case _LLaMACppFileTypeMostlyIQ4_XS:
+		return GGUFFileTypeMostlyIQ4_XS
+	case _LLaMACppFileTypeMostlyBF16:
+		return GGUFFileTypeMostlyBF16
+	case _LLaMACppFileTypeMostlyQ4_0_4_4:
+		return GGUFFileTypeMostlyQ4_0_4_4
+	case _LLaMACppFileTypeMostlyQ4_0_4_8:
+		return GGUFFileTypeMostlyQ4_0_4_8
+	case _LLaMACppFileTypeMostlyQ4_0_8_8:
+		return GGUFFileTypeMostlyQ4_0_8_8
+	}
+	return _GGUFFileTypeCount
+}
+
+// _GGUFQuantizationRecipe holds the name and the default tensor type of a llama.cpp file type.
+type _GGUFQuantizationRecipe struct {
+	FileType _LLaMACppFileType
+	Name     string
+	Type     GGMLType
+}
+
+// _GGUFQuantizationRecipes is the list of _GGUFQuantizationRecipe,
+// the default tensor types follow
+// https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama.cpp#L15720-L15768.
+var _GGUFQuantizationRecipes = []_GGUFQuantizationRecipe{
+	{_LLaMACppFileTypeAllF32, "F32", GGMLTypeF32},
+	{_LLaMACppFileTypeMostlyF16, "F16", GGMLTypeF16},
+	{_LLaMACppFileTypeMostlyBF16, "BF16", GGMLTypeBF16},
+	{_LLaMACppFileTypeMostlyQ4_0, "Q4_0", GGMLTypeQ4_0},
+	{_LLaMACppFileTypeMostlyQ4_1, "Q4_1", GGMLTypeQ4_1},
+	{_LLaMACppFileTypeMostlyQ5_0, "Q5_0", GGMLTypeQ5_0},
+	{_LLaMACppFileTypeMostlyQ5_1, "Q5_1", GGMLTypeQ5_1},
+	{_LLaMACppFileTypeMostlyQ8_0, "Q8_0", GGMLTypeQ8_0},
+	{_LLaMACppFileTypeMostlyQ2_K, "Q2_K", GGMLTypeQ2_K},
+	{_LLaMACppFileTypeMostlyQ2_K_S, "Q2_K_S", GGMLTypeQ2_K},
+	{_LLaMACppFileTypeMostlyQ3_K_S, "Q3_K_S", GGMLTypeQ3_K},
+	{_LLaMACppFileTypeMostlyQ3_K_M, "Q3_K_M", GGMLTypeQ3_K},
+	{_LLaMACppFileTypeMostlyQ3_K_L, "Q3_K_L", GGMLTypeQ3_K},
+	{_LLaMACppFileTypeMostlyQ4_K_S, "Q4_K_S", GGMLTypeQ4_K},
+	{_LLaMACppFileTypeMostlyQ4_K_M, "Q4_K_M", GGMLTypeQ4_K},
+	{_LLaMACppFileTypeMostlyQ5_K_S, "Q5_K_S", GGMLTypeQ5_K},
+	{_LLaMACppFileTypeMostlyQ5_K_M, "Q5_K_M", GGMLTypeQ5_K},
+	{_LLaMACppFileTypeMostlyQ6_K, "Q6_K", GGMLTypeQ6_K},
+	{_LLaMACppFileTypeMostlyIQ2_XXS, "IQ2_XXS", GGMLTypeIQ2_XXS},
+	{_LLaMACppFileTypeMostlyIQ2_XS, "IQ2_XS", GGMLTypeIQ2_XS},
+	{_LLaMACppFileTypeMostlyIQ2_S, "IQ2_S", GGMLTypeIQ2_XS},
+	{_LLaMACppFileTypeMostlyIQ2_M, "IQ2_M", GGMLTypeIQ2_S},
+	{_LLaMACppFileTypeMostlyIQ3_XS, "IQ3_XS", GGMLTypeIQ3_S},
+	{_LLaMACppFileTypeMostlyIQ3_XXS, "IQ3_XXS", GGMLTypeIQ3_XXS},
+	{_LLaMACppFileTypeMostlyIQ3_S, "IQ3_S", GGMLTypeIQ3_S},
+	{_LLaMACppFileTypeMostlyIQ3_M, "IQ3_M", GGMLTypeIQ3_S},
+	{_LLaMACppFileTypeMostlyIQ1_S, "IQ1_S", GGMLTypeIQ1_S},
+	{_LLaMACppFileTypeMostlyIQ1_M, "IQ1_M", GGMLTypeIQ1_M},
+	{_LLaMACppFileTypeMostlyIQ4_NL, "IQ4_NL", GGMLTypeIQ4_NL},
+	{_LLaMACppFileTypeMostlyIQ4_XS, "IQ4_XS", GGMLTypeIQ4_XS},
+	{_LLaMACppFileTypeMostlyQ4_0_4_4, "Q4_0_4_4", GGMLTypeQ4_0_4_4},
+	{_LLaMACppFileTypeMostlyQ4_0_4_8, "Q4_0_4_8", GGMLTypeQ4_0_4_8},
+	{_LLaMACppFileTypeMostlyQ4_0_8_8, "Q4_0_8_8", GGMLTypeQ4_0_8_8},
+	{_LLaMACppFileTypeMostlyTQ1_0, "TQ1_0", GGMLTypeTQ1_0},
+	{_LLaMACppFileTypeMostlyTQ2_0, "TQ2_0", GGMLTypeTQ2_0},
+}
+
+// _GGUFQuantizationState holds the model hyperparameters that
+// affect the tensor type decisions of llama.cpp quantizer.
+type _GGUFQuantizationState struct {
+	Architecture   string
+	BlockCount     uint64
+	EmbeddingGQA   uint64
+	ExpertCount    uint32
+	Is70B          bool
+	HasOutput      bool
+	HasImatrix     bool
+	AttentionWVCnt uint64
+}
+
+// QuantizationMix recognizes the quantization recipe of the GGUF file,
+// by replaying the tensor type decisions of llama.cpp quantizer for each candidate recipe,
+// and picking the recipe that matches the most quantizable tensors.
+//
+// The decisions mirror
+// https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama.cpp#L15412-L15700.
+func (gf *GGUFFile) QuantizationMix() (qm GGUFQuantizationMix) {
+	const (
+		fileTypeKey       = "general.file_type"
+		imatrixFileKey    = "quantize.imatrix.file"
+		imatrixDatasetKey = "quantize.imatrix.dataset"
+	)
+
+	qm.FileType = _GGUFFileTypeCount
+	qm.Confidence = GGUFQuantizationConfidenceLow
+
+	m, _ := gf.Header.MetadataKV.Index([]string{
+		fileTypeKey,
+		imatrixFileKey,
+		imatrixDatasetKey,
+	})
+
+	// Filter the quantizable tensors,
+	// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama.cpp#L16040-L16064.
+	var tis []GGUFTensorInfo
+	for i := range gf.TensorInfos {
+		if gf.TensorInfos[i].isQuantizable() {
+			tis = append(tis, gf.TensorInfos[i])
+		}
+	}
+	if len(tis) == 0 {
+		return qm
+	}
+
+	a := gf.Architecture()
+	s := _GGUFQuantizationState{
+		Architecture: a.Architecture,
+		BlockCount:   a.BlockCount,
+		EmbeddingGQA: a.EmbeddingGQA,
+		ExpertCount:  a.ExpertCount,
+		Is70B:        a.BlockCount == 80 && a.ExpertCount == 0 && (a.Architecture == "llama" || a.Architecture == "qwen2"),
+	}
+	for i := range tis {
+		switch {
+		case tis[i].Name == "output.weight":
+			s.HasOutput = true
+		case strings.Contains(tis[i].Name, "attn_v.weight"), strings.Contains(tis[i].Name, "attn_qkv.weight"):
+			s.AttentionWVCnt++
+		}
+	}
+
+	// Imatrix affects a few decisions,
+	// the files quantized before the imatrix metadata introduced may not tell,
+	// so try both if the metadata is absent.
+	imatrixes := []bool{false, true}
+	if _, ok := m[imatrixFileKey]; ok {
+		imatrixes = []bool{true}
+	} else if _, ok = m[imatrixDatasetKey]; ok {
+		imatrixes = []bool{true}
+	}
+
+	ft := _LLaMACppFileTypeGuessed
+	if v, ok := m[fileTypeKey]; ok {
+		ft = _LLaMACppFileType(ValueNumeric[uint32](v))
+	}
+
+	var (
+		best        _GGUFQuantizationRecipe
+		bestImatrix bool
+		bestMatched = -1
+	)
+	for _, r := range _GGUFQuantizationRecipes {
+		for _, im := range imatrixes {
+			s.HasImatrix = im
+			var matched int
+			for i := range tis {
+				if r.expect(tis[i], s) == tis[i].Type {
+					matched++
+				}
+			}
+			if matched > bestMatched || (matched == bestMatched && r.FileType == ft && best.FileType != ft) {
+				best, bestImatrix, bestMatched = r, im, matched
+			}
+		}
+	}
+
+	s.HasImatrix = bestImatrix
+	for i := range tis {
+		if et := best.expect(tis[i], s); et != tis[i].Type {
+			qm.Deviations = append(qm.Deviations, GGUFQuantizationDeviation{
+				Name:     tis[i].Name,
+				Expected: et,
+				Actual:   tis[i].Type,
+			})
+		}
+	}
+
+	qm.FileType = best.FileType.GGUFFileType()
+	qm.Name = best.Name
+	qm.Imatrix = bestImatrix
+	qm.Matched = uint64(bestMatched)
+	qm.Total = uint64(len(tis))
+	switch {
+	case len(qm.Deviations) == 0:
+		qm.Confidence = GGUFQuantizationConfidenceHigh
+	case qm.Matched*10 >= qm.Total*9:
+		qm.Confidence = GGUFQuantizationConfidenceMedium
+	}
+	if ft != _LLaMACppFileTypeGuessed && ft != best.FileType &&
+		qm.Confidence == GGUFQuantizationConfidenceHigh {
+		qm.Confidence = GGUFQuantizationConfidenceMedium
+	}
+
+	return qm
+}
+
+// isQuantizable returns true if the tensor is quantized by llama.cpp quantizer.
+func (ti GGUFTensorInfo) isQuantizable() bool {
+	switch {
+	case !strings.HasSuffix(ti.Name, "weight"):
+		return false
+	case ti.NDimensions < 2:
+		return false
+	case strings.Contains(ti.Name, "_norm.weight"):
+		return false
+	case strings.Contains(ti.Name, "ffn_gate_inp.weight"):
+		return false
+	case ti.Name == "position_embd.weight", ti.Name == "token_types.weight":
+		return false
+	case strings.Contains(ti.Name, "ssm_conv1d.weight"),
+		strings.Contains(ti.Name, "ssm_x.weight"),
+		strings.Contains(ti.Name, "ssm_dt.weight"):
+		return false
+	}
+	return true
+}
+
+// expect returns the tensor type that the recipe expects for the given tensor.
+func (r _GGUFQuantizationRecipe) expect(ti GGUFTensorInfo, s _GGUFQuantizationState) GGMLType {
+	ft, nt := r.FileType, r.Type
+
+	// Non-quantized recipes keep the default type.
+	if tt, ok := nt.Trait(); !ok || !tt.Quantized {
+		return nt
+	}
+
+	useMoreBits := func(i, n uint64) bool {
+		return i < n/8 || i >= 7*n/8 || (int64(i)-int64(n/8))%3 == 2
+	}
+	isIQ1or2 := ft == _LLaMACppFileTypeMostlyIQ2_XXS || ft == _LLaMACppFileTypeMostlyIQ2_XS ||
+		ft == _LLaMACppFileTypeMostlyIQ2_S || ft == _LLaMACppFileTypeMostlyIQ2_M ||
+		ft == _LLaMACppFileTypeMostlyIQ1_S || ft == _LLaMACppFileTypeMostlyIQ1_M
+	isIQ2SorM := ft == _LLaMACppFileTypeMostlyIQ2_S || ft == _LLaMACppFileTypeMostlyIQ2_M
+
+	var (
+		name = ti.Name
+		il   = ti.layerIndex()
+		nl   = s.BlockCount
+	)
+	switch {
+	case name == "output.weight" || (!s.HasOutput && name == "token_embd.weight"):
+		switch {
+		case s.Architecture == "falcon" || ti.Dimensions[0]%256 != 0:
+			nt = GGMLTypeQ8_0
+		case isIQ1or2 || ft == _LLaMACppFileTypeMostlyIQ3_XXS:
+			nt = GGMLTypeQ5_K
+		case nt != GGMLTypeQ8_0:
+			nt = GGMLTypeQ6_K
+		}
+	case name == "token_embd.weight":
+		switch {
+		case ft == _LLaMACppFileTypeMostlyIQ2_XXS || ft == _LLaMACppFileTypeMostlyIQ2_XS ||
+			ft == _LLaMACppFileTypeMostlyIQ1_S || ft == _LLaMACppFileTypeMostlyIQ1_M:
+			nt = GGMLTypeQ2_K
+		case isIQ2SorM, ft == _LLaMACppFileTypeMostlyIQ3_XXS:
+			nt = GGMLTypeIQ3_S
+		case ft == _LLaMACppFileTypeMostlyTQ1_0 || ft == _LLaMACppFileTypeMostlyTQ2_0:
+			nt = GGMLTypeQ4_K
+		case nt == GGMLTypeQ4_0_4_4 || nt == GGMLTypeQ4_0_4_8 || nt == GGMLTypeQ4_0_8_8:
+			nt = GGMLTypeQ4_0
+		}
+	case isIQ1or2:
+		switch {
+		case strings.Contains(name, "attn_v.weight"):
+			switch {
+			case s.EmbeddingGQA >= 4 || s.ExpertCount >= 4:
+				nt = GGMLTypeQ4_K
+			case isIQ2SorM:
+				nt = GGMLTypeIQ3_S
+			default:
+				nt = GGMLTypeQ2_K
+			}
+		case s.ExpertCount == 8 && strings.Contains(name, "attn_k.weight"):
+			nt = GGMLTypeQ4_K
+		case strings.Contains(name, "ffn_down"):
+			if il < nl/8 {
+				nt = GGMLTypeQ2_K
+				if isIQ2SorM {
+					nt = GGMLTypeIQ3_S
+				}
+			}
+		case strings.Contains(name, "attn_output.weight"):
+			switch {
+			case s.ExpertCount == 8:
+				nt = GGMLTypeQ5_K
+			case ft == _LLaMACppFileTypeMostlyIQ1_S || ft == _LLaMACppFileTypeMostlyIQ1_M:
+				nt = GGMLTypeIQ2_XXS
+			case isIQ2SorM:
+				nt = GGMLTypeIQ3_S
+			}
+		}
+	case strings.Contains(name, "attn_v.weight"):
+		switch {
+		case ft == _LLaMACppFileTypeMostlyQ2_K:
+			nt = GGMLTypeQ3_K
+			if s.EmbeddingGQA >= 4 {
+				nt = GGMLTypeQ4_K
+			}
+		case ft == _LLaMACppFileTypeMostlyQ2_K_S && s.EmbeddingGQA >= 4:
+			nt = GGMLTypeQ4_K
+		case ft == _LLaMACppFileTypeMostlyIQ3_XXS:
+			switch {
+			case s.EmbeddingGQA >= 4:
+				nt = GGMLTypeQ4_K
+			case !s.HasImatrix:
+				nt = GGMLTypeIQ3_S
+			default:
+				nt = GGMLTypeIQ3_XXS
+			}
+		case (ft == _LLaMACppFileTypeMostlyIQ3_XS || ft == _LLaMACppFileTypeMostlyIQ3_S) && s.EmbeddingGQA >= 4:
+			nt = GGMLTypeQ4_K
+		case ft == _LLaMACppFileTypeMostlyIQ3_M:
+			nt = GGMLTypeQ4_K
+		case ft == _LLaMACppFileTypeMostlyQ3_K_M:
+			nt = GGMLTypeQ4_K
+			if il < 2 {
+				nt = GGMLTypeQ5_K
+			}
+		case ft == _LLaMACppFileTypeMostlyQ3_K_L:
+			nt = GGMLTypeQ5_K
+		case (ft == _LLaMACppFileTypeMostlyIQ4_NL || ft == _LLaMACppFileTypeMostlyIQ4_XS) && s.EmbeddingGQA >= 4:
+			nt = GGMLTypeQ5_K
+		case (ft == _LLaMACppFileTypeMostlyQ4_K_M || ft == _LLaMACppFileTypeMostlyQ5_K_M) &&
+			useMoreBits(il, s.AttentionWVCnt):
+			nt = GGMLTypeQ6_K
+		case ft == _LLaMACppFileTypeMostlyQ4_K_S && il < 4:
+			nt = GGMLTypeQ5_K
+		}
+		if s.Is70B && (nt == GGMLTypeQ3_K || nt == GGMLTypeQ4_K) {
+			nt = GGMLTypeQ5_K
+		}
+		if s.ExpertCount == 8 {
+			nt = GGMLTypeQ8_0
+		}
+	case strings.Contains(name, "attn_k.weight"):
+		switch {
+		case s.ExpertCount == 8:
+			nt = GGMLTypeQ8_0
+		case ft == _LLaMACppFileTypeMostlyIQ3_XS:
+			nt = GGMLTypeIQ3_XXS
+		case ft == _LLaMACppFileTypeMostlyIQ3_XXS:
+			nt = GGMLTypeIQ2_S
+		}
+	case strings.Contains(name, "attn_q.weight"):
+		switch {
+		case ft == _LLaMACppFileTypeMostlyIQ3_XS:
+			nt = GGMLTypeIQ3_XXS
+		case ft == _LLaMACppFileTypeMostlyIQ3_XXS:
+			nt = GGMLTypeIQ2_S
+		}
+	case strings.Contains(name, "ffn_down"):
+		switch {
+		case ft == _LLaMACppFileTypeMostlyQ2_K:
+			nt = GGMLTypeQ3_K
+		case ft == _LLaMACppFileTypeMostlyQ2_K_S:
+			if il < nl/8 {
+				nt = GGMLTypeQ4_K
+			}
+		case ft == _LLaMACppFileTypeMostlyIQ3_XXS && !s.HasImatrix:
+			nt = GGMLTypeQ3_K
+			if il < nl/8 {
+				nt = GGMLTypeQ4_K
+			}
+		case ft == _LLaMACppFileTypeMostlyQ3_K_M:
+			switch {
+			case il < nl/16:
+				nt = GGMLTypeQ5_K
+			case s.Architecture != "falcon" || useMoreBits(il, nl):
+				nt = GGMLTypeQ4_K
+			default:
+				nt = GGMLTypeQ3_K
+			}
+		case ft == _LLaMACppFileTypeMostlyIQ3_M && (il < nl/8 || (s.ExpertCount == 8 && useMoreBits(il, nl))):
+			nt = GGMLTypeQ4_K
+		case ft == _LLaMACppFileTypeMostlyQ3_K_L:
+			nt = GGMLTypeQ5_K
+			if s.Architecture == "falcon" {
+				nt = GGMLTypeQ4_K
+			}
+		case ft == _LLaMACppFileTypeMostlyQ4_K_M:
+			if s.Architecture == "falcon" {
+				switch {
+				case il < nl/16:
+					nt = GGMLTypeQ6_K
+				case useMoreBits(il, nl):
+					nt = GGMLTypeQ5_K
+				default:
+					nt = GGMLTypeQ4_K
+				}
+			} else if useMoreBits(il, nl) {
+				nt = GGMLTypeQ6_K
+			}
+		case il < nl/8 && (ft == _LLaMACppFileTypeMostlyIQ4_NL || ft == _LLaMACppFileTypeMostlyIQ4_XS) && !s.HasImatrix:
+			nt = GGMLTypeQ5_K
+		case ft == _LLaMACppFileTypeMostlyQ5_K_M && useMoreBits(il, nl):
+			nt = GGMLTypeQ6_K
+		case ft == _LLaMACppFileTypeMostlyQ4_K_S && s.Architecture != "falcon" && il < nl/8:
+			nt = GGMLTypeQ5_K
+		case (ft == _LLaMACppFileTypeMostlyQ4_0 || ft == _LLaMACppFileTypeMostlyQ5_0) && s.HasImatrix && il < nl/8:
+			nt = GGMLTypeQ4_1
+			if ft == _LLaMACppFileTypeMostlyQ5_0 {
+				nt = GGMLTypeQ5_1
+			}
+		}
+	case strings.Contains(name, "attn_output.weight"):
+		if s.Architecture != "falcon" {
+			if s.ExpertCount == 8 {
+				switch ft {
+				case _LLaMACppFileTypeMostlyQ2_K, _LLaMACppFileTypeMostlyIQ3_XS, _LLaMACppFileTypeMostlyIQ3_XXS,
+					_LLaMACppFileTypeMostlyQ3_K_S, _LLaMACppFileTypeMostlyQ3_K_M, _LLaMACppFileTypeMostlyIQ4_NL,
+					_LLaMACppFileTypeMostlyQ4_K_S, _LLaMACppFileTypeMostlyQ4_K_M, _LLaMACppFileTypeMostlyIQ3_S,
+					_LLaMACppFileTypeMostlyIQ3_M, _LLaMACppFileTypeMostlyIQ4_XS:
+					nt = GGMLTypeQ5_K
+				}
+			} else {
+				switch ft {
+				case _LLaMACppFileTypeMostlyQ2_K:
+					nt = GGMLTypeQ3_K
+				case _LLaMACppFileTypeMostlyIQ3_XXS:
+					nt = GGMLTypeIQ3_S
+				case _LLaMACppFileTypeMostlyQ3_K_M:
+					nt = GGMLTypeQ4_K
+				case _LLaMACppFileTypeMostlyQ3_K_L:
+					nt = GGMLTypeQ5_K
+				case _LLaMACppFileTypeMostlyIQ3_M:
+					nt = GGMLTypeQ4_K
+				}
+			}
+		} else if ft == _LLaMACppFileTypeMostlyQ3_K_L {
+			nt = GGMLTypeQ4_K
+		}
+	case strings.Contains(name, "attn_qkv.weight"):
+		switch ft {
+		case _LLaMACppFileTypeMostlyQ3_K_M, _LLaMACppFileTypeMostlyQ3_K_L, _LLaMACppFileTypeMostlyIQ3_M:
+			nt = GGMLTypeQ4_K
+		case _LLaMACppFileTypeMostlyQ4_K_M:
+			nt = GGMLTypeQ5_K
+		case _LLaMACppFileTypeMostlyQ5_K_M:
+			nt = GGMLTypeQ6_K
+		}
+	case strings.Contains(name, "ffn_gate"), strings.Contains(name, "ffn_up"):
+		if ft == _LLaMACppFileTypeMostlyIQ3_XS && il >= nl/8 && il < 7*nl/8 {
+			nt = GGMLTypeIQ3_XXS
+		}
+	}
+
+	// Fallback if the row size is not a multiple of the k-quants block size.
+	if ti.Dimensions[0]%256 != 0 {
+		switch nt {
+		case GGMLTypeIQ2_XXS, GGMLTypeIQ2_XS, GGMLTypeIQ2_S, GGMLTypeIQ3_XXS, GGMLTypeIQ3_S,
+			GGMLTypeIQ1_S, GGMLTypeIQ1_M, GGMLTypeQ2_K, GGMLTypeQ3_K, GGMLTypeIQ4_XS:
+			nt = GGMLTypeIQ4_NL
+		case GGMLTypeQ4_K:
+			nt = GGMLTypeQ5_0
+		case GGMLTypeQ5_K:
+			nt = GGMLTypeQ5_1
+		case GGMLTypeQ6_K:
+			nt = GGMLTypeQ8_0
+		case GGMLTypeTQ1_0, GGMLTypeTQ2_0:
+			nt = GGMLTypeQ4_0
+		}
+	}
+
+	return nt
+}
+
+// layerIndex returns the block index of the tensor,
+// e.g. 3 for "blk.3.ffn_down.weight",
+// returns 0 if the tensor is not in a block.
+func (ti GGUFTensorInfo) layerIndex() uint64 {
+	if !strings.HasPrefix(ti.Name, "blk.") {
+		return 0
+	}
+	p := strings.SplitN(ti.Name, ".", 3)
+	if len(p) < 3 {
+		return 0
+	}
+	i, err := strconv.ParseUint(p[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+// layerIndexOf returns the block index of the layer by its first tensor,
+// e.g. 3 for the "blk.3" layer,
+// returns 0 if the layer has no tensors.
+func layerIndexOf(l IGGUFTensorInfos) uint64 {
+	switch v := l.(type) {
+	case GGUFTensorInfo:
+		return v.layerIndex()
+	case *GGUFNamedTensorInfos:
+		if len(v.GGUFLayerTensorInfos) != 0 {
+			return layerIndexOf(v.GGUFLayerTensorInfos[0])
+		}
+	case GGUFTensorInfos:
+		if len(v) != 0 {
+			return v[0].layerIndex()
+		}
+	case GGUFLayerTensorInfos:
+		if len(v) != 0 {
+			return layerIndexOf(v[0])
+		}
+	}
+	return 0
+}
+
+// stackLayerIndex returns the block index of the tensor within the encoder or decoder stack,
+// e.g. 3 for "blk.3.ffn_down.weight" or "dec.blk.3.ffn_down.weight",
+// returns 0 if the tensor is not in a block.
+func (ti GGUFTensorInfo) stackLayerIndex() uint64 {
+	ti.Name = strings.TrimPrefix(strings.TrimPrefix(ti.Name, "enc."), "dec.")
+	return ti.layerIndex()
+}
@@ -0,0 +1,178 @@
+package gguf_parser
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGGUFFile_QuantizationMix(t *testing.T) {
+	ctx := context.Background()
+
+	f, err := ParseGGUFFileFromHuggingFace(
+		ctx,
+		"NousResearch/Hermes-2-Pro-Mistral-7B-GGUF",
+		"Hermes-2-Pro-Mistral-7B.Q4_K_M.gguf",
+		SkipLargeMetadata())
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	t.Log("\n", spew.Sdump(f.QuantizationMix()), "\n")
+}
+
+func TestGGUFFile_QuantizationMix_Recipes(t *testing.T) {
+	// Mistral-7B alike.
+	const (
+		nLayer = 32
+		nEmbd  = 4096
+		nFF    = 14336
+		nVocab = 32000
+	)
+	useMoreBits := func(i int) bool {
+		return i < nLayer/8 || i >= 7*nLayer/8 || (i-nLayer/8)%3 == 2
+	}
+	build := func(get func(name string, il int) GGMLType) *GGUFFile {
+		var tis GGUFTensorInfos
+		add := func(name string, il int, dims ...uint64) {
+			typ := GGMLTypeF32
+			if len(dims) > 1 {
+				typ = get(name, il)
+			}
+			tis = append(tis, GGUFTensorInfo{
+				Name:        name,
+				NDimensions: uint32(len(dims)),
+				Dimensions:  dims,
+				Type:        typ,
+			})
+		}
+		add("token_embd.weight", 0, nEmbd, nVocab)
+		for i := 0; i < nLayer; i++ {
+			add(fmt.Sprintf("blk.%d.attn_norm.weight", i), i, nEmbd)
+			add(fmt.Sprintf("blk.%d.attn_q.weight", i), i, nEmbd, nEmbd)
+			add(fmt.Sprintf("blk.%d.attn_k.weight", i), i, nEmbd, nEmbd/4)
+			add(fmt.Sprintf("blk.%d.attn_v.weight", i), i, nEmbd, nEmbd/4)
+			add(fmt.Sprintf("blk.%d.attn_output.weight", i), i, nEmbd, nEmbd)
+			add(fmt.Sprintf("blk.%d.ffn_norm.weight", i), i, nEmbd)
+			add(fmt.Sprintf("blk.%d.ffn_gate.weight", i), i, nEmbd, nFF)
+			add(fmt.Sprintf("blk.%d.ffn_up.weight", i), i, nEmbd, nFF)
+			add(fmt.Sprintf("blk.%d.ffn_down.weight", i), i, nFF, nEmbd)
+		}
+		add("output_norm.weight", 0, nEmbd)
+		add("output.weight", 0, nEmbd, nVocab)
+
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: GGUFMetadataKVs{
+					{Key: "general.architecture", ValueType: GGUFMetadataValueTypeString, Value: "llama"},
+					{Key: "llama.block_count", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(nLayer)},
+					{Key: "llama.embedding_length", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(nEmbd)},
+					{Key: "llama.attention.head_count", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(32)},
+					{Key: "llama.attention.head_count_kv", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(8)},
+				},
+			},
+			TensorInfos: tis,
+		}
+	}
+
+	q4km := func(name string, il int) GGMLType {
+		switch name {
+		case "output.weight":
+			return GGMLTypeQ6_K
+		case fmt.Sprintf("blk.%d.attn_v.weight", il), fmt.Sprintf("blk.%d.ffn_down.weight", il):
+			if useMoreBits(il) {
+				return GGMLTypeQ6_K
+			}
+		}
+		return GGMLTypeQ4_K
+	}
+	q4ks := func(name string, il int) GGMLType {
+		switch name {
+		case "output.weight":
+			return GGMLTypeQ6_K
+		case fmt.Sprintf("blk.%d.attn_v.weight", il), fmt.Sprintf("blk.%d.ffn_down.weight", il):
+			if il < 4 {
+				return GGMLTypeQ5_K
+			}
+		}
+		return GGMLTypeQ4_K
+	}
+	q3km := func(name string, il int) GGMLType {
+		switch name {
+		case "output.weight":
+			return GGMLTypeQ6_K
+		case fmt.Sprintf("blk.%d.attn_v.weight", il):
+			if il < 2 {
+				return GGMLTypeQ5_K
+			}
+			return GGMLTypeQ4_K
+		case fmt.Sprintf("blk.%d.ffn_down.weight", il):
+			if il < nLayer/16 {
+				return GGMLTypeQ5_K
+			}
+			return GGMLTypeQ4_K
+		case fmt.Sprintf("blk.%d.attn_output.weight", il):
+			return GGMLTypeQ4_K
+		}
+		return GGMLTypeQ3_K
+	}
+
+	cases := []struct {
+		name       string
+		given      *GGUFFile
+		expected   string
+		confidence GGUFQuantizationConfidence
+		deviations int
+	}{
+		{
+			name:       "Q4_K_M",
+			given:      build(q4km),
+			expected:   "Q4_K_M",
+			confidence: GGUFQuantizationConfidenceHigh,
+		},
+		{
+			name:       "Q4_K_S",
+			given:      build(q4ks),
+			expected:   "Q4_K_S",
+			confidence: GGUFQuantizationConfidenceHigh,
+		},
+		{
+			name:       "Q3_K_M",
+			given:      build(q3km),
+			expected:   "Q3_K_M",
+			confidence: GGUFQuantizationConfidenceHigh,
+		},
+		{
+			name: "Q4_K_M with Q8_0 output",
+			given: build(func(name string, il int) GGMLType {
+				if name == "output.weight" {
+					return GGMLTypeQ8_0
+				}
+				return q4km(name, il)
+			}),
+			expected:   "Q4_K_M",
+			confidence: GGUFQuantizationConfidenceMedium,
+			deviations: 1,
+		},
+		{
+			name: "F16",
+			given: build(func(string, int) GGMLType {
+				return GGMLTypeF16
+			}),
+			expected:   "F16",
+			confidence: GGUFQuantizationConfidenceHigh,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.given.QuantizationMix()
+			assert.Equal(t, tc.expected, actual.Name)
+			assert.Equal(t, tc.confidence, actual.Confidence)
+			assert.Len(t, actual.Deviations, tc.deviations)
+		})
+	}
+}
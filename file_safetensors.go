@@ -0,0 +1,439 @@
+package gguf_parser
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gpustack/gguf-parser-go/util/funcx"
+	"github.com/gpustack/gguf-parser-go/util/httpx"
+	"github.com/gpustack/gguf-parser-go/util/json"
+	"github.com/gpustack/gguf-parser-go/util/osx"
+)
+
+// SafetensorsFile represents a safetensors checkpoint,
+// see https://huggingface.co/docs/safetensors/index#format.
+//
+// Compared with the complete safetensors file,
+// this structure lacks the tensor data part,
+// and the shards of a sharded checkpoint are merged into one.
+type SafetensorsFile struct {
+	/* Basic */
+
+	// Metadata is the free-form metadata("__metadata__") of the header,
+	// merged from all shards.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// TensorInfos are the tensor infos of the checkpoint,
+	// sorted by the shard and the data offset.
+	TensorInfos SafetensorsTensorInfos `json:"tensorInfos"`
+	// Shards holds the file names of the checkpoint,
+	// it has only one item if the checkpoint is not sharded.
+	Shards []string `json:"shards"`
+
+	/* Appendix */
+
+	// Size is the size of the checkpoint, summed from all shards.
+	Size GGUFBytesScalar `json:"size"`
+	// ModelSize is the size of the tensor data.
+	ModelSize GGUFBytesScalar `json:"modelSize"`
+	// ModelParameters is the number of the model parameters.
+	ModelParameters GGUFParametersScalar `json:"modelParameters"`
+	// ModelBitsPerWeight is the bits per weight of the model.
+	ModelBitsPerWeight GGUFBitsPerWeightScalar `json:"modelBitsPerWeight"`
+}
+
+// Types for SafetensorsTensorInfo.
+type (
+	// SafetensorsTensorInfo represents a tensor info in a safetensors file.
+	SafetensorsTensorInfo struct {
+		/* Basic */
+
+		// Name is the name of the tensor,
+		// e.g. "model.layers.0.self_attn.q_proj.weight".
+		Name string `json:"name"`
+		// DType is the data type of the tensor,
+		// e.g. "BF16", "F16", "F32".
+		DType string `json:"dtype"`
+		// Shape is the shape of the tensor in row-major order,
+		// which is the reverse of GGUFTensorInfo.Dimensions.
+		Shape []uint64 `json:"shape"`
+		// DataOffsets is the [begin, end) offsets in bytes of the tensor's data,
+		// the offsets are relative to the data part of the shard.
+		DataOffsets [2]uint64 `json:"dataOffsets"`
+
+		/* Appendix */
+
+		// Shard is the index of SafetensorsFile.Shards which the tensor belongs to.
+		Shard int `json:"shard"`
+		// StartOffset is the offset in bytes of the tensor's data in the shard.
+		//
+		// The offset is the start of the shard.
+		StartOffset int64 `json:"startOffset"`
+	}
+
+	// SafetensorsTensorInfos is a list of SafetensorsTensorInfo.
+	SafetensorsTensorInfos []SafetensorsTensorInfo
+)
+
+// _SafetensorsDTypes maps the safetensors data types to the GGML types,
+// the data types without GGML type counterpart are mapped to the size in bytes only.
+var _SafetensorsDTypes = map[string]struct {
+	Type GGMLType
+	Size uint64
+}{
+	"F64":     {GGMLTypeF64, 8},
+	"F32":     {GGMLTypeF32, 4},
+	"F16":     {GGMLTypeF16, 2},
+	"BF16":    {GGMLTypeBF16, 2},
+	"I64":     {GGMLTypeI64, 8},
+	"I32":     {GGMLTypeI32, 4},
+	"I16":     {GGMLTypeI16, 2},
+	"I8":      {GGMLTypeI8, 1},
+	"U64":     {_GGMLTypeCount, 8},
+	"U32":     {_GGMLTypeCount, 4},
+	"U16":     {_GGMLTypeCount, 2},
+	"U8":      {_GGMLTypeCount, 1},
+	"BOOL":    {_GGMLTypeCount, 1},
+	"F8_E4M3": {_GGMLTypeCount, 1},
+	"F8_E5M2": {_GGMLTypeCount, 1},
+}
+
+// _SafetensorsHeaderMaxSize is the maximum size of the header,
+// see https://github.com/huggingface/safetensors/blob/v0.4.5/safetensors/src/tensor.rs#L14.
+const _SafetensorsHeaderMaxSize = 100_000_000
+
+// _SafetensorsIndexSuffix is the suffix of the index file of a sharded checkpoint,
+// e.g. "model.safetensors.index.json".
+const _SafetensorsIndexSuffix = ".index.json"
+
+var ErrSafetensorsFileInvalidFormat = errors.New("invalid safetensors format")
+
+// ParseSafetensorsFile parses a safetensors file from the local given path,
+// and returns the SafetensorsFile, or an error if any.
+//
+// If the path is an index file, e.g. "model.safetensors.index.json",
+// all shards listed in the index are parsed and merged.
+func ParseSafetensorsFile(path string, opts ...GGUFReadOption) (*SafetensorsFile, error) {
+	var o _GGUFReadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if !strings.HasSuffix(path, _SafetensorsIndexSuffix) {
+		return parseSafetensorsFileFromLocal(path, o)
+	}
+
+	bs, err := osx.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read index file: %w", err)
+	}
+	shards, err := parseSafetensorsIndex(bs)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSafetensorsShards(shards, func(shard string) (*SafetensorsFile, error) {
+		return parseSafetensorsFileFromLocal(filepath.Join(filepath.Dir(path), shard), o)
+	})
+}
+
+func parseSafetensorsFileFromLocal(path string, o _GGUFReadOptions) (*SafetensorsFile, error) {
+	var (
+		f io.ReadSeeker
+		s int64
+	)
+	if o.MMap {
+		mf, err := osx.OpenMmapFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("open mmap file: %w", err)
+		}
+		defer osx.Close(mf)
+		f = io.NewSectionReader(mf, 0, mf.Len())
+		s = mf.Len()
+	} else {
+		ff, err := osx.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open file: %w", err)
+		}
+		defer osx.Close(ff)
+		f = ff
+		s = funcx.MustNoError(ff.Stat()).Size()
+	}
+
+	sf, err := parseSafetensorsFile(s, f)
+	if err != nil {
+		return nil, err
+	}
+	sf.Shards = []string{filepath.Base(path)}
+	return sf, nil
+}
+
+// ParseSafetensorsRemote parses a safetensors file from a remote URL,
+// and returns the SafetensorsFile, or an error if any.
+//
+// If the URL points to an index file, e.g. ".../model.safetensors.index.json",
+// all shards listed in the index are parsed and merged,
+// the shards are resolved as the siblings of the index file.
+func ParseSafetensorsRemote(ctx context.Context, url string, opts ...GGUFReadOption) (*SafetensorsFile, error) {
+	var o _GGUFReadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cli := newRemoteClient(o)
+
+	if !strings.HasSuffix(url, _SafetensorsIndexSuffix) {
+		return parseSafetensorsFileFromRemote(ctx, cli, url, o)
+	}
+
+	bs, err := getRemoteFile(ctx, cli, url)
+	if err != nil {
+		return nil, fmt.Errorf("get index file: %w", err)
+	}
+	shards, err := parseSafetensorsIndex(bs)
+	if err != nil {
+		return nil, err
+	}
+	base := url[:strings.LastIndex(url, "/")+1]
+	return mergeSafetensorsShards(shards, func(shard string) (*SafetensorsFile, error) {
+		return parseSafetensorsFileFromRemote(ctx, cli, base+shard, o)
+	})
+}
+
+// ParseSafetensorsFromHuggingFace parses a safetensors file from Hugging Face(https://huggingface.co/),
+// and returns the SafetensorsFile, or an error if any.
+func ParseSafetensorsFromHuggingFace(ctx context.Context, repo, file string, opts ...GGUFReadOption) (*SafetensorsFile, error) {
+	return ParseSafetensorsRemote(ctx, fmt.Sprintf("https://huggingface.co/%s/resolve/main/%s", repo, file), opts...)
+}
+
+func parseSafetensorsFileFromRemote(ctx context.Context, cli *http.Client, url string, o _GGUFReadOptions) (*SafetensorsFile, error) {
+	rf, err := openRemoteFile(ctx, cli, url, o)
+	if err != nil {
+		return nil, err
+	}
+	defer osx.Close(rf)
+
+	sf, err := parseSafetensorsFile(rf.Len(), io.NewSectionReader(rf, 0, rf.Len()))
+	if err != nil {
+		return nil, err
+	}
+	sf.Shards = []string{url[strings.LastIndex(url, "/")+1:]}
+	return sf, nil
+}
+
+// getRemoteFile gets the whole content of a small remote file, e.g. a JSON file.
+func getRemoteFile(ctx context.Context, cli *http.Client, url string) (bs []byte, err error) {
+	req, err := httpx.NewGetRequestWithContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+
+	err = httpx.Do(cli, req, func(resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("status code %d", resp.StatusCode)
+		}
+		bs, err = io.ReadAll(resp.Body)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("do request %s: %w", url, err)
+	}
+	return bs, nil
+}
+
+func parseSafetensorsFile(s int64, f io.ReadSeeker) (*SafetensorsFile, error) {
+	var n uint64
+	if err := binary.Read(f, binary.LittleEndian, &n); err != nil {
+		return nil, fmt.Errorf("read header size: %w", err)
+	}
+	if n < 2 || n > _SafetensorsHeaderMaxSize || int64(n) > s-8 {
+		return nil, ErrSafetensorsFileInvalidFormat
+	}
+
+	bs := make([]byte, n)
+	if _, err := io.ReadFull(f, bs); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	var hdr map[string]json.RawMessage
+	if err := json.Unmarshal(bs, &hdr); err != nil {
+		return nil, fmt.Errorf("%w: unmarshal header: %v", ErrSafetensorsFileInvalidFormat, err)
+	}
+
+	sf := SafetensorsFile{
+		TensorInfos: make(SafetensorsTensorInfos, 0, len(hdr)),
+		Size:        GGUFBytesScalar(s),
+	}
+	ds := uint64(s) - 8 - n // data size
+	for k, v := range hdr {
+		if k == "__metadata__" {
+			if err := json.Unmarshal(v, &sf.Metadata); err != nil {
+				return nil, fmt.Errorf("%w: unmarshal metadata: %v", ErrSafetensorsFileInvalidFormat, err)
+			}
+			continue
+		}
+
+		ti := SafetensorsTensorInfo{Name: k}
+		var t struct {
+			DType       string    `json:"dtype"`
+			Shape       []uint64  `json:"shape"`
+			DataOffsets [2]uint64 `json:"data_offsets"`
+		}
+		if err := json.Unmarshal(v, &t); err != nil {
+			return nil, fmt.Errorf("%w: unmarshal tensor %s: %v", ErrSafetensorsFileInvalidFormat, k, err)
+		}
+		ti.DType, ti.Shape, ti.DataOffsets = t.DType, t.Shape, t.DataOffsets
+		if ti.DataOffsets[0] > ti.DataOffsets[1] || ti.DataOffsets[1] > ds {
+			return nil, fmt.Errorf("%w: tensor %s: data offsets %v out of range", ErrSafetensorsFileInvalidFormat, k, ti.DataOffsets)
+		}
+		ti.StartOffset = int64(8 + n + ti.DataOffsets[0])
+		sf.TensorInfos = append(sf.TensorInfos, ti)
+	}
+	sort.Slice(sf.TensorInfos, func(i, j int) bool {
+		return sf.TensorInfos[i].DataOffsets[0] < sf.TensorInfos[j].DataOffsets[0]
+	})
+	sf.fill()
+
+	return &sf, nil
+}
+
+// parseSafetensorsIndex parses the index file of a sharded checkpoint,
+// and returns the shard file names in order.
+//
+// The shard file names must be plain file names next to the index file,
+// the absolute names, the names containing ".." or a path separator are rejected.
+func parseSafetensorsIndex(bs []byte) ([]string, error) {
+	var idx struct {
+		WeightMap map[string]string `json:"weight_map"`
+	}
+	if err := json.Unmarshal(bs, &idx); err != nil {
+		return nil, fmt.Errorf("unmarshal index file: %w", err)
+	}
+	if len(idx.WeightMap) == 0 {
+		return nil, errors.New("no weight map in index file")
+	}
+
+	m := make(map[string]struct{})
+	shards := make([]string, 0)
+	for _, v := range idx.WeightMap {
+		if _, ok := m[v]; ok {
+			continue
+		}
+		if v == "" || filepath.IsAbs(v) || strings.Contains(v, "..") || strings.ContainsAny(v, `/\`) {
+			return nil, fmt.Errorf("invalid shard file name %q in index file", v)
+		}
+		m[v] = struct{}{}
+		shards = append(shards, v)
+	}
+	sort.Strings(shards)
+	return shards, nil
+}
+
+// mergeSafetensorsShards parses the given shards with the given function,
+// and merges the results into one SafetensorsFile.
+func mergeSafetensorsShards(shards []string, parse func(shard string) (*SafetensorsFile, error)) (*SafetensorsFile, error) {
+	var sf SafetensorsFile
+	for i, shard := range shards {
+		ssf, err := parse(shard)
+		if err != nil {
+			return nil, fmt.Errorf("parse shard %s: %w", shard, err)
+		}
+		for k, v := range ssf.Metadata {
+			if sf.Metadata == nil {
+				sf.Metadata = make(map[string]string)
+			}
+			sf.Metadata[k] = v
+		}
+		for j := range ssf.TensorInfos {
+			ssf.TensorInfos[j].Shard = i
+		}
+		sf.TensorInfos = append(sf.TensorInfos, ssf.TensorInfos...)
+		sf.Shards = append(sf.Shards, shard)
+		sf.Size += ssf.Size
+	}
+	sf.fill()
+
+	return &sf, nil
+}
+
+// fill fills the appendix of the SafetensorsFile.
+func (sf *SafetensorsFile) fill() {
+	sf.ModelSize = GGUFBytesScalar(sf.TensorInfos.Bytes())
+	sf.ModelParameters = GGUFParametersScalar(sf.TensorInfos.Elements())
+	if sf.ModelParameters != 0 {
+		sf.ModelBitsPerWeight = GGUFBitsPerWeightScalar(float64(sf.ModelSize) * 8 / float64(sf.ModelParameters))
+	}
+}
+
+// Elements returns the number of elements of the SafetensorsTensorInfo.
+func (ti SafetensorsTensorInfo) Elements() uint64 {
+	ret := uint64(1)
+	for _, d := range ti.Shape {
+		ret *= d
+	}
+	return ret
+}
+
+// Bytes returns the number of bytes of the SafetensorsTensorInfo.
+func (ti SafetensorsTensorInfo) Bytes() uint64 {
+	return ti.DataOffsets[1] - ti.DataOffsets[0]
+}
+
+// GGUFTensorInfo returns the GGUFTensorInfo form of the SafetensorsTensorInfo,
+// the dimensions are reversed and the data type is converted to GGMLType,
+// it returns false if the data type has no GGMLType counterpart.
+func (ti SafetensorsTensorInfo) GGUFTensorInfo() (GGUFTensorInfo, bool) {
+	dt, ok := _SafetensorsDTypes[ti.DType]
+	if !ok || dt.Type == _GGMLTypeCount {
+		return GGUFTensorInfo{}, false
+	}
+
+	dims := make([]uint64, len(ti.Shape))
+	for i := range ti.Shape {
+		dims[i] = ti.Shape[len(ti.Shape)-1-i]
+	}
+	if len(dims) == 0 {
+		dims = []uint64{1}
+	}
+	return GGUFTensorInfo{
+		Name:        ti.Name,
+		NDimensions: uint32(len(dims)),
+		Dimensions:  dims,
+		Type:        dt.Type,
+		Offset:      ti.DataOffsets[0],
+		StartOffset: ti.StartOffset,
+	}, true
+}
+
+// Get returns the SafetensorsTensorInfo with the given name,
+// and true if found, and false otherwise.
+func (tis SafetensorsTensorInfos) Get(name string) (info SafetensorsTensorInfo, found bool) {
+	for i := range tis {
+		if tis[i].Name == name {
+			return tis[i], true
+		}
+	}
+	return SafetensorsTensorInfo{}, false
+}
+
+// Elements returns the number of elements of the SafetensorsTensorInfos.
+func (tis SafetensorsTensorInfos) Elements() uint64 {
+	var ret uint64
+	for i := range tis {
+		ret += tis[i].Elements()
+	}
+	return ret
+}
+
+// Bytes returns the number of bytes of the SafetensorsTensorInfos.
+func (tis SafetensorsTensorInfos) Bytes() uint64 {
+	var ret uint64
+	for i := range tis {
+		ret += tis[i].Bytes()
+	}
+	return ret
+}
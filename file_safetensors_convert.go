@@ -0,0 +1,314 @@
+package gguf_parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gpustack/gguf-parser-go/util/json"
+	"github.com/gpustack/gguf-parser-go/util/osx"
+)
+
+// HuggingFaceConfig represents the model configuration of a Hugging Face transformers checkpoint,
+// i.e. the config.json,
+// only the fields used to convert the checkpoint to GGUF are included.
+type HuggingFaceConfig struct {
+	// Architectures is the model classes of the checkpoint,
+	// e.g. ["LlamaForCausalLM"].
+	Architectures []string `json:"architectures"`
+	// ModelType is the type of the model,
+	// e.g. "llama".
+	ModelType string `json:"model_type"`
+	// TorchDType is the data type of the checkpoint,
+	// e.g. "bfloat16".
+	TorchDType string `json:"torch_dtype"`
+	// VocabSize is the size of the vocabulary.
+	VocabSize uint64 `json:"vocab_size"`
+	// HiddenSize is the embedding length.
+	HiddenSize uint64 `json:"hidden_size"`
+	// IntermediateSize is the feed forward length.
+	IntermediateSize uint64 `json:"intermediate_size"`
+	// NumHiddenLayers is the block count.
+	NumHiddenLayers uint64 `json:"num_hidden_layers"`
+	// NumAttentionHeads is the number of attention heads.
+	NumAttentionHeads uint64 `json:"num_attention_heads"`
+	// NumKeyValueHeads is the number of key-value heads,
+	// it is equal to NumAttentionHeads if not specified.
+	NumKeyValueHeads uint64 `json:"num_key_value_heads"`
+	// HeadDim is the length of each attention head,
+	// it is HiddenSize / NumAttentionHeads if not specified.
+	HeadDim uint64 `json:"head_dim"`
+	// MaxPositionEmbeddings is the context length.
+	MaxPositionEmbeddings uint64 `json:"max_position_embeddings"`
+	// RMSNormEps is the epsilon of the RMS normalization.
+	RMSNormEps float32 `json:"rms_norm_eps"`
+	// RopeTheta is the base frequency of RoPE.
+	RopeTheta float32 `json:"rope_theta"`
+	// NumLocalExperts is the number of experts, only for MoE models.
+	NumLocalExperts uint64 `json:"num_local_experts"`
+	// NumExpertsPerTok is the number of experts used per token, only for MoE models.
+	NumExpertsPerTok uint64 `json:"num_experts_per_tok"`
+	// TieWordEmbeddings indicates whether the output layer shares the weights with the token embedding.
+	TieWordEmbeddings bool `json:"tie_word_embeddings"`
+}
+
+// ParseHuggingFaceConfigFile parses a Hugging Face config.json from the local given path,
+// and returns the HuggingFaceConfig, or an error if any.
+func ParseHuggingFaceConfigFile(path string) (*HuggingFaceConfig, error) {
+	bs, err := osx.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return parseHuggingFaceConfig(bs)
+}
+
+// ParseHuggingFaceConfigRemote parses a Hugging Face config.json from a remote URL,
+// and returns the HuggingFaceConfig, or an error if any.
+func ParseHuggingFaceConfigRemote(ctx context.Context, url string, opts ...GGUFReadOption) (*HuggingFaceConfig, error) {
+	var o _GGUFReadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	bs, err := getRemoteFile(ctx, newRemoteClient(o), url)
+	if err != nil {
+		return nil, err
+	}
+	return parseHuggingFaceConfig(bs)
+}
+
+func parseHuggingFaceConfig(bs []byte) (*HuggingFaceConfig, error) {
+	var cfg HuggingFaceConfig
+	if err := json.Unmarshal(bs, &cfg); err != nil {
+		return nil, fmt.Errorf("unmarshal config: %w", err)
+	}
+	if cfg.NumKeyValueHeads == 0 {
+		cfg.NumKeyValueHeads = cfg.NumAttentionHeads
+	}
+	if cfg.HeadDim == 0 && cfg.NumAttentionHeads != 0 {
+		cfg.HeadDim = cfg.HiddenSize / cfg.NumAttentionHeads
+	}
+	return &cfg, nil
+}
+
+// _HuggingFaceModelTypeArchitectures maps the Hugging Face model types to the llama.cpp architectures,
+// which are supported by SafetensorsFile.ConvertToGGUF.
+var _HuggingFaceModelTypeArchitectures = map[string]string{
+	"llama":   "llama",
+	"mistral": "llama",
+	"mixtral": "llama",
+	"qwen2":   "qwen2",
+	"gemma":   "gemma",
+}
+
+// _HuggingFaceTensorNames maps the Hugging Face tensor names to the GGUF tensor names,
+// the "model.layers.{bid}." prefix is stripped from the block tensor names,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/gguf-py/gguf/tensor_mapping.py.
+var _HuggingFaceTensorNames = map[string]string{
+	"model.embed_tokens.weight": "token_embd.weight",
+	"model.norm.weight":         "output_norm.weight",
+	"lm_head.weight":            "output.weight",
+
+	"input_layernorm.weight":          "attn_norm.weight",
+	"self_attn.q_proj.weight":         "attn_q.weight",
+	"self_attn.q_proj.bias":           "attn_q.bias",
+	"self_attn.k_proj.weight":         "attn_k.weight",
+	"self_attn.k_proj.bias":           "attn_k.bias",
+	"self_attn.v_proj.weight":         "attn_v.weight",
+	"self_attn.v_proj.bias":           "attn_v.bias",
+	"self_attn.o_proj.weight":         "attn_output.weight",
+	"post_attention_layernorm.weight": "ffn_norm.weight",
+	"mlp.gate_proj.weight":            "ffn_gate.weight",
+	"mlp.up_proj.weight":              "ffn_up.weight",
+	"mlp.down_proj.weight":            "ffn_down.weight",
+	"block_sparse_moe.gate.weight":    "ffn_gate_inp.weight",
+}
+
+// _HuggingFaceExpertTensorNames maps the Hugging Face expert tensor names to the GGUF merged expert tensor names,
+// the "model.layers.{bid}.block_sparse_moe.experts.{xid}." prefix is stripped.
+var _HuggingFaceExpertTensorNames = map[string]string{
+	"w1.weight": "ffn_gate_exps.weight",
+	"w2.weight": "ffn_down_exps.weight",
+	"w3.weight": "ffn_up_exps.weight",
+}
+
+var (
+	_HuggingFaceBlockTensorNameRegex  = regexp.MustCompile(`^model\.layers\.(\d+)\.(.+)$`)
+	_HuggingFaceExpertTensorNameRegex = regexp.MustCompile(`^block_sparse_moe\.experts\.(\d+)\.(.+)$`)
+)
+
+// ConvertToGGUF converts the SafetensorsFile to a GGUFFile with the given HuggingFaceConfig,
+// which is inspired by
+// https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/convert_hf_to_gguf.py.
+//
+// The result GGUFFile only contains the architecture metadata and the tensor infos,
+// which is enough to estimate the usage of the checkpoint in llama.cpp before converting,
+// the tokenizer metadata is not included.
+//
+// The outType is the type of the converted tensors, one of F32, F16, BF16 and Q8_0,
+// the normalization tensors and the other 1D tensors are always converted to F32.
+func (sf *SafetensorsFile) ConvertToGGUF(cfg *HuggingFaceConfig, outType GGMLType) (*GGUFFile, error) {
+	arch, ok := _HuggingFaceModelTypeArchitectures[cfg.ModelType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported model type %q", cfg.ModelType)
+	}
+	var ft _LLaMACppFileType
+	switch outType {
+	case GGMLTypeF32:
+		ft = _LLaMACppFileTypeAllF32
+	case GGMLTypeF16:
+		ft = _LLaMACppFileTypeMostlyF16
+	case GGMLTypeBF16:
+		ft = _LLaMACppFileTypeMostlyBF16
+	case GGMLTypeQ8_0:
+		ft = _LLaMACppFileTypeMostlyQ8_0
+	default:
+		return nil, fmt.Errorf("unsupported output type %s", outType)
+	}
+
+	var (
+		errs []error
+		tis  = make(GGUFTensorInfos, 0, len(sf.TensorInfos))
+		exps = map[string]int{} // indexes of the merged expert tensors
+	)
+	for _, sti := range sf.TensorInfos {
+		if strings.HasSuffix(sti.Name, "rotary_emb.inv_freq") {
+			continue
+		}
+		ti, ok := sti.GGUFTensorInfo()
+		if !ok {
+			errs = append(errs, fmt.Errorf("tensor %s: unsupported dtype %s", sti.Name, sti.DType))
+			continue
+		}
+
+		n, ok := _HuggingFaceTensorNames[sti.Name]
+		if !ok {
+			m := _HuggingFaceBlockTensorNameRegex.FindStringSubmatch(sti.Name)
+			if m == nil {
+				errs = append(errs, fmt.Errorf("tensor %s: cannot be mapped", sti.Name))
+				continue
+			}
+			if em := _HuggingFaceExpertTensorNameRegex.FindStringSubmatch(m[2]); em != nil {
+				if n, ok = _HuggingFaceExpertTensorNames[em[2]]; ok {
+					n = "blk." + m[1] + "." + n
+					if j, ok := exps[n]; ok {
+						tis[j].Dimensions[2]++
+						continue
+					}
+					ti.Name = n
+					ti.NDimensions = 3
+					ti.Dimensions = []uint64{ti.Dimensions[0], ti.Dimensions[1], 1}
+					tis = append(tis, ti)
+					exps[n] = len(tis) - 1
+					continue
+				}
+			}
+			if n, ok = _HuggingFaceTensorNames[m[2]]; !ok {
+				errs = append(errs, fmt.Errorf("tensor %s: cannot be mapped", sti.Name))
+				continue
+			}
+			n = "blk." + m[1] + "." + n
+		}
+		ti.Name = n
+		tis = append(tis, ti)
+	}
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// Sort the tensors, convert the types and place the tensors.
+	sort.SliceStable(tis, func(i, j int) bool {
+		return ggufTensorOrder(tis[i].Name) < ggufTensorOrder(tis[j].Name)
+	})
+	var offset uint64
+	for i := range tis {
+		switch {
+		case tis[i].NDimensions == 1 || strings.HasSuffix(tis[i].Name, "_norm.weight") ||
+			strings.HasSuffix(tis[i].Name, "ffn_gate_inp.weight"):
+			tis[i].Type = GGMLTypeF32
+		case outType == GGMLTypeQ8_0 && tis[i].Dimensions[0]%32 != 0:
+			tis[i].Type = GGMLTypeF16
+		default:
+			tis[i].Type = outType
+		}
+		tis[i].Offset = offset
+		offset += tis[i].Bytes()
+		offset += (32 - offset%32) % 32
+	}
+
+	kv := func(k string, v any) GGUFMetadataKV {
+		var vt GGUFMetadataValueType
+		switch v.(type) {
+		case string:
+			vt = GGUFMetadataValueTypeString
+		case uint32:
+			vt = GGUFMetadataValueTypeUint32
+		case float32:
+			vt = GGUFMetadataValueTypeFloat32
+		}
+		return GGUFMetadataKV{Key: k, ValueType: vt, Value: v}
+	}
+	kvs := GGUFMetadataKVs{
+		kv("general.architecture", arch),
+		kv("general.file_type", uint32(ft.GGUFFileType())),
+		kv(arch+".vocab_size", uint32(cfg.VocabSize)),
+		kv(arch+".context_length", uint32(cfg.MaxPositionEmbeddings)),
+		kv(arch+".embedding_length", uint32(cfg.HiddenSize)),
+		kv(arch+".block_count", uint32(cfg.NumHiddenLayers)),
+		kv(arch+".feed_forward_length", uint32(cfg.IntermediateSize)),
+		kv(arch+".attention.head_count", uint32(cfg.NumAttentionHeads)),
+		kv(arch+".attention.head_count_kv", uint32(cfg.NumKeyValueHeads)),
+		kv(arch+".attention.key_length", uint32(cfg.HeadDim)),
+		kv(arch+".attention.value_length", uint32(cfg.HeadDim)),
+		kv(arch+".attention.layer_norm_rms_epsilon", cfg.RMSNormEps),
+		kv(arch+".rope.dimension_count", uint32(cfg.HeadDim)),
+	}
+	if cfg.RopeTheta != 0 {
+		kvs = append(kvs, kv(arch+".rope.freq_base", cfg.RopeTheta))
+	}
+	if cfg.NumLocalExperts != 0 {
+		kvs = append(kvs,
+			kv(arch+".expert_count", uint32(cfg.NumLocalExperts)),
+			kv(arch+".expert_used_count", uint32(cfg.NumExpertsPerTok)))
+	}
+
+	gf := GGUFFile{
+		Header: GGUFHeader{
+			Magic:           GGUFMagicGGUFLe,
+			Version:         GGUFVersionV3,
+			TensorCount:     uint64(len(tis)),
+			MetadataKVCount: uint64(len(kvs)),
+			MetadataKV:      kvs,
+		},
+		TensorInfos: tis,
+	}
+	gf.ModelSize = GGUFBytesScalar(offset)
+	gf.Size = gf.ModelSize
+	gf.ModelParameters = GGUFParametersScalar(tis.Elements())
+	if gf.ModelParameters != 0 {
+		gf.ModelBitsPerWeight = GGUFBitsPerWeightScalar(float64(gf.ModelSize) * 8 / float64(gf.ModelParameters))
+	}
+
+	return &gf, nil
+}
+
+// ggufTensorOrder returns the order of the GGUF tensor name,
+// the input tensors come first, then the block tensors in ascending order, and the output tensors come last.
+func ggufTensorOrder(name string) uint64 {
+	switch {
+	case strings.HasPrefix(name, "token_embd."):
+		return 0
+	case strings.HasPrefix(name, "blk."):
+		s := strings.TrimPrefix(name, "blk.")
+		if i := strings.IndexByte(s, '.'); i > 0 {
+			if il, err := strconv.ParseUint(s[:i], 10, 64); err == nil {
+				return il + 1
+			}
+		}
+	}
+	return 1 << 32
+}
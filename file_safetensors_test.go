@@ -0,0 +1,158 @@
+package gguf_parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gpustack/gguf-parser-go/util/json"
+)
+
+func TestParseSafetensorsFile(t *testing.T) {
+	const (
+		nEmbd   = 64
+		nFF     = 128
+		nVocab  = 100
+		nLayer  = 2
+		nExpert = 2
+	)
+
+	type tensor struct {
+		name  string
+		shape []uint64
+	}
+	write := func(dir, file string, ts []tensor) {
+		hdr := map[string]any{
+			"__metadata__": map[string]string{"format": "pt"},
+		}
+		var off uint64
+		for _, t := range ts {
+			n := uint64(2) // BF16
+			for _, d := range t.shape {
+				n *= d
+			}
+			hdr[t.name] = map[string]any{
+				"dtype":        "BF16",
+				"shape":        t.shape,
+				"data_offsets": []uint64{off, off + n},
+			}
+			off += n
+		}
+		bs, err := json.Marshal(hdr)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		_ = binary.Write(&buf, binary.LittleEndian, uint64(len(bs)))
+		buf.Write(bs)
+		buf.Write(make([]byte, off))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, file), buf.Bytes(), 0o600))
+	}
+
+	dir := t.TempDir()
+	wm := map[string]string{}
+	for i := 0; i < nLayer; i++ {
+		ts := []tensor{
+			{fmt.Sprintf("model.layers.%d.input_layernorm.weight", i), []uint64{nEmbd}},
+			{fmt.Sprintf("model.layers.%d.self_attn.q_proj.weight", i), []uint64{nEmbd, nEmbd}},
+			{fmt.Sprintf("model.layers.%d.self_attn.k_proj.weight", i), []uint64{nEmbd / 4, nEmbd}},
+			{fmt.Sprintf("model.layers.%d.self_attn.v_proj.weight", i), []uint64{nEmbd / 4, nEmbd}},
+			{fmt.Sprintf("model.layers.%d.self_attn.o_proj.weight", i), []uint64{nEmbd, nEmbd}},
+			{fmt.Sprintf("model.layers.%d.post_attention_layernorm.weight", i), []uint64{nEmbd}},
+			{fmt.Sprintf("model.layers.%d.block_sparse_moe.gate.weight", i), []uint64{nExpert, nEmbd}},
+		}
+		for x := 0; x < nExpert; x++ {
+			ts = append(ts,
+				tensor{fmt.Sprintf("model.layers.%d.block_sparse_moe.experts.%d.w1.weight", i, x), []uint64{nFF, nEmbd}},
+				tensor{fmt.Sprintf("model.layers.%d.block_sparse_moe.experts.%d.w2.weight", i, x), []uint64{nEmbd, nFF}},
+				tensor{fmt.Sprintf("model.layers.%d.block_sparse_moe.experts.%d.w3.weight", i, x), []uint64{nFF, nEmbd}})
+		}
+		if i == 0 {
+			ts = append(ts, tensor{"model.embed_tokens.weight", []uint64{nVocab, nEmbd}})
+		} else {
+			ts = append(ts,
+				tensor{"model.norm.weight", []uint64{nEmbd}},
+				tensor{"lm_head.weight", []uint64{nVocab, nEmbd}})
+		}
+		file := fmt.Sprintf("model-%05d-of-%05d.safetensors", i+1, nLayer)
+		write(dir, file, ts)
+		for _, t := range ts {
+			wm[t.name] = file
+		}
+	}
+	bs, err := json.Marshal(map[string]any{"weight_map": wm})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "model.safetensors.index.json"), bs, 0o600))
+
+	sf, err := ParseSafetensorsFile(filepath.Join(dir, "model.safetensors.index.json"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"model-00001-of-00002.safetensors", "model-00002-of-00002.safetensors"}, sf.Shards)
+	assert.Len(t, sf.TensorInfos, len(wm))
+	assert.Equal(t, map[string]string{"format": "pt"}, sf.Metadata)
+	assert.Equal(t, GGUFBytesScalar(sf.ModelParameters*2), sf.ModelSize)
+
+	ti, ok := sf.TensorInfos.Get("lm_head.weight")
+	require.True(t, ok)
+	assert.Equal(t, 1, ti.Shard)
+	gti, ok := ti.GGUFTensorInfo()
+	require.True(t, ok)
+	assert.Equal(t, []uint64{nEmbd, nVocab}, gti.Dimensions)
+	assert.Equal(t, GGMLTypeBF16, gti.Type)
+
+	cfg, err := parseHuggingFaceConfig([]byte(`{
+		"model_type": "mixtral",
+		"vocab_size": 100,
+		"hidden_size": 64,
+		"intermediate_size": 128,
+		"num_hidden_layers": 2,
+		"num_attention_heads": 4,
+		"num_key_value_heads": 1,
+		"max_position_embeddings": 4096,
+		"rms_norm_eps": 1e-05,
+		"num_local_experts": 2,
+		"num_experts_per_tok": 1
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(16), cfg.HeadDim)
+
+	gf, err := sf.ConvertToGGUF(cfg, GGMLTypeQ8_0)
+	require.NoError(t, err)
+	assert.Equal(t, "token_embd.weight", gf.TensorInfos[0].Name)
+	assert.Equal(t, "output.weight", gf.TensorInfos[len(gf.TensorInfos)-1].Name)
+
+	exps, ok := gf.TensorInfos.Get("blk.1.ffn_down_exps.weight")
+	require.True(t, ok)
+	assert.Equal(t, []uint64{nFF, nEmbd, nExpert}, exps.Dimensions)
+	assert.Equal(t, GGMLTypeQ8_0, exps.Type)
+	norm, ok := gf.TensorInfos.Get("blk.0.attn_norm.weight")
+	require.True(t, ok)
+	assert.Equal(t, GGMLTypeF32, norm.Type)
+
+	a := gf.Architecture()
+	assert.Equal(t, "llama", a.Architecture)
+	assert.Equal(t, uint64(nLayer), a.BlockCount)
+	assert.Equal(t, uint32(nExpert), a.ExpertCount)
+	assert.Equal(t, "Q8_0", gf.Model().FileType.String())
+
+	e := gf.EstimateLLaMACppUsage(WithContextSize(512))
+	assert.Equal(t, GGUFBytesScalar(gf.TensorInfos.Bytes()), e.Load.Weight.Sum()+e.Offload.Weight.Sum())
+
+	gf, err = sf.ConvertToGGUF(cfg, GGMLTypeBF16)
+	require.NoError(t, err)
+	assert.Equal(t, GGUFFileTypeMostlyBF16, gf.Model().FileType)
+
+	_, err = sf.ConvertToGGUF(&HuggingFaceConfig{ModelType: "unknown"}, GGMLTypeF16)
+	assert.Error(t, err)
+
+	for _, shard := range []string{"/tmp/model.safetensors", "../model.safetensors", "sub/model.safetensors", `sub\model.safetensors`, ""} {
+		bs, err := json.Marshal(map[string]any{"weight_map": map[string]string{"lm_head.weight": shard}})
+		require.NoError(t, err)
+		_, err = parseSafetensorsIndex(bs)
+		assert.Error(t, err, "shard %q should be rejected", shard)
+	}
+}
@@ -6,6 +6,9 @@ type GGUFTokenizerMetadata struct {
 
 	// Model is the model of the tokenizer.
 	Model string `json:"model"`
+	// Pre is the pre-tokenizer of the tokenizer,
+	// which is only meaningful for the BPE tokenizer.
+	Pre string `json:"pre,omitempty"`
 	// TokensLength is the size of tokens.
 	TokensLength uint64 `json:"tokensLength"`
 	// MergeLength is the size of merges.
@@ -40,6 +43,18 @@ type GGUFTokenizerMetadata struct {
 	//
 	// Use -1 if the token is not found.
 	PaddingTokenID int64 `json:"paddingTokenID"`
+	// AddBOSToken indicates whether to add the BOS token to the beginning of the prompt.
+	//
+	// Takes the llama.cpp default of the tokenizer model if not specified.
+	AddBOSToken bool `json:"addBOSToken"`
+	// AddEOSToken indicates whether to add the EOS token to the end of the prompt.
+	AddEOSToken bool `json:"addEOSToken"`
+	// AddSpacePrefix indicates whether to add a space to the beginning of the prompt.
+	//
+	// Takes the llama.cpp default of the tokenizer model if not specified.
+	AddSpacePrefix bool `json:"addSpacePrefix"`
+	// RemoveExtraWhitespaces indicates whether to remove the extra whitespaces of the prompt.
+	RemoveExtraWhitespaces bool `json:"removeExtraWhitespaces"`
 
 	/* Appendix */
 
@@ -47,13 +62,28 @@ type GGUFTokenizerMetadata struct {
 	TokensSize int64 `json:"tokensSize"`
 	// MergesSize is the size of merges in bytes.
 	MergesSize int64 `json:"mergesSize"`
+	// PrecompiledCharsmapSize is the size of the precompiled charsmap in bytes,
+	// which is used by the UGM tokenizer to normalize the prompt.
+	PrecompiledCharsmapSize int64 `json:"precompiledCharsmapSize"`
+	// NormalTokensLength is the number of normal tokens.
+	NormalTokensLength uint64 `json:"normalTokensLength"`
+	// ControlTokensLength is the number of control tokens.
+	ControlTokensLength uint64 `json:"controlTokensLength"`
+	// UserDefinedTokensLength is the number of user-defined tokens.
+	UserDefinedTokensLength uint64 `json:"userDefinedTokensLength"`
+	// ByteTokensLength is the number of byte tokens.
+	ByteTokensLength uint64 `json:"byteTokensLength"`
+	// UnusedTokensLength is the number of unused tokens.
+	UnusedTokensLength uint64 `json:"unusedTokensLength"`
 }
 
 // Tokenizer returns the tokenizer metadata of a GGUF file.
 func (gf *GGUFFile) Tokenizer() (gt GGUFTokenizerMetadata) {
 	const (
 		modelKey            = "tokenizer.ggml.model"
+		preKey              = "tokenizer.ggml.pre"
 		tokensKey           = "tokenizer.ggml.tokens"
+		tokenTypeKey        = "tokenizer.ggml.token_type"
 		mergesKey           = "tokenizer.ggml.merges"
 		addedTokensKey      = "tokenizer.ggml.added_tokens"
 		bosTokenIDKey       = "tokenizer.ggml.bos_token_id"
@@ -63,11 +93,18 @@ func (gf *GGUFFile) Tokenizer() (gt GGUFTokenizerMetadata) {
 		unknownTokenIDKey   = "tokenizer.ggml.unknown_token_id"
 		separatorTokenIDKey = "tokenizer.ggml.separator_token_id"
 		paddingTokenIDKey   = "tokenizer.ggml.padding_token_id"
+		addBOSTokenKey      = "tokenizer.ggml.add_bos_token"
+		addEOSTokenKey      = "tokenizer.ggml.add_eos_token"
+		addSpacePrefixKey   = "tokenizer.ggml.add_space_prefix"
+		removeExtraWSKey    = "tokenizer.ggml.remove_extra_whitespaces"
+		charsmapKey         = "tokenizer.ggml.precompiled_charsmap"
 	)
 
 	m, _ := gf.Header.MetadataKV.Index([]string{
 		modelKey,
+		preKey,
 		tokensKey,
+		tokenTypeKey,
 		mergesKey,
 		addedTokensKey,
 		bosTokenIDKey,
@@ -77,6 +114,11 @@ func (gf *GGUFFile) Tokenizer() (gt GGUFTokenizerMetadata) {
 		unknownTokenIDKey,
 		separatorTokenIDKey,
 		paddingTokenIDKey,
+		addBOSTokenKey,
+		addEOSTokenKey,
+		addSpacePrefixKey,
+		removeExtraWSKey,
+		charsmapKey,
 	})
 
 	gt.BOSTokenID = -1
@@ -90,11 +132,29 @@ func (gf *GGUFFile) Tokenizer() (gt GGUFTokenizerMetadata) {
 	if v, ok := m[modelKey]; ok {
 		gt.Model = v.ValueString()
 	}
+	if v, ok := m[preKey]; ok {
+		gt.Pre = v.ValueString()
+	}
 	if v, ok := m[tokensKey]; ok {
 		arr := v.ValueArray()
 		gt.TokensLength = arr.Len
 		gt.TokensSize = arr.Size
 	}
+	if v, ok := m[tokenTypeKey]; ok {
+		arr := v.ValueArray()
+		cs := arr.Counts
+		if uint64(len(arr.Array)) == arr.Len {
+			cs = make(map[int64]uint64)
+			for _, t := range ValuesNumeric[int64](arr) {
+				cs[t]++
+			}
+		}
+		gt.NormalTokensLength = cs[int64(GGUFTokenTypeNormal)]
+		gt.ControlTokensLength = cs[int64(GGUFTokenTypeControl)]
+		gt.UserDefinedTokensLength = cs[int64(GGUFTokenTypeUserDefined)]
+		gt.ByteTokensLength = cs[int64(GGUFTokenTypeByte)]
+		gt.UnusedTokensLength = cs[int64(GGUFTokenTypeUnused)]
+	}
 	if v, ok := m[mergesKey]; ok {
 		arr := v.ValueArray()
 		gt.MergesLength = arr.Len
@@ -125,5 +185,38 @@ func (gf *GGUFFile) Tokenizer() (gt GGUFTokenizerMetadata) {
 		gt.PaddingTokenID = ValueNumeric[int64](v)
 	}
 
+	// Model specific defaults,
+	// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama.cpp#L5560-L5620.
+	switch gt.Model {
+	case "llama":
+		gt.AddBOSToken = true
+		gt.AddSpacePrefix = true
+	case "bert":
+		gt.AddBOSToken = true
+	case "gpt2":
+		switch gt.Pre {
+		case "llama3", "llama-v3", "llama-bpe", "tekken":
+			gt.AddBOSToken = true
+		}
+	case "t5":
+		gt.AddEOSToken = true
+		gt.AddSpacePrefix = true
+	}
+	if v, ok := m[addBOSTokenKey]; ok {
+		gt.AddBOSToken = v.ValueBool()
+	}
+	if v, ok := m[addEOSTokenKey]; ok {
+		gt.AddEOSToken = v.ValueBool()
+	}
+	if v, ok := m[addSpacePrefixKey]; ok {
+		gt.AddSpacePrefix = v.ValueBool()
+	}
+	if v, ok := m[removeExtraWSKey]; ok {
+		gt.RemoveExtraWhitespaces = v.ValueBool()
+	}
+	if v, ok := m[charsmapKey]; ok {
+		gt.PrecompiledCharsmapSize = v.ValueArray().Size
+	}
+
 	return gt
 }
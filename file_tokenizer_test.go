@@ -1,11 +1,16 @@
 package gguf_parser
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGGUFFile_Tokenizer(t *testing.T) {
@@ -44,3 +49,66 @@ func BenchmarkGGUFFile_Tokenizer(b *testing.B) {
 		_ = f.Tokenizer()
 	}
 }
+
+func TestGGUFFile_Tokenizer_TokenTypes(t *testing.T) {
+	var buf bytes.Buffer
+	w := func(v any) {
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+	ws := func(s string) {
+		w(uint64(len(s)))
+		buf.WriteString(s)
+	}
+	w(GGUFMagicGGUFLe)
+	w(GGUFVersionV3)
+	w(uint64(0)) // tensor count
+	w(uint64(6)) // metadata count
+	ws("tokenizer.ggml.model")
+	w(GGUFMetadataValueTypeString)
+	ws("llama")
+	ws("tokenizer.ggml.tokens")
+	w(GGUFMetadataValueTypeArray)
+	w(GGUFMetadataValueTypeString)
+	w(uint64(6))
+	for _, s := range []string{"<unk>", "<s>", "</s>", "<0x0A>", "▁a", "<tool>"} {
+		ws(s)
+	}
+	ws("tokenizer.ggml.token_type")
+	w(GGUFMetadataValueTypeArray)
+	w(GGUFMetadataValueTypeInt32)
+	w(uint64(6))
+	w([]int32{2, 3, 3, 6, 1, 4})
+	ws("tokenizer.ggml.precompiled_charsmap")
+	w(GGUFMetadataValueTypeArray)
+	w(GGUFMetadataValueTypeUint8)
+	w(uint64(16))
+	w(make([]uint8, 16))
+	ws("tokenizer.ggml.add_bos_token")
+	w(GGUFMetadataValueTypeBool)
+	w(false)
+	ws("tokenizer.ggml.remove_extra_whitespaces")
+	w(GGUFMetadataValueTypeBool)
+	w(true)
+
+	p := filepath.Join(t.TempDir(), "tokenizer.gguf")
+	require.NoError(t, os.WriteFile(p, buf.Bytes(), 0o600))
+
+	for _, opts := range [][]GGUFReadOption{nil, {SkipLargeMetadata()}} {
+		f, err := ParseGGUFFile(p, opts...)
+		require.NoError(t, err)
+
+		actual := f.Tokenizer()
+		assert.Equal(t, "llama", actual.Model)
+		assert.Equal(t, uint64(6), actual.TokensLength)
+		assert.False(t, actual.AddBOSToken)
+		assert.False(t, actual.AddEOSToken)
+		assert.True(t, actual.AddSpacePrefix)
+		assert.True(t, actual.RemoveExtraWhitespaces)
+		assert.Equal(t, int64(16), actual.PrecompiledCharsmapSize)
+		assert.Equal(t, uint64(1), actual.NormalTokensLength)
+		assert.Equal(t, uint64(2), actual.ControlTokensLength)
+		assert.Equal(t, uint64(1), actual.UserDefinedTokensLength)
+		assert.Equal(t, uint64(1), actual.ByteTokensLength)
+		assert.Equal(t, uint64(0), actual.UnusedTokensLength)
+	}
+}
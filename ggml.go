@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"strconv"
+	"sync"
 )
 
 // Types for GGMLType.
@@ -59,53 +61,183 @@ const (
 	GGMLTypeQ4_0_4_4
 	GGMLTypeQ4_0_4_8
 	GGMLTypeQ4_0_8_8
+	GGMLTypeTQ1_0
+	GGMLTypeTQ2_0
 	_GGMLTypeCount // Unknown
 )
 
-// _GGMLTypeTraits is a table of GGMLTypeTrait for GGMLType.
-var _GGMLTypeTraits = map[GGMLType]GGMLTypeTrait{
-	GGMLTypeF32:      {BlockSize: 1, TypeSize: 4},
-	GGMLTypeF16:      {BlockSize: 1, TypeSize: 2},
-	GGMLTypeQ4_0:     {BlockSize: 32, TypeSize: 18, Quantized: true},
-	GGMLTypeQ4_1:     {BlockSize: 32, TypeSize: 20, Quantized: true},
-	GGMLTypeQ4_2:     {BlockSize: 0, TypeSize: 0}, // Deprecated
-	GGMLTypeQ4_3:     {BlockSize: 0, TypeSize: 0}, // Deprecated
-	GGMLTypeQ5_0:     {BlockSize: 32, TypeSize: 22, Quantized: true},
-	GGMLTypeQ5_1:     {BlockSize: 32, TypeSize: 24, Quantized: true},
-	GGMLTypeQ8_0:     {BlockSize: 32, TypeSize: 34, Quantized: true},
-	GGMLTypeQ8_1:     {BlockSize: 32, TypeSize: 36, Quantized: true},
-	GGMLTypeQ2_K:     {BlockSize: 256, TypeSize: 84, Quantized: true},
-	GGMLTypeQ3_K:     {BlockSize: 256, TypeSize: 110, Quantized: true},
-	GGMLTypeQ4_K:     {BlockSize: 256, TypeSize: 144, Quantized: true},
-	GGMLTypeQ5_K:     {BlockSize: 256, TypeSize: 176, Quantized: true},
-	GGMLTypeQ6_K:     {BlockSize: 256, TypeSize: 210, Quantized: true},
-	GGMLTypeQ8_K:     {BlockSize: 256, TypeSize: 292, Quantized: true},
-	GGMLTypeIQ2_XXS:  {BlockSize: 256, TypeSize: 66, Quantized: true},
-	GGMLTypeIQ2_XS:   {BlockSize: 256, TypeSize: 74, Quantized: true},
-	GGMLTypeIQ3_XXS:  {BlockSize: 256, TypeSize: 98, Quantized: true},
-	GGMLTypeIQ1_S:    {BlockSize: 256, TypeSize: 50, Quantized: true},
-	GGMLTypeIQ4_NL:   {BlockSize: 32, TypeSize: 18, Quantized: true},
-	GGMLTypeIQ3_S:    {BlockSize: 256, TypeSize: 110, Quantized: true},
-	GGMLTypeIQ2_S:    {BlockSize: 256, TypeSize: 82, Quantized: true},
-	GGMLTypeIQ4_XS:   {BlockSize: 256, TypeSize: 136, Quantized: true},
-	GGMLTypeI8:       {BlockSize: 1, TypeSize: 1},
-	GGMLTypeI16:      {BlockSize: 1, TypeSize: 2},
-	GGMLTypeI32:      {BlockSize: 1, TypeSize: 4},
-	GGMLTypeI64:      {BlockSize: 1, TypeSize: 8},
-	GGMLTypeF64:      {BlockSize: 1, TypeSize: 8},
-	GGMLTypeIQ1_M:    {BlockSize: 256, TypeSize: 56, Quantized: true},
-	GGMLTypeBF16:     {BlockSize: 1, TypeSize: 2},
-	GGMLTypeQ4_0_4_4: {BlockSize: 32, TypeSize: 18, Quantized: true},
-	GGMLTypeQ4_0_4_8: {BlockSize: 32, TypeSize: 18, Quantized: true},
-	GGMLTypeQ4_0_8_8: {BlockSize: 32, TypeSize: 18, Quantized: true},
+// _GGMLTypeCustomMin is the minimum GGMLType that can be registered by RegisterGGMLType,
+// which leaves a gap above the built-in types for the upstream types introduced later.
+const _GGMLTypeCustomMin GGMLType = 1024
+
+var (
+	// _GGMLTypeMu guards _GGMLTypeNames and _GGMLTypeTraits,
+	// which can be extended by RegisterGGMLType.
+	_GGMLTypeMu sync.RWMutex
+
+	// _GGMLTypeNames is a table of name for GGMLType.
+	_GGMLTypeNames = map[GGMLType]string{
+		GGMLTypeF32:      "F32",
+		GGMLTypeF16:      "F16",
+		GGMLTypeQ4_0:     "Q4_0",
+		GGMLTypeQ4_1:     "Q4_1",
+		GGMLTypeQ4_2:     "Q4_2",
+		GGMLTypeQ4_3:     "Q4_3",
+		GGMLTypeQ5_0:     "Q5_0",
+		GGMLTypeQ5_1:     "Q5_1",
+		GGMLTypeQ8_0:     "Q8_0",
+		GGMLTypeQ8_1:     "Q8_1",
+		GGMLTypeQ2_K:     "Q2_K",
+		GGMLTypeQ3_K:     "Q3_K",
+		GGMLTypeQ4_K:     "Q4_K",
+		GGMLTypeQ5_K:     "Q5_K",
+		GGMLTypeQ6_K:     "Q6_K",
+		GGMLTypeQ8_K:     "Q8_K",
+		GGMLTypeIQ2_XXS:  "IQ2_XXS",
+		GGMLTypeIQ2_XS:   "IQ2_XS",
+		GGMLTypeIQ3_XXS:  "IQ3_XXS",
+		GGMLTypeIQ1_S:    "IQ1_S",
+		GGMLTypeIQ4_NL:   "IQ4_NL",
+		GGMLTypeIQ3_S:    "IQ3_S",
+		GGMLTypeIQ2_S:    "IQ2_S",
+		GGMLTypeIQ4_XS:   "IQ4_XS",
+		GGMLTypeI8:       "I8",
+		GGMLTypeI16:      "I16",
+		GGMLTypeI32:      "I32",
+		GGMLTypeI64:      "I64",
+		GGMLTypeF64:      "F64",
+		GGMLTypeIQ1_M:    "IQ1_M",
+		GGMLTypeBF16:     "BF16",
+		GGMLTypeQ4_0_4_4: "Q4_0_4_4",
+		GGMLTypeQ4_0_4_8: "Q4_0_4_8",
+		GGMLTypeQ4_0_8_8: "Q4_0_8_8",
+		GGMLTypeTQ1_0:    "TQ1_0",
+		GGMLTypeTQ2_0:    "TQ2_0",
+	}
+
+	// _GGMLTypeTraits is a table of GGMLTypeTrait for GGMLType.
+	_GGMLTypeTraits = map[GGMLType]GGMLTypeTrait{
+		GGMLTypeF32:      {BlockSize: 1, TypeSize: 4},
+		GGMLTypeF16:      {BlockSize: 1, TypeSize: 2},
+		GGMLTypeQ4_0:     {BlockSize: 32, TypeSize: 18, Quantized: true},
+		GGMLTypeQ4_1:     {BlockSize: 32, TypeSize: 20, Quantized: true},
+		GGMLTypeQ4_2:     {BlockSize: 0, TypeSize: 0}, // Deprecated
+		GGMLTypeQ4_3:     {BlockSize: 0, TypeSize: 0}, // Deprecated
+		GGMLTypeQ5_0:     {BlockSize: 32, TypeSize: 22, Quantized: true},
+		GGMLTypeQ5_1:     {BlockSize: 32, TypeSize: 24, Quantized: true},
+		GGMLTypeQ8_0:     {BlockSize: 32, TypeSize: 34, Quantized: true},
+		GGMLTypeQ8_1:     {BlockSize: 32, TypeSize: 36, Quantized: true},
+		GGMLTypeQ2_K:     {BlockSize: 256, TypeSize: 84, Quantized: true},
+		GGMLTypeQ3_K:     {BlockSize: 256, TypeSize: 110, Quantized: true},
+		GGMLTypeQ4_K:     {BlockSize: 256, TypeSize: 144, Quantized: true},
+		GGMLTypeQ5_K:     {BlockSize: 256, TypeSize: 176, Quantized: true},
+		GGMLTypeQ6_K:     {BlockSize: 256, TypeSize: 210, Quantized: true},
+		GGMLTypeQ8_K:     {BlockSize: 256, TypeSize: 292, Quantized: true},
+		GGMLTypeIQ2_XXS:  {BlockSize: 256, TypeSize: 66, Quantized: true},
+		GGMLTypeIQ2_XS:   {BlockSize: 256, TypeSize: 74, Quantized: true},
+		GGMLTypeIQ3_XXS:  {BlockSize: 256, TypeSize: 98, Quantized: true},
+		GGMLTypeIQ1_S:    {BlockSize: 256, TypeSize: 50, Quantized: true},
+		GGMLTypeIQ4_NL:   {BlockSize: 32, TypeSize: 18, Quantized: true},
+		GGMLTypeIQ3_S:    {BlockSize: 256, TypeSize: 110, Quantized: true},
+		GGMLTypeIQ2_S:    {BlockSize: 256, TypeSize: 82, Quantized: true},
+		GGMLTypeIQ4_XS:   {BlockSize: 256, TypeSize: 136, Quantized: true},
+		GGMLTypeI8:       {BlockSize: 1, TypeSize: 1},
+		GGMLTypeI16:      {BlockSize: 1, TypeSize: 2},
+		GGMLTypeI32:      {BlockSize: 1, TypeSize: 4},
+		GGMLTypeI64:      {BlockSize: 1, TypeSize: 8},
+		GGMLTypeF64:      {BlockSize: 1, TypeSize: 8},
+		GGMLTypeIQ1_M:    {BlockSize: 256, TypeSize: 56, Quantized: true},
+		GGMLTypeBF16:     {BlockSize: 1, TypeSize: 2},
+		GGMLTypeQ4_0_4_4: {BlockSize: 32, TypeSize: 18, Quantized: true},
+		GGMLTypeQ4_0_4_8: {BlockSize: 32, TypeSize: 18, Quantized: true},
+		GGMLTypeQ4_0_8_8: {BlockSize: 32, TypeSize: 18, Quantized: true},
+		GGMLTypeTQ1_0:    {BlockSize: 256, TypeSize: 54, Quantized: true},
+		GGMLTypeTQ2_0:    {BlockSize: 256, TypeSize: 66, Quantized: true},
+	}
+)
+
+// RegisterGGMLType registers a GGMLType with the given name and GGMLTypeTrait,
+// which is used to support the types introduced by the llama.cpp forks,
+// or the upstream types that are not supported yet.
+//
+// The type must not be less than 1024,
+// which keeps the registered types away from the built-in types and the upcoming upstream types,
+// and the type that has been registered cannot be registered again.
+func RegisterGGMLType(t GGMLType, name string, trait GGMLTypeTrait) error {
+	if t < _GGMLTypeCount {
+		return fmt.Errorf("cannot override built-in type: %v", t)
+	}
+	if t < _GGMLTypeCustomMin {
+		return fmt.Errorf("type %d is reserved, must not be less than %d", uint32(t), uint32(_GGMLTypeCustomMin))
+	}
+	if name == "" {
+		return errors.New("blank name")
+	}
+	if trait.BlockSize == 0 || trait.TypeSize == 0 {
+		return fmt.Errorf("invalid trait of type %s: block size and type size must be positive", name)
+	}
+
+	_GGMLTypeMu.Lock()
+	defer _GGMLTypeMu.Unlock()
+
+	if _, ok := _GGMLTypeTraits[t]; ok {
+		return fmt.Errorf("type %d has been registered as %s", uint32(t), _GGMLTypeNames[t])
+	}
+	for et, en := range _GGMLTypeNames {
+		if en == name {
+			return fmt.Errorf("name %s has been registered by type %d", name, uint32(et))
+		}
+	}
+
+	_GGMLTypeNames[t] = name
+	_GGMLTypeTraits[t] = trait
+	return nil
+}
+
+// unregisterGGMLType removes the GGMLType registered by RegisterGGMLType,
+// the built-in types are never removed.
+func unregisterGGMLType(t GGMLType) {
+	if t < _GGMLTypeCustomMin {
+		return
+	}
+
+	_GGMLTypeMu.Lock()
+	defer _GGMLTypeMu.Unlock()
+
+	delete(_GGMLTypeNames, t)
+	delete(_GGMLTypeTraits, t)
+}
+
+// String returns the name of the GGMLType.
+func (t GGMLType) String() string {
+	_GGMLTypeMu.RLock()
+	n, ok := _GGMLTypeNames[t]
+	_GGMLTypeMu.RUnlock()
+	if ok {
+		return n
+	}
+	if t == _GGMLTypeCount {
+		return "Unknown"
+	}
+	return "GGMLType(" + strconv.FormatUint(uint64(t), 10) + ")"
 }
 
 // Trait returns the GGMLTypeTrait of the GGMLType.
 func (t GGMLType) Trait() (GGMLTypeTrait, bool) {
+	_GGMLTypeMu.RLock()
 	tt, ok := _GGMLTypeTraits[t]
+	_GGMLTypeMu.RUnlock()
 	return tt, ok
 }
 
+// Valid returns true if the GGMLType is known and can be sized,
+// i.e. it is built-in or registered by RegisterGGMLType,
+// and it is not deprecated.
+func (t GGMLType) Valid() bool {
+	tt, ok := t.Trait()
+	return ok && tt.BlockSize != 0 && tt.TypeSize != 0
+}
+
 // RowSizeOf returns the size of the given dimensions according to the GGMLType's GGMLTypeTrait,
 // which is inspired by
 // https://github.com/ggerganov/ggml/blob/0cbb7c0e053f5419cfbebb46fbf4d4ed60182cf5/src/ggml.c#L3142-L3145.
@@ -114,22 +246,35 @@ func (t GGMLType) Trait() (GGMLTypeTrait, bool) {
 // i.e. 0 is the first dimension, 1 is the second dimension, and so on.
 //
 // The value of the item is the number of elements in the corresponding dimension.
+//
+// RowSizeOf panics if the dimensions are empty or the GGMLType is invalid,
+// use TryRowSizeOf if the GGMLType is not trusted, e.g. read from a file.
 func (t GGMLType) RowSizeOf(dimensions []uint64) uint64 {
+	ds, err := t.TryRowSizeOf(dimensions)
+	if err != nil {
+		panic(err)
+	}
+	return ds
+}
+
+// TryRowSizeOf is the same as RowSizeOf,
+// but returns an error instead of panicking if the dimensions are empty or the GGMLType is invalid.
+func (t GGMLType) TryRowSizeOf(dimensions []uint64) (uint64, error) {
 	if len(dimensions) == 0 {
-		panic(errors.New("no dimensions"))
+		return 0, errors.New("no dimensions")
 	}
 
-	tt, ok := t.Trait()
-	if !ok {
-		panic(fmt.Errorf("invalid type: %v", t))
+	if !t.Valid() {
+		return 0, fmt.Errorf("invalid type: %v", t)
 	}
+	tt, _ := t.Trait()
 
 	// https://github.com/ggerganov/ggml/blob/a10a8b880c059b3b29356eb9a9f8df72f03cdb6a/src/ggml.c#L2640-L2643
 	ds := tt.TypeSize * dimensions[0] / tt.BlockSize // Row size
 	for i := 1; i < len(dimensions); i++ {
 		ds *= dimensions[i]
 	}
-	return ds
+	return ds, nil
 }
 
 // GGMLMemoryPadding returns the padded size of the given size according to GGML memory padding,
@@ -0,0 +1,61 @@
+package gguf_parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGGMLType_RowSizeOf(t *testing.T) {
+	cases := []struct {
+		given    GGMLType
+		expected uint64
+	}{
+		{GGMLTypeF32, 4096 * 4},
+		{GGMLTypeQ4_0, 4096 / 32 * 18},
+		{GGMLTypeQ4_K, 4096 / 256 * 144},
+		{GGMLTypeTQ1_0, 4096 / 256 * 54},
+		{GGMLTypeTQ2_0, 4096 / 256 * 66},
+	}
+	for _, tc := range cases {
+		t.Run(tc.given.String(), func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.given.RowSizeOf([]uint64{4096}))
+		})
+	}
+
+	_, err := GGMLTypeF32.TryRowSizeOf(nil)
+	assert.Error(t, err, "empty dimensions should be rejected")
+	_, err = GGMLTypeQ4_2.TryRowSizeOf([]uint64{4096})
+	assert.Error(t, err, "deprecated type should be rejected")
+	_, err = GGMLType(1024).TryRowSizeOf([]uint64{4096})
+	assert.Error(t, err, "unknown type should be rejected")
+}
+
+func TestRegisterGGMLType(t *testing.T) {
+	const typ = GGMLType(1024)
+	t.Cleanup(func() { unregisterGGMLType(typ) })
+
+	assert.Equal(t, "GGMLType(1024)", typ.String())
+	assert.False(t, typ.Valid())
+
+	assert.Error(t, RegisterGGMLType(GGMLTypeQ4_K, "Q4_K", GGMLTypeTrait{BlockSize: 256, TypeSize: 144}),
+		"built-in type should not be overridden")
+	assert.Error(t, RegisterGGMLType(_GGMLTypeCount, "IQ4_KS", GGMLTypeTrait{BlockSize: 256, TypeSize: 136}),
+		"reserved type should be rejected")
+	assert.Error(t, RegisterGGMLType(typ, "IQ4_KS", GGMLTypeTrait{}),
+		"zero trait should be rejected")
+	assert.Error(t, RegisterGGMLType(typ, "Q4_K", GGMLTypeTrait{BlockSize: 256, TypeSize: 136}),
+		"existing name should be rejected")
+
+	assert.NoError(t, RegisterGGMLType(typ, "IQ4_KS", GGMLTypeTrait{BlockSize: 256, TypeSize: 136, Quantized: true}))
+	assert.Error(t, RegisterGGMLType(typ, "IQ4_KS", GGMLTypeTrait{BlockSize: 256, TypeSize: 136, Quantized: true}),
+		"registered type should not be registered again")
+
+	assert.Equal(t, "IQ4_KS", typ.String())
+	assert.True(t, typ.Valid())
+	assert.Equal(t, uint64(4096/256*136), typ.RowSizeOf([]uint64{4096}))
+
+	unregisterGGMLType(typ)
+	assert.False(t, typ.Valid())
+	assert.Equal(t, "GGMLType(1024)", typ.String())
+}
@@ -0,0 +1,490 @@
+package gguf_parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GGUFTokenType is the type of token in the GGUF vocabulary,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/include/llama.h#L87-L95.
+type GGUFTokenType int32
+
+// GGUFTokenType constants.
+const (
+	GGUFTokenTypeUndefined GGUFTokenType = iota
+	GGUFTokenTypeNormal
+	GGUFTokenTypeUnknown
+	GGUFTokenTypeControl
+	GGUFTokenTypeUserDefined
+	GGUFTokenTypeUnused
+	GGUFTokenTypeByte
+)
+
+// GGUFTokenizerVocabularyKeys are the keys of the large GGUFMetadataKV items that NewTokenizer requires,
+// which can be kept by KeepLargeMetadata when parsing with SkipLargeMetadata.
+var GGUFTokenizerVocabularyKeys = []string{
+	"tokenizer.ggml.tokens",
+	"tokenizer.ggml.scores",
+	"tokenizer.ggml.token_type",
+	"tokenizer.ggml.merges",
+}
+
+// GGUFTokenizer tokenizes text into tokens and detokenizes tokens into text,
+// with the vocabulary of a GGUF file,
+// which mirrors the tokenizers of llama.cpp,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama-vocab.cpp.
+//
+// Supports the SPM(llama), BPE(gpt2) and WPM(bert) tokenizer models.
+type GGUFTokenizer struct {
+	model    string
+	tokens   []string
+	scores   []float32
+	types    []GGUFTokenType
+	tokenIDs map[string]int32
+
+	// Special tokens.
+	bos, eos, unk, sep int32
+	specials           []int32 // sorted by the text length in descending order
+
+	// Behaviors.
+	addBOS, addEOS bool
+	addSpacePrefix bool
+	cleanSpaces    bool
+	ignoreMerges   bool
+
+	// BPE.
+	mergeRanks map[string]int // "left right" -> rank
+	regexes    []_GGUFTokenizerRegex
+
+	// WPM.
+	maxTokenLen int
+}
+
+// NewTokenizer returns the GGUFTokenizer built from the vocabulary of the GGUF file,
+// the GGUF file must be parsed without SkipLargeMetadata,
+// or keeps GGUFTokenizerVocabularyKeys by KeepLargeMetadata.
+func (gf *GGUFFile) NewTokenizer() (*GGUFTokenizer, error) {
+	const (
+		modelKey          = "tokenizer.ggml.model"
+		preKey            = "tokenizer.ggml.pre"
+		tokensKey         = "tokenizer.ggml.tokens"
+		scoresKey         = "tokenizer.ggml.scores"
+		tokenTypeKey      = "tokenizer.ggml.token_type"
+		mergesKey         = "tokenizer.ggml.merges"
+		bosTokenIDKey     = "tokenizer.ggml.bos_token_id"
+		eosTokenIDKey     = "tokenizer.ggml.eos_token_id"
+		unknownTokenIDKey = "tokenizer.ggml.unknown_token_id"
+		sepTokenIDKey     = "tokenizer.ggml.seperator_token_id" // the typo is kept as llama.cpp
+		clsTokenIDKey     = "tokenizer.ggml.cls_token_id"
+		addBOSKey         = "tokenizer.ggml.add_bos_token"
+		addEOSKey         = "tokenizer.ggml.add_eos_token"
+		addSpacePrefixKey = "tokenizer.ggml.add_space_prefix"
+	)
+
+	m, _ := gf.Header.MetadataKV.Index([]string{
+		modelKey,
+		preKey,
+		tokensKey,
+		scoresKey,
+		tokenTypeKey,
+		mergesKey,
+		bosTokenIDKey,
+		eosTokenIDKey,
+		unknownTokenIDKey,
+		sepTokenIDKey,
+		clsTokenIDKey,
+		addBOSKey,
+		addEOSKey,
+		addSpacePrefixKey,
+	})
+
+	array := func(key string) (GGUFMetadataKVArrayValue, bool, error) {
+		v, ok := m[key]
+		if !ok {
+			return GGUFMetadataKVArrayValue{}, false, nil
+		}
+		if v.ValueType != GGUFMetadataValueTypeArray {
+			return GGUFMetadataKVArrayValue{}, false, fmt.Errorf("%s: not an array", key)
+		}
+		av := v.ValueArray()
+		if uint64(len(av.Array)) != av.Len {
+			return GGUFMetadataKVArrayValue{}, false, fmt.Errorf("%s: not loaded, parse without SkipLargeMetadata", key)
+		}
+		return av, true, nil
+	}
+
+	t := &GGUFTokenizer{
+		bos: -1,
+		eos: -1,
+		unk: -1,
+		sep: -1,
+	}
+
+	if v, ok := m[modelKey]; ok {
+		t.model = v.ValueString()
+	}
+	var pre string
+	if v, ok := m[preKey]; ok {
+		pre = v.ValueString()
+	}
+
+	// Tokens.
+	{
+		av, ok, err := array(tokensKey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || av.Len == 0 {
+			return nil, errors.New("no tokens")
+		}
+		t.tokens = av.ValuesString()
+		t.tokenIDs = make(map[string]int32, len(t.tokens))
+		for i := range t.tokens {
+			t.tokenIDs[t.tokens[i]] = int32(i)
+			t.maxTokenLen = max(t.maxTokenLen, len(t.tokens[i]))
+		}
+
+		t.scores = make([]float32, len(t.tokens))
+		if av, ok, err = array(scoresKey); err != nil {
+			return nil, err
+		} else if ok && av.Len == uint64(len(t.tokens)) {
+			t.scores = ValuesNumeric[float32](av)
+		}
+
+		t.types = make([]GGUFTokenType, len(t.tokens))
+		if av, ok, err = array(tokenTypeKey); err != nil {
+			return nil, err
+		} else if ok && av.Len == uint64(len(t.tokens)) {
+			for i, v := range ValuesNumeric[int32](av) {
+				t.types[i] = GGUFTokenType(v)
+			}
+		} else {
+			for i := range t.types {
+				t.types[i] = GGUFTokenTypeNormal
+			}
+		}
+	}
+
+	// Model specific defaults.
+	switch t.model {
+	case "llama":
+		t.bos, t.eos, t.unk = 1, 2, 0
+		t.addSpacePrefix = true
+		t.addBOS = true
+	case "gpt2":
+		t.bos, t.eos = 11, 11
+		t.cleanSpaces = true
+
+		av, ok, err := array(mergesKey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errors.New("no merges")
+		}
+		t.mergeRanks = make(map[string]int, av.Len)
+		for i, v := range av.ValuesString() {
+			t.mergeRanks[v] = i
+		}
+
+		if err = t.initPreTokenizer(pre); err != nil {
+			return nil, err
+		}
+	case "bert":
+		t.bos, t.unk, t.sep = 101, 100, 102
+		t.cleanSpaces = true
+		t.addBOS = true
+	default:
+		return nil, fmt.Errorf("unsupported tokenizer model %q", t.model)
+	}
+
+	// Overrides.
+	if v, ok := m[bosTokenIDKey]; ok {
+		t.bos = ValueNumeric[int32](v)
+	}
+	if v, ok := m[eosTokenIDKey]; ok {
+		t.eos = ValueNumeric[int32](v)
+	}
+	if v, ok := m[unknownTokenIDKey]; ok {
+		t.unk = ValueNumeric[int32](v)
+	}
+	if v, ok := m[sepTokenIDKey]; ok {
+		t.sep = ValueNumeric[int32](v)
+	}
+	if v, ok := m[clsTokenIDKey]; ok && t.model == "bert" {
+		t.bos = ValueNumeric[int32](v)
+	}
+	if v, ok := m[addBOSKey]; ok {
+		t.addBOS = v.ValueBool()
+	}
+	if v, ok := m[addEOSKey]; ok {
+		t.addEOS = v.ValueBool()
+	}
+	if v, ok := m[addSpacePrefixKey]; ok {
+		t.addSpacePrefix = v.ValueBool()
+	}
+
+	// Special tokens.
+	for i := range t.types {
+		switch t.types[i] {
+		case GGUFTokenTypeControl, GGUFTokenTypeUserDefined, GGUFTokenTypeUnknown:
+			t.specials = append(t.specials, int32(i))
+		}
+	}
+	sort.SliceStable(t.specials, func(i, j int) bool {
+		return len(t.tokens[t.specials[i]]) > len(t.tokens[t.specials[j]])
+	})
+
+	return t, nil
+}
+
+// Tokenize tokenizes the given text into tokens,
+// which is similar to llama.cpp `llama_tokenize`.
+//
+// If addSpecial is true, the BOS/EOS tokens are added as the model requires,
+// the tokens that the vocabulary does not define, i.e. the ID is negative, are never added.
+// If parseSpecial is true, the control tokens in the text are parsed as tokens,
+// otherwise, only the user-defined tokens are parsed.
+func (t *GGUFTokenizer) Tokenize(text string, addSpecial, parseSpecial bool) []int32 {
+	frags := t.partition(text, parseSpecial)
+
+	var ret []int32
+	switch t.model {
+	case "llama":
+		isPrevSpecial := true // prefix with space if the first token
+		if addSpecial && t.addBOS && t.bos >= 0 {
+			ret = append(ret, t.bos)
+		}
+		for _, f := range frags {
+			if f.token >= 0 {
+				ret = append(ret, f.token)
+				isPrevSpecial = true
+				continue
+			}
+			s := f.text
+			if t.addSpacePrefix && isPrevSpecial {
+				s = " " + s
+			}
+			ret = t.tokenizeSPM(strings.ReplaceAll(s, " ", "▁"), ret)
+			isPrevSpecial = false
+		}
+		if addSpecial && t.addEOS && t.eos >= 0 {
+			ret = append(ret, t.eos)
+		}
+	case "gpt2":
+		if addSpecial && t.addBOS && t.bos >= 0 {
+			ret = append(ret, t.bos)
+		}
+		for _, f := range frags {
+			if f.token >= 0 {
+				ret = append(ret, f.token)
+				continue
+			}
+			ret = t.tokenizeBPE(f.text, ret)
+		}
+		if addSpecial && t.addEOS && t.eos >= 0 {
+			ret = append(ret, t.eos)
+		}
+	case "bert":
+		if addSpecial && t.bos >= 0 {
+			ret = append(ret, t.bos)
+		}
+		for _, f := range frags {
+			if f.token >= 0 {
+				ret = append(ret, f.token)
+				continue
+			}
+			ret = t.tokenizeWPM(f.text, ret)
+		}
+		if addSpecial && t.sep >= 0 {
+			ret = append(ret, t.sep)
+		}
+	}
+	return ret
+}
+
+// Detokenize detokenizes the given tokens into text,
+// which is similar to llama.cpp `llama_detokenize`.
+//
+// If removeSpecial is true, the leading BOS token and the trailing EOS token are removed as the model adds.
+// If unparseSpecial is true, the control tokens are rendered as text,
+// otherwise, they are omitted.
+func (t *GGUFTokenizer) Detokenize(tokens []int32, removeSpecial, unparseSpecial bool) string {
+	removeSpace := t.addSpacePrefix
+	if removeSpecial && t.addBOS && len(tokens) > 0 && tokens[0] == t.bos {
+		removeSpace = false
+		tokens = tokens[1:]
+	}
+	if removeSpecial && t.addEOS && len(tokens) > 0 && tokens[len(tokens)-1] == t.eos {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	var sb strings.Builder
+	for _, id := range tokens {
+		p := t.piece(id, unparseSpecial)
+		if removeSpace {
+			p = strings.TrimPrefix(p, " ")
+		}
+		removeSpace = false
+		sb.WriteString(p)
+	}
+	if !t.cleanSpaces {
+		return sb.String()
+	}
+	return cleanTokenizerSpaces(sb.String())
+}
+
+// Piece returns the text piece of the given token,
+// which is similar to llama.cpp `llama_token_to_piece`.
+//
+// If special is true, the control tokens are rendered as text,
+// otherwise, they are omitted.
+func (t *GGUFTokenizer) Piece(token int32, special bool) string {
+	return t.piece(token, special)
+}
+
+func (t *GGUFTokenizer) piece(id int32, special bool) string {
+	if id < 0 || int(id) >= len(t.tokens) {
+		return ""
+	}
+
+	s, typ := t.tokens[id], t.types[id]
+	switch typ {
+	case GGUFTokenTypeControl:
+		if special {
+			return s
+		}
+		return ""
+	case GGUFTokenTypeUnknown, GGUFTokenTypeUserDefined:
+		return s
+	case GGUFTokenTypeNormal:
+		if t.model == "gpt2" {
+			return decodeBPEText(s)
+		}
+		return strings.ReplaceAll(s, "▁", " ")
+	case GGUFTokenTypeByte:
+		if t.model != "gpt2" && len(s) == 6 {
+			if b, err := strconv.ParseUint(s[3:5], 16, 8); err == nil {
+				return string([]byte{byte(b)})
+			}
+		}
+	}
+	return ""
+}
+
+// TokensLength returns the size of the vocabulary.
+func (t *GGUFTokenizer) TokensLength() int {
+	return len(t.tokens)
+}
+
+type _GGUFTokenizerFragment struct {
+	token int32 // -1 if the fragment is raw text
+	text  string
+}
+
+// partition splits the text into fragments by the special tokens,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama-vocab.cpp#L1380-L1510.
+func (t *GGUFTokenizer) partition(text string, parseSpecial bool) []_GGUFTokenizerFragment {
+	if text == "" {
+		return nil
+	}
+	frags := []_GGUFTokenizerFragment{{token: -1, text: text}}
+
+	for _, id := range t.specials {
+		if !parseSpecial && t.types[id] != GGUFTokenTypeUserDefined {
+			continue
+		}
+		st := t.tokens[id]
+		if st == "" {
+			continue
+		}
+
+		var nfrags []_GGUFTokenizerFragment
+		for _, f := range frags {
+			if f.token >= 0 || !strings.Contains(f.text, st) {
+				nfrags = append(nfrags, f)
+				continue
+			}
+			s := f.text
+			for {
+				i := strings.Index(s, st)
+				if i < 0 {
+					break
+				}
+				if i > 0 {
+					nfrags = append(nfrags, _GGUFTokenizerFragment{token: -1, text: s[:i]})
+				}
+				nfrags = append(nfrags, _GGUFTokenizerFragment{token: id})
+				s = s[i+len(st):]
+			}
+			if s != "" {
+				nfrags = append(nfrags, _GGUFTokenizerFragment{token: -1, text: s})
+			}
+		}
+		frags = nfrags
+	}
+	return frags
+}
+
+// cleanTokenizerSpaces cleans up the spaces before punctuations and contractions,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama-vocab.cpp#L1868-L1930.
+func cleanTokenizerSpaces(s string) string {
+	if s == "" {
+		return s
+	}
+
+	// " ?", " !", " .", " ,".
+	bs := []byte(s)
+	r := bs[:1]
+	for i := 1; i < len(bs); i++ {
+		x := bs[i]
+		if bs[i-1] == ' ' && (x == '?' || x == '!' || x == '.' || x == ',') {
+			r = r[:len(r)-1]
+		}
+		r = append(r, x)
+	}
+
+	// " ' ".
+	bs = append([]byte(nil), r...)
+	r = bs[:1]
+	for i := 1; i < len(bs); i++ {
+		x := bs[i]
+		if x == '\'' && i+1 < len(bs) && bs[i-1] == ' ' && bs[i+1] == ' ' {
+			r = r[:len(r)-1]
+			i++
+		}
+		r = append(r, x)
+	}
+
+	// " 's", " 'm", " 're", " 've".
+	bs = append([]byte(nil), r...)
+	r = bs[:1]
+	for i := 1; i < len(bs); i++ {
+		x := bs[i]
+		if bs[i-1] == ' ' && x == '\'' && i+1 < len(bs) {
+			switch x1 := bs[i+1]; {
+			case x1 == 's' || x1 == 'm':
+				r = r[:len(r)-1]
+			case i+2 < len(bs) && (x1 == 'r' || x1 == 'v') && bs[i+2] == 'e':
+				r = r[:len(r)-1]
+			}
+		}
+		r = append(r, x)
+	}
+	return string(r)
+}
+
+// _GGUFTokenizerRegex is a pre-tokenizer regex of BPE,
+// which emulates the `\s+(?!\S)` alternative,
+// as Go regexp does not support lookahead.
+type _GGUFTokenizerRegex struct {
+	*regexp.Regexp
+
+	// lookahead is the index of the submatch that emulates `\s+(?!\S)`,
+	// or -1 if the regex does not contain it.
+	lookahead int
+}
@@ -0,0 +1,406 @@
+package gguf_parser
+
+import (
+	"container/heap"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// _GGUFTokenizerPreRegexes holds the pre-tokenizer regexes of BPE,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama-vocab.cpp#L386-L520.
+var _GGUFTokenizerPreRegexes = func() map[string][]string {
+	const (
+		gpt2   = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)`
+		llama3 = `(?:'[sS]|'[tT]|'[rR][eE]|'[vV][eE]|'[mM]|'[lL][lL]|'[dD])|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+		qwen2  = `(?:'[sS]|'[tT]|'[rR][eE]|'[vV][eE]|'[mM]|'[lL][lL]|'[dD])|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+		poro   = ` ?[^(\s|.,!?…。，、।۔،)]+`
+		cjk    = `[一-龥ࠀ-一가-퟿]+`
+	)
+	return map[string][]string{
+		"default": {
+			`[\p{P}\$\+<=>\^~\|]+`,
+			gpt2,
+			`\p{N}+`,
+			`[0-9][0-9][0-9]`,
+		},
+		"llama3": {
+			llama3,
+		},
+		"deepseek-llm": {
+			`[\r\n]`,
+			`\s?[A-Za-zµÀ-ÖØ-öø-ƺƼ-ƿǄ-ʓʕ-ʯͰ-ͳͶͷͻ-ͽͿΆΈ-ΊΌΎ-ΡΣ-ϵϷ-ҁҊ-ԯԱ-ՖႠ-ჅᎠ-Ᏽᏸ-ᏽᲐ-ᲺᲽ-Ჿᴀ-ᴫᵫ-ᵷᵹ-ᶚḀ-ἕἘ-Ἕἠ-ὅὈ-Ὅὐ-ὗὙὛὝὟ-ώᾀ-ᾴᾶ-ᾼιῂ-ῄῆ-ῌῐ-ΐῖ-Ίῠ-Ῥῲ-ῴῶ-ῼℂℇℊ-ℓℕℙ-ℝℤΩℨK-ℭℯ-ℴℹℼ-ℿⅅ-ⅉⅎↃↄⰀ-ⱻⱾ-ⳤⳫ-ⳮⳲⳳꙀ-ꙭꚀ-ꚛꜢ-ꝯꝱ-ꞇꞋ-ꞎꭰ-ꮿﬀ-ﬆﬓ-ﬗＡ-Ｚａ-ｚ𐐀-𐑏𐒰-𐓓𐓘-𐓻𐲀-𐲲𐳀-𐳲𑢠-𑣟𞤀-𞥃]+`,
+			`\s?[!-/:-~！-／：-～‘-‟　-。]+`,
+			`\s+$`,
+			cjk,
+			`\p{N}+`,
+		},
+		"deepseek-coder": {
+			`[\r\n]`,
+			`\s?\p{L}+`,
+			`\s?\p{P}+`,
+			cjk,
+			`\p{N}`,
+		},
+		"falcon": {
+			`[\p{P}\$\+<=>\^~\|` + "`" + `]+`,
+			gpt2,
+			`[0-9][0-9][0-9]`,
+		},
+		"starcoder": {
+			`\p{N}`,
+			gpt2,
+		},
+		"gpt2": {
+			gpt2,
+		},
+		"qwen2": {
+			qwen2,
+		},
+		"poro": {
+			poro,
+		},
+		"viking": {
+			poro,
+			`\p{N}`,
+		},
+		"tekken": {
+			`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`,
+		},
+	}
+}()
+
+// initPreTokenizer initializes the pre-tokenizer of BPE with the given "tokenizer.ggml.pre",
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama.cpp#L5700-L5800.
+func (t *GGUFTokenizer) initPreTokenizer(pre string) error {
+	var rs string
+	switch pre {
+	case "", "default":
+		rs = "default"
+	case "llama3", "llama-v3", "llama-bpe":
+		rs = "llama3"
+		t.ignoreMerges = true
+		t.addBOS = true
+	case "dbrx", "smaug-bpe":
+		rs = "llama3"
+	case "deepseek-llm", "deepseek-coder":
+		rs = pre
+		t.cleanSpaces = false
+	case "falcon":
+		rs = "falcon"
+	case "starcoder", "refact", "command-r", "smollm", "codeshell", "exaone":
+		rs = "starcoder"
+		switch pre {
+		case "refact", "command-r", "smollm", "starcoder":
+			t.cleanSpaces = false
+		}
+	case "gpt-2", "phi-2", "jina-es", "jina-de", "jina-v1-en", "jina-v2-es", "jina-v2-de", "jina-v2-code",
+		"mpt", "olmo", "jais":
+		rs = "gpt2"
+	case "qwen2", "stablelm2":
+		rs = "qwen2"
+		if pre == "qwen2" {
+			t.cleanSpaces = false
+		}
+	case "chatglm-bpe":
+		rs = "llama3"
+		t.bos = -1
+	case "poro-chat", "bloom", "gpt3-finnish":
+		rs = "poro"
+		if pre == "poro-chat" {
+			t.cleanSpaces = false
+		}
+	case "viking":
+		rs = "viking"
+		t.cleanSpaces = false
+	case "tekken":
+		rs = "tekken"
+		t.cleanSpaces = false
+		t.ignoreMerges = true
+		t.addBOS = true
+	default:
+		return fmt.Errorf("unsupported pre-tokenizer %q", pre)
+	}
+
+	for _, r := range _GGUFTokenizerPreRegexes[rs] {
+		re, err := compileGGUFTokenizerRegex(r)
+		if err != nil {
+			return fmt.Errorf("compile pre-tokenizer regex %q: %w", r, err)
+		}
+		t.regexes = append(t.regexes, re)
+	}
+	return nil
+}
+
+// compileGGUFTokenizerRegex compiles the given pre-tokenizer regex,
+// `\s` is extended to match Unicode whitespaces,
+// and `\s+(?!\S)` is emulated by a capturing group, see _GGUFTokenizerRegex.
+func compileGGUFTokenizerRegex(expr string) (_GGUFTokenizerRegex, error) {
+	const lookahead = `\s+(?!\S)`
+
+	r := _GGUFTokenizerRegex{lookahead: -1}
+	if strings.Contains(expr, lookahead) {
+		expr = strings.Replace(expr, lookahead, `(\s+)`, 1)
+		r.lookahead = 1
+	}
+
+	// Extend `\s`, both inside and outside the character classes.
+	var sb strings.Builder
+	inClass := false
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\' && i+1 < len(expr):
+			if expr[i+1] == 's' {
+				if inClass {
+					sb.WriteString(`\s\v\p{Z}\x{85}`)
+				} else {
+					sb.WriteString(`[\s\v\p{Z}\x{85}]`)
+				}
+			} else {
+				sb.WriteString(expr[i : i+2])
+			}
+			i++
+		case c == '[':
+			inClass = true
+			sb.WriteByte(c)
+		case c == ']':
+			inClass = false
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return r, err
+	}
+	r.Regexp = re
+	return r, nil
+}
+
+// split splits the given text into words with the pre-tokenizer regexes,
+// the unmatched pieces are kept as words as well,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/unicode.cpp#L790-L830.
+func (t *GGUFTokenizer) split(text string) []string {
+	words := []string{text}
+	for _, re := range t.regexes {
+		var nwords []string
+		for _, w := range words {
+			nwords = re.split(w, nwords)
+		}
+		words = nwords
+	}
+	return words
+}
+
+func (r _GGUFTokenizerRegex) split(text string, output []string) []string {
+	for start := 0; start < len(text); {
+		loc := r.FindStringSubmatchIndex(text[start:])
+		if loc == nil {
+			output = append(output, text[start:])
+			break
+		}
+		b, e := start+loc[0], start+loc[1]
+
+		// Emulate `\s+(?!\S)`:
+		// if the whitespaces are followed by a non-whitespace,
+		// leave the last whitespace to the next word.
+		if r.lookahead >= 0 && loc[2*r.lookahead] >= 0 && e < len(text) {
+			if c, _ := utf8.DecodeRuneInString(text[e:]); !isTokenizerSpace(c) {
+				_, n := utf8.DecodeLastRuneInString(text[b:e])
+				if e-n > b {
+					e -= n
+				}
+			}
+		}
+
+		if b > start {
+			output = append(output, text[start:b])
+		}
+		if e == b {
+			// Empty match, move forward one character.
+			_, n := utf8.DecodeRuneInString(text[b:])
+			e = b + n
+		}
+		output = append(output, text[b:e])
+		start = e
+	}
+	return output
+}
+
+// tokenizeBPE tokenizes the given text with the BPE tokenizer,
+// and appends the tokens to the given output,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama-vocab.cpp#L530-L640.
+func (t *GGUFTokenizer) tokenizeBPE(text string, output []int32) []int32 {
+	for _, w := range t.split(text) {
+		w = encodeBPEText(w)
+		if t.ignoreMerges {
+			if id, ok := t.tokenIDs[w]; ok {
+				output = append(output, id)
+				continue
+			}
+		}
+
+		// Split the word into characters.
+		var syms []_SPMSymbol
+		for offs := 0; offs < len(w); {
+			_, n := utf8.DecodeRuneInString(w[offs:])
+			syms = append(syms, _SPMSymbol{
+				prev: len(syms) - 1,
+				next: len(syms) + 1,
+				text: w[offs : offs+n],
+			})
+			offs += n
+		}
+		if len(syms) == 0 {
+			continue
+		}
+		syms[len(syms)-1].next = -1
+
+		var q _BPEBigramQueue
+		tryAddBigram := func(left, right int) {
+			if left == -1 || right == -1 {
+				return
+			}
+			lt, rt := syms[left].text, syms[right].text
+			rank, ok := t.mergeRanks[lt+" "+rt]
+			if !ok {
+				return
+			}
+			heap.Push(&q, _BPEBigram{
+				left:  left,
+				right: right,
+				text:  lt + rt,
+				rank:  rank,
+			})
+		}
+
+		for i := 1; i < len(syms); i++ {
+			tryAddBigram(i-1, i)
+		}
+
+		for q.Len() != 0 {
+			bg := heap.Pop(&q).(_BPEBigram)
+			left, right := &syms[bg.left], &syms[bg.right]
+
+			// If one of the symbols already got merged, skip it.
+			if left.text == "" || right.text == "" || left.text+right.text != bg.text {
+				continue
+			}
+
+			left.text += right.text
+			right.text = ""
+
+			left.next = right.next
+			if right.next >= 0 {
+				syms[right.next].prev = bg.left
+			}
+
+			tryAddBigram(left.prev, bg.left)
+			tryAddBigram(bg.left, left.next)
+		}
+
+		for i := 0; i != -1; i = syms[i].next {
+			s := syms[i].text
+			if id, ok := t.tokenIDs[s]; ok {
+				output = append(output, id)
+				continue
+			}
+			for j := 0; j < len(s); j++ {
+				if id, ok := t.tokenIDs[string(s[j])]; ok {
+					output = append(output, id)
+				}
+			}
+		}
+	}
+	return output
+}
+
+type (
+	// _BPEBigram is a candidate to merge of the BPE tokenizer.
+	_BPEBigram struct {
+		left, right int
+		text        string
+		rank        int
+	}
+
+	// _BPEBigramQueue is a priority queue of _BPEBigram,
+	// the bigram with lower rank, or lower left index if the ranks are equal, pops first.
+	_BPEBigramQueue []_BPEBigram
+)
+
+func (q _BPEBigramQueue) Len() int { return len(q) }
+
+func (q _BPEBigramQueue) Less(i, j int) bool {
+	return q[i].rank < q[j].rank || (q[i].rank == q[j].rank && q[i].left < q[j].left)
+}
+
+func (q _BPEBigramQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *_BPEBigramQueue) Push(x any) { *q = append(*q, x.(_BPEBigram)) }
+
+func (q *_BPEBigramQueue) Pop() any {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[:n-1]
+	return x
+}
+
+// _BPEByteRunes maps the bytes to the printable runes of the byte-level BPE,
+// and _BPERuneBytes is the reverse,
+// see https://github.com/openai/gpt-2/blob/9b63575ef42771a015060c964af2c3da4cf7c8ab/src/encoder.py#L9-L28.
+var _BPEByteRunes, _BPERuneBytes = func() ([256]rune, map[rune]byte) {
+	var (
+		brs [256]rune
+		rbs = make(map[rune]byte, 256)
+	)
+	n := 0
+	for b := 0; b < 256; b++ {
+		r := rune(b)
+		if !(('!' <= b && b <= '~') || (0xA1 <= b && b <= 0xAC) || (0xAE <= b && b <= 0xFF)) {
+			r = rune(256 + n)
+			n++
+		}
+		brs[b] = r
+		rbs[r] = byte(b)
+	}
+	return brs, rbs
+}()
+
+// encodeBPEText encodes the bytes of the given text into the printable runes of the byte-level BPE.
+func encodeBPEText(s string) string {
+	var sb strings.Builder
+	sb.Grow(len(s) * 2)
+	for i := 0; i < len(s); i++ {
+		sb.WriteRune(_BPEByteRunes[s[i]])
+	}
+	return sb.String()
+}
+
+// decodeBPEText decodes the printable runes of the byte-level BPE into the bytes,
+// the runes out of the byte-level BPE are kept as is.
+func decodeBPEText(s string) string {
+	bs := make([]byte, 0, len(s))
+	for _, r := range s {
+		if b, ok := _BPERuneBytes[r]; ok {
+			bs = append(bs, b)
+		} else {
+			bs = utf8.AppendRune(bs, r)
+		}
+	}
+	return string(bs)
+}
+
+// isTokenizerSpace reports whether the rune is a whitespace,
+// which is consistent with the extended `\s`.
+func isTokenizerSpace(r rune) bool {
+	switch r {
+	case '\t', '\n', '\v', '\f', '\r', ' ', 0x85:
+		return true
+	}
+	return r > 0x7F && unicode.Is(unicode.Z, r)
+}
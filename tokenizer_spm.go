@@ -0,0 +1,151 @@
+package gguf_parser
+
+import (
+	"container/heap"
+	"fmt"
+	"unicode/utf8"
+)
+
+type (
+	// _SPMSymbol is a symbol of the SPM tokenizer,
+	// which is a piece of the text linked to the previous and next symbols.
+	_SPMSymbol struct {
+		prev, next int
+		text       string
+	}
+
+	// _SPMBigram is a candidate to merge of the SPM tokenizer.
+	_SPMBigram struct {
+		left, right int
+		score       float32
+		size        int
+	}
+
+	// _SPMBigramQueue is a priority queue of _SPMBigram,
+	// the bigram with higher score, or lower left index if the scores are equal, pops first.
+	_SPMBigramQueue []_SPMBigram
+)
+
+func (q _SPMBigramQueue) Len() int { return len(q) }
+
+func (q _SPMBigramQueue) Less(i, j int) bool {
+	return q[i].score > q[j].score || (q[i].score == q[j].score && q[i].left < q[j].left)
+}
+
+func (q _SPMBigramQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *_SPMBigramQueue) Push(x any) { *q = append(*q, x.(_SPMBigram)) }
+
+func (q *_SPMBigramQueue) Pop() any {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[:n-1]
+	return x
+}
+
+// tokenizeSPM tokenizes the given (whitespace escaped) text with the SPM tokenizer,
+// and appends the tokens to the given output,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama-vocab.cpp#L222-L340.
+func (t *GGUFTokenizer) tokenizeSPM(text string, output []int32) []int32 {
+	// Split the text into characters.
+	var syms []_SPMSymbol
+	for offs := 0; offs < len(text); {
+		_, n := utf8.DecodeRuneInString(text[offs:])
+		syms = append(syms, _SPMSymbol{
+			prev: len(syms) - 1,
+			next: len(syms) + 1,
+			text: text[offs : offs+n],
+		})
+		offs += n
+	}
+	if len(syms) == 0 {
+		return output
+	}
+	syms[len(syms)-1].next = -1
+
+	var (
+		q        _SPMBigramQueue
+		revMerge = map[string][2]int{}
+	)
+	tryAddBigram := func(left, right int) {
+		if left == -1 || right == -1 {
+			return
+		}
+		s := syms[left].text + syms[right].text
+		id, ok := t.tokenIDs[s]
+		if !ok {
+			return
+		}
+		heap.Push(&q, _SPMBigram{
+			left:  left,
+			right: right,
+			score: t.scores[id],
+			size:  len(s),
+		})
+		revMerge[s] = [2]int{left, right}
+	}
+
+	// Seed the queue with all possible 2-character tokens.
+	for i := 1; i < len(syms); i++ {
+		tryAddBigram(i-1, i)
+	}
+
+	// Keep substituting the highest frequency pairs for as long as we can.
+	for q.Len() != 0 {
+		bg := heap.Pop(&q).(_SPMBigram)
+		left, right := &syms[bg.left], &syms[bg.right]
+
+		// If one of the symbols already got merged, skip it.
+		if left.text == "" || right.text == "" || len(left.text)+len(right.text) != bg.size {
+			continue
+		}
+
+		// Merge the right symbol into the left one.
+		left.text += right.text
+		right.text = ""
+
+		// Remove the right symbol from the chain.
+		left.next = right.next
+		if right.next >= 0 {
+			syms[right.next].prev = bg.left
+		}
+
+		// Find more substitutions.
+		tryAddBigram(left.prev, bg.left)
+		tryAddBigram(bg.left, left.next)
+	}
+
+	var resegment func(s string)
+	resegment = func(s string) {
+		if id, ok := t.tokenIDs[s]; ok {
+			output = append(output, id)
+			return
+		}
+		if p, ok := revMerge[s]; ok {
+			resegment(syms[p[0]].text)
+			resegment(syms[p[1]].text)
+			return
+		}
+		// Output any symbols that did not form tokens as bytes.
+		for i := 0; i < len(s); i++ {
+			output = append(output, t.byteToken(s[i]))
+		}
+	}
+	for i := 0; i != -1; i = syms[i].next {
+		resegment(syms[i].text)
+	}
+	return output
+}
+
+// byteToken returns the token of the given byte,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama-vocab.cpp#L1105-L1130.
+func (t *GGUFTokenizer) byteToken(b byte) int32 {
+	if id, ok := t.tokenIDs[fmt.Sprintf("<0x%02X>", b)]; ok {
+		return id
+	}
+	if id, ok := t.tokenIDs[string([]byte{b})]; ok {
+		return id
+	}
+	return t.unk
+}
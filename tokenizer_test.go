@@ -0,0 +1,210 @@
+package gguf_parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGGUFFile_NewTokenizer(t *testing.T) {
+	type token struct {
+		text  string
+		score float32
+		typ   GGUFTokenType
+	}
+	vocab := func(model, pre string, tokens []token, merges []string, kvs ...GGUFMetadataKV) *GGUFFile {
+		var (
+			ts = make([]any, len(tokens))
+			ss = make([]any, len(tokens))
+			ys = make([]any, len(tokens))
+			ms = make([]any, len(merges))
+		)
+		for i := range tokens {
+			ts[i], ss[i], ys[i] = tokens[i].text, tokens[i].score, int32(tokens[i].typ)
+		}
+		for i := range merges {
+			ms[i] = merges[i]
+		}
+		array := func(key string, typ GGUFMetadataValueType, vs []any) GGUFMetadataKV {
+			return GGUFMetadataKV{
+				Key:       key,
+				ValueType: GGUFMetadataValueTypeArray,
+				Value:     GGUFMetadataKVArrayValue{Type: typ, Len: uint64(len(vs)), Array: vs},
+			}
+		}
+		return &GGUFFile{
+			Header: GGUFHeader{
+				MetadataKV: append(GGUFMetadataKVs{
+					{Key: "tokenizer.ggml.model", ValueType: GGUFMetadataValueTypeString, Value: model},
+					{Key: "tokenizer.ggml.pre", ValueType: GGUFMetadataValueTypeString, Value: pre},
+					array("tokenizer.ggml.tokens", GGUFMetadataValueTypeString, ts),
+					array("tokenizer.ggml.scores", GGUFMetadataValueTypeFloat32, ss),
+					array("tokenizer.ggml.token_type", GGUFMetadataValueTypeInt32, ys),
+					array("tokenizer.ggml.merges", GGUFMetadataValueTypeString, ms),
+				}, kvs...),
+			},
+		}
+	}
+	normal := func(texts ...string) []token {
+		r := make([]token, len(texts))
+		for i := range texts {
+			r[i] = token{text: texts[i], score: -float32(i), typ: GGUFTokenTypeNormal}
+		}
+		return r
+	}
+	ids := func(tk *GGUFTokenizer, texts ...string) []int32 {
+		r := make([]int32, len(texts))
+		for i := range texts {
+			id, ok := tk.tokenIDs[texts[i]]
+			require.True(t, ok, texts[i])
+			r[i] = id
+		}
+		return r
+	}
+
+	t.Run("spm", func(t *testing.T) {
+		tokens := []token{
+			{"<unk>", 0, GGUFTokenTypeUnknown},
+			{"<s>", 0, GGUFTokenTypeControl},
+			{"</s>", 0, GGUFTokenTypeControl},
+			{"<|im|>", 0, GGUFTokenTypeUserDefined},
+			{"<0x21>", 0, GGUFTokenTypeByte},
+			{"llo", -1, GGUFTokenTypeNormal},
+			{"ll", -2, GGUFTokenTypeNormal},
+			{"▁h", -3, GGUFTokenTypeNormal},
+			{"▁he", -4, GGUFTokenTypeNormal},
+			{"▁hello", -5, GGUFTokenTypeNormal},
+		}
+		tokens = append(tokens, normal("▁", "h", "e", "l", "o")...)
+		tk, err := vocab("llama", "", tokens, nil).NewTokenizer()
+		require.NoError(t, err)
+		assert.Equal(t, len(tokens), tk.TokensLength())
+
+		r := tk.Tokenize("hello!", true, false)
+		assert.Equal(t, append([]int32{1}, ids(tk, "▁hello", "<0x21>")...), r)
+		assert.Equal(t, "hello!", tk.Detokenize(r[1:], false, false))
+		assert.Equal(t, "<s> hello!", tk.Detokenize(r, false, true))
+
+		r = tk.Tokenize("hello<|im|>hello</s>", false, false)
+		assert.Equal(t, ids(tk, "▁hello", "<|im|>", "▁hello", "<unk>", "<unk>", "<unk>", "<unk>"), r)
+
+		r = tk.Tokenize("hello</s>", false, true)
+		assert.Equal(t, ids(tk, "▁hello", "</s>"), r)
+		assert.Equal(t, "hello", tk.Detokenize(r, false, false))
+	})
+
+	t.Run("bpe", func(t *testing.T) {
+		tokens := normal(
+			"h", "e", "l", "o", "Ġ", "w", "r", "d", "!",
+			"he", "ll", "llo", "hello", "Ġw", "or", "Ġwor", "ld", "Ġworld")
+		merges := []string{"h e", "l l", "ll o", "he llo", "Ġ w", "o r", "Ġw or", "l d", "Ġwor ld"}
+		tk, err := vocab("gpt2", "gpt-2", tokens, merges).NewTokenizer()
+		require.NoError(t, err)
+
+		r := tk.Tokenize("hello world!", false, false)
+		assert.Equal(t, ids(tk, "hello", "Ġworld", "!"), r)
+		assert.Equal(t, "hello world!", tk.Detokenize(r, false, false))
+
+		r = tk.Tokenize("held", false, false)
+		assert.Equal(t, ids(tk, "he", "ld"), r)
+
+		// The undefined BOS/EOS tokens are not added.
+		tk, err = vocab("gpt2", "gpt-2", tokens, merges,
+			GGUFMetadataKV{Key: "tokenizer.ggml.bos_token_id", ValueType: GGUFMetadataValueTypeInt32, Value: int32(-1)},
+			GGUFMetadataKV{Key: "tokenizer.ggml.eos_token_id", ValueType: GGUFMetadataValueTypeInt32, Value: int32(-1)},
+			GGUFMetadataKV{Key: "tokenizer.ggml.add_bos_token", ValueType: GGUFMetadataValueTypeBool, Value: true},
+			GGUFMetadataKV{Key: "tokenizer.ggml.add_eos_token", ValueType: GGUFMetadataValueTypeBool, Value: true},
+		).NewTokenizer()
+		require.NoError(t, err)
+		assert.Equal(t, ids(tk, "hello", "Ġworld", "!"), tk.Tokenize("hello world!", true, false))
+
+		_, err = vocab("gpt2", "unknown", tokens, merges).NewTokenizer()
+		assert.Error(t, err)
+	})
+
+	t.Run("wpm", func(t *testing.T) {
+		tokens := []token{
+			{"[UNK]", 0, GGUFTokenTypeUnknown},
+			{"[CLS]", 0, GGUFTokenTypeControl},
+			{"[SEP]", 0, GGUFTokenTypeControl},
+		}
+		tokens = append(tokens, normal("▁hello", "▁,", "▁un", "able", "▁world", "▁!", "▁中")...)
+		tk, err := vocab("bert", "", tokens, nil,
+			GGUFMetadataKV{Key: "tokenizer.ggml.unknown_token_id", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(0)},
+			GGUFMetadataKV{Key: "tokenizer.ggml.cls_token_id", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(1)},
+			GGUFMetadataKV{Key: "tokenizer.ggml.seperator_token_id", ValueType: GGUFMetadataValueTypeUint32, Value: uint32(2)},
+		).NewTokenizer()
+		require.NoError(t, err)
+
+		r := tk.Tokenize("Hello, unable\tWORLD! xyz 中", true, false)
+		assert.Equal(t, ids(tk, "[CLS]", "▁hello", "▁,", "▁un", "able", "▁world", "▁!", "[UNK]", "▁中", "[SEP]"), r)
+		assert.Equal(t, " hello, unable world![UNK] 中", tk.Detokenize(r, true, false))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := vocab("unknown", "", normal("a"), nil).NewTokenizer()
+		assert.Error(t, err)
+
+		gf := vocab("llama", "", normal("a"), nil)
+		for i := range gf.Header.MetadataKV {
+			if gf.Header.MetadataKV[i].Key == "tokenizer.ggml.tokens" {
+				av := gf.Header.MetadataKV[i].ValueArray()
+				av.Array = nil
+				gf.Header.MetadataKV[i].Value = av
+			}
+		}
+		_, err = gf.NewTokenizer()
+		assert.ErrorContains(t, err, "SkipLargeMetadata")
+	})
+}
+
+// TestGGUFTokenizer_Vocab verifies the tokenizer with the test vectors of llama.cpp,
+// set TEST_VOCAB_PATH to the directory of the ggml-vocab-*.gguf files and their .inp/.out files,
+// see https://github.com/ggerganov/llama.cpp/tree/278d0e18469aacf505be18ce790a63c7cc31be26/models.
+func TestGGUFTokenizer_Vocab(t *testing.T) {
+	dir := os.Getenv("TEST_VOCAB_PATH")
+	if dir == "" {
+		t.Skip("TEST_VOCAB_PATH is not set")
+	}
+
+	ps, err := filepath.Glob(filepath.Join(dir, "ggml-vocab-*.gguf"))
+	require.NoError(t, err)
+
+	for _, p := range ps {
+		inp, err := os.ReadFile(p + ".inp")
+		if err != nil {
+			continue
+		}
+		out, err := os.ReadFile(p + ".out")
+		if err != nil {
+			continue
+		}
+
+		t.Run(filepath.Base(p), func(t *testing.T) {
+			gf, err := ParseGGUFFile(p)
+			require.NoError(t, err)
+			tk, err := gf.NewTokenizer()
+			if err != nil {
+				t.Skip(err)
+			}
+
+			texts := strings.Split(string(inp), "\n__ggml_vocab_test__\n")
+			expects := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+			for i := 0; i < len(texts) && i < len(expects); i++ {
+				var expect []int32
+				for _, s := range strings.Fields(expects[i]) {
+					id, err := strconv.ParseInt(s, 10, 32)
+					require.NoError(t, err)
+					expect = append(expect, int32(id))
+				}
+				assert.Equal(t, expect, tk.Tokenize(texts[i], false, false), fmt.Sprintf("%q", texts[i]))
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package gguf_parser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenizeWPM tokenizes the given text with the WPM tokenizer,
+// and appends the tokens to the given output,
+// see https://github.com/ggerganov/llama.cpp/blob/278d0e18469aacf505be18ce790a63c7cc31be26/src/llama-vocab.cpp#L646-L760.
+//
+// Different from llama.cpp, the text is not normalized in NFD form.
+func (t *GGUFTokenizer) tokenizeWPM(text string, output []int32) []int32 {
+	for _, w := range preprocessWPMText(text) {
+		// Prepend the word with the "▁" to indicate the beginning of a word.
+		w = "▁" + w
+
+		n, nOutput := len(w), len(output)
+		match := true
+		for i := 0; i < n; i++ {
+			match = false
+			for j := min(n, i+t.maxTokenLen+1); j > i; j-- {
+				if id, ok := t.tokenIDs[w[i:j]]; ok {
+					output = append(output, id)
+					match = true
+					i = j - 1
+					break
+				}
+			}
+			if !match {
+				// Discard all the tokens of the word.
+				output = output[:nOutput]
+				break
+			}
+		}
+		if !match {
+			output = append(output, t.unk)
+		}
+	}
+	return output
+}
+
+// preprocessWPMText splits the given text into lowercase words,
+// the punctuations, the ASCII symbols and the CJK characters are split as single character words,
+// and the control characters are removed.
+func preprocessWPMText(text string) []string {
+	var (
+		words []string
+		sb    strings.Builder
+	)
+	flush := func() {
+		if sb.Len() != 0 {
+			words = append(words, sb.String())
+			sb.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isTokenizerSpace(r):
+			flush()
+		case r == 0 || r == unicode.ReplacementChar || unicode.IsControl(r):
+		case unicode.IsPunct(r) || (r < 0x7F && unicode.IsSymbol(r)) || isCJKCharacter(r):
+			flush()
+			words = append(words, string(unicode.ToLower(r)))
+		default:
+			sb.WriteRune(unicode.ToLower(r))
+		}
+	}
+	flush()
+	return words
+}
+
+// isCJKCharacter reports whether the rune is a CJK character,
+// see https://github.com/google-research/bert/blob/eedf5716ce1268e56f0a50264a88cafad334ac61/tokenization.py#L264-L284.
+func isCJKCharacter(r rune) bool {
+	return (r >= 0x4E00 && r <= 0x9FFF) ||
+		(r >= 0x3400 && r <= 0x4DBF) ||
+		(r >= 0x20000 && r <= 0x2A6DF) ||
+		(r >= 0x2A700 && r <= 0x2B73F) ||
+		(r >= 0x2B740 && r <= 0x2B81F) ||
+		(r >= 0x2B920 && r <= 0x2CEAF) ||
+		(r >= 0xF900 && r <= 0xFAFF) ||
+		(r >= 0x2F800 && r <= 0x2FA1F)
+}
@@ -0,0 +1,1037 @@
+package jinja
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type (
+	filterFunc func(v any, args []any, kwargs *Dict) (any, error)
+	testFunc   func(v any, args []any) (bool, error)
+)
+
+var globals = map[string]any{
+	"raise_exception": Func(func(args []any, _ *Dict) (any, error) {
+		var msg string
+		if len(args) > 0 {
+			msg = toString(args[0])
+		}
+		return nil, &RaiseError{Message: msg}
+	}),
+	"range": Func(func(args []any, _ *Dict) (any, error) {
+		var b [3]int64
+		b[2] = 1
+		switch len(args) {
+		case 1:
+			b[1] = intArg(args, 0, 0)
+		case 2, 3:
+			b[0], b[1], b[2] = intArg(args, 0, 0), intArg(args, 1, 0), intArg(args, 2, 1)
+		default:
+			return nil, errors.New("range expected 1 to 3 arguments")
+		}
+		if b[2] == 0 {
+			return nil, errors.New("range step cannot be zero")
+		}
+		var r []any
+		for i := b[0]; (b[2] > 0 && i < b[1]) || (b[2] < 0 && i > b[1]); i += b[2] {
+			r = append(r, i)
+		}
+		return r, nil
+	}),
+	"namespace": Func(func(args []any, kwargs *Dict) (any, error) {
+		d := NewDict()
+		d.namespace = true
+		for _, a := range args {
+			if ad, ok := a.(*Dict); ok {
+				for _, k := range ad.keys {
+					d.set(k, ad.values[k])
+				}
+			}
+		}
+		for _, k := range kwargs.Keys() {
+			d.set(k, kwargs.values[k])
+		}
+		return d, nil
+	}),
+	"dict": Func(func(args []any, kwargs *Dict) (any, error) {
+		d := NewDict()
+		for _, a := range args {
+			if ad, ok := a.(*Dict); ok {
+				for _, k := range ad.keys {
+					d.set(k, ad.values[k])
+				}
+			}
+		}
+		for _, k := range kwargs.Keys() {
+			d.set(k, kwargs.values[k])
+		}
+		return d, nil
+	}),
+	"strftime_now": Func(func(args []any, _ *Dict) (any, error) {
+		if len(args) == 0 {
+			return nil, errors.New("strftime_now expected 1 argument")
+		}
+		return strftime(time.Now(), toString(args[0])), nil
+	}),
+}
+
+// intArg returns the i-th argument as an integer,
+// or the default value if absent.
+func intArg(args []any, i int, def int64) int64 {
+	if i >= len(args) {
+		return def
+	}
+	n, _, _, ok := toNumber(args[i])
+	if !ok {
+		return def
+	}
+	return n
+}
+
+// arg returns the i-th positional argument or the named keyword argument,
+// or the default value if absent.
+func arg(args []any, kwargs *Dict, i int, name string, def any) any {
+	if i >= 0 && i < len(args) {
+		return args[i]
+	}
+	if v, ok := kwargs.Get(name); ok {
+		return v
+	}
+	return def
+}
+
+// strftime formats the time with the Python strftime format.
+func strftime(t time.Time, format string) string {
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 >= len(format) {
+			sb.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			sb.WriteString(t.Format("2006"))
+		case 'y':
+			sb.WriteString(t.Format("06"))
+		case 'm':
+			sb.WriteString(t.Format("01"))
+		case 'd':
+			sb.WriteString(t.Format("02"))
+		case '-':
+			if i+1 < len(format) {
+				i++
+				switch format[i] {
+				case 'd':
+					sb.WriteString(strconv.Itoa(t.Day()))
+				case 'm':
+					sb.WriteString(strconv.Itoa(int(t.Month())))
+				default:
+					sb.WriteString("%-" + string(format[i]))
+				}
+			}
+		case 'B':
+			sb.WriteString(t.Format("January"))
+		case 'b':
+			sb.WriteString(t.Format("Jan"))
+		case 'A':
+			sb.WriteString(t.Format("Monday"))
+		case 'a':
+			sb.WriteString(t.Format("Mon"))
+		case 'H':
+			sb.WriteString(t.Format("15"))
+		case 'I':
+			sb.WriteString(t.Format("03"))
+		case 'M':
+			sb.WriteString(t.Format("04"))
+		case 'S':
+			sb.WriteString(t.Format("05"))
+		case 'p':
+			sb.WriteString(t.Format("PM"))
+		case 'j':
+			fmt.Fprintf(&sb, "%03d", t.YearDay())
+		case '%':
+			sb.WriteByte('%')
+		default:
+			sb.WriteByte('%')
+			sb.WriteByte(format[i])
+		}
+	}
+	return sb.String()
+}
+
+var filters map[string]filterFunc
+
+func init() {
+	filters = map[string]filterFunc{
+		"abs": func(v any, _ []any, _ *Dict) (any, error) {
+			i, f, isFloat, ok := toNumber(v)
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("bad operand type for abs(): %s", typeName(v))
+			case isFloat:
+				return math.Abs(f), nil
+			case i < 0:
+				return -i, nil
+			}
+			return i, nil
+		},
+		"attr": func(v any, args []any, _ *Dict) (any, error) {
+			return getAttr(v, toString(arg(args, nil, 0, "", ""))), nil
+		},
+		"capitalize": func(v any, _ []any, _ *Dict) (any, error) {
+			return capitalize(toString(v)), nil
+		},
+		"count":   filterLength,
+		"length":  filterLength,
+		"default": filterDefault,
+		"d":       filterDefault,
+		"dictsort": func(v any, args []any, kwargs *Dict) (any, error) {
+			d, ok := v.(*Dict)
+			if !ok {
+				return nil, fmt.Errorf("dictsort expected dict, got %s", typeName(v))
+			}
+			ks := append([]string(nil), d.keys...)
+			sort.Strings(ks)
+			if truthy(arg(args, kwargs, 2, "reverse", false)) {
+				for i, j := 0, len(ks)-1; i < j; i, j = i+1, j-1 {
+					ks[i], ks[j] = ks[j], ks[i]
+				}
+			}
+			r := make([]any, len(ks))
+			for i, k := range ks {
+				r[i] = []any{k, d.values[k]}
+			}
+			return r, nil
+		},
+		"escape": filterEscape,
+		"e":      filterEscape,
+		"first": func(v any, _ []any, _ *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil || len(items) == 0 {
+				return undefined{}, err
+			}
+			return items[0], nil
+		},
+		"last": func(v any, _ []any, _ *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil || len(items) == 0 {
+				return undefined{}, err
+			}
+			return items[len(items)-1], nil
+		},
+		"format": func(v any, args []any, kwargs *Dict) (any, error) {
+			if kwargs.Len() > 0 {
+				return printf(toString(v), nil, kwargs)
+			}
+			return printf(toString(v), args, nil)
+		},
+		"float": func(v any, args []any, kwargs *Dict) (any, error) {
+			if _, f, _, ok := toNumber(v); ok {
+				return f, nil
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(toString(v)), 64); err == nil {
+				return f, nil
+			}
+			return arg(args, kwargs, 0, "default", 0.0), nil
+		},
+		"int": func(v any, args []any, kwargs *Dict) (any, error) {
+			if i, _, _, ok := toNumber(v); ok {
+				return i, nil
+			}
+			s := strings.TrimSpace(toString(v))
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i, nil
+			}
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return int64(f), nil
+			}
+			return arg(args, kwargs, 0, "default", int64(0)), nil
+		},
+		"items": func(v any, _ []any, _ *Dict) (any, error) {
+			return dictItems(v)
+		},
+		"join": func(v any, args []any, kwargs *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+			attr, hasAttr := kwargs.Get("attribute")
+			ss := make([]string, len(items))
+			for i, item := range items {
+				if hasAttr {
+					item = getItem(item, attr)
+				}
+				ss[i] = toString(item)
+			}
+			return strings.Join(ss, toString(arg(args, kwargs, 0, "d", ""))), nil
+		},
+		"list": func(v any, _ []any, _ *Dict) (any, error) {
+			items, err := iterate(v)
+			return append([]any{}, items...), err
+		},
+		"lower": func(v any, _ []any, _ *Dict) (any, error) {
+			return strings.ToLower(toString(v)), nil
+		},
+		"upper": func(v any, _ []any, _ *Dict) (any, error) {
+			return strings.ToUpper(toString(v)), nil
+		},
+		"title": func(v any, _ []any, _ *Dict) (any, error) {
+			return title(toString(v)), nil
+		},
+		"map": func(v any, args []any, kwargs *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+			r := make([]any, len(items))
+			if attr, ok := kwargs.Get("attribute"); ok {
+				def, hasDef := kwargs.Get("default")
+				for i := range items {
+					r[i] = getItem(items[i], attr)
+					if _, isUndef := r[i].(undefined); isUndef && hasDef {
+						r[i] = def
+					}
+				}
+				return r, nil
+			}
+			if len(args) == 0 {
+				return nil, errors.New("map expected a filter name or an attribute")
+			}
+			f, ok := filters[toString(args[0])]
+			if !ok {
+				return nil, fmt.Errorf("unknown filter %q", toString(args[0]))
+			}
+			for i := range items {
+				if r[i], err = f(items[i], args[1:], kwargs); err != nil {
+					return nil, err
+				}
+			}
+			return r, nil
+		},
+		"max": func(v any, _ []any, _ *Dict) (any, error) {
+			return extremum(v, 1)
+		},
+		"min": func(v any, _ []any, _ *Dict) (any, error) {
+			return extremum(v, -1)
+		},
+		"replace": func(v any, args []any, kwargs *Dict) (any, error) {
+			n := -1
+			if c := arg(args, kwargs, 2, "count", nil); c != nil {
+				n = int(intArg([]any{c}, 0, -1))
+			}
+			return strings.Replace(toString(v),
+				toString(arg(args, kwargs, 0, "old", "")), toString(arg(args, kwargs, 1, "new", "")), n), nil
+		},
+		"reverse": func(v any, _ []any, _ *Dict) (any, error) {
+			if s, ok := v.(string); ok {
+				rs := []rune(s)
+				for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+					rs[i], rs[j] = rs[j], rs[i]
+				}
+				return string(rs), nil
+			}
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+			r := make([]any, len(items))
+			for i := range items {
+				r[len(items)-1-i] = items[i]
+			}
+			return r, nil
+		},
+		"round": func(v any, args []any, kwargs *Dict) (any, error) {
+			_, f, _, ok := toNumber(v)
+			if !ok {
+				return nil, fmt.Errorf("round expected number, got %s", typeName(v))
+			}
+			p := math.Pow(10, float64(intArg([]any{arg(args, kwargs, 0, "precision", int64(0))}, 0, 0)))
+			switch toString(arg(args, kwargs, 1, "method", "common")) {
+			case "ceil":
+				return math.Ceil(f*p) / p, nil
+			case "floor":
+				return math.Floor(f*p) / p, nil
+			}
+			return math.Round(f*p) / p, nil
+		},
+		"safe": func(v any, _ []any, _ *Dict) (any, error) {
+			return v, nil
+		},
+		"select": func(v any, args []any, _ *Dict) (any, error) {
+			return selectItems(v, nil, args, false)
+		},
+		"reject": func(v any, args []any, _ *Dict) (any, error) {
+			return selectItems(v, nil, args, true)
+		},
+		"selectattr": func(v any, args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("selectattr expected an attribute")
+			}
+			return selectItems(v, args[0], args[1:], false)
+		},
+		"rejectattr": func(v any, args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("rejectattr expected an attribute")
+			}
+			return selectItems(v, args[0], args[1:], true)
+		},
+		"sort": func(v any, args []any, kwargs *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+			r := append([]any{}, items...)
+			reverse := truthy(arg(args, kwargs, 0, "reverse", false))
+			attr, hasAttr := kwargs.Get("attribute")
+			sort.SliceStable(r, func(i, j int) bool {
+				a, b := r[i], r[j]
+				if hasAttr {
+					a, b = getItem(a, attr), getItem(b, attr)
+				}
+				c, cerr := compare(a, b)
+				if cerr != nil && err == nil {
+					err = cerr
+				}
+				if reverse {
+					return c > 0
+				}
+				return c < 0
+			})
+			return r, err
+		},
+		"string": func(v any, _ []any, _ *Dict) (any, error) {
+			return toString(v), nil
+		},
+		"sum": func(v any, args []any, kwargs *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+			attr, hasAttr := kwargs.Get("attribute")
+			r := arg(args, kwargs, 1, "start", int64(0))
+			for _, item := range items {
+				if hasAttr {
+					item = getItem(item, attr)
+				}
+				if r, err = arithmetic("+", r, item); err != nil {
+					return nil, err
+				}
+			}
+			return r, nil
+		},
+		"tojson": func(v any, args []any, kwargs *Dict) (any, error) {
+			indent := int64(-1)
+			if i := arg(args, kwargs, 0, "indent", nil); i != nil {
+				indent = intArg([]any{i}, 0, -1)
+			}
+			return toJSON(v, int(indent)), nil
+		},
+		"trim": func(v any, args []any, kwargs *Dict) (any, error) {
+			if c := arg(args, kwargs, 0, "chars", nil); c != nil {
+				return strings.Trim(toString(v), toString(c)), nil
+			}
+			return strings.TrimSpace(toString(v)), nil
+		},
+		"unique": func(v any, _ []any, _ *Dict) (any, error) {
+			items, err := iterate(v)
+			if err != nil {
+				return nil, err
+			}
+			var r []any
+			for _, item := range items {
+				if ok, _ := contains(r, item); !ok {
+					r = append(r, item)
+				}
+			}
+			return r, nil
+		},
+		"indent": func(v any, args []any, kwargs *Dict) (any, error) {
+			var pad string
+			switch w := arg(args, kwargs, 0, "width", int64(4)).(type) {
+			case string:
+				pad = w
+			default:
+				pad = strings.Repeat(" ", int(intArg([]any{w}, 0, 4)))
+			}
+			first := truthy(arg(args, kwargs, 1, "first", false))
+			blank := truthy(arg(args, kwargs, 2, "blank", false))
+			lines := strings.Split(toString(v), "\n")
+			for i := range lines {
+				if (i == 0 && !first) || (!blank && strings.TrimSpace(lines[i]) == "") {
+					continue
+				}
+				lines[i] = pad + lines[i]
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+		"wordcount": func(v any, _ []any, _ *Dict) (any, error) {
+			return int64(len(strings.Fields(toString(v)))), nil
+		},
+	}
+}
+
+func filterLength(v any, _ []any, _ *Dict) (any, error) {
+	switch vv := v.(type) {
+	case string:
+		return int64(len([]rune(vv))), nil
+	case []any:
+		return int64(len(vv)), nil
+	case *Dict:
+		return int64(vv.Len()), nil
+	case undefined:
+		return int64(0), nil
+	}
+	return nil, fmt.Errorf("object of type %s has no len()", typeName(v))
+}
+
+func filterDefault(v any, args []any, kwargs *Dict) (any, error) {
+	def := arg(args, kwargs, 0, "default_value", "")
+	if _, ok := v.(undefined); ok {
+		return def, nil
+	}
+	if truthy(arg(args, kwargs, 1, "boolean", false)) && !truthy(v) {
+		return def, nil
+	}
+	return v, nil
+}
+
+func filterEscape(v any, _ []any, _ *Dict) (any, error) {
+	return html.EscapeString(toString(v)), nil
+}
+
+func dictItems(v any) (any, error) {
+	switch d := v.(type) {
+	case *Dict:
+		r := make([]any, len(d.keys))
+		for i, k := range d.keys {
+			r[i] = []any{k, d.values[k]}
+		}
+		return r, nil
+	case undefined:
+		return []any{}, nil
+	}
+	return nil, fmt.Errorf("items expected dict, got %s", typeName(v))
+}
+
+func extremum(v any, sign int) (any, error) {
+	items, err := iterate(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return undefined{}, nil
+	}
+	r := items[0]
+	for _, item := range items[1:] {
+		c, err := compare(item, r)
+		if err != nil {
+			return nil, err
+		}
+		if c*sign > 0 {
+			r = item
+		}
+	}
+	return r, nil
+}
+
+// printf formats the string with the Python printf-style formatting,
+// e.g. "%s - %d" % (a, b), or "%(a)s" % {"a": a} if the mapping is given.
+func printf(format string, args []any, mapping *Dict) (string, error) {
+	var (
+		sb strings.Builder
+		n  int
+	)
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+
+		// Key.
+		var v any
+		if i < len(format) && format[i] == '(' {
+			j := strings.IndexByte(format[i:], ')')
+			if j < 0 {
+				return "", errors.New("incomplete format key")
+			}
+			var ok bool
+			if v, ok = mapping.Get(format[i+1 : i+j]); !ok {
+				return "", fmt.Errorf("format key %q not found", format[i+1:i+j])
+			}
+			i += j + 1
+		} else {
+			if n >= len(args) {
+				return "", errors.New("not enough arguments for format string")
+			}
+			v = args[n]
+			n++
+		}
+
+		// Flags, width and precision.
+		j := i
+		for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+			j++
+		}
+		for j < len(format) && (format[j] >= '0' && format[j] <= '9' || format[j] == '.') {
+			j++
+		}
+		if j >= len(format) {
+			return "", errors.New("incomplete format")
+		}
+		spec := "%" + format[i:j]
+		i = j
+
+		// Conversion.
+		switch c := format[i]; c {
+		case 's':
+			sb.WriteString(fmt.Sprintf(spec+"s", toString(v)))
+		case 'r':
+			sb.WriteString(fmt.Sprintf(spec+"s", repr(v)))
+		case 'd', 'i', 'u', 'x', 'X', 'o', 'c':
+			iv, fv, isFloat, ok := toNumber(v)
+			if !ok {
+				return "", fmt.Errorf("%%%c format: a number is required, not %s", c, typeName(v))
+			}
+			if isFloat {
+				iv = int64(fv)
+			}
+			switch c {
+			case 'd', 'i', 'u':
+				c = 'd'
+			case 'c':
+				spec += "c"
+				sb.WriteString(fmt.Sprintf(spec, rune(iv)))
+				continue
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(c), iv))
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			_, fv, _, ok := toNumber(v)
+			if !ok {
+				return "", fmt.Errorf("%%%c format: a real number is required, not %s", c, typeName(v))
+			}
+			if c == 'F' {
+				c = 'f'
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(c), fv))
+		default:
+			return "", fmt.Errorf("unsupported format character %q", c)
+		}
+	}
+	if mapping == nil && n < len(args) {
+		return "", errors.New("not all arguments converted during string formatting")
+	}
+	return sb.String(), nil
+}
+
+// selectItems selects the items (or their attribute) passing the test,
+// or rejecting the test if reject is true.
+func selectItems(v, attr any, args []any, reject bool) (any, error) {
+	items, err := iterate(v)
+	if err != nil {
+		return nil, err
+	}
+	t := func(v any, _ []any) (bool, error) { return truthy(v), nil }
+	if len(args) > 0 {
+		name := toString(args[0])
+		var ok bool
+		if t, ok = tests[name]; !ok {
+			return nil, fmt.Errorf("unknown test %q", name)
+		}
+		args = args[1:]
+	}
+	r := []any{}
+	for _, item := range items {
+		x := item
+		if attr != nil {
+			x = getItem(item, attr)
+		}
+		ok, err := t(x, args)
+		if err != nil {
+			return nil, err
+		}
+		if ok != reject {
+			r = append(r, item)
+		}
+	}
+	return r, nil
+}
+
+var tests map[string]testFunc
+
+func init() {
+	isType := func(f func(v any) bool) testFunc {
+		return func(v any, _ []any) (bool, error) { return f(v), nil }
+	}
+	cmp := func(op string) testFunc {
+		return func(v any, args []any) (bool, error) {
+			if len(args) == 0 {
+				return false, fmt.Errorf("test %q expected 1 argument", op)
+			}
+			return compareOp(op, v, args[0])
+		}
+	}
+
+	tests = map[string]testFunc{
+		"defined": isType(func(v any) bool {
+			_, ok := v.(undefined)
+			return !ok
+		}),
+		"undefined": isType(func(v any) bool {
+			_, ok := v.(undefined)
+			return ok
+		}),
+		"none": isType(func(v any) bool {
+			return v == nil
+		}),
+		"boolean": isType(func(v any) bool {
+			_, ok := v.(bool)
+			return ok
+		}),
+		"true": isType(func(v any) bool {
+			b, ok := v.(bool)
+			return ok && b
+		}),
+		"false": isType(func(v any) bool {
+			b, ok := v.(bool)
+			return ok && !b
+		}),
+		"integer": isType(func(v any) bool {
+			_, ok := v.(int64)
+			return ok
+		}),
+		"float": isType(func(v any) bool {
+			_, ok := v.(float64)
+			return ok
+		}),
+		"number": isType(func(v any) bool {
+			switch v.(type) {
+			case int64, float64:
+				return true
+			}
+			return false
+		}),
+		"string": isType(func(v any) bool {
+			_, ok := v.(string)
+			return ok
+		}),
+		"mapping": isType(func(v any) bool {
+			_, ok := v.(*Dict)
+			return ok
+		}),
+		"iterable": isType(func(v any) bool {
+			switch v.(type) {
+			case string, []any, *Dict:
+				return true
+			}
+			return false
+		}),
+		"sequence": isType(func(v any) bool {
+			switch v.(type) {
+			case string, []any, *Dict:
+				return true
+			}
+			return false
+		}),
+		"callable": isType(func(v any) bool {
+			_, ok := v.(Func)
+			return ok
+		}),
+		"lower": isType(func(v any) bool {
+			s, ok := v.(string)
+			return ok && s == strings.ToLower(s)
+		}),
+		"upper": isType(func(v any) bool {
+			s, ok := v.(string)
+			return ok && s == strings.ToUpper(s)
+		}),
+		"odd": func(v any, _ []any) (bool, error) {
+			i, ok := v.(int64)
+			return ok && i%2 != 0, nil
+		},
+		"even": func(v any, _ []any) (bool, error) {
+			i, ok := v.(int64)
+			return ok && i%2 == 0, nil
+		},
+		"divisibleby": func(v any, args []any) (bool, error) {
+			i, ok := v.(int64)
+			n := intArg(args, 0, 0)
+			return ok && n != 0 && i%n == 0, nil
+		},
+		"sameas": func(v any, args []any) (bool, error) {
+			if len(args) == 0 {
+				return false, errors.New("test \"sameas\" expected 1 argument")
+			}
+			switch v.(type) {
+			case nil, bool:
+				return v == args[0], nil
+			}
+			return equal(v, args[0]), nil
+		},
+		"in": func(v any, args []any) (bool, error) {
+			if len(args) == 0 {
+				return false, errors.New("test \"in\" expected 1 argument")
+			}
+			return contains(args[0], v)
+		},
+		"eq":          cmp("=="),
+		"equalto":     cmp("=="),
+		"==":          cmp("=="),
+		"ne":          cmp("!="),
+		"!=":          cmp("!="),
+		"lt":          cmp("<"),
+		"lessthan":    cmp("<"),
+		"le":          cmp("<="),
+		"gt":          cmp(">"),
+		"greaterthan": cmp(">"),
+		"ge":          cmp(">="),
+	}
+}
+
+// method returns the bound method of the value as Python,
+// or nil if not found.
+func method(v any, name string) Func {
+	switch vv := v.(type) {
+	case string:
+		return stringMethod(vv, name)
+	case *Dict:
+		switch name {
+		case "items":
+			return func(_ []any, _ *Dict) (any, error) { return dictItems(vv) }
+		case "keys":
+			return func(_ []any, _ *Dict) (any, error) { return iterate(vv) }
+		case "values":
+			return func(_ []any, _ *Dict) (any, error) {
+				r := make([]any, len(vv.keys))
+				for i, k := range vv.keys {
+					r[i] = vv.values[k]
+				}
+				return r, nil
+			}
+		case "get":
+			return func(args []any, kwargs *Dict) (any, error) {
+				if len(args) == 0 {
+					return nil, errors.New("get expected at least 1 argument")
+				}
+				if r, ok := vv.values[toString(args[0])]; ok {
+					return r, nil
+				}
+				return arg(args, kwargs, 1, "default", nil), nil
+			}
+		}
+	case []any:
+		switch name {
+		case "index":
+			return func(args []any, _ *Dict) (any, error) {
+				for i := range vv {
+					if len(args) > 0 && equal(vv[i], args[0]) {
+						return int64(i), nil
+					}
+				}
+				return nil, errors.New("value is not in list")
+			}
+		case "count":
+			return func(args []any, _ *Dict) (any, error) {
+				var n int64
+				for i := range vv {
+					if len(args) > 0 && equal(vv[i], args[0]) {
+						n++
+					}
+				}
+				return n, nil
+			}
+		}
+	}
+	return nil
+}
+
+func stringMethod(s, name string) Func {
+	strip := func(trim func(string, string) string, trimSpace func(string) string) Func {
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) > 0 && args[0] != nil {
+				return trim(s, toString(args[0])), nil
+			}
+			return trimSpace(s), nil
+		}
+	}
+	affix := func(has func(string, string) bool) Func {
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%s expected 1 argument", name)
+			}
+			if l, ok := args[0].([]any); ok {
+				for _, a := range l {
+					if has(s, toString(a)) {
+						return true, nil
+					}
+				}
+				return false, nil
+			}
+			return has(s, toString(args[0])), nil
+		}
+	}
+
+	switch name {
+	case "strip":
+		return strip(strings.Trim, strings.TrimSpace)
+	case "lstrip":
+		return strip(strings.TrimLeft, func(s string) string { return strings.TrimLeftFunc(s, unicode.IsSpace) })
+	case "rstrip":
+		return strip(strings.TrimRight, func(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) })
+	case "startswith":
+		return affix(strings.HasPrefix)
+	case "endswith":
+		return affix(strings.HasSuffix)
+	case "upper":
+		return func(_ []any, _ *Dict) (any, error) { return strings.ToUpper(s), nil }
+	case "lower":
+		return func(_ []any, _ *Dict) (any, error) { return strings.ToLower(s), nil }
+	case "title":
+		return func(_ []any, _ *Dict) (any, error) { return title(s), nil }
+	case "capitalize":
+		return func(_ []any, _ *Dict) (any, error) { return capitalize(s), nil }
+	case "replace":
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) < 2 {
+				return nil, errors.New("replace expected at least 2 arguments")
+			}
+			return strings.Replace(s, toString(args[0]), toString(args[1]), int(intArg(args, 2, -1))), nil
+		}
+	case "find":
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("find expected 1 argument")
+			}
+			i := strings.Index(s, toString(args[0]))
+			if i < 0 {
+				return int64(-1), nil
+			}
+			return int64(len([]rune(s[:i]))), nil
+		}
+	case "count":
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("count expected 1 argument")
+			}
+			return int64(strings.Count(s, toString(args[0]))), nil
+		}
+	case "split", "rsplit":
+		return func(args []any, kwargs *Dict) (any, error) {
+			n := int(intArg([]any{arg(args, kwargs, 1, "maxsplit", int64(-1))}, 0, -1))
+			var ss []string
+			if sep := arg(args, kwargs, 0, "sep", nil); sep != nil {
+				switch {
+				case n < 0:
+					ss = strings.Split(s, toString(sep))
+				case name == "split":
+					ss = strings.SplitN(s, toString(sep), n+1)
+				default:
+					ss = rsplitN(s, toString(sep), n+1)
+				}
+			} else {
+				ss = strings.Fields(s)
+			}
+			r := make([]any, len(ss))
+			for i := range ss {
+				r[i] = ss[i]
+			}
+			return r, nil
+		}
+	case "splitlines":
+		return func(_ []any, _ *Dict) (any, error) {
+			ss := strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n"), "\n")
+			r := make([]any, 0, len(ss))
+			for i := range ss {
+				if s != "" {
+					r = append(r, ss[i])
+				}
+			}
+			return r, nil
+		}
+	case "join":
+		return func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("join expected 1 argument")
+			}
+			items, err := iterate(args[0])
+			if err != nil {
+				return nil, err
+			}
+			ss := make([]string, len(items))
+			for i := range items {
+				ss[i] = toString(items[i])
+			}
+			return strings.Join(ss, s), nil
+		}
+	case "isdigit", "isnumeric":
+		return func(_ []any, _ *Dict) (any, error) {
+			return s != "" && strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) }) < 0, nil
+		}
+	case "isspace":
+		return func(_ []any, _ *Dict) (any, error) {
+			return s != "" && strings.TrimSpace(s) == "", nil
+		}
+	case "format":
+		return func(args []any, _ *Dict) (any, error) {
+			r := s
+			for _, a := range args {
+				r = strings.Replace(r, "{}", toString(a), 1)
+			}
+			return r, nil
+		}
+	}
+	return nil
+}
+
+// rsplitN splits the string from the right into at most n parts.
+func rsplitN(s, sep string, n int) []string {
+	var r []string
+	for len(r) < n-1 {
+		i := strings.LastIndex(s, sep)
+		if i < 0 {
+			break
+		}
+		r = append(r, s[i+len(sep):])
+		s = s[:i]
+	}
+	r = append(r, s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return r
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	rs := []rune(strings.ToLower(s))
+	rs[0] = unicode.ToUpper(rs[0])
+	return string(rs)
+}
+
+func title(s string) string {
+	rs := []rune(s)
+	prev := false
+	for i, r := range rs {
+		if prev {
+			rs[i] = unicode.ToLower(r)
+		} else {
+			rs[i] = unicode.ToUpper(r)
+		}
+		prev = unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	return string(rs)
+}
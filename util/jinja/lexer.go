@@ -0,0 +1,349 @@
+package jinja
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenName tokenKind = iota
+	tokenString
+	tokenInt
+	tokenFloat
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	// s is the raw name, operator or number,
+	// or the unquoted string.
+	s string
+}
+
+type segmentKind int
+
+const (
+	segmentText segmentKind = iota
+	segmentOutput
+	segmentStatement
+)
+
+// segment is a piece of the template source,
+// which is either a text, an output tag(`{{ ... }}`) or a statement tag(`{% ... %}`).
+type segment struct {
+	kind   segmentKind
+	text   string
+	tokens []token
+	line   int
+}
+
+// lex splits the source into segments,
+// with the whitespace control of `trim_blocks` and `lstrip_blocks` enabled as HuggingFace transformers does,
+// see https://github.com/huggingface/transformers/blob/4fe75ca3d30af9c7a0b5cd1bb1b8e60c21e3fe21/src/transformers/utils/chat_template_utils.py#L426-L431.
+func lex(src string) ([]segment, error) {
+	var segs []segment
+	appendText := func(s string) {
+		if s != "" {
+			segs = append(segs, segment{kind: segmentText, text: s})
+		}
+	}
+
+	for i := 0; i < len(src); {
+		j := indexTagStart(src, i)
+		if j < 0 {
+			appendText(src[i:])
+			break
+		}
+		line := strings.Count(src[:j], "\n") + 1
+
+		var (
+			open         = src[j : j+2]
+			k            = j + 2
+			lTrim, lKeep bool
+		)
+		switch {
+		case k < len(src) && src[k] == '-':
+			lTrim = true
+			k++
+		case k < len(src) && src[k] == '+':
+			lKeep = true
+			k++
+		}
+
+		// Trim the whitespaces before the tag.
+		text := src[i:j]
+		switch {
+		case lTrim:
+			text = strings.TrimRight(text, " \t\r\n")
+		case !lKeep && open != "{{":
+			// Strip the spaces and tabs from the beginning of a line to the block.
+			p := j
+			for p > 0 && (src[p-1] == ' ' || src[p-1] == '\t') {
+				p--
+			}
+			if p == 0 || src[p-1] == '\n' {
+				text = text[:max(p, i)-i]
+			}
+		}
+		appendText(text)
+
+		var (
+			seg          segment
+			rTrim, rKeep bool
+		)
+		switch open {
+		case "{#":
+			e := strings.Index(src[k:], "#}")
+			if e < 0 {
+				return nil, fmt.Errorf("line %d: unclosed comment", line)
+			}
+			if e > 0 && src[k+e-1] == '-' {
+				rTrim = true
+			} else if e > 0 && src[k+e-1] == '+' {
+				rKeep = true
+			}
+			k += e + 2
+		default:
+			closing := "}}"
+			seg.kind = segmentOutput
+			if open == "{%" {
+				closing = "%}"
+				seg.kind = segmentStatement
+			}
+			var err error
+			seg.tokens, k, rTrim, rKeep, err = lexTag(src, k, closing)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			seg.line = line
+		}
+
+		// Take the raw block as text.
+		if seg.kind == segmentStatement && len(seg.tokens) == 1 && seg.tokens[0].s == "raw" {
+			e, ee, err := indexEndRaw(src, k)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			appendText(src[k:e])
+			k = ee
+		} else if open != "{#" {
+			segs = append(segs, seg)
+		}
+
+		// Trim the whitespaces after the tag.
+		switch {
+		case rTrim:
+			for k < len(src) && strings.IndexByte(" \t\r\n", src[k]) >= 0 {
+				k++
+			}
+		case !rKeep && open != "{{":
+			// Remove the first newline after the block.
+			if strings.HasPrefix(src[k:], "\r\n") {
+				k += 2
+			} else if strings.HasPrefix(src[k:], "\n") {
+				k++
+			}
+		}
+		i = k
+	}
+
+	return segs, nil
+}
+
+// indexTagStart returns the index of the next tag start from i,
+// or -1 if not found.
+func indexTagStart(src string, i int) int {
+	for {
+		j := strings.IndexByte(src[i:], '{')
+		if j < 0 || i+j+1 >= len(src) {
+			return -1
+		}
+		switch src[i+j+1] {
+		case '{', '%', '#':
+			return i + j
+		}
+		i += j + 1
+	}
+}
+
+// indexEndRaw returns the start and end index of the `{% endraw %}` tag from i.
+func indexEndRaw(src string, i int) (start, end int, err error) {
+	for {
+		j := strings.Index(src[i:], "{%")
+		if j < 0 {
+			return 0, 0, errors.New("unclosed raw block")
+		}
+		start = i + j
+		k := start + 2
+		if k < len(src) && (src[k] == '-' || src[k] == '+') {
+			k++
+		}
+		tks, end, _, _, err := lexTag(src, k, "%}")
+		if err == nil && len(tks) == 1 && tks[0].s == "endraw" {
+			return start, end, nil
+		}
+		i = start + 2
+	}
+}
+
+// lexTag tokenizes the tag content from i until the closing delimiter,
+// returns the tokens, the index after the closing delimiter,
+// and whether to trim or keep the whitespaces after the tag.
+func lexTag(src string, i int, closing string) (tks []token, next int, rTrim, rKeep bool, err error) {
+	// Track the brackets to not close the tag inside them, e.g. `{{ {'a': {}} }}`.
+	depth := 0
+	for {
+		for i < len(src) && strings.IndexByte(" \t\r\n", src[i]) >= 0 {
+			i++
+		}
+		if i >= len(src) {
+			return nil, 0, false, false, errors.New("unclosed tag")
+		}
+
+		switch {
+		case depth > 0:
+		case strings.HasPrefix(src[i:], closing):
+			return tks, i + 2, false, false, nil
+		case src[i] == '-' && strings.HasPrefix(src[i+1:], closing):
+			return tks, i + 3, true, false, nil
+		case src[i] == '+' && strings.HasPrefix(src[i+1:], closing):
+			return tks, i + 3, false, true, nil
+		}
+
+		c := src[i]
+		switch {
+		case c == '_' || isLetter(c):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || isLetter(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			tks = append(tks, token{kind: tokenName, s: src[i:j]})
+			i = j
+		case isDigit(c):
+			j, kind := i+1, tokenInt
+			for j < len(src) && (isDigit(src[j]) || src[j] == '_') {
+				j++
+			}
+			if j+1 < len(src) && src[j] == '.' && isDigit(src[j+1]) {
+				kind = tokenFloat
+				j++
+				for j < len(src) && (isDigit(src[j]) || src[j] == '_') {
+					j++
+				}
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				e := j + 1
+				if e < len(src) && (src[e] == '+' || src[e] == '-') {
+					e++
+				}
+				if e < len(src) && isDigit(src[e]) {
+					kind = tokenFloat
+					for j = e; j < len(src) && isDigit(src[j]); j++ {
+					}
+				}
+			}
+			tks = append(tks, token{kind: kind, s: strings.ReplaceAll(src[i:j], "_", "")})
+			i = j
+		case c == '\'' || c == '"':
+			s, j, err := unquote(src, i)
+			if err != nil {
+				return nil, 0, false, false, err
+			}
+			tks = append(tks, token{kind: tokenString, s: s})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"**", "//", "==", "!=", "<=", ">="} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				if strings.IndexByte("+-*/%~<>=()[]{},:.|", c) < 0 {
+					return nil, 0, false, false, fmt.Errorf("unexpected character %q", c)
+				}
+				op = src[i : i+1]
+			}
+			switch op {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth = max(depth-1, 0)
+			}
+			tks = append(tks, token{kind: tokenOperator, s: op})
+			i += len(op)
+		}
+	}
+}
+
+// unquote unquotes the string literal starting at i,
+// returns the unquoted string and the index after the literal.
+func unquote(src string, i int) (string, int, error) {
+	var (
+		q  = src[i]
+		sb strings.Builder
+	)
+	for j := i + 1; j < len(src); {
+		c := src[j]
+		switch {
+		case c == q:
+			return sb.String(), j + 1, nil
+		case c == '\\' && j+1 < len(src):
+			j++
+			switch e := src[j]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case '0':
+				sb.WriteByte(0)
+			case 'u', 'x':
+				n := 4
+				if e == 'x' {
+					n = 2
+				}
+				if j+n >= len(src) {
+					return "", 0, errors.New("invalid escape sequence")
+				}
+				r, err := strconv.ParseUint(src[j+1:j+1+n], 16, 32)
+				if err != nil {
+					return "", 0, errors.New("invalid escape sequence")
+				}
+				sb.WriteRune(rune(r))
+				j += n
+			case '\n':
+			default:
+				if e != '\\' && e != '\'' && e != '"' {
+					sb.WriteByte('\\')
+				}
+				sb.WriteByte(e)
+			}
+			j++
+		default:
+			_, n := utf8.DecodeRuneInString(src[j:])
+			sb.WriteString(src[j : j+n])
+			j += n
+		}
+	}
+	return "", 0, errors.New("unclosed string literal")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
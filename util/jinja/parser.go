@@ -0,0 +1,895 @@
+package jinja
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type (
+	node interface{}
+
+	textNode struct {
+		text string
+	}
+
+	outputNode struct {
+		expr expr
+	}
+
+	ifNode struct {
+		conds    []expr
+		bodies   [][]node
+		elseBody []node
+	}
+
+	forNode struct {
+		targets  []string
+		iter     expr
+		cond     expr
+		body     []node
+		elseBody []node
+	}
+
+	setNode struct {
+		// targets holds the variable names,
+		// or the namespace name and the attribute name if attr is true.
+		targets []string
+		attr    bool
+		value   expr
+		body    []node
+	}
+
+	macroNode struct {
+		name     string
+		params   []string
+		defaults []expr
+		body     []node
+	}
+
+	groupNode struct {
+		body []node
+	}
+
+	breakNode struct{}
+
+	continueNode struct{}
+)
+
+type (
+	expr interface{}
+
+	literalExpr struct {
+		value any
+	}
+
+	nameExpr struct {
+		name string
+	}
+
+	listExpr struct {
+		items []expr
+	}
+
+	dictExpr struct {
+		keys, values []expr
+	}
+
+	attrExpr struct {
+		x    expr
+		name string
+	}
+
+	indexExpr struct {
+		x, index expr
+	}
+
+	sliceExpr struct {
+		x                 expr
+		start, stop, step expr
+	}
+
+	callExpr struct {
+		fn   expr
+		args arguments
+	}
+
+	filterExpr struct {
+		x    expr
+		name string
+		args arguments
+	}
+
+	testExpr struct {
+		x      expr
+		name   string
+		args   arguments
+		negate bool
+	}
+
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+
+	binaryExpr struct {
+		op   string
+		l, r expr
+	}
+
+	compareExpr struct {
+		x   expr
+		ops []string
+		ys  []expr
+	}
+
+	condExpr struct {
+		cond, x, y expr
+	}
+
+	arguments struct {
+		positional []expr
+		names      []string
+		keywords   []expr
+	}
+)
+
+type parser struct {
+	segs []segment
+	i    int
+}
+
+// parseBody parses the nodes until one of the given end statements,
+// returns the nodes and the tokens of the end statement.
+func (p *parser) parseBody(ends ...string) ([]node, *tokenStream, error) {
+	var nodes []node
+	for p.i < len(p.segs) {
+		seg := p.segs[p.i]
+		p.i++
+
+		switch seg.kind {
+		case segmentText:
+			nodes = append(nodes, textNode{text: seg.text})
+		case segmentOutput:
+			ts := &tokenStream{tks: seg.tokens, line: seg.line}
+			x, err := ts.parseExpr()
+			if err == nil {
+				err = ts.expectEnd()
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			nodes = append(nodes, outputNode{expr: x})
+		case segmentStatement:
+			ts := &tokenStream{tks: seg.tokens, line: seg.line}
+			kw, err := ts.expectName()
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, e := range ends {
+				if kw == e {
+					return nodes, ts, nil
+				}
+			}
+			n, err := p.parseStatement(kw, ts)
+			if err != nil {
+				return nil, nil, err
+			}
+			nodes = append(nodes, n)
+		}
+	}
+
+	if len(ends) != 0 {
+		return nil, nil, fmt.Errorf("missing %q", ends[len(ends)-1])
+	}
+	return nodes, nil, nil
+}
+
+func (p *parser) parseStatement(kw string, ts *tokenStream) (node, error) {
+	switch kw {
+	case "if":
+		var n ifNode
+		for {
+			cond, err := ts.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err = ts.expectEnd(); err != nil {
+				return nil, err
+			}
+			body, end, err := p.parseBody("elif", "else", "endif")
+			if err != nil {
+				return nil, err
+			}
+			n.conds = append(n.conds, cond)
+			n.bodies = append(n.bodies, body)
+
+			switch end.tks[0].s {
+			case "elif":
+				ts = end
+				continue
+			case "else":
+				if err = end.expectEnd(); err != nil {
+					return nil, err
+				}
+				if n.elseBody, end, err = p.parseBody("endif"); err != nil {
+					return nil, err
+				}
+			}
+			return n, end.expectEnd()
+		}
+	case "for":
+		var n forNode
+		for {
+			t, err := ts.expectName()
+			if err != nil {
+				return nil, err
+			}
+			n.targets = append(n.targets, t)
+			if !ts.skipOperator(",") {
+				break
+			}
+		}
+		if !ts.skipName("in") {
+			return nil, ts.errorf("expected 'in'")
+		}
+		var err error
+		if n.iter, err = ts.parseTuple(false); err != nil {
+			return nil, err
+		}
+		if ts.skipName("if") {
+			if n.cond, err = ts.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		ts.skipName("recursive")
+		if err = ts.expectEnd(); err != nil {
+			return nil, err
+		}
+		body, end, err := p.parseBody("else", "endfor")
+		if err != nil {
+			return nil, err
+		}
+		n.body = body
+		if end.tks[0].s == "else" {
+			if err = end.expectEnd(); err != nil {
+				return nil, err
+			}
+			if n.elseBody, end, err = p.parseBody("endfor"); err != nil {
+				return nil, err
+			}
+		}
+		return n, end.expectEnd()
+	case "set":
+		var n setNode
+		t, err := ts.expectName()
+		if err != nil {
+			return nil, err
+		}
+		n.targets = append(n.targets, t)
+		switch {
+		case ts.skipOperator("."):
+			if t, err = ts.expectName(); err != nil {
+				return nil, err
+			}
+			n.targets = append(n.targets, t)
+			n.attr = true
+		default:
+			for ts.skipOperator(",") {
+				if t, err = ts.expectName(); err != nil {
+					return nil, err
+				}
+				n.targets = append(n.targets, t)
+			}
+		}
+		if ts.skipOperator("=") {
+			if n.value, err = ts.parseTuple(true); err != nil {
+				return nil, err
+			}
+			return n, ts.expectEnd()
+		}
+		if err = ts.expectEnd(); err != nil {
+			return nil, err
+		}
+		body, end, err := p.parseBody("endset")
+		if err != nil {
+			return nil, err
+		}
+		n.body = body
+		return n, end.expectEnd()
+	case "macro":
+		var (
+			n   macroNode
+			err error
+		)
+		if n.name, err = ts.expectName(); err != nil {
+			return nil, err
+		}
+		if err = ts.expectOperator("("); err != nil {
+			return nil, err
+		}
+		for !ts.skipOperator(")") {
+			if len(n.params) != 0 {
+				if err = ts.expectOperator(","); err != nil {
+					return nil, err
+				}
+			}
+			param, err := ts.expectName()
+			if err != nil {
+				return nil, err
+			}
+			var def expr
+			if ts.skipOperator("=") {
+				if def, err = ts.parseExpr(); err != nil {
+					return nil, err
+				}
+			}
+			n.params = append(n.params, param)
+			n.defaults = append(n.defaults, def)
+		}
+		if err = ts.expectEnd(); err != nil {
+			return nil, err
+		}
+		body, end, err := p.parseBody("endmacro")
+		if err != nil {
+			return nil, err
+		}
+		n.body = body
+		return n, end.expectEnd()
+	case "generation":
+		// The generation block is used to mark the assistant messages by HuggingFace transformers,
+		// which renders as is.
+		if err := ts.expectEnd(); err != nil {
+			return nil, err
+		}
+		body, end, err := p.parseBody("endgeneration")
+		if err != nil {
+			return nil, err
+		}
+		return groupNode{body: body}, end.expectEnd()
+	case "break":
+		return breakNode{}, ts.expectEnd()
+	case "continue":
+		return continueNode{}, ts.expectEnd()
+	}
+	return nil, ts.errorf("unknown statement %q", kw)
+}
+
+type tokenStream struct {
+	tks  []token
+	i    int
+	line int
+}
+
+func (ts *tokenStream) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", ts.line, fmt.Sprintf(format, args...))
+}
+
+func (ts *tokenStream) peek() (token, bool) {
+	if ts.i >= len(ts.tks) {
+		return token{}, false
+	}
+	return ts.tks[ts.i], true
+}
+
+func (ts *tokenStream) peekOperator(op string) bool {
+	t, ok := ts.peek()
+	return ok && t.kind == tokenOperator && t.s == op
+}
+
+func (ts *tokenStream) peekName(name string) bool {
+	t, ok := ts.peek()
+	return ok && t.kind == tokenName && t.s == name
+}
+
+func (ts *tokenStream) skipOperator(op string) bool {
+	if ts.peekOperator(op) {
+		ts.i++
+		return true
+	}
+	return false
+}
+
+func (ts *tokenStream) skipName(name string) bool {
+	if ts.peekName(name) {
+		ts.i++
+		return true
+	}
+	return false
+}
+
+func (ts *tokenStream) expectOperator(op string) error {
+	if !ts.skipOperator(op) {
+		return ts.errorf("expected %q", op)
+	}
+	return nil
+}
+
+func (ts *tokenStream) expectName() (string, error) {
+	t, ok := ts.peek()
+	if !ok || t.kind != tokenName {
+		return "", ts.errorf("expected name")
+	}
+	ts.i++
+	return t.s, nil
+}
+
+func (ts *tokenStream) expectEnd() error {
+	if t, ok := ts.peek(); ok {
+		return ts.errorf("unexpected %q", t.s)
+	}
+	return nil
+}
+
+// parseTuple parses the expressions separated by commas,
+// returns a tuple if there are more than one expression.
+func (ts *tokenStream) parseTuple(withCond bool) (expr, error) {
+	parse := ts.parseExpr
+	if !withCond {
+		parse = ts.parseOr
+	}
+
+	x, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	if !ts.peekOperator(",") {
+		return x, nil
+	}
+	items := []expr{x}
+	for ts.skipOperator(",") {
+		if t, ok := ts.peek(); !ok || (t.kind == tokenName && t.s == "if") {
+			break
+		}
+		if x, err = parse(); err != nil {
+			return nil, err
+		}
+		items = append(items, x)
+	}
+	return listExpr{items: items}, nil
+}
+
+func (ts *tokenStream) parseExpr() (expr, error) {
+	x, err := ts.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !ts.skipName("if") {
+		return x, nil
+	}
+	cond, err := ts.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	var y expr
+	if ts.skipName("else") {
+		if y, err = ts.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return condExpr{cond: cond, x: x, y: y}, nil
+}
+
+func (ts *tokenStream) parseOr() (expr, error) {
+	x, err := ts.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for ts.skipName("or") {
+		y, err := ts.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: "or", l: x, r: y}
+	}
+	return x, nil
+}
+
+func (ts *tokenStream) parseAnd() (expr, error) {
+	x, err := ts.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for ts.skipName("and") {
+		y, err := ts.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: "and", l: x, r: y}
+	}
+	return x, nil
+}
+
+func (ts *tokenStream) parseNot() (expr, error) {
+	if ts.skipName("not") {
+		x, err := ts.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: "not", x: x}, nil
+	}
+	return ts.parseCompare()
+}
+
+func (ts *tokenStream) parseCompare() (expr, error) {
+	x, err := ts.parseMath1()
+	if err != nil {
+		return nil, err
+	}
+	var n compareExpr
+	for {
+		var op string
+		t, ok := ts.peek()
+		switch {
+		case !ok:
+		case t.kind == tokenOperator:
+			switch t.s {
+			case "==", "!=", "<", "<=", ">", ">=":
+				op = t.s
+				ts.i++
+			}
+		case t.kind == tokenName && t.s == "in":
+			op = "in"
+			ts.i++
+		case t.kind == tokenName && t.s == "not" &&
+			ts.i+1 < len(ts.tks) && ts.tks[ts.i+1].kind == tokenName && ts.tks[ts.i+1].s == "in":
+			op = "not in"
+			ts.i += 2
+		}
+		if op == "" {
+			break
+		}
+		y, err := ts.parseMath1()
+		if err != nil {
+			return nil, err
+		}
+		n.ops = append(n.ops, op)
+		n.ys = append(n.ys, y)
+	}
+	if len(n.ops) == 0 {
+		return x, nil
+	}
+	n.x = x
+	return n, nil
+}
+
+func (ts *tokenStream) parseBinary(next func() (expr, error), ops ...string) (expr, error) {
+	x, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := ts.peek()
+		if !ok || t.kind != tokenOperator {
+			return x, nil
+		}
+		match := false
+		for _, op := range ops {
+			if t.s == op {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return x, nil
+		}
+		ts.i++
+		y, err := next()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: t.s, l: x, r: y}
+	}
+}
+
+func (ts *tokenStream) parseMath1() (expr, error) {
+	return ts.parseBinary(ts.parseConcat, "+", "-")
+}
+
+func (ts *tokenStream) parseConcat() (expr, error) {
+	return ts.parseBinary(ts.parseMath2, "~")
+}
+
+func (ts *tokenStream) parseMath2() (expr, error) {
+	return ts.parseBinary(ts.parsePow, "*", "/", "//", "%")
+}
+
+func (ts *tokenStream) parsePow() (expr, error) {
+	return ts.parseBinary(func() (expr, error) { return ts.parseUnary(true) }, "**")
+}
+
+func (ts *tokenStream) parseUnary(withFilter bool) (x expr, err error) {
+	switch {
+	case ts.skipOperator("-"):
+		if x, err = ts.parseUnary(false); err != nil {
+			return nil, err
+		}
+		x = unaryExpr{op: "-", x: x}
+	case ts.skipOperator("+"):
+		if x, err = ts.parseUnary(false); err != nil {
+			return nil, err
+		}
+	default:
+		if x, err = ts.parsePrimary(); err != nil {
+			return nil, err
+		}
+	}
+	if x, err = ts.parsePostfix(x); err != nil {
+		return nil, err
+	}
+	if withFilter {
+		return ts.parseFilter(x)
+	}
+	return x, nil
+}
+
+func (ts *tokenStream) parsePrimary() (expr, error) {
+	t, ok := ts.peek()
+	if !ok {
+		return nil, ts.errorf("unexpected end of expression")
+	}
+	ts.i++
+
+	switch t.kind {
+	case tokenName:
+		switch t.s {
+		case "true", "True":
+			return literalExpr{value: true}, nil
+		case "false", "False":
+			return literalExpr{value: false}, nil
+		case "none", "None":
+			return literalExpr{value: nil}, nil
+		}
+		return nameExpr{name: t.s}, nil
+	case tokenString:
+		s := t.s
+		for {
+			n, ok := ts.peek()
+			if !ok || n.kind != tokenString {
+				break
+			}
+			s += n.s
+			ts.i++
+		}
+		return literalExpr{value: s}, nil
+	case tokenInt:
+		v, err := strconv.ParseInt(t.s, 10, 64)
+		if err != nil {
+			return nil, ts.errorf("invalid integer %q", t.s)
+		}
+		return literalExpr{value: v}, nil
+	case tokenFloat:
+		v, err := strconv.ParseFloat(t.s, 64)
+		if err != nil {
+			return nil, ts.errorf("invalid float %q", t.s)
+		}
+		return literalExpr{value: v}, nil
+	}
+
+	switch t.s {
+	case "(":
+		if ts.skipOperator(")") {
+			return listExpr{}, nil
+		}
+		x, err := ts.parseTuple(true)
+		if err != nil {
+			return nil, err
+		}
+		return x, ts.expectOperator(")")
+	case "[":
+		var n listExpr
+		for !ts.skipOperator("]") {
+			if len(n.items) != 0 {
+				if err := ts.expectOperator(","); err != nil {
+					return nil, err
+				}
+				if ts.skipOperator("]") {
+					break
+				}
+			}
+			x, err := ts.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			n.items = append(n.items, x)
+		}
+		return n, nil
+	case "{":
+		var n dictExpr
+		for !ts.skipOperator("}") {
+			if len(n.keys) != 0 {
+				if err := ts.expectOperator(","); err != nil {
+					return nil, err
+				}
+				if ts.skipOperator("}") {
+					break
+				}
+			}
+			k, err := ts.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err = ts.expectOperator(":"); err != nil {
+				return nil, err
+			}
+			v, err := ts.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			n.keys = append(n.keys, k)
+			n.values = append(n.values, v)
+		}
+		return n, nil
+	}
+	return nil, ts.errorf("unexpected %q", t.s)
+}
+
+func (ts *tokenStream) parsePostfix(x expr) (expr, error) {
+	for {
+		switch {
+		case ts.skipOperator("."):
+			t, ok := ts.peek()
+			if !ok || (t.kind != tokenName && t.kind != tokenInt) {
+				return nil, ts.errorf("expected attribute name")
+			}
+			ts.i++
+			if t.kind == tokenInt {
+				i, _ := strconv.ParseInt(t.s, 10, 64)
+				x = indexExpr{x: x, index: literalExpr{value: i}}
+			} else {
+				x = attrExpr{x: x, name: t.s}
+			}
+		case ts.skipOperator("["):
+			var (
+				parts [3]expr
+				n     int
+			)
+			for {
+				if !ts.peekOperator(":") && !ts.peekOperator("]") {
+					v, err := ts.parseExpr()
+					if err != nil {
+						return nil, err
+					}
+					parts[n] = v
+				}
+				if n < 2 && ts.skipOperator(":") {
+					n++
+					continue
+				}
+				break
+			}
+			if err := ts.expectOperator("]"); err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				if parts[0] == nil {
+					return nil, ts.errorf("expected subscript")
+				}
+				x = indexExpr{x: x, index: parts[0]}
+			} else {
+				x = sliceExpr{x: x, start: parts[0], stop: parts[1], step: parts[2]}
+			}
+		case ts.skipOperator("("):
+			args, err := ts.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			x = callExpr{fn: x, args: args}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// parseArguments parses the arguments after the left parenthesis.
+func (ts *tokenStream) parseArguments() (args arguments, err error) {
+	for !ts.skipOperator(")") {
+		if len(args.positional)+len(args.keywords) != 0 {
+			if err = ts.expectOperator(","); err != nil {
+				return args, err
+			}
+			if ts.skipOperator(")") {
+				break
+			}
+		}
+		if t, ok := ts.peek(); ok && t.kind == tokenName &&
+			ts.i+1 < len(ts.tks) && ts.tks[ts.i+1].kind == tokenOperator && ts.tks[ts.i+1].s == "=" {
+			ts.i += 2
+			v, err := ts.parseExpr()
+			if err != nil {
+				return args, err
+			}
+			args.names = append(args.names, t.s)
+			args.keywords = append(args.keywords, v)
+			continue
+		}
+		if len(args.keywords) != 0 {
+			return args, ts.errorf("positional argument follows keyword argument")
+		}
+		v, err := ts.parseExpr()
+		if err != nil {
+			return args, err
+		}
+		args.positional = append(args.positional, v)
+	}
+	return args, nil
+}
+
+func (ts *tokenStream) parseFilter(x expr) (expr, error) {
+	for {
+		switch {
+		case ts.skipOperator("|"):
+			name, err := ts.expectName()
+			if err != nil {
+				return nil, err
+			}
+			n := filterExpr{x: x, name: name}
+			if ts.skipOperator("(") {
+				if n.args, err = ts.parseArguments(); err != nil {
+					return nil, err
+				}
+			}
+			x = n
+		case ts.skipName("is"):
+			n := testExpr{x: x, negate: ts.skipName("not")}
+			var err error
+			if n.name, err = ts.expectName(); err != nil {
+				return nil, err
+			}
+			if ts.skipOperator("(") {
+				if n.args, err = ts.parseArguments(); err != nil {
+					return nil, err
+				}
+			} else if ts.startsTestArgument() {
+				a, err := ts.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				if a, err = ts.parsePostfix(a); err != nil {
+					return nil, err
+				}
+				n.args.positional = []expr{a}
+			}
+			x = n
+		default:
+			return x, nil
+		}
+	}
+}
+
+// startsTestArgument reports whether the next token starts the argument of a test without parentheses,
+// e.g. `x is divisibleby 3`.
+func (ts *tokenStream) startsTestArgument() bool {
+	t, ok := ts.peek()
+	if !ok {
+		return false
+	}
+	switch t.kind {
+	case tokenName:
+		switch t.s {
+		case "else", "or", "and", "if", "in", "not", "is":
+			return false
+		}
+		return true
+	case tokenOperator:
+		return t.s == "[" || t.s == "{"
+	}
+	return true
+}
+
+// parse parses the template source into nodes.
+func parse(src string) ([]node, error) {
+	segs, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{segs: segs}
+	nodes, _, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	if p.i != len(p.segs) {
+		return nil, errors.New("unexpected end statement")
+	}
+	return nodes, nil
+}
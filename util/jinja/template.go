@@ -0,0 +1,777 @@
+// Package jinja implements a subset of Jinja2,
+// which covers the syntax used by the chat templates of HuggingFace transformers.
+package jinja
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Template is a parsed Jinja2 template.
+type Template struct {
+	nodes []node
+}
+
+// RaiseError is the error raised by the `raise_exception` function in the template.
+type RaiseError struct {
+	Message string
+}
+
+func (e *RaiseError) Error() string {
+	return e.Message
+}
+
+var (
+	errBreak    = errors.New("break")
+	errContinue = errors.New("continue")
+)
+
+// Parse parses the source into a Template.
+func Parse(src string) (*Template, error) {
+	nodes, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{nodes: nodes}, nil
+}
+
+// Render renders the template with the given variables.
+func (t *Template) Render(vars map[string]any) (string, error) {
+	sc := &scope{vars: map[string]any{}}
+	for k, v := range globals {
+		sc.vars[k] = v
+	}
+	sc = sc.child()
+	for k, v := range vars {
+		sc.vars[k] = toValue(v)
+	}
+
+	var sb strings.Builder
+	if err := execute(t.nodes, sc, &sb); err != nil {
+		if errors.Is(err, errBreak) || errors.Is(err, errContinue) {
+			return "", fmt.Errorf("%v outside loop", err)
+		}
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+type scope struct {
+	vars   map[string]any
+	parent *scope
+}
+
+func (s *scope) child() *scope {
+	return &scope{vars: map[string]any{}, parent: s}
+}
+
+func (s *scope) lookup(name string) (any, bool) {
+	for c := s; c != nil; c = c.parent {
+		if v, ok := c.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func execute(nodes []node, sc *scope, sb *strings.Builder) error {
+	for _, n := range nodes {
+		if err := executeNode(n, sc, sb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func executeNode(n node, sc *scope, sb *strings.Builder) error {
+	switch n := n.(type) {
+	case textNode:
+		sb.WriteString(n.text)
+	case outputNode:
+		v, err := evaluate(n.expr, sc)
+		if err != nil {
+			return err
+		}
+		sb.WriteString(toString(v))
+	case ifNode:
+		for i := range n.conds {
+			v, err := evaluate(n.conds[i], sc)
+			if err != nil {
+				return err
+			}
+			if truthy(v) {
+				return execute(n.bodies[i], sc, sb)
+			}
+		}
+		return execute(n.elseBody, sc, sb)
+	case forNode:
+		return executeFor(n, sc, sb)
+	case setNode:
+		var (
+			v   any
+			err error
+		)
+		if n.body != nil {
+			var bsb strings.Builder
+			if err = execute(n.body, sc, &bsb); err != nil {
+				return err
+			}
+			v = bsb.String()
+		} else if v, err = evaluate(n.value, sc); err != nil {
+			return err
+		}
+		if n.attr {
+			ns, _ := sc.lookup(n.targets[0])
+			d, ok := ns.(*Dict)
+			if !ok || !d.namespace {
+				return fmt.Errorf("cannot assign attribute on non-namespace %q", n.targets[0])
+			}
+			d.set(n.targets[1], v)
+			return nil
+		}
+		return assign(sc, n.targets, v)
+	case macroNode:
+		sc.vars[n.name] = macro(n, sc)
+	case groupNode:
+		return execute(n.body, sc, sb)
+	case breakNode:
+		return errBreak
+	case continueNode:
+		return errContinue
+	}
+	return nil
+}
+
+// assign assigns the value to the targets in the scope,
+// unpacks the value if there are multiple targets.
+func assign(sc *scope, targets []string, v any) error {
+	if len(targets) == 1 {
+		sc.vars[targets[0]] = v
+		return nil
+	}
+	items, err := iterate(v)
+	if err != nil {
+		return err
+	}
+	if len(items) != len(targets) {
+		return fmt.Errorf("cannot unpack %d values into %d variables", len(items), len(targets))
+	}
+	for i := range targets {
+		sc.vars[targets[i]] = items[i]
+	}
+	return nil
+}
+
+func executeFor(n forNode, sc *scope, sb *strings.Builder) error {
+	iv, err := evaluate(n.iter, sc)
+	if err != nil {
+		return err
+	}
+	items, err := iterate(iv)
+	if err != nil {
+		return err
+	}
+
+	lsc := sc.child()
+	if n.cond != nil {
+		var filtered []any
+		for _, item := range items {
+			if err = assign(lsc, n.targets, item); err != nil {
+				return err
+			}
+			c, err := evaluate(n.cond, lsc)
+			if err != nil {
+				return err
+			}
+			if truthy(c) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	if len(items) == 0 {
+		return execute(n.elseBody, sc, sb)
+	}
+
+	for i, item := range items {
+		if err = assign(lsc, n.targets, item); err != nil {
+			return err
+		}
+		loop := NewDict()
+		loop.set("index", int64(i+1))
+		loop.set("index0", int64(i))
+		loop.set("revindex", int64(len(items)-i))
+		loop.set("revindex0", int64(len(items)-i-1))
+		loop.set("first", i == 0)
+		loop.set("last", i == len(items)-1)
+		loop.set("length", int64(len(items)))
+		if i > 0 {
+			loop.set("previtem", items[i-1])
+		} else {
+			loop.set("previtem", undefined{name: "previtem"})
+		}
+		if i < len(items)-1 {
+			loop.set("nextitem", items[i+1])
+		} else {
+			loop.set("nextitem", undefined{name: "nextitem"})
+		}
+		loop.set("cycle", Func(func(args []any, _ *Dict) (any, error) {
+			if len(args) == 0 {
+				return nil, errors.New("no items for cycling given")
+			}
+			return args[i%len(args)], nil
+		}))
+		lsc.vars["loop"] = loop
+
+		err = execute(n.body, lsc, sb)
+		switch {
+		case errors.Is(err, errBreak):
+			return nil
+		case errors.Is(err, errContinue):
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+// macro returns the Func of the macro,
+// which renders the body with the arguments in a new scope.
+func macro(n macroNode, sc *scope) Func {
+	return func(args []any, kwargs *Dict) (any, error) {
+		msc := sc.child()
+		for i, p := range n.params {
+			switch {
+			case i < len(args):
+				msc.vars[p] = args[i]
+			default:
+				if v, ok := kwargs.Get(p); ok {
+					msc.vars[p] = v
+					continue
+				}
+				if n.defaults[i] == nil {
+					msc.vars[p] = undefined{name: p}
+					continue
+				}
+				v, err := evaluate(n.defaults[i], msc)
+				if err != nil {
+					return nil, err
+				}
+				msc.vars[p] = v
+			}
+		}
+		var sb strings.Builder
+		if err := execute(n.body, msc, &sb); err != nil {
+			return nil, err
+		}
+		return sb.String(), nil
+	}
+}
+
+func evaluate(x expr, sc *scope) (any, error) {
+	switch x := x.(type) {
+	case literalExpr:
+		return x.value, nil
+	case nameExpr:
+		if v, ok := sc.lookup(x.name); ok {
+			return v, nil
+		}
+		return undefined{name: x.name}, nil
+	case listExpr:
+		r := make([]any, len(x.items))
+		for i := range x.items {
+			v, err := evaluate(x.items[i], sc)
+			if err != nil {
+				return nil, err
+			}
+			r[i] = v
+		}
+		return r, nil
+	case dictExpr:
+		d := NewDict()
+		for i := range x.keys {
+			k, err := evaluate(x.keys[i], sc)
+			if err != nil {
+				return nil, err
+			}
+			v, err := evaluate(x.values[i], sc)
+			if err != nil {
+				return nil, err
+			}
+			d.set(toString(k), v)
+		}
+		return d, nil
+	case attrExpr:
+		v, err := evaluate(x.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.(undefined); ok {
+			return nil, fmt.Errorf("%s is undefined", describe(x.x))
+		}
+		return getAttr(v, x.name), nil
+	case indexExpr:
+		v, err := evaluate(x.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.(undefined); ok {
+			return nil, fmt.Errorf("%s is undefined", describe(x.x))
+		}
+		i, err := evaluate(x.index, sc)
+		if err != nil {
+			return nil, err
+		}
+		return getItem(v, i), nil
+	case sliceExpr:
+		return evaluateSlice(x, sc)
+	case callExpr:
+		fn, err := evaluate(x.fn, sc)
+		if err != nil {
+			return nil, err
+		}
+		f, ok := fn.(Func)
+		if !ok {
+			return nil, fmt.Errorf("%s is not callable", describe(x.fn))
+		}
+		args, kwargs, err := evaluateArguments(x.args, sc)
+		if err != nil {
+			return nil, err
+		}
+		return f(args, kwargs)
+	case filterExpr:
+		f, ok := filters[x.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %q", x.name)
+		}
+		v, err := evaluate(x.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		args, kwargs, err := evaluateArguments(x.args, sc)
+		if err != nil {
+			return nil, err
+		}
+		return f(v, args, kwargs)
+	case testExpr:
+		t, ok := tests[x.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown test %q", x.name)
+		}
+		v, err := evaluate(x.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		args, _, err := evaluateArguments(x.args, sc)
+		if err != nil {
+			return nil, err
+		}
+		r, err := t(v, args)
+		if err != nil {
+			return nil, err
+		}
+		return r != x.negate, nil
+	case unaryExpr:
+		v, err := evaluate(x.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		if x.op == "not" {
+			return !truthy(v), nil
+		}
+		i, f, isFloat, ok := toNumber(v)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("bad operand type for unary -: %s", typeName(v))
+		case isFloat:
+			return -f, nil
+		}
+		return -i, nil
+	case binaryExpr:
+		l, err := evaluate(x.l, sc)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "and":
+			if !truthy(l) {
+				return l, nil
+			}
+			return evaluate(x.r, sc)
+		case "or":
+			if truthy(l) {
+				return l, nil
+			}
+			return evaluate(x.r, sc)
+		}
+		r, err := evaluate(x.r, sc)
+		if err != nil {
+			return nil, err
+		}
+		return arithmetic(x.op, l, r)
+	case compareExpr:
+		l, err := evaluate(x.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		for i, op := range x.ops {
+			r, err := evaluate(x.ys[i], sc)
+			if err != nil {
+				return nil, err
+			}
+			ok, err := compareOp(op, l, r)
+			if err != nil || !ok {
+				return false, err
+			}
+			l = r
+		}
+		return true, nil
+	case condExpr:
+		c, err := evaluate(x.cond, sc)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(c) {
+			return evaluate(x.x, sc)
+		}
+		if x.y == nil {
+			return undefined{}, nil
+		}
+		return evaluate(x.y, sc)
+	}
+	return nil, fmt.Errorf("unknown expression %T", x)
+}
+
+// describe returns the name of the expression for error messages.
+func describe(x expr) string {
+	switch x := x.(type) {
+	case nameExpr:
+		return fmt.Sprintf("%q", x.name)
+	case attrExpr:
+		return fmt.Sprintf("%q", x.name)
+	}
+	return "value"
+}
+
+func evaluateArguments(a arguments, sc *scope) ([]any, *Dict, error) {
+	args := make([]any, len(a.positional))
+	for i := range a.positional {
+		v, err := evaluate(a.positional[i], sc)
+		if err != nil {
+			return nil, nil, err
+		}
+		args[i] = v
+	}
+	kwargs := NewDict()
+	for i := range a.keywords {
+		v, err := evaluate(a.keywords[i], sc)
+		if err != nil {
+			return nil, nil, err
+		}
+		kwargs.set(a.names[i], v)
+	}
+	return args, kwargs, nil
+}
+
+func evaluateSlice(x sliceExpr, sc *scope) (any, error) {
+	v, err := evaluate(x.x, sc)
+	if err != nil {
+		return nil, err
+	}
+	var bounds [3]*int64
+	for i, b := range []expr{x.start, x.stop, x.step} {
+		if b == nil {
+			continue
+		}
+		bv, err := evaluate(b, sc)
+		if err != nil {
+			return nil, err
+		}
+		if bv == nil {
+			continue
+		}
+		n, _, isFloat, ok := toNumber(bv)
+		if !ok || isFloat {
+			return nil, errors.New("slice indices must be integers")
+		}
+		bounds[i] = &n
+	}
+
+	switch vv := v.(type) {
+	case []any:
+		idx, err := sliceIndices(len(vv), bounds)
+		if err != nil {
+			return nil, err
+		}
+		r := make([]any, len(idx))
+		for i, j := range idx {
+			r[i] = vv[j]
+		}
+		return r, nil
+	case string:
+		rs := []rune(vv)
+		idx, err := sliceIndices(len(rs), bounds)
+		if err != nil {
+			return nil, err
+		}
+		r := make([]rune, len(idx))
+		for i, j := range idx {
+			r[i] = rs[j]
+		}
+		return string(r), nil
+	case undefined:
+		return vv, nil
+	}
+	return nil, fmt.Errorf("%s is not sliceable", typeName(v))
+}
+
+// sliceIndices returns the indices selected by the slice as Python.
+func sliceIndices(n int, bounds [3]*int64) ([]int, error) {
+	step := int64(1)
+	if bounds[2] != nil {
+		step = *bounds[2]
+	}
+	if step == 0 {
+		return nil, errors.New("slice step cannot be zero")
+	}
+
+	clamp := func(b *int64, def int64) int64 {
+		if b == nil {
+			return def
+		}
+		i := *b
+		if i < 0 {
+			i += int64(n)
+		}
+		lo, hi := int64(0), int64(n)
+		if step < 0 {
+			lo, hi = -1, int64(n)-1
+		}
+		return min(max(i, lo), hi)
+	}
+
+	var idx []int
+	if step > 0 {
+		for i := clamp(bounds[0], 0); i < clamp(bounds[1], int64(n)); i += step {
+			idx = append(idx, int(i))
+		}
+	} else {
+		for i := clamp(bounds[0], int64(n)-1); i > clamp(bounds[1], -1); i += step {
+			idx = append(idx, int(i))
+		}
+	}
+	return idx, nil
+}
+
+func arithmetic(op string, l, r any) (any, error) {
+	if op == "~" {
+		return toString(l) + toString(r), nil
+	}
+
+	li, lf, lIsFloat, lok := toNumber(l)
+	ri, rf, rIsFloat, rok := toNumber(r)
+	if lok && rok {
+		isFloat := lIsFloat || rIsFloat
+		switch op {
+		case "+":
+			if isFloat {
+				return lf + rf, nil
+			}
+			return li + ri, nil
+		case "-":
+			if isFloat {
+				return lf - rf, nil
+			}
+			return li - ri, nil
+		case "*":
+			if isFloat {
+				return lf * rf, nil
+			}
+			return li * ri, nil
+		case "/":
+			if rf == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return lf / rf, nil
+		case "//":
+			if rf == 0 {
+				return nil, errors.New("integer division by zero")
+			}
+			if isFloat {
+				return math.Floor(lf / rf), nil
+			}
+			q := li / ri
+			if (li%ri != 0) && ((li < 0) != (ri < 0)) {
+				q--
+			}
+			return q, nil
+		case "%":
+			if rf == 0 {
+				return nil, errors.New("modulo by zero")
+			}
+			if isFloat {
+				m := math.Mod(lf, rf)
+				if m != 0 && (m < 0) != (rf < 0) {
+					m += rf
+				}
+				return m, nil
+			}
+			m := li % ri
+			if m != 0 && (m < 0) != (ri < 0) {
+				m += ri
+			}
+			return m, nil
+		case "**":
+			if !isFloat && ri >= 0 {
+				p := int64(1)
+				for i := int64(0); i < ri; i++ {
+					p *= li
+				}
+				return p, nil
+			}
+			return math.Pow(lf, rf), nil
+		}
+	}
+
+	switch op {
+	case "%":
+		if s, ok := l.(string); ok {
+			switch rv := r.(type) {
+			case []any:
+				return printf(s, rv, nil)
+			case *Dict:
+				return printf(s, nil, rv)
+			}
+			return printf(s, []any{r}, nil)
+		}
+	case "+":
+		switch lv := l.(type) {
+		case string:
+			if rv, ok := r.(string); ok {
+				return lv + rv, nil
+			}
+		case []any:
+			if rv, ok := r.([]any); ok {
+				return append(append(make([]any, 0, len(lv)+len(rv)), lv...), rv...), nil
+			}
+		}
+	case "*":
+		if s, ok := l.(string); ok && rok && !rIsFloat {
+			return strings.Repeat(s, int(max(ri, 0))), nil
+		}
+		if lv, ok := l.([]any); ok && rok && !rIsFloat {
+			var res []any
+			for i := int64(0); i < ri; i++ {
+				res = append(res, lv...)
+			}
+			return res, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported operand type(s) for %s: %s and %s", op, typeName(l), typeName(r))
+}
+
+func compareOp(op string, l, r any) (bool, error) {
+	switch op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		return contains(r, l)
+	case "not in":
+		ok, err := contains(r, l)
+		return !ok, err
+	}
+	c, err := compare(l, r)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+// contains reports whether the container contains the item.
+func contains(container, item any) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("'in <string>' requires string as left operand, not %s", typeName(item))
+		}
+		return strings.Contains(c, s), nil
+	case []any:
+		for i := range c {
+			if equal(c[i], item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *Dict:
+		_, ok := c.values[toString(item)]
+		return ok, nil
+	case undefined:
+		return false, nil
+	}
+	return false, fmt.Errorf("argument of type %s is not iterable", typeName(container))
+}
+
+// getAttr returns the attribute of the value,
+// which is the item of a Dict, or the method of the value.
+func getAttr(v any, name string) any {
+	if d, ok := v.(*Dict); ok {
+		if r, ok := d.values[name]; ok {
+			return r
+		}
+	}
+	if m := method(v, name); m != nil {
+		return m
+	}
+	return undefined{name: name}
+}
+
+// getItem returns the item of the value,
+// falls back to the attribute of the value.
+func getItem(v, key any) any {
+	switch vv := v.(type) {
+	case []any:
+		if i, ok := key.(int64); ok {
+			if i < 0 {
+				i += int64(len(vv))
+			}
+			if i >= 0 && i < int64(len(vv)) {
+				return vv[i]
+			}
+			return undefined{}
+		}
+	case string:
+		if i, ok := key.(int64); ok {
+			rs := []rune(vv)
+			if i < 0 {
+				i += int64(len(rs))
+			}
+			if i >= 0 && i < int64(len(rs)) {
+				return string(rs[i])
+			}
+			return undefined{}
+		}
+	case *Dict:
+		if r, ok := vv.values[toString(key)]; ok {
+			return r
+		}
+		return undefined{name: toString(key)}
+	}
+	if s, ok := key.(string); ok {
+		return getAttr(v, s)
+	}
+	return undefined{}
+}
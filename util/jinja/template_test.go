@@ -0,0 +1,433 @@
+package jinja
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type renderCase struct {
+	name     string
+	given    string
+	vars     map[string]any
+	expected string
+}
+
+func testRender(t *testing.T, cases []renderCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := Parse(tc.given)
+			require.NoError(t, err)
+			actual, err := tmpl.Render(tc.vars)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestTemplate_Render_Statements(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name:     "text",
+			given:    "hello",
+			expected: "hello",
+		},
+		{
+			name:     "output",
+			given:    "{{ a }}-{{ b }}",
+			vars:     map[string]any{"a": 1, "b": "x"},
+			expected: "1-x",
+		},
+		{
+			name:     "comment",
+			given:    "a{# ignored #}b",
+			expected: "ab",
+		},
+		{
+			name:     "if",
+			given:    "{% if x %}yes{% endif %}",
+			vars:     map[string]any{"x": true},
+			expected: "yes",
+		},
+		{
+			name:     "if elif else",
+			given:    "{% if x == 1 %}one{% elif x == 2 %}two{% else %}many{% endif %}",
+			vars:     map[string]any{"x": 2},
+			expected: "two",
+		},
+		{
+			name:     "if else",
+			given:    "{% if x %}yes{% else %}no{% endif %}",
+			vars:     map[string]any{"x": ""},
+			expected: "no",
+		},
+		{
+			name:     "for",
+			given:    "{% for x in xs %}{{ x }},{% endfor %}",
+			vars:     map[string]any{"xs": []any{1, 2, 3}},
+			expected: "1,2,3,",
+		},
+		{
+			name:     "for else",
+			given:    "{% for x in xs %}{{ x }}{% else %}empty{% endfor %}",
+			vars:     map[string]any{"xs": []any{}},
+			expected: "empty",
+		},
+		{
+			name:     "for if",
+			given:    "{% for x in xs if x is odd %}{{ x }}{% endfor %}",
+			vars:     map[string]any{"xs": []any{1, 2, 3, 4, 5}},
+			expected: "135",
+		},
+		{
+			name:     "for unpack",
+			given:    "{% for k, v in d.items() %}{{ k }}={{ v }};{% endfor %}",
+			vars:     map[string]any{"d": map[string]any{"a": 1}},
+			expected: "a=1;",
+		},
+		{
+			name:     "for break and continue",
+			given:    "{% for x in range(10) %}{% if x == 1 %}{% continue %}{% endif %}{% if x == 4 %}{% break %}{% endif %}{{ x }}{% endfor %}",
+			expected: "023",
+		},
+		{
+			name:     "set",
+			given:    "{% set x = 1 + 2 %}{{ x }}",
+			expected: "3",
+		},
+		{
+			name:     "set block",
+			given:    "{% set x %}a{{ 1 }}b{% endset %}[{{ x }}]",
+			expected: "[a1b]",
+		},
+		{
+			name:     "set in loop is scoped",
+			given:    "{% set x = 0 %}{% for i in range(3) %}{% set x = i %}{% endfor %}{{ x }}",
+			expected: "0",
+		},
+		{
+			name:     "macro",
+			given:    "{% macro greet(name, greeting='Hello') %}{{ greeting }}, {{ name }}!{% endmacro %}{{ greet('A') }} {{ greet('B', greeting='Hi') }}",
+			expected: "Hello, A! Hi, B!",
+		},
+		{
+			name:     "generation",
+			given:    "{% generation %}text{% endgeneration %}",
+			expected: "text",
+		},
+		{
+			name:     "raw",
+			given:    "{% raw %}{{ not rendered }}{% endraw %}",
+			expected: "{{ not rendered }}",
+		},
+		{
+			name:     "namespace",
+			given:    "{% set ns = namespace(found=false, n=0) %}{% for x in xs %}{% if x == 2 %}{% set ns.found = true %}{% endif %}{% set ns.n = ns.n + x %}{% endfor %}{{ ns.found }} {{ ns.n }}",
+			vars:     map[string]any{"xs": []any{1, 2, 3}},
+			expected: "True 6",
+		},
+	})
+}
+
+func TestTemplate_Render_Loop(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name:     "index",
+			given:    "{% for x in 'abc' %}{{ loop.index }}{{ loop.index0 }}{% endfor %}",
+			expected: "102132",
+		},
+		{
+			name:     "revindex",
+			given:    "{% for x in 'abc' %}{{ loop.revindex }}{{ loop.revindex0 }}{% endfor %}",
+			expected: "322110",
+		},
+		{
+			name:     "first and last",
+			given:    "{% for x in 'abc' %}{% if loop.first %}[{% endif %}{{ x }}{% if loop.last %}]{% endif %}{% endfor %}",
+			expected: "[abc]",
+		},
+		{
+			name:     "length",
+			given:    "{% for x in 'abc' %}{{ loop.length }}{% endfor %}",
+			expected: "333",
+		},
+		{
+			name:     "previtem and nextitem",
+			given:    "{% for x in 'abc' %}{{ loop.previtem | default('-') }}{{ loop.nextitem | default('-') }} {% endfor %}",
+			expected: "-b ac b- ",
+		},
+		{
+			name:     "cycle",
+			given:    "{% for x in 'abc' %}{{ loop.cycle('odd', 'even') }} {% endfor %}",
+			expected: "odd even odd ",
+		},
+		{
+			name:     "nested",
+			given:    "{% for x in 'ab' %}{% for y in 'cd' %}{{ loop.index }}{% endfor %}{{ loop.index }}{% endfor %}",
+			expected: "121122",
+		},
+	})
+}
+
+func TestTemplate_Render_WhitespaceControl(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name:     "trim left and right",
+			given:    "a  {{- 1 -}}  b",
+			expected: "a1b",
+		},
+		{
+			name:     "trim blocks",
+			given:    "{% if true %}\nx\n{% endif %}\n",
+			expected: "x\n",
+		},
+		{
+			name:     "lstrip blocks",
+			given:    "  {% if true %}\n  x\n  {% endif %}\n",
+			expected: "  x\n",
+		},
+		{
+			name:     "keep left",
+			given:    "  {%+ if true %}x{% endif %}",
+			expected: "  x",
+		},
+		{
+			name:     "keep right",
+			given:    "{% if true +%}\nx{% endif %}",
+			expected: "\nx",
+		},
+		{
+			name:     "trim statements",
+			given:    "a\n  {%- if true -%}\n  b\n  {%- endif -%}\n  c",
+			expected: "abc",
+		},
+		{
+			name:     "output keeps whitespaces",
+			given:    "  {{ 1 }}\n",
+			expected: "  1\n",
+		},
+		{
+			name:     "trim comment",
+			given:    "a\n{#- c -#}\nb",
+			expected: "ab",
+		},
+	})
+}
+
+func TestTemplate_Render_Expressions(t *testing.T) {
+	testRender(t, []renderCase{
+		{
+			name:     "arithmetic",
+			given:    "{{ 1 + 2 * 3 }} {{ (1 + 2) * 3 }} {{ 7 // 2 }} {{ 7 % 3 }} {{ 7 / 2 }} {{ 2 ** 3 }} {{ -1 }}",
+			expected: "7 9 3 1 3.5 8 -1",
+		},
+		{
+			name:     "string concat",
+			given:    "{{ 'a' + 'b' }} {{ 'a' ~ 1 }} {{ 'ab' * 2 }}",
+			expected: "ab a1 abab",
+		},
+		{
+			name:     "comparison",
+			given:    "{{ 1 < 2 }} {{ 1 == 1.0 }} {{ 'a' != 'b' }} {{ 2 >= 3 }}",
+			expected: "True True True False",
+		},
+		{
+			name:     "logic",
+			given:    "{{ true and false }} {{ true or false }} {{ not true }} {{ none or 'x' }}",
+			expected: "False True False x",
+		},
+		{
+			name:     "in",
+			given:    "{{ 'a' in 'abc' }} {{ 1 in [1, 2] }} {{ 'k' in {'k': 1} }} {{ 3 not in [1, 2] }}",
+			expected: "True True True True",
+		},
+		{
+			name:     "conditional",
+			given:    "{{ 'y' if x else 'n' }} {{ 'y' if not x }}",
+			vars:     map[string]any{"x": false},
+			expected: "n y",
+		},
+		{
+			name:     "attribute and subscript",
+			given:    "{{ m.role }} {{ m['content'] }} {{ xs[1] }} {{ xs[-1] }}",
+			vars:     map[string]any{"m": map[string]any{"role": "user", "content": "hi"}, "xs": []any{1, 2, 3}},
+			expected: "user hi 2 3",
+		},
+		{
+			name:     "literals",
+			given:    "{{ [1, 'a'] }} {{ {'a': 1} }} {{ (1, 2) }} {{ none }} {{ True }}",
+			expected: "[1, 'a'] {'a': 1} [1, 2] None True",
+		},
+		{
+			name:     "undefined",
+			given:    "[{{ missing }}] {{ missing is defined }} {{ missing is undefined }}",
+			expected: "[] False True",
+		},
+		{
+			name:     "string methods",
+			given:    "{{ ' a '.strip() }} {{ 'abc'.startswith('a') }} {{ 'a,b'.split(',') }} {{ 'Ab'.upper() }} {{ 'x{}'.format(1) }}",
+			expected: "a True ['a', 'b'] AB x1",
+		},
+		{
+			name:     "dict methods",
+			given:    "{{ d.get('a') }} {{ d.get('b', 0) }} {{ d.keys() | list }} {{ d.values() | list }}",
+			vars:     map[string]any{"d": map[string]any{"a": 1}},
+			expected: "1 0 ['a'] [1]",
+		},
+	})
+}
+
+func TestTemplate_Render_Slicing(t *testing.T) {
+	vars := map[string]any{"xs": []any{0, 1, 2, 3, 4}, "s": "hello"}
+	testRender(t, []renderCase{
+		{name: "start", given: "{{ xs[2:] }}", vars: vars, expected: "[2, 3, 4]"},
+		{name: "stop", given: "{{ xs[:2] }}", vars: vars, expected: "[0, 1]"},
+		{name: "start and stop", given: "{{ xs[1:3] }}", vars: vars, expected: "[1, 2]"},
+		{name: "negative", given: "{{ xs[-2:] }}", vars: vars, expected: "[3, 4]"},
+		{name: "step", given: "{{ xs[::2] }}", vars: vars, expected: "[0, 2, 4]"},
+		{name: "reverse", given: "{{ xs[::-1] }}", vars: vars, expected: "[4, 3, 2, 1, 0]"},
+		{name: "out of range", given: "{{ xs[3:10] }}", vars: vars, expected: "[3, 4]"},
+		{name: "string", given: "{{ s[1:3] }} {{ s[::-1] }}", vars: vars, expected: "el olleh"},
+	})
+}
+
+func TestTemplate_Render_Filters(t *testing.T) {
+	vars := map[string]any{
+		"xs":    []any{3, 1, 2},
+		"users": []any{map[string]any{"name": "b", "age": 2}, map[string]any{"name": "a", "age": 1}},
+		"d":     map[string]any{"b": 2, "a": 1},
+	}
+	testRender(t, []renderCase{
+		{name: "abs", given: "{{ -3 | abs }}", expected: "3"},
+		{name: "attr", given: "{{ users[0] | attr('name') }}", vars: vars, expected: "b"},
+		{name: "capitalize", given: "{{ 'hELLO' | capitalize }}", expected: "Hello"},
+		{name: "count", given: "{{ xs | count }}", vars: vars, expected: "3"},
+		{name: "length", given: "{{ 'abc' | length }}", expected: "3"},
+		{name: "default", given: "{{ missing | default('x') }} {{ '' | default('x', true) }} {{ '' | d('y') }}", expected: "x x "},
+		{name: "dictsort", given: "{{ d | dictsort }}", vars: vars, expected: "[['a', 1], ['b', 2]]"},
+		{name: "escape", given: "{{ '<a>' | escape }} {{ '&' | e }}", expected: "&lt;a&gt; &amp;"},
+		{name: "format", given: "{{ '%s - %d' | format('a', 2) }} {{ '%05.2f|%-3s|%x' | format(3.14159, 'b', 255) }} {{ '%(n)s%%' | format(n=50) }}", expected: "a - 2 03.14|b  |ff 50%"},
+		{name: "format operator", given: "{{ '%s=%r' % ('a', 'b') }} {{ '%03d' % 7 }}", expected: "a='b' 007"},
+		{name: "first", given: "{{ xs | first }}", vars: vars, expected: "3"},
+		{name: "last", given: "{{ xs | last }}", vars: vars, expected: "2"},
+		{name: "float", given: "{{ '1.5' | float }} {{ 'x' | float }}", expected: "1.5 0.0"},
+		{name: "int", given: "{{ '42' | int }} {{ 1.9 | int }} {{ 'x' | int(7) }}", expected: "42 1 7"},
+		{name: "items", given: "{% for k, v in {'b': 2, 'a': 1} | items %}{{ k }}{{ v }}{% endfor %}", expected: "b2a1"},
+		{name: "join", given: "{{ xs | join(',') }} {{ users | join('/', attribute='name') }}", vars: vars, expected: "3,1,2 b/a"},
+		{name: "list", given: "{{ 'ab' | list }}", expected: "['a', 'b']"},
+		{name: "lower", given: "{{ 'AB' | lower }}", expected: "ab"},
+		{name: "upper", given: "{{ 'ab' | upper }}", expected: "AB"},
+		{name: "title", given: "{{ 'hello world' | title }}", expected: "Hello World"},
+		{name: "map", given: "{{ users | map(attribute='name') | join }} {{ ['1', '2'] | map('int') | sum }}", vars: vars, expected: "ba 3"},
+		{name: "max", given: "{{ xs | max }}", vars: vars, expected: "3"},
+		{name: "min", given: "{{ xs | min }}", vars: vars, expected: "1"},
+		{name: "replace", given: "{{ 'aaa' | replace('a', 'b') }}", expected: "bbb"},
+		{name: "reverse", given: "{{ xs | reverse | list }} {{ 'abc' | reverse }}", vars: vars, expected: "[2, 1, 3] cba"},
+		{name: "round", given: "{{ 2.5 | round }} {{ 1.234 | round(2) }} {{ 1.5 | round(0, 'floor') }}", expected: "3.0 1.23 1.0"},
+		{name: "safe", given: "{{ '<a>' | safe }}", expected: "<a>"},
+		{name: "select", given: "{{ xs | select('odd') | list }}", vars: vars, expected: "[3, 1]"},
+		{name: "reject", given: "{{ xs | reject('odd') | list }}", vars: vars, expected: "[2]"},
+		{name: "selectattr", given: "{{ users | selectattr('age', 'gt', 1) | map(attribute='name') | list }}", vars: vars, expected: "['b']"},
+		{name: "rejectattr", given: "{{ users | rejectattr('age', 'gt', 1) | map(attribute='name') | list }}", vars: vars, expected: "['a']"},
+		{name: "sort", given: "{{ xs | sort }} {{ xs | sort(reverse=true) }} {{ users | sort(attribute='age') | map(attribute='name') | join }}", vars: vars, expected: "[1, 2, 3] [3, 2, 1] ab"},
+		{name: "string", given: "{{ (1 | string) + 'a' }}", expected: "1a"},
+		{name: "sum", given: "{{ xs | sum }} {{ users | sum(attribute='age') }}", vars: vars, expected: "6 3"},
+		{name: "tojson", given: "{{ {'a': [1, 'x', none, true]} | tojson }}", expected: `{"a": [1, "x", null, true]}`},
+		{name: "trim", given: "[{{ '  a  ' | trim }}] [{{ 'xxaxx' | trim('x') }}]", expected: "[a] [a]"},
+		{name: "unique", given: "{{ [1, 2, 1, 3] | unique | list }}", expected: "[1, 2, 3]"},
+		{name: "indent", given: "{{ 'a\nb' | indent(2) }}|{{ 'a\nb' | indent(2, true) }}", expected: "a\n  b|  a\n  b"},
+		{name: "wordcount", given: "{{ 'a b  c' | wordcount }}", expected: "3"},
+		{name: "chain", given: "{{ ' AB ' | trim | lower | length }}", expected: "2"},
+	})
+}
+
+func TestTemplate_Render_Tests(t *testing.T) {
+	testRender(t, []renderCase{
+		{name: "defined", given: "{{ x is defined }} {{ y is defined }}", vars: map[string]any{"x": 1}, expected: "True False"},
+		{name: "none", given: "{{ none is none }} {{ 1 is not none }}", expected: "True True"},
+		{name: "boolean", given: "{{ true is boolean }} {{ 1 is boolean }}", expected: "True False"},
+		{name: "number", given: "{{ 1 is integer }} {{ 1.0 is float }} {{ 1 is number }}", expected: "True True True"},
+		{name: "string", given: "{{ 'a' is string }} {{ 1 is string }}", expected: "True False"},
+		{name: "mapping", given: "{{ {} is mapping }} {{ [] is mapping }}", expected: "True False"},
+		{name: "iterable", given: "{{ [] is iterable }} {{ 'a' is iterable }} {{ 1 is iterable }}", expected: "True True False"},
+		{name: "sequence", given: "{{ [] is sequence }} {{ {} is sequence }} {{ 1 is sequence }}", expected: "True True False"},
+		{name: "callable", given: "{{ range is callable }}", expected: "True"},
+		{name: "case", given: "{{ 'a' is lower }} {{ 'A' is upper }}", expected: "True True"},
+		{name: "odd and even", given: "{{ 3 is odd }} {{ 3 is even }}", expected: "True False"},
+		{name: "divisibleby", given: "{{ 9 is divisibleby 3 }} {{ 9 is divisibleby(2) }}", expected: "True False"},
+		{name: "sameas", given: "{{ none is sameas none }}", expected: "True"},
+		{name: "in", given: "{{ 1 is in [1, 2] }}", expected: "True"},
+		{name: "comparison", given: "{{ 1 is eq 1 }} {{ 1 is lt 2 }} {{ 2 is ge 3 }} {{ 'a' is equalto 'a' }}", expected: "True True False True"},
+	})
+}
+
+func TestTemplate_Render_Globals(t *testing.T) {
+	testRender(t, []renderCase{
+		{name: "range", given: "{{ range(3) | list }} {{ range(1, 6, 2) | list }}", expected: "[0, 1, 2] [1, 3, 5]"},
+		{name: "dict", given: "{{ dict(a=1)['a'] }}", expected: "1"},
+	})
+
+	tmpl, err := Parse("{{ raise_exception('bad role') }}")
+	require.NoError(t, err)
+	_, err = tmpl.Render(nil)
+	var re *RaiseError
+	if assert.True(t, errors.As(err, &re)) {
+		assert.Equal(t, "bad role", re.Message)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := []struct {
+		name     string
+		given    string
+		expected string
+	}{
+		{name: "unclosed comment", given: "a\n{# c", expected: "line 2: unclosed comment"},
+		{name: "unclosed output", given: "a\n\n{{ x", expected: "line 3:"},
+		{name: "unclosed raw", given: "{% raw %}x", expected: "line 1: unclosed raw block"},
+		{name: "missing end", given: "{% if x %}a", expected: `missing "endif"`},
+		{name: "unknown statement", given: "a\n{% foo %}", expected: `line 2: unknown statement "foo"`},
+		{name: "missing in", given: "{% for x of xs %}{% endfor %}", expected: "line 1: expected 'in'"},
+		{name: "unexpected token", given: "\n{{ 1 + }}", expected: "line 2: unexpected end of expression"},
+		{name: "unexpected character", given: "{{ 1 $ 2 }}", expected: `line 1: unexpected character '$'`},
+		{name: "positional after keyword", given: "{{ f(a=1, 2) }}", expected: "line 1: positional argument follows keyword argument"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(tc.given)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestTemplate_Render_Errors(t *testing.T) {
+	cases := []struct {
+		name  string
+		given string
+	}{
+		{name: "break outside loop", given: "{% break %}"},
+		{name: "unknown filter", given: "{{ 1 | nope }}"},
+		{name: "unknown test", given: "{{ 1 is nope }}"},
+		{name: "call undefined", given: "{{ nope() }}"},
+		{name: "attribute of undefined", given: "{{ nope.attr }}"},
+		{name: "subscript of undefined", given: "{{ nope['key'] }}"},
+		{name: "format without enough arguments", given: "{{ '%s %s' | format(1) }}"},
+		{name: "format with too many arguments", given: "{{ '%s' | format(1, 2) }}"},
+		{name: "format with non-number", given: "{{ '%d' | format('a') }}"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := Parse(tc.given)
+			if err != nil {
+				return
+			}
+			_, err = tmpl.Render(nil)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,585 @@
+package jinja
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gpustack/gguf-parser-go/util/json"
+)
+
+type (
+	// Dict is an insertion ordered dictionary of the template.
+	Dict struct {
+		keys   []string
+		values map[string]any
+
+		// namespace indicates the Dict is created by `namespace()`,
+		// which allows to assign attributes.
+		namespace bool
+	}
+
+	// Func is a callable of the template.
+	Func func(args []any, kwargs *Dict) (any, error)
+
+	// undefined is the value of an undefined variable,
+	// which is chainable.
+	undefined struct {
+		name string
+	}
+)
+
+// NewDict returns a new Dict.
+func NewDict() *Dict {
+	return &Dict{values: map[string]any{}}
+}
+
+// Set sets the value of the key,
+// the value is converted to the template value.
+func (d *Dict) Set(key string, value any) {
+	d.set(key, toValue(value))
+}
+
+func (d *Dict) set(key string, value any) {
+	if _, ok := d.values[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.values[key] = value
+}
+
+// Get returns the value of the key.
+func (d *Dict) Get(key string) (any, bool) {
+	if d == nil {
+		return nil, false
+	}
+	v, ok := d.values[key]
+	return v, ok
+}
+
+// Keys returns the keys in insertion order.
+func (d *Dict) Keys() []string {
+	if d == nil {
+		return nil
+	}
+	return d.keys
+}
+
+// Len returns the number of the keys.
+func (d *Dict) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.keys)
+}
+
+// toValue converts the Go value into the template value,
+// which is one of nil, undefined, bool, int64, float64, string, []any, *Dict and Func.
+func toValue(v any) any {
+	switch vv := v.(type) {
+	case nil, undefined, bool, int64, float64, string, *Dict, Func:
+		return vv
+	case int:
+		return int64(vv)
+	case int8:
+		return int64(vv)
+	case int16:
+		return int64(vv)
+	case int32:
+		return int64(vv)
+	case uint:
+		return int64(vv)
+	case uint8:
+		return int64(vv)
+	case uint16:
+		return int64(vv)
+	case uint32:
+		return int64(vv)
+	case uint64:
+		return int64(vv)
+	case float32:
+		return float64(vv)
+	case func(args []any, kwargs *Dict) (any, error):
+		return Func(vv)
+	case []any:
+		r := make([]any, len(vv))
+		for i := range vv {
+			r[i] = toValue(vv[i])
+		}
+		return r
+	case []string:
+		r := make([]any, len(vv))
+		for i := range vv {
+			r[i] = vv[i]
+		}
+		return r
+	case map[string]any:
+		d := NewDict()
+		for _, k := range sortedKeys(vv) {
+			d.set(k, toValue(vv[k]))
+		}
+		return d
+	case map[string]string:
+		d := NewDict()
+		for _, k := range sortedKeys(vv) {
+			d.set(k, vv[k])
+		}
+		return d
+	}
+
+	// Convert via JSON to keep the field order of structs.
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	dec := stdjson.NewDecoder(bytes.NewReader(bs))
+	dec.UseNumber()
+	r, err := decodeJSONValue(dec)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return r
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// decodeJSONValue decodes the next JSON value from the decoder,
+// objects are decoded into Dict in order.
+func decodeJSONValue(dec *stdjson.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tt := t.(type) {
+	case stdjson.Delim:
+		switch tt {
+		case '[':
+			r := []any{}
+			for dec.More() {
+				v, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				r = append(r, v)
+			}
+			_, err = dec.Token()
+			return r, err
+		case '{':
+			d := NewDict()
+			for dec.More() {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				d.set(fmt.Sprint(k), v)
+			}
+			_, err = dec.Token()
+			return d, err
+		}
+		return nil, errors.New("invalid JSON delimiter")
+	case stdjson.Number:
+		if i, err := tt.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := tt.Float64()
+		return f, err
+	}
+	return t, nil
+}
+
+// truthy returns the truth value of the value in Python semantics.
+func truthy(v any) bool {
+	switch vv := v.(type) {
+	case nil, undefined:
+		return false
+	case bool:
+		return vv
+	case int64:
+		return vv != 0
+	case float64:
+		return vv != 0
+	case string:
+		return vv != ""
+	case []any:
+		return len(vv) != 0
+	case *Dict:
+		return vv.Len() != 0
+	}
+	return true
+}
+
+// toString returns the string of the value as Python `str()`.
+func toString(v any) string {
+	switch vv := v.(type) {
+	case undefined:
+		return ""
+	case string:
+		return vv
+	}
+	return repr(v)
+}
+
+// repr returns the representation of the value as Python `repr()`.
+func repr(v any) string {
+	switch vv := v.(type) {
+	case nil:
+		return "None"
+	case undefined:
+		return ""
+	case bool:
+		if vv {
+			return "True"
+		}
+		return "False"
+	case int64:
+		return strconv.FormatInt(vv, 10)
+	case float64:
+		return formatFloat(vv)
+	case string:
+		q := byte('\'')
+		if strings.IndexByte(vv, '\'') >= 0 && strings.IndexByte(vv, '"') < 0 {
+			q = '"'
+		}
+		var sb strings.Builder
+		sb.WriteByte(q)
+		for _, r := range vv {
+			switch {
+			case r == '\\' || r == rune(q):
+				sb.WriteByte('\\')
+				sb.WriteRune(r)
+			case r == '\n':
+				sb.WriteString(`\n`)
+			case r == '\r':
+				sb.WriteString(`\r`)
+			case r == '\t':
+				sb.WriteString(`\t`)
+			case !unicode.IsPrint(r) && r != ' ':
+				if r < 0x100 {
+					fmt.Fprintf(&sb, `\x%02x`, r)
+				} else {
+					fmt.Fprintf(&sb, `\u%04x`, r)
+				}
+			default:
+				sb.WriteRune(r)
+			}
+		}
+		sb.WriteByte(q)
+		return sb.String()
+	case []any:
+		ss := make([]string, len(vv))
+		for i := range vv {
+			ss[i] = repr(vv[i])
+		}
+		return "[" + strings.Join(ss, ", ") + "]"
+	case *Dict:
+		ss := make([]string, len(vv.keys))
+		for i, k := range vv.keys {
+			ss[i] = repr(k) + ": " + repr(vv.values[k])
+		}
+		if vv.namespace {
+			return "<Namespace {" + strings.Join(ss, ", ") + "}>"
+		}
+		return "{" + strings.Join(ss, ", ") + "}"
+	case Func:
+		return "<function>"
+	}
+	return fmt.Sprint(v)
+}
+
+// formatFloat formats the float as Python `repr()`.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	if a := math.Abs(f); a != 0 && (a >= 1e16 || a < 1e-4) {
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		// Python keeps at least two digits of the exponent.
+		if i := strings.IndexByte(s, 'e'); i >= 0 && len(s)-i == 3 {
+			s = s[:i+2] + "0" + s[i+2:]
+		}
+		return s
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsRune(s, '.') {
+		s += ".0"
+	}
+	return s
+}
+
+// toJSON returns the JSON of the value as Python `json.dumps(ensure_ascii=False)`,
+// indents with the given number of spaces if indent is not negative.
+func toJSON(v any, indent int) string {
+	var sb strings.Builder
+	writeJSON(&sb, v, indent, 0)
+	return sb.String()
+}
+
+func writeJSON(sb *strings.Builder, v any, indent, level int) {
+	newline := func(level int) {
+		if indent >= 0 {
+			sb.WriteByte('\n')
+			sb.WriteString(strings.Repeat(" ", indent*level))
+		}
+	}
+	sep := ", "
+	if indent >= 0 {
+		sep = ","
+	}
+
+	switch vv := v.(type) {
+	case nil, undefined:
+		sb.WriteString("null")
+	case bool:
+		sb.WriteString(strconv.FormatBool(vv))
+	case int64:
+		sb.WriteString(strconv.FormatInt(vv, 10))
+	case float64:
+		switch {
+		case math.IsInf(vv, 1):
+			sb.WriteString("Infinity")
+		case math.IsInf(vv, -1):
+			sb.WriteString("-Infinity")
+		case math.IsNaN(vv):
+			sb.WriteString("NaN")
+		default:
+			sb.WriteString(formatFloat(vv))
+		}
+	case string:
+		sb.WriteByte('"')
+		for _, r := range vv {
+			switch r {
+			case '"':
+				sb.WriteString(`\"`)
+			case '\\':
+				sb.WriteString(`\\`)
+			case '\n':
+				sb.WriteString(`\n`)
+			case '\r':
+				sb.WriteString(`\r`)
+			case '\t':
+				sb.WriteString(`\t`)
+			case '\b':
+				sb.WriteString(`\b`)
+			case '\f':
+				sb.WriteString(`\f`)
+			default:
+				if r < 0x20 {
+					fmt.Fprintf(sb, `\u%04x`, r)
+				} else {
+					sb.WriteRune(r)
+				}
+			}
+		}
+		sb.WriteByte('"')
+	case []any:
+		if len(vv) == 0 {
+			sb.WriteString("[]")
+			return
+		}
+		sb.WriteByte('[')
+		for i := range vv {
+			if i > 0 {
+				sb.WriteString(sep)
+			}
+			newline(level + 1)
+			writeJSON(sb, vv[i], indent, level+1)
+		}
+		newline(level)
+		sb.WriteByte(']')
+	case *Dict:
+		if vv.Len() == 0 {
+			sb.WriteString("{}")
+			return
+		}
+		sb.WriteByte('{')
+		for i, k := range vv.keys {
+			if i > 0 {
+				sb.WriteString(sep)
+			}
+			newline(level + 1)
+			writeJSON(sb, k, indent, level+1)
+			sb.WriteString(": ")
+			writeJSON(sb, vv.values[k], indent, level+1)
+		}
+		newline(level)
+		sb.WriteByte('}')
+	default:
+		writeJSON(sb, toString(v), indent, level)
+	}
+}
+
+// toNumber returns the number of the value,
+// and whether the value is a number.
+func toNumber(v any) (i int64, f float64, isFloat, ok bool) {
+	switch vv := v.(type) {
+	case bool:
+		if vv {
+			return 1, 1, false, true
+		}
+		return 0, 0, false, true
+	case int64:
+		return vv, float64(vv), false, true
+	case float64:
+		return int64(vv), vv, true, true
+	}
+	return 0, 0, false, false
+}
+
+// equal reports whether the values are equal in Python semantics.
+func equal(a, b any) bool {
+	if ai, af, aIsFloat, ok := toNumber(a); ok {
+		bi, bf, bIsFloat, ok := toNumber(b)
+		if !ok {
+			return false
+		}
+		if aIsFloat || bIsFloat {
+			return af == bf
+		}
+		return ai == bi
+	}
+
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case undefined:
+		_, ok := b.(undefined)
+		return ok
+	case string:
+		bv, ok := b.(string)
+		return ok && av == bv
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case *Dict:
+		bv, ok := b.(*Dict)
+		if !ok || av.Len() != bv.Len() {
+			return false
+		}
+		for _, k := range av.keys {
+			v, ok := bv.values[k]
+			if !ok || !equal(av.values[k], v) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compare returns -1, 0 or 1 to compare the values.
+func compare(a, b any) (int, error) {
+	if ai, af, aIsFloat, ok := toNumber(a); ok {
+		if bi, bf, bIsFloat, ok := toNumber(b); ok {
+			if aIsFloat || bIsFloat {
+				switch {
+				case af < bf:
+					return -1, nil
+				case af > bf:
+					return 1, nil
+				}
+				return 0, nil
+			}
+			switch {
+			case ai < bi:
+				return -1, nil
+			case ai > bi:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return strings.Compare(as, bs), nil
+		}
+	}
+	if al, ok := a.([]any); ok {
+		if bl, ok := b.([]any); ok {
+			for i := 0; i < len(al) && i < len(bl); i++ {
+				c, err := compare(al[i], bl[i])
+				if err != nil || c != 0 {
+					return c, err
+				}
+			}
+			return compare(int64(len(al)), int64(len(bl)))
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", typeName(a), typeName(b))
+}
+
+// typeName returns the Python type name of the value.
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "NoneType"
+	case undefined:
+		return "Undefined"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "str"
+	case []any:
+		return "list"
+	case *Dict:
+		return "dict"
+	case Func:
+		return "function"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// iterate returns the items to iterate over the value.
+func iterate(v any) ([]any, error) {
+	switch vv := v.(type) {
+	case undefined:
+		return nil, nil
+	case []any:
+		return vv, nil
+	case *Dict:
+		r := make([]any, len(vv.keys))
+		for i, k := range vv.keys {
+			r[i] = k
+		}
+		return r, nil
+	case string:
+		r := make([]any, 0, len(vv))
+		for _, c := range vv {
+			r = append(r, string(c))
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("%s is not iterable", typeName(v))
+}
@@ -28,6 +28,13 @@ func Open(path string) (*os.File, error) {
 	return os.Open(p)
 }
 
+// ReadFile is similar to os.ReadFile but supports ~ as the home directory.
+func ReadFile(path string) ([]byte, error) {
+	p := filepath.Clean(path)
+	p = InlineTilde(p)
+	return os.ReadFile(p)
+}
+
 // Exists checks if the given path exists.
 func Exists(path string, checks ...func(os.FileInfo) bool) bool {
 	p := filepath.Clean(path)
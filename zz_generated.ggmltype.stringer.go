@@ -1,57 +0,0 @@
-// Code generated by "stringer -linecomment -type GGMLType -output zz_generated.ggmltype.stringer.go -trimprefix GGMLType"; DO NOT EDIT.
-
-package gguf_parser
-
-import "strconv"
-
-func _() {
-	// An "invalid array index" compiler error signifies that the constant values have changed.
-	// Re-run the stringer command to generate them again.
-	var x [1]struct{}
-	_ = x[GGMLTypeF32-0]
-	_ = x[GGMLTypeF16-1]
-	_ = x[GGMLTypeQ4_0-2]
-	_ = x[GGMLTypeQ4_1-3]
-	_ = x[GGMLTypeQ4_2-4]
-	_ = x[GGMLTypeQ4_3-5]
-	_ = x[GGMLTypeQ5_0-6]
-	_ = x[GGMLTypeQ5_1-7]
-	_ = x[GGMLTypeQ8_0-8]
-	_ = x[GGMLTypeQ8_1-9]
-	_ = x[GGMLTypeQ2_K-10]
-	_ = x[GGMLTypeQ3_K-11]
-	_ = x[GGMLTypeQ4_K-12]
-	_ = x[GGMLTypeQ5_K-13]
-	_ = x[GGMLTypeQ6_K-14]
-	_ = x[GGMLTypeQ8_K-15]
-	_ = x[GGMLTypeIQ2_XXS-16]
-	_ = x[GGMLTypeIQ2_XS-17]
-	_ = x[GGMLTypeIQ3_XXS-18]
-	_ = x[GGMLTypeIQ1_S-19]
-	_ = x[GGMLTypeIQ4_NL-20]
-	_ = x[GGMLTypeIQ3_S-21]
-	_ = x[GGMLTypeIQ2_S-22]
-	_ = x[GGMLTypeIQ4_XS-23]
-	_ = x[GGMLTypeI8-24]
-	_ = x[GGMLTypeI16-25]
-	_ = x[GGMLTypeI32-26]
-	_ = x[GGMLTypeI64-27]
-	_ = x[GGMLTypeF64-28]
-	_ = x[GGMLTypeIQ1_M-29]
-	_ = x[GGMLTypeBF16-30]
-	_ = x[GGMLTypeQ4_0_4_4-31]
-	_ = x[GGMLTypeQ4_0_4_8-32]
-	_ = x[GGMLTypeQ4_0_8_8-33]
-	_ = x[_GGMLTypeCount-34]
-}
-
-const _GGMLType_name = "F32F16Q4_0Q4_1Q4_2Q4_3Q5_0Q5_1Q8_0Q8_1Q2_KQ3_KQ4_KQ5_KQ6_KQ8_KIQ2_XXSIQ2_XSIQ3_XXSIQ1_SIQ4_NLIQ3_SIQ2_SIQ4_XSI8I16I32I64F64IQ1_MBF16Q4_0_4_4Q4_0_4_8Q4_0_8_8Unknown"
-
-var _GGMLType_index = [...]uint8{0, 3, 6, 10, 14, 18, 22, 26, 30, 34, 38, 42, 46, 50, 54, 58, 62, 69, 75, 82, 87, 93, 98, 103, 109, 111, 114, 117, 120, 123, 128, 132, 140, 148, 156, 163}
-
-func (i GGMLType) String() string {
-	if i >= GGMLType(len(_GGMLType_index)-1) {
-		return "GGMLType(" + strconv.FormatInt(int64(i), 10) + ")"
-	}
-	return _GGMLType_name[_GGMLType_index[i]:_GGMLType_index[i+1]]
-}
@@ -37,15 +37,32 @@ func _() {
 	_ = x[GGUFFileTypeMostlyQ4_0_4_8-26]
 	_ = x[GGUFFileTypeMostlyQ4_0_8_8-27]
 	_ = x[_GGUFFileTypeCount-28]
+	_ = x[GGUFFileTypeMostlyQ3_K_M-1024]
+	_ = x[GGUFFileTypeMostlyQ3_K_L-1025]
+	_ = x[GGUFFileTypeMostlyQ4_K_S-1026]
+	_ = x[GGUFFileTypeMostlyQ4_K_M-1027]
+	_ = x[GGUFFileTypeMostlyQ5_K_S-1028]
+	_ = x[GGUFFileTypeMostlyQ5_K_M-1029]
 }
 
-const _GGUFFileType_name = "F32F16Q4_0Q4_1Q4_1_F16Q4_2Q4_3Q8_0Q5_0Q5_1Q2_KQ3_K/Q3_K_SQ4_K/Q3_K_MQ5_K/Q3_K_LQ6_K/Q4_K_SIQ2_XXS/Q4_K_MIQ2_XS/Q5_K_SIQ3_XXS/Q5_K_MIQ1_S/Q6_KIQ4_NLIQ3_SIQ2_SIQ4_XSIQ1_MBF16Q4_0_4x4Q4_0_4x8Q4_0_8x8Unknown"
+const (
+	_GGUFFileType_name_0 = "F32F16Q4_0Q4_1Q4_1_F16Q4_2Q4_3Q8_0Q5_0Q5_1Q2_KQ3_K/Q3_K_SQ4_K/Q3_K_MQ5_K/Q3_K_LQ6_K/Q4_K_SIQ2_XXS/Q4_K_MIQ2_XS/Q5_K_SIQ3_XXS/Q5_K_MIQ1_S/Q6_KIQ4_NLIQ3_SIQ2_SIQ4_XSIQ1_MBF16Q4_0_4x4Q4_0_4x8Q4_0_8x8Unknown"
+	_GGUFFileType_name_1 = "Q3_K_MQ3_K_LQ4_K_SQ4_K_MQ5_K_SQ5_K_M"
+)
 
-var _GGUFFileType_index = [...]uint8{0, 3, 6, 10, 14, 22, 26, 30, 34, 38, 42, 46, 57, 68, 79, 90, 104, 117, 131, 141, 147, 152, 157, 163, 168, 172, 180, 188, 196, 203}
+var (
+	_GGUFFileType_index_0 = [...]uint8{0, 3, 6, 10, 14, 22, 26, 30, 34, 38, 42, 46, 57, 68, 79, 90, 104, 117, 131, 141, 147, 152, 157, 163, 168, 172, 180, 188, 196, 203}
+	_GGUFFileType_index_1 = [...]uint8{0, 6, 12, 18, 24, 30, 36}
+)
 
 func (i GGUFFileType) String() string {
-	if i >= GGUFFileType(len(_GGUFFileType_index)-1) {
+	switch {
+	case i <= 28:
+		return _GGUFFileType_name_0[_GGUFFileType_index_0[i]:_GGUFFileType_index_0[i+1]]
+	case 1024 <= i && i <= 1029:
+		i -= 1024
+		return _GGUFFileType_name_1[_GGUFFileType_index_1[i]:_GGUFFileType_index_1[i+1]]
+	default:
 		return "GGUFFileType(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _GGUFFileType_name[_GGUFFileType_index[i]:_GGUFFileType_index[i+1]]
 }